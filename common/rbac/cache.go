@@ -0,0 +1,94 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/common/rbac/models"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/cache"
+)
+
+// The effective permissions of the most recently checked users are cached, see
+// userPermissionIDs.
+const (
+	permissionCacheSize = 1024
+	permissionCacheTTL  = 5 * time.Minute
+)
+
+// watchPermissionChanges registers hooks that drop the cached permissions when
+// a row of any of the stores of rbac is written, including by a caller using
+// the stores directly. Stores that do not support hooks are only covered by
+// the Rbac methods and InvalidatePermissionCache.
+func (rbac *Rbac) watchPermissionChanges() {
+	for _, event := range []store.Event{store.AfterInsert, store.AfterUpdate, store.AfterDelete} {
+		_ = store.On(rbac.PermissionStore, event, invalidateOn[models.Permission](rbac.permissions))
+		_ = store.On(rbac.RoleStore, event, invalidateOn[models.Role](rbac.permissions))
+		_ = store.On(rbac.UserStore, event, invalidateOn[models.User](rbac.permissions))
+		_ = store.On(rbac.RolePermissionStore, event, invalidateOn[models.RolePermission](rbac.permissions))
+		_ = store.On(rbac.UserRoleStore, event, invalidateOn[models.UserRole](rbac.permissions))
+	}
+}
+
+// invalidateOn returns a hook that drops every entry of permissions once the
+// transaction of the change commits, so that a concurrent HasPermission does
+// not cache the permissions the change replaces.
+func invalidateOn[T any](permissions *cache.LRU[string, []int64]) store.Hook[T] {
+	return func(ctx context.Context, obj *T) error {
+		store.OnCommit(store.TxFromContext(ctx), permissions.Purge)
+		return nil
+	}
+}
+
+// InvalidatePermissionCache drops the cached permissions of every user. It must
+// be called after a change to the stores that runs no hooks, such as the
+// Restore of a permission or a role from the trash.
+func (rbac *Rbac) InvalidatePermissionCache() {
+	rbac.permissions.Purge()
+}
+
+// PermissionCacheStats returns the counters of the cache of the permissions of
+// users.
+func (rbac *Rbac) PermissionCacheStats() cache.Stats {
+	return rbac.permissions.Stats()
+}
+
+// userPermissionIDs returns the ids of the permissions granted to the user
+// whose UserID is userID by their roles. Permissions and roles in the trash
+// are left out. The result is cached, except inside a transaction, which may
+// see changes that are not committed.
+func (rbac *Rbac) userPermissionIDs(ctx context.Context, userID string) ([]int64, error) {
+	if rbac.inTx {
+		return rbac.loadUserPermissionIDs(ctx, userID)
+	}
+	return rbac.permissions.Load(userID, func() ([]int64, error) {
+		return rbac.loadUserPermissionIDs(ctx, userID)
+	})
+}
+
+func (rbac *Rbac) loadUserPermissionIDs(ctx context.Context, userID string) ([]int64, error) {
+	roleIDs, err := rbac.userRoleIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	links, err := store.Project[struct {
+		PermissionID int64 `db:"permission_id"`
+	}](ctx, rbac.RolePermissionStore, store.In(models.RolePermissionColRoleID, roleIDs))
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RolePermissionStore.Project failed: %w", err)
+	}
+	permissionIDs := make([]int64, 0, len(links))
+	for _, link := range links {
+		permissionIDs = append(permissionIDs, link.PermissionID)
+	}
+	permissions, err := store.Project[idOnly](ctx, rbac.PermissionStore, store.In(models.PermissionColID, permissionIDs))
+	if err != nil {
+		return nil, fmt.Errorf("rbac.PermissionStore.Project failed: %w", err)
+	}
+	permissionIDs = permissionIDs[:0]
+	for _, permission := range permissions {
+		permissionIDs = append(permissionIDs, permission.ID)
+	}
+	return permissionIDs, nil
+}
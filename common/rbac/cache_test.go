@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/common/rbac/models"
+	"github.com/yinloo-ola/tt-app/util"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestRbac_PermissionCache(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rbac := newTestRbac(t)
+
+	read, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "read"})
+	util.PanicErr(err)
+	write, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "write"})
+	util.PanicErr(err)
+	umpire, err := rbac.AddRole(ctx, models.Role{Name: "umpire", Permissions: []int64{read, write}})
+	util.PanicErr(err)
+	alice, err := rbac.AddUser(ctx, models.User{UserID: "alice", Roles: []int64{umpire}})
+	util.PanicErr(err)
+
+	// the second check is served from the cache
+	hasPerm, err := rbac.HasPermission(ctx, "alice", write)
+	util.PanicErr(err)
+	assert.True(hasPerm)
+	hasPerm, err = rbac.HasPermission(ctx, "alice", read)
+	util.PanicErr(err)
+	assert.True(hasPerm)
+	stats := rbac.PermissionCacheStats()
+	assert.Equal(int64(1), stats.Hits)
+	assert.Equal(int64(1), stats.Misses)
+	_, err = rbac.HasPermission(ctx, "bob", read)
+	assert.ErrorIs(err, store.ErrNotFound)
+
+	// changes through Rbac and through the stores are seen at once
+	util.PanicErr(rbac.SetRolePermissions(ctx, umpire, []int64{read}))
+	hasPerm, err = rbac.HasPermission(ctx, "alice", write)
+	util.PanicErr(err)
+	assert.False(hasPerm)
+	util.PanicErr(rbac.PermissionStore.DeleteMulti(ctx, []int64{read}))
+	hasPerm, err = rbac.HasPermission(ctx, "alice", read)
+	util.PanicErr(err)
+	assert.False(hasPerm)
+
+	// Restore runs no hooks
+	permissionTrash, err := store.AsSoftDeleter(rbac.PermissionStore)
+	util.PanicErr(err)
+	util.PanicErr(permissionTrash.Restore(ctx, []int64{read}))
+	rbac.InvalidatePermissionCache()
+	perms, err := rbac.GetUserPermissions(ctx, "alice")
+	util.PanicErr(err)
+	assert.Equal([]int64{read}, permissionIDs(perms))
+
+	util.PanicErr(rbac.SetUserRoles(ctx, alice, nil))
+	perms, err = rbac.GetUserPermissions(ctx, "alice")
+	util.PanicErr(err)
+	assert.Empty(perms)
+}
+
+func permissionIDs(perms []models.Permission) []int64 {
+	ids := make([]int64, 0, len(perms))
+	for _, perm := range perms {
+		ids = append(ids, perm.ID)
+	}
+	return ids
+}
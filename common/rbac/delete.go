@@ -0,0 +1,178 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/common/rbac/models"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// DeletePolicy decides what happens to the roles granted a permission, or to
+// the users assigned a role, when that permission or role is deleted.
+type DeletePolicy string
+
+// DeleteRestrict refuses to delete while references exist and returns an
+// *InUseError listing them.
+const DeleteRestrict DeletePolicy = "restrict"
+
+// DeleteCascade removes the id from every reference, then deletes.
+const DeleteCascade DeletePolicy = "cascade"
+
+// DeleteDetach removes the id from every reference but keeps the permission or
+// role itself, so that it can be granted again later.
+const DeleteDetach DeletePolicy = "detach"
+
+var ErrInvalidDeletePolicy error = errors.New("invalid delete policy")
+
+// ParseDeletePolicy parses a policy name. An empty name is DeleteRestrict.
+func ParseDeletePolicy(name string) (DeletePolicy, error) {
+	switch policy := DeletePolicy(name); policy {
+	case "":
+		return DeleteRestrict, nil
+	case DeleteRestrict, DeleteCascade, DeleteDetach:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidDeletePolicy, name)
+	}
+}
+
+var ErrInUse error = errors.New("in use")
+
+// InUseError is returned by DeleteRestrict when a permission is granted to
+// roles, or a role is assigned to users.
+type InUseError struct {
+	// Kind is "permission" or "role".
+	Kind  string
+	Name  string
+	Roles []models.Role
+	Users []models.User
+}
+
+func (e *InUseError) Error() string {
+	names := make([]string, 0, len(e.Roles)+len(e.Users))
+	noun := "roles"
+	for _, role := range e.Roles {
+		names = append(names, role.Name)
+	}
+	if len(e.Users) > 0 {
+		noun = "users"
+		for _, user := range e.Users {
+			names = append(names, user.UserID)
+		}
+	}
+	return fmt.Sprintf("%s %s is used by %s %s", e.Kind, e.Name, noun, strings.Join(names, ", "))
+}
+
+func (e *InUseError) Is(target error) bool {
+	return target == ErrInUse
+}
+
+// DeletePermission moves the permission id to the trash, or only detaches it
+// from its roles, according to policy. Only roles that are not in the trash
+// restrict the delete.
+func (rbac *Rbac) DeletePermission(ctx context.Context, id int64, policy DeletePolicy) error {
+	return rbac.RunInTx(ctx, func(tx *Rbac) error {
+		permission, err := tx.PermissionStore.GetOne(ctx, id)
+		if err != nil {
+			return err
+		}
+		links, err := tx.RolePermissionStore.FindWhere(ctx, store.Eq(models.RolePermissionColPermissionID, id))
+		if err != nil {
+			return fmt.Errorf("rbac.RolePermissionStore.FindWhere failed: %w", err)
+		}
+		linkIDs := make([]int64, 0, len(links))
+		roleIDs := make([]int64, 0, len(links))
+		for _, link := range links {
+			linkIDs = append(linkIDs, link.ID)
+			roleIDs = append(roleIDs, link.RoleID)
+		}
+
+		switch policy {
+		case DeleteRestrict:
+			roles, err := tx.RoleStore.GetMulti(ctx, roleIDs)
+			if err != nil {
+				return fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+			}
+			if len(roles) > 0 {
+				return &InUseError{Kind: "permission", Name: permission.Name, Roles: roles}
+			}
+		case DeleteCascade, DeleteDetach:
+			if len(linkIDs) > 0 {
+				if err = tx.RolePermissionStore.DeleteMulti(ctx, linkIDs); err != nil {
+					return fmt.Errorf("rbac.RolePermissionStore.DeleteMulti failed: %w", err)
+				}
+			}
+			if policy == DeleteDetach {
+				return nil
+			}
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidDeletePolicy, policy)
+		}
+		return tx.PermissionStore.DeleteMulti(ctx, []int64{id})
+	})
+}
+
+// DeleteRole moves the role id to the trash, or only detaches it from its
+// users, according to policy. The role keeps its permissions, so that
+// restoring it from the trash grants them again.
+func (rbac *Rbac) DeleteRole(ctx context.Context, id int64, policy DeletePolicy) error {
+	return rbac.RunInTx(ctx, func(tx *Rbac) error {
+		role, err := tx.RoleStore.GetOne(ctx, id)
+		if err != nil {
+			return err
+		}
+		links, err := tx.UserRoleStore.FindWhere(ctx, store.Eq(models.UserRoleColRoleID, id))
+		if err != nil {
+			return fmt.Errorf("rbac.UserRoleStore.FindWhere failed: %w", err)
+		}
+		linkIDs := make([]int64, 0, len(links))
+		userIDs := make([]int64, 0, len(links))
+		for _, link := range links {
+			linkIDs = append(linkIDs, link.ID)
+			userIDs = append(userIDs, link.UserID)
+		}
+
+		switch policy {
+		case DeleteRestrict:
+			if len(links) > 0 {
+				users, err := tx.UserStore.GetMulti(ctx, userIDs)
+				if err != nil {
+					return fmt.Errorf("rbac.UserStore.GetMulti failed: %w", err)
+				}
+				return &InUseError{Kind: "role", Name: role.Name, Users: users}
+			}
+		case DeleteCascade, DeleteDetach:
+			if len(linkIDs) > 0 {
+				if err = tx.UserRoleStore.DeleteMulti(ctx, linkIDs); err != nil {
+					return fmt.Errorf("rbac.UserRoleStore.DeleteMulti failed: %w", err)
+				}
+			}
+			if policy == DeleteDetach {
+				return nil
+			}
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidDeletePolicy, policy)
+		}
+		return tx.RoleStore.DeleteMulti(ctx, []int64{id})
+	})
+}
+
+// UsersWithRole returns the users assigned roleID.
+func (rbac *Rbac) UsersWithRole(ctx context.Context, roleID int64) ([]models.User, error) {
+	links, err := rbac.UserRoleStore.FindWhere(ctx, store.Eq(models.UserRoleColRoleID, roleID))
+	if err != nil {
+		return nil, fmt.Errorf("rbac.UserRoleStore.FindWhere failed: %w", err)
+	}
+	userIDs := make([]int64, 0, len(links))
+	for _, link := range links {
+		userIDs = append(userIDs, link.UserID)
+	}
+	users, err := rbac.UserStore.GetMulti(ctx, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("rbac.UserStore.GetMulti failed: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,133 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/common/rbac/models"
+	"github.com/yinloo-ola/tt-app/util"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/audit"
+)
+
+func TestParseDeletePolicy(t *testing.T) {
+	assert := assert.New(t)
+	for name, want := range map[string]DeletePolicy{
+		"":         DeleteRestrict,
+		"restrict": DeleteRestrict,
+		"cascade":  DeleteCascade,
+		"detach":   DeleteDetach,
+	} {
+		policy, err := ParseDeletePolicy(name)
+		assert.NoError(err)
+		assert.Equal(want, policy)
+	}
+	_, err := ParseDeletePolicy("drop")
+	assert.ErrorIs(err, ErrInvalidDeletePolicy)
+}
+
+func TestRbac_Delete(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rbac, auditLog := newServedTestRbac(t)
+
+	read, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "read"})
+	util.PanicErr(err)
+	write, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "write"})
+	util.PanicErr(err)
+	unused, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "unused"})
+	util.PanicErr(err)
+	umpire, err := rbac.AddRole(ctx, models.Role{Name: "umpire", Permissions: []int64{read, write}})
+	util.PanicErr(err)
+	referee, err := rbac.AddRole(ctx, models.Role{Name: "referee", Permissions: []int64{read}})
+	util.PanicErr(err)
+	_, err = rbac.AddUser(ctx, models.User{UserID: "alice", Roles: []int64{umpire, referee}})
+	util.PanicErr(err)
+	_, err = rbac.AddUser(ctx, models.User{UserID: "bob", Roles: []int64{umpire}})
+	util.PanicErr(err)
+
+	// restrict lists the references and keeps everything
+	err = rbac.DeletePermission(ctx, read, DeleteRestrict)
+	assert.ErrorIs(err, ErrInUse)
+	var inUse *InUseError
+	if assert.True(errors.As(err, &inUse)) {
+		assert.Len(inUse.Roles, 2)
+		assert.Equal("permission read is used by roles umpire, referee", err.Error())
+	}
+	err = rbac.DeleteRole(ctx, umpire, DeleteRestrict)
+	assert.ErrorIs(err, ErrInUse)
+	assert.Equal("role umpire is used by users alice, bob", err.Error())
+	roles, err := rbac.RolesWithPermission(ctx, read)
+	util.PanicErr(err)
+	assert.Len(roles, 2)
+
+	assert.NoError(rbac.DeletePermission(ctx, unused, DeleteRestrict))
+	_, err = rbac.PermissionStore.GetOne(ctx, unused)
+	assert.ErrorIs(err, store.ErrNotFound)
+	assert.ErrorIs(rbac.DeletePermission(ctx, unused, DeleteRestrict), store.ErrNotFound)
+
+	// detach keeps the permission but takes it away from every role
+	assert.NoError(rbac.DeletePermission(ctx, write, DeleteDetach))
+	_, err = rbac.PermissionStore.GetOne(ctx, write)
+	assert.NoError(err)
+	roles, err = rbac.RolesWithPermission(ctx, write)
+	util.PanicErr(err)
+	assert.Empty(roles)
+
+	// cascade takes the permission away from every role and moves it to the trash
+	assert.NoError(rbac.DeletePermission(ctx, read, DeleteCascade))
+	_, err = rbac.PermissionStore.GetOne(ctx, read)
+	assert.ErrorIs(err, store.ErrNotFound)
+	hasPerm, err := rbac.HasPermission(ctx, "alice", read)
+	util.PanicErr(err)
+	assert.False(hasPerm)
+
+	assert.NoError(rbac.DeleteRole(ctx, referee, DeleteDetach))
+	users, err := rbac.UsersWithRole(ctx, referee)
+	util.PanicErr(err)
+	assert.Empty(users)
+	_, err = rbac.RoleStore.GetOne(ctx, referee)
+	assert.NoError(err)
+
+	users, err = rbac.UsersWithRole(ctx, umpire)
+	util.PanicErr(err)
+	assert.Len(users, 2)
+	assert.NoError(rbac.DeleteRole(ctx, umpire, DeleteCascade))
+	_, err = rbac.RoleStore.GetOne(ctx, umpire)
+	assert.ErrorIs(err, store.ErrNotFound)
+	links, err := rbac.UserRoleStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Empty(links)
+
+	assert.ErrorIs(rbac.DeleteRole(ctx, referee, "drop"), ErrInvalidDeletePolicy)
+
+	// a deleted role waits in the trash with its permissions
+	score, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "score"})
+	util.PanicErr(err)
+	coach, err := rbac.AddRole(ctx, models.Role{Name: "coach", Permissions: []int64{score}})
+	util.PanicErr(err)
+	assert.NoError(rbac.DeleteRole(ctx, coach, DeleteRestrict))
+	roleTrash, err := store.AsSoftDeleter(rbac.RoleStore)
+	util.PanicErr(err)
+	trashed, err := roleTrash.FindDeleted(ctx)
+	util.PanicErr(err)
+	if assert.Len(trashed, 2) {
+		assert.ElementsMatch([]int64{umpire, coach}, []int64{trashed[0].ID, trashed[1].ID})
+	}
+	assert.NoError(roleTrash.Restore(ctx, []int64{coach}))
+	roles, err = rbac.RoleStore.GetMulti(ctx, []int64{coach})
+	util.PanicErr(err)
+	util.PanicErr(rbac.LoadRolePermissions(ctx, roles))
+	assert.Equal([]int64{score}, roles[0].Permissions)
+
+	// roles in the trash do not restrict deleting their permissions
+	assert.NoError(rbac.DeleteRole(ctx, coach, DeleteRestrict))
+	assert.NoError(rbac.DeletePermission(ctx, score, DeleteRestrict))
+
+	deletes, err := auditLog.FindWhere(ctx, store.Eq(audit.EntryColEntity, "role"), store.Eq(audit.EntryColAction, audit.ActionDelete))
+	util.PanicErr(err)
+	assert.NotEmpty(deletes)
+}
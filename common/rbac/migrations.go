@@ -0,0 +1,61 @@
+package rbac
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
+)
+
+// Migrations upgrade an rbac SQLite database. Run them with DB.Migrate before
+// opening the stores, which then bring the tables in line with the models.
+var Migrations = []sqlitestore.Migration{
+	{Version: 1, Name: "join_tables", Up: migrateJoinTables},
+}
+
+// migrateJoinTables moves the JSON id arrays of role.permissions and user.roles
+// into the role_permission and user_role tables. Ids of deleted permissions and
+// roles are dropped. The old columns are dropped by the Role and User stores.
+func migrateJoinTables(ctx context.Context, tx *sql.Tx) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS role_permission (id INTEGER NOT NULL PRIMARY KEY,
+			role_id INTEGER NOT NULL DEFAULT 0 REFERENCES role(id) ON DELETE CASCADE,
+			permission_id INTEGER NOT NULL DEFAULT 0 REFERENCES permission(id) ON DELETE CASCADE)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS uniq_role_permission ON role_permission (role_id, permission_id)`,
+		`CREATE TABLE IF NOT EXISTS user_role (id INTEGER NOT NULL PRIMARY KEY,
+			user_id INTEGER NOT NULL DEFAULT 0 REFERENCES user(id) ON DELETE CASCADE,
+			role_id INTEGER NOT NULL DEFAULT 0 REFERENCES role(id) ON DELETE CASCADE)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS uniq_user_role ON user_role (user_id, role_id)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	copies := []struct {
+		table, column, parent, insert string
+	}{
+		{"role", "permissions", "permission", "INSERT OR IGNORE INTO role_permission (role_id, permission_id)"},
+		{"user", "roles", "role", "INSERT OR IGNORE INTO user_role (user_id, role_id)"},
+	}
+	for _, c := range copies {
+		var exists int
+		err := tx.QueryRowContext(ctx, "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			continue
+		}
+		// the arrays were stored as blobs before the store encoded JSON columns
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`%s
+			SELECT t.id, j.value FROM (SELECT id, CAST(%s AS TEXT) AS ids FROM %s WHERE json_valid(CAST(%s AS TEXT))) t, json_each(t.ids) j
+			WHERE j.value IN (SELECT id FROM %s)`, c.insert, c.column, c.table, c.column, c.parent))
+		if err != nil {
+			return fmt.Errorf("copy %s.%s failed: %w", c.table, c.column, err)
+		}
+	}
+	return nil
+}
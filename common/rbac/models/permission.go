@@ -2,16 +2,23 @@ package models
 
 import "github.com/yinloo-ola/tt-app/util/store"
 
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Permission
+
 type Permission struct {
 	ID          int64  `db:"id,pk" form:"id"`
-	Name        string `db:"name,idx_asc,uniq" form:"name"`
-	Description string `db:"description" form:"description"`
-}
-
-func (o *Permission) FieldsVals() []any {
-	return []any{o.ID, o.Name, o.Description}
+	Name        string `db:"name,idx_asc,uniq,fts" form:"name"`
+	Description string `db:"description,fts" form:"description"`
+	// DeletedAt is the unix time the permission was moved to the trash, or 0.
+	DeletedAt int64 `db:"deleted_at,soft_delete" form:"-"`
+	CreatedAt int64 `db:"created_at,created_at" form:"-"`
+	UpdatedAt int64 `db:"updated_at,updated_at" form:"-"`
+	// Version is carried by the edit form, so that saving a permission changed
+	// since the form was opened fails with store.ErrStaleVersion.
+	Version int64 `db:"version,version" form:"version"`
 }
 
-func (o *Permission) ScanRow(row store.RowScanner) error {
-	return row.Scan(&o.ID, &o.Name, &o.Description)
+// PermissionWhitelist lists the fields users may filter and sort permissions on.
+var PermissionWhitelist = store.Whitelist{
+	Filter: []string{PermissionColName, PermissionColDescription},
+	Sort:   []string{PermissionColID, PermissionColName},
 }
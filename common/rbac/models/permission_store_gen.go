@@ -0,0 +1,24 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package models
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Permission.
+const (
+	PermissionColID          = "id"
+	PermissionColName        = "name"
+	PermissionColDescription = "description"
+	PermissionColDeletedAt   = "deleted_at"
+	PermissionColCreatedAt   = "created_at"
+	PermissionColUpdatedAt   = "updated_at"
+	PermissionColVersion     = "version"
+)
+
+func (o *Permission) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Description, o.DeletedAt, o.CreatedAt, o.UpdatedAt, o.Version}, nil
+}
+
+func (o *Permission) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Description, &o.DeletedAt, &o.CreatedAt, &o.UpdatedAt, &o.Version)
+}
@@ -1,32 +1,26 @@
 package models
 
-import (
-	"encoding/json"
+import "github.com/yinloo-ola/tt-app/util/store"
 
-	"github.com/yinloo-ola/tt-app/util"
-	"github.com/yinloo-ola/tt-app/util/store"
-)
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Role
 
 type Role struct {
-	ID          int64   `db:"id,pk" form:"id"`
-	Name        string  `db:"name,idx_asc,uniq" form:"name"`
-	Description string  `db:"description" form:"description"`
-	Permissions []int64 `db:"permissions,json" form:"permissions"`
+	ID          int64  `db:"id,pk" form:"id"`
+	Name        string `db:"name,idx_asc,uniq,fts" form:"name"`
+	Description string `db:"description,fts" form:"description"`
+	// Permissions is stored in the role_permission table, see Rbac.AddRole.
+	Permissions []int64 `db:"-" form:"permissions"`
+	// DeletedAt is the unix time the role was moved to the trash, or 0.
+	DeletedAt int64 `db:"deleted_at,soft_delete" form:"-"`
+	CreatedAt int64 `db:"created_at,created_at" form:"-"`
+	UpdatedAt int64 `db:"updated_at,updated_at" form:"-"`
+	// Version is carried by the edit form, so that saving a role changed
+	// since the form was opened fails with store.ErrStaleVersion.
+	Version int64 `db:"version,version" form:"version"`
 }
 
-func (o *Role) FieldsVals() []any {
-	perms, err := json.Marshal(o.Permissions)
-	util.PanicErr(err)
-	return []any{o.ID, o.Name, o.Description, perms}
-}
-
-func (o *Role) ScanRow(row store.RowScanner) error {
-	var perms []byte
-	err := row.Scan(&o.ID, &o.Name, &o.Description, &perms)
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(perms, &o.Permissions)
-	util.PanicErr(err)
-	return nil
+// RoleWhitelist lists the fields users may filter and sort roles on.
+var RoleWhitelist = store.Whitelist{
+	Filter: []string{RoleColName, RoleColDescription},
+	Sort:   []string{RoleColID, RoleColName},
 }
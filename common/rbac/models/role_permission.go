@@ -0,0 +1,11 @@
+package models
+
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=RolePermission
+
+// RolePermission grants a permission to a role. Deleting either side deletes
+// the grant.
+type RolePermission struct {
+	ID           int64 `db:"id,pk"`
+	RoleID       int64 `db:"role_id,idx_asc,fk=role.id,on_delete=cascade"`
+	PermissionID int64 `db:"permission_id,idx_asc,fk=permission.id,on_delete=cascade"`
+}
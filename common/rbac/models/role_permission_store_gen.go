@@ -0,0 +1,20 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package models
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of RolePermission.
+const (
+	RolePermissionColID           = "id"
+	RolePermissionColRoleID       = "role_id"
+	RolePermissionColPermissionID = "permission_id"
+)
+
+func (o *RolePermission) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.RoleID, o.PermissionID}, nil
+}
+
+func (o *RolePermission) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.RoleID, &o.PermissionID)
+}
@@ -0,0 +1,24 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package models
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Role.
+const (
+	RoleColID          = "id"
+	RoleColName        = "name"
+	RoleColDescription = "description"
+	RoleColDeletedAt   = "deleted_at"
+	RoleColCreatedAt   = "created_at"
+	RoleColUpdatedAt   = "updated_at"
+	RoleColVersion     = "version"
+)
+
+func (o *Role) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Description, o.DeletedAt, o.CreatedAt, o.UpdatedAt, o.Version}, nil
+}
+
+func (o *Role) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Description, &o.DeletedAt, &o.CreatedAt, &o.UpdatedAt, &o.Version)
+}
@@ -1,31 +1,18 @@
 package models
 
-import (
-	"encoding/json"
+import "github.com/yinloo-ola/tt-app/util/store"
 
-	"github.com/yinloo-ola/tt-app/util"
-	"github.com/yinloo-ola/tt-app/util/store"
-)
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=User
 
 type User struct {
-	ID     int64   `db:"id,pk"`
-	UserID string  `db:"user_id,idx_asc,uniq"`
-	Roles  []int64 `db:"roles,json"`
+	ID     int64  `db:"id,pk"`
+	UserID string `db:"user_id,idx_asc,uniq"`
+	// Roles is stored in the user_role table, see Rbac.AddUser.
+	Roles []int64 `db:"-"`
 }
 
-func (o *User) FieldsVals() []any {
-	roles, err := json.Marshal(o.Roles)
-	util.PanicErr(err)
-	return []any{o.ID, o.UserID, roles}
-}
-
-func (o *User) ScanRow(row store.RowScanner) error {
-	var roles []byte
-	err := row.Scan(&o.ID, &o.UserID, &roles)
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(roles, &o.Roles)
-	util.PanicErr(err)
-	return nil
+// UserWhitelist lists the fields users may filter and sort users on.
+var UserWhitelist = store.Whitelist{
+	Filter: []string{UserColUserID},
+	Sort:   []string{UserColID, UserColUserID},
 }
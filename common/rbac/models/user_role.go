@@ -0,0 +1,11 @@
+package models
+
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=UserRole
+
+// UserRole assigns a role to a user. UserID is the id of the user row, not
+// User.UserID. Deleting either side deletes the assignment.
+type UserRole struct {
+	ID     int64 `db:"id,pk"`
+	UserID int64 `db:"user_id,idx_asc,fk=user.id,on_delete=cascade"`
+	RoleID int64 `db:"role_id,idx_asc,fk=role.id,on_delete=cascade"`
+}
@@ -0,0 +1,20 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package models
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of UserRole.
+const (
+	UserRoleColID     = "id"
+	UserRoleColUserID = "user_id"
+	UserRoleColRoleID = "role_id"
+)
+
+func (o *UserRole) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.UserID, o.RoleID}, nil
+}
+
+func (o *UserRole) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.UserID, &o.RoleID)
+}
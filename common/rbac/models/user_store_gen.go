@@ -0,0 +1,19 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package models
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of User.
+const (
+	UserColID     = "id"
+	UserColUserID = "user_id"
+)
+
+func (o *User) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.UserID}, nil
+}
+
+func (o *User) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.UserID)
+}
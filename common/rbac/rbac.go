@@ -1,77 +1,309 @@
 package rbac
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/yinloo-ola/tt-app/common/rbac/models"
 	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/cache"
 )
 
 type Rbac struct {
-	PermissionStore store.Store[models.Permission, *models.Permission]
-	RoleStore       store.Store[models.Role, *models.Role]
-	UserStore       store.Store[models.User, *models.User]
+	PermissionStore     store.Store[models.Permission, *models.Permission]
+	RoleStore           store.Store[models.Role, *models.Role]
+	UserStore           store.Store[models.User, *models.User]
+	RolePermissionStore store.Store[models.RolePermission, *models.RolePermission]
+	UserRoleStore       store.Store[models.UserRole, *models.UserRole]
+	db                  store.Transactor
+	inTx                bool
+	// permissions caches the ids of the permissions of users by UserID. It is
+	// shared with the Rbac passed to RunInTx.
+	permissions *cache.LRU[string, []int64]
 }
 
-func NewRbac(permissionStore store.Store[
-	models.Permission, *models.Permission],
+// NewRbac returns an Rbac over the given stores. db must be the database the
+// stores were opened on; it is used to update them atomically. NewRbac
+// registers hooks on the stores to keep the cache of the permissions of users
+// up to date, see HasPermission.
+func NewRbac(db store.Transactor,
+	permissionStore store.Store[models.Permission, *models.Permission],
 	roleStore store.Store[models.Role, *models.Role],
 	userStore store.Store[models.User, *models.User],
+	rolePermissionStore store.Store[models.RolePermission, *models.RolePermission],
+	userRoleStore store.Store[models.UserRole, *models.UserRole],
 ) *Rbac {
-	return &Rbac{
-		PermissionStore: permissionStore,
-		RoleStore:       roleStore,
-		UserStore:       userStore,
+	rbac := &Rbac{
+		PermissionStore:     permissionStore,
+		RoleStore:           roleStore,
+		UserStore:           userStore,
+		RolePermissionStore: rolePermissionStore,
+		UserRoleStore:       userRoleStore,
+		db:                  db,
+		permissions:         cache.NewLRU[string, []int64](permissionCacheSize, permissionCacheTTL),
 	}
+	rbac.watchPermissionChanges()
+	return rbac
 }
 
-func (rbac *Rbac) HasPermission(userID string, permissionID int64) (bool, error) {
-	users, err := rbac.UserStore.FindWhere(&store.WhereCond{
-		Field: "user_id", Val: userID, Op: store.OpEqual,
+// RunInTx calls fn with an Rbac whose stores are bound to a single transaction,
+// so that changes across permissions, roles and users commit or roll back
+// together. Calling RunInTx on the Rbac passed to fn joins the same transaction.
+func (rbac *Rbac) RunInTx(ctx context.Context, fn func(tx *Rbac) error) error {
+	if rbac.inTx {
+		return fn(rbac)
+	}
+	// the hooks only cover the stores that support them
+	defer rbac.permissions.Purge()
+	return rbac.db.RunInTx(ctx, func(tx store.Tx) error {
+		permissionStore, err := store.WithTx(rbac.PermissionStore, tx)
+		if err != nil {
+			return fmt.Errorf("bind PermissionStore to tx failed: %w", err)
+		}
+		roleStore, err := store.WithTx(rbac.RoleStore, tx)
+		if err != nil {
+			return fmt.Errorf("bind RoleStore to tx failed: %w", err)
+		}
+		userStore, err := store.WithTx(rbac.UserStore, tx)
+		if err != nil {
+			return fmt.Errorf("bind UserStore to tx failed: %w", err)
+		}
+		rolePermissionStore, err := store.WithTx(rbac.RolePermissionStore, tx)
+		if err != nil {
+			return fmt.Errorf("bind RolePermissionStore to tx failed: %w", err)
+		}
+		userRoleStore, err := store.WithTx(rbac.UserRoleStore, tx)
+		if err != nil {
+			return fmt.Errorf("bind UserRoleStore to tx failed: %w", err)
+		}
+		return fn(&Rbac{
+			PermissionStore:     permissionStore,
+			RoleStore:           roleStore,
+			UserStore:           userStore,
+			RolePermissionStore: rolePermissionStore,
+			UserRoleStore:       userRoleStore,
+			db:                  rbac.db,
+			inTx:                true,
+			permissions:         rbac.permissions,
+		})
+	})
+}
+
+// AddRole inserts role together with its permissions.
+func (rbac *Rbac) AddRole(ctx context.Context, role models.Role) (int64, error) {
+	var id int64
+	err := rbac.RunInTx(ctx, func(tx *Rbac) error {
+		var err error
+		id, err = tx.RoleStore.Insert(ctx, role)
+		if err != nil {
+			return err
+		}
+		return tx.SetRolePermissions(ctx, id, role.Permissions)
+	})
+	return id, err
+}
+
+// UpdateRole updates role and replaces its permissions.
+func (rbac *Rbac) UpdateRole(ctx context.Context, id int64, role models.Role) error {
+	return rbac.RunInTx(ctx, func(tx *Rbac) error {
+		if err := tx.RoleStore.Update(ctx, id, role); err != nil {
+			return err
+		}
+		return tx.SetRolePermissions(ctx, id, role.Permissions)
+	})
+}
+
+// SetRolePermissions grants exactly permissionIDs to the role.
+func (rbac *Rbac) SetRolePermissions(ctx context.Context, roleID int64, permissionIDs []int64) error {
+	return rbac.RunInTx(ctx, func(tx *Rbac) error {
+		links, err := tx.RolePermissionStore.FindWhere(ctx, store.Eq(models.RolePermissionColRoleID, roleID))
+		if err != nil {
+			return fmt.Errorf("rbac.RolePermissionStore.FindWhere failed: %w", err)
+		}
+		stale := make([]int64, 0, len(links))
+		granted := make([]int64, 0, len(links))
+		for _, link := range links {
+			if slices.Contains(permissionIDs, link.PermissionID) {
+				granted = append(granted, link.PermissionID)
+			} else {
+				stale = append(stale, link.ID)
+			}
+		}
+		if len(stale) > 0 {
+			if err = tx.RolePermissionStore.DeleteMulti(ctx, stale); err != nil {
+				return fmt.Errorf("rbac.RolePermissionStore.DeleteMulti failed: %w", err)
+			}
+		}
+		for _, permissionID := range permissionIDs {
+			if slices.Contains(granted, permissionID) {
+				continue
+			}
+			link := models.RolePermission{RoleID: roleID, PermissionID: permissionID}
+			if _, err = tx.RolePermissionStore.Insert(ctx, link); err != nil {
+				return fmt.Errorf("rbac.RolePermissionStore.Insert failed: %w", err)
+			}
+			granted = append(granted, permissionID)
+		}
+		return nil
 	})
+}
+
+// LoadRolePermissions fills the Permissions of roles. Permissions in the trash
+// are left out.
+func (rbac *Rbac) LoadRolePermissions(ctx context.Context, roles []models.Role) error {
+	roleIDs := make([]int64, 0, len(roles))
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+	links, err := rbac.RolePermissionStore.FindWhere(ctx, store.In(models.RolePermissionColRoleID, roleIDs))
 	if err != nil {
-		return false, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
+		return fmt.Errorf("rbac.RolePermissionStore.FindWhere failed: %w", err)
 	}
-	if len(users) != 1 {
-		return false, store.ErrNotFound
+	permissionIDs := make([]int64, 0, len(links))
+	for _, link := range links {
+		if !slices.Contains(permissionIDs, link.PermissionID) {
+			permissionIDs = append(permissionIDs, link.PermissionID)
+		}
 	}
-
-	roles, err := rbac.RoleStore.GetMulti(users[0].Roles)
+	permissions, err := rbac.PermissionStore.GetMulti(ctx, permissionIDs)
 	if err != nil {
-		return false, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+		return fmt.Errorf("rbac.PermissionStore.GetMulti failed: %w", err)
+	}
+	live := make(map[int64]bool, len(permissions))
+	for _, permission := range permissions {
+		live[permission.ID] = true
 	}
-	for _, r := range roles {
-		for _, p := range r.Permissions {
-			if p == permissionID {
-				return true, nil
+	for i := range roles {
+		roles[i].Permissions = []int64{}
+		for _, link := range links {
+			if link.RoleID == roles[i].ID && live[link.PermissionID] {
+				roles[i].Permissions = append(roles[i].Permissions, link.PermissionID)
 			}
 		}
 	}
-	return false, nil
+	return nil
+}
+
+// RolesWithPermission returns the roles granted permissionID.
+func (rbac *Rbac) RolesWithPermission(ctx context.Context, permissionID int64) ([]models.Role, error) {
+	links, err := rbac.RolePermissionStore.FindWhere(ctx, store.Eq(models.RolePermissionColPermissionID, permissionID))
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RolePermissionStore.FindWhere failed: %w", err)
+	}
+	roleIDs := make([]int64, 0, len(links))
+	for _, link := range links {
+		roleIDs = append(roleIDs, link.RoleID)
+	}
+	roles, err := rbac.RoleStore.GetMulti(ctx, roleIDs)
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+	}
+	return roles, nil
+}
+
+// AddUser inserts user together with its roles.
+func (rbac *Rbac) AddUser(ctx context.Context, user models.User) (int64, error) {
+	var id int64
+	err := rbac.RunInTx(ctx, func(tx *Rbac) error {
+		var err error
+		id, err = tx.UserStore.Insert(ctx, user)
+		if err != nil {
+			return err
+		}
+		return tx.SetUserRoles(ctx, id, user.Roles)
+	})
+	return id, err
 }
 
-func (rbac *Rbac) GetUserPermissions(userID string) ([]models.Permission, error) {
-	users, err := rbac.UserStore.FindWhere(&store.WhereCond{
-		Field: "user_id", Val: userID, Op: store.OpEqual,
+// SetUserRoles assigns exactly roleIDs to the user with row id userID.
+func (rbac *Rbac) SetUserRoles(ctx context.Context, userID int64, roleIDs []int64) error {
+	return rbac.RunInTx(ctx, func(tx *Rbac) error {
+		links, err := tx.UserRoleStore.FindWhere(ctx, store.Eq(models.UserRoleColUserID, userID))
+		if err != nil {
+			return fmt.Errorf("rbac.UserRoleStore.FindWhere failed: %w", err)
+		}
+		stale := make([]int64, 0, len(links))
+		assigned := make([]int64, 0, len(links))
+		for _, link := range links {
+			if slices.Contains(roleIDs, link.RoleID) {
+				assigned = append(assigned, link.RoleID)
+			} else {
+				stale = append(stale, link.ID)
+			}
+		}
+		if len(stale) > 0 {
+			if err = tx.UserRoleStore.DeleteMulti(ctx, stale); err != nil {
+				return fmt.Errorf("rbac.UserRoleStore.DeleteMulti failed: %w", err)
+			}
+		}
+		for _, roleID := range roleIDs {
+			if slices.Contains(assigned, roleID) {
+				continue
+			}
+			if _, err = tx.UserRoleStore.Insert(ctx, models.UserRole{UserID: userID, RoleID: roleID}); err != nil {
+				return fmt.Errorf("rbac.UserRoleStore.Insert failed: %w", err)
+			}
+			assigned = append(assigned, roleID)
+		}
+		return nil
 	})
+}
+
+// idOnly projects a row onto its primary key.
+type idOnly struct {
+	ID int64 `db:"id"`
+}
+
+// userRoleIDs returns the ids of the roles of the user whose UserID is userID.
+// Roles in the trash are left out.
+func (rbac *Rbac) userRoleIDs(ctx context.Context, userID string) ([]int64, error) {
+	users, err := store.Project[idOnly](ctx, rbac.UserStore, store.Eq(models.UserColUserID, userID))
 	if err != nil {
-		return nil, fmt.Errorf("rbac.UserStore.FindField failed: %w", err)
+		return nil, fmt.Errorf("rbac.UserStore.Project failed: %w", err)
 	}
 	if len(users) != 1 {
 		return nil, store.ErrNotFound
 	}
-
-	roles, err := rbac.RoleStore.GetMulti(users[0].Roles)
+	links, err := store.Project[struct {
+		RoleID int64 `db:"role_id"`
+	}](ctx, rbac.UserRoleStore, store.Eq(models.UserRoleColUserID, users[0].ID))
 	if err != nil {
-		return nil, fmt.Errorf("rbac.RoleStore.GetMulti failed: %w", err)
+		return nil, fmt.Errorf("rbac.UserRoleStore.Project failed: %w", err)
+	}
+	roleIDs := make([]int64, 0, len(links))
+	for _, link := range links {
+		roleIDs = append(roleIDs, link.RoleID)
+	}
+	roles, err := store.Project[idOnly](ctx, rbac.RoleStore, store.In(models.RoleColID, roleIDs))
+	if err != nil {
+		return nil, fmt.Errorf("rbac.RoleStore.Project failed: %w", err)
+	}
+	roleIDs = roleIDs[:0]
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
 	}
+	return roleIDs, nil
+}
 
-	permissionIDs := make([]int64, 0, len(roles)*3)
-	for _, r := range roles {
-		permissionIDs = append(permissionIDs, r.Permissions...)
+// HasPermission reports whether the roles of the user whose UserID is userID
+// grant permissionID. The permissions of users are cached until a change to
+// the stores of rbac.
+func (rbac *Rbac) HasPermission(ctx context.Context, userID string, permissionID int64) (bool, error) {
+	permissionIDs, err := rbac.userPermissionIDs(ctx, userID)
+	if err != nil {
+		return false, err
 	}
+	return slices.Contains(permissionIDs, permissionID), nil
+}
 
-	permissions, err := rbac.PermissionStore.GetMulti(permissionIDs)
+func (rbac *Rbac) GetUserPermissions(ctx context.Context, userID string) ([]models.Permission, error) {
+	permissionIDs, err := rbac.userPermissionIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := rbac.PermissionStore.GetMulti(ctx, permissionIDs)
 	if err != nil {
 		return nil, fmt.Errorf("rbac.PermissionStore.GetMulti failed: %w", err)
 	}
@@ -79,17 +311,12 @@ func (rbac *Rbac) GetUserPermissions(userID string) ([]models.Permission, error)
 }
 
 func (rbac *Rbac) Close() error {
-	err1 := rbac.PermissionStore.Close()
-	err2 := rbac.RoleStore.Close()
-	err3 := rbac.UserStore.Close()
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-	if err3 != nil {
-		return err3
-	}
-	return nil
+	return errors.Join(
+		rbac.PermissionStore.Close(),
+		rbac.RoleStore.Close(),
+		rbac.UserStore.Close(),
+		rbac.RolePermissionStore.Close(),
+		rbac.UserRoleStore.Close(),
+		rbac.db.Close(),
+	)
 }
@@ -1,8 +1,11 @@
 package rbac
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -10,38 +13,22 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/yinloo-ola/tt-app/common/rbac/models"
 	"github.com/yinloo-ola/tt-app/util"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/audit"
+	"github.com/yinloo-ola/tt-app/util/store/cache"
 	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
 )
 
 func TestParallelInsert(t *testing.T) {
-	path := "rbac_parallel.db"
-	t.Cleanup(func() {
-		errRemove := os.Remove(path)
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		_ = os.Remove(path + "-shm")
-		_ = os.Remove(path + "-wal")
-	})
-
 	assert := assert.New(t)
+	ctx := context.Background()
 
-	permissionStore, err := sqlitestore.NewStore[models.Permission](path)
-	util.PanicErr(err)
-	roleStore, err := sqlitestore.NewStore[models.Role](path)
-	util.PanicErr(err)
-	userStore, err := sqlitestore.NewStore[models.User](path)
-	util.PanicErr(err)
-	rbac := NewRbac(
-		permissionStore, roleStore, userStore,
-	)
-	defer func() {
-		errClose := rbac.Close()
-		util.PanicErr(errClose)
-	}()
+	rbac := newTestRbac(t)
 	permsIn := make([]models.Permission, 0, 100)
 	permChan := make(chan models.Permission, 100)
+	collected := make(chan struct{})
 	go func(ch chan models.Permission) {
+		defer close(collected)
 		for p := range ch {
 			permsIn = append(permsIn, p)
 		}
@@ -57,7 +44,7 @@ func TestParallelInsert(t *testing.T) {
 				Name:        fmt.Sprintf("Name %d", i),
 				Description: fmt.Sprintf("Desc %d", i),
 			}
-			id, err := rbac.PermissionStore.Insert(perm)
+			id, err := rbac.PermissionStore.Insert(ctx, perm)
 			util.PanicErr(err)
 			perm.ID = id
 			permChan <- perm
@@ -65,42 +52,104 @@ func TestParallelInsert(t *testing.T) {
 	}
 	wg.Wait()
 	close(permChan)
+	<-collected
 
-	perms, err := rbac.PermissionStore.FindWhere()
+	perms, err := rbac.PermissionStore.FindWhere(ctx)
 	util.PanicErr(err)
 	assert.Len(perms, 100)
-	assert.ElementsMatch(permsIn, perms)
+	assert.ElementsMatch(permsIn, unstampedPermissions(perms))
 }
 
-func TestRbac_HasPermission(t *testing.T) {
-	path := "rbac_has_permission.db"
+// unstampedPermissions clears the fields of perms maintained by the store, to
+// compare them with the permissions that were inserted.
+func unstampedPermissions(perms []models.Permission) []models.Permission {
+	for i := range perms {
+		perms[i].CreatedAt, perms[i].UpdatedAt, perms[i].Version = 0, 0, 0
+	}
+	return perms
+}
+
+// unstampedRoles is unstampedPermissions for roles.
+func unstampedRoles(roles []models.Role) []models.Role {
+	for i := range roles {
+		roles[i].CreatedAt, roles[i].UpdatedAt, roles[i].Version = 0, 0, 0
+	}
+	return roles
+}
+
+// newTestRbac returns an Rbac over an empty SQLite database in a temporary
+// directory, closed at the end of the test.
+func newTestRbac(t *testing.T) *Rbac {
+	return openTestRbac(t, filepath.Join(t.TempDir(), "rbac.db"))
+}
+
+// openTestRbac returns an Rbac over the SQLite database at path, after running
+// Migrations on it like the server does, closed at the end of the test.
+func openTestRbac(t *testing.T, path string) *Rbac {
+	db, err := sqlitestore.Open(path)
+	util.PanicErr(err)
+	util.PanicErr(db.Migrate(context.Background(), Migrations))
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
+	util.PanicErr(err)
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
+	util.PanicErr(err)
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
+	util.PanicErr(err)
+	rolePermissionStore, err := sqlitestore.NewStoreFromDB[models.RolePermission](db)
+	util.PanicErr(err)
+	userRoleStore, err := sqlitestore.NewStoreFromDB[models.UserRole](db)
+	util.PanicErr(err)
+	rbac := NewRbac(db, permissionStore, roleStore, userStore, rolePermissionStore, userRoleStore)
 	t.Cleanup(func() {
-		errRemove := os.Remove(path)
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		_ = os.Remove(path + "-shm")
-		_ = os.Remove(path + "-wal")
+		assert.NoError(t, rbac.Close())
 	})
+	return rbac
+}
 
-	assert := assert.New(t)
-
-	permissionStore, err := sqlitestore.NewStore[models.Permission](path)
+// newServedTestRbac is newTestRbac with the stores wrapped like those of the
+// server: every change goes to the returned audit log, and the permissions and
+// roles are cached.
+func newServedTestRbac(t *testing.T) (*Rbac, audit.Log) {
+	db, err := sqlitestore.Open(filepath.Join(t.TempDir(), "rbac.db"))
+	util.PanicErr(err)
+	util.PanicErr(db.Migrate(context.Background(), Migrations))
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
+	util.PanicErr(err)
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
+	util.PanicErr(err)
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
 	util.PanicErr(err)
-	roleStore, err := sqlitestore.NewStore[models.Role](path)
+	rolePermissionStore, err := sqlitestore.NewStoreFromDB[models.RolePermission](db)
 	util.PanicErr(err)
-	userStore, err := sqlitestore.NewStore[models.User](path)
+	userRoleStore, err := sqlitestore.NewStoreFromDB[models.UserRole](db)
 	util.PanicErr(err)
-	rbac := NewRbac(
-		permissionStore, roleStore, userStore,
+	auditLog, err := sqlitestore.NewStoreFromDB[audit.Entry](db)
+	util.PanicErr(err)
+	storeCache := cache.Options{Size: 100, TTL: time.Minute}
+	rbac := NewRbac(db,
+		cache.NewStore(audit.NewStore(db, permissionStore, auditLog, "permission"), storeCache),
+		cache.NewStore(audit.NewStore(db, roleStore, auditLog, "role"), storeCache),
+		audit.NewStore(db, userStore, auditLog, "user"),
+		audit.NewStore(db, rolePermissionStore, auditLog, "role_permission"),
+		audit.NewStore(db, userRoleStore, auditLog, "user_role"),
 	)
-	defer func() {
-		errClose := rbac.Close()
-		util.PanicErr(errClose)
-	}()
+	t.Cleanup(func() {
+		assert.NoError(t, rbac.Close())
+		assert.NoError(t, auditLog.Close())
+	})
+	return rbac, auditLog
+}
+
+func TestRbac_HasPermission(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rbac := newTestRbac(t)
 	permsIn := make([]models.Permission, 0, 100)
 	permChan := make(chan models.Permission, 100)
+	collected := make(chan struct{})
 	go func(ch chan models.Permission) {
+		defer close(collected)
 		for p := range ch {
 			permsIn = append(permsIn, p)
 		}
@@ -116,15 +165,15 @@ func TestRbac_HasPermission(t *testing.T) {
 				Name:        fmt.Sprintf("Name %d", i),
 				Description: fmt.Sprintf("Desc %d", i),
 			}
-			id, err := rbac.PermissionStore.Insert(perm)
+			id, err := rbac.PermissionStore.Insert(ctx, perm)
 			util.PanicErr(err)
 			perm.ID = id
 			permChan <- perm
 		}(&wg)
 	}
 	wg.Wait()
-	time.Sleep(10 * time.Millisecond)
 	close(permChan)
+	<-collected
 
 	roles := make([]models.Role, 0, 10)
 	partSize := len(permsIn) / 10
@@ -139,7 +188,7 @@ func TestRbac_HasPermission(t *testing.T) {
 		for j := startIndex; j < endIndex; j++ {
 			role.Permissions = append(role.Permissions, permsIn[j].ID)
 		}
-		id, err := rbac.RoleStore.Insert(role)
+		id, err := rbac.AddRole(ctx, role)
 		util.PanicErr(err)
 		role.ID = id
 		roles = append(roles, role)
@@ -155,7 +204,7 @@ func TestRbac_HasPermission(t *testing.T) {
 		for j := start; j < end; j++ {
 			user.Roles = append(user.Roles, roles[j].ID)
 		}
-		id, err := rbac.UserStore.Insert(user)
+		id, err := rbac.AddUser(ctx, user)
 		util.PanicErr(err)
 		user.ID = id
 		users = append(users, user)
@@ -166,7 +215,7 @@ func TestRbac_HasPermission(t *testing.T) {
 		end := (i + 1) * 20
 
 		for j := start; j < end; j++ {
-			hasPerm, err := rbac.HasPermission(users[i].UserID, permsIn[j].ID)
+			hasPerm, err := rbac.HasPermission(ctx, users[i].UserID, permsIn[j].ID)
 			util.PanicErr(err)
 			assert.True(hasPerm)
 		}
@@ -180,7 +229,7 @@ func TestRbac_HasPermission(t *testing.T) {
 			if j >= start && j < end {
 				continue
 			}
-			hasPerm, err := rbac.HasPermission(users[i].UserID, permsIn[j].ID)
+			hasPerm, err := rbac.HasPermission(ctx, users[i].UserID, permsIn[j].ID)
 			util.PanicErr(err)
 			assert.False(hasPerm)
 		}
@@ -189,34 +238,15 @@ func TestRbac_HasPermission(t *testing.T) {
 }
 
 func TestRbac_GetUserPermissions(t *testing.T) {
-	path := "rbac_has_permission.db"
-	t.Cleanup(func() {
-		errRemove := os.Remove(path)
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		_ = os.Remove(path + "-shm")
-		_ = os.Remove(path + "-wal")
-	})
-
 	assert := assert.New(t)
+	ctx := context.Background()
 
-	permissionStore, err := sqlitestore.NewStore[models.Permission](path)
-	util.PanicErr(err)
-	roleStore, err := sqlitestore.NewStore[models.Role](path)
-	util.PanicErr(err)
-	userStore, err := sqlitestore.NewStore[models.User](path)
-	util.PanicErr(err)
-	rbac := NewRbac(
-		permissionStore, roleStore, userStore,
-	)
-	defer func() {
-		errClose := rbac.Close()
-		util.PanicErr(errClose)
-	}()
+	rbac := newTestRbac(t)
 	permsIn := make([]models.Permission, 0, 100)
 	permChan := make(chan models.Permission, 100)
+	collected := make(chan struct{})
 	go func(ch chan models.Permission) {
+		defer close(collected)
 		for p := range ch {
 			permsIn = append(permsIn, p)
 		}
@@ -232,15 +262,15 @@ func TestRbac_GetUserPermissions(t *testing.T) {
 				Name:        fmt.Sprintf("Name %d", i),
 				Description: fmt.Sprintf("Desc %d", i),
 			}
-			id, err := rbac.PermissionStore.Insert(perm)
+			id, err := rbac.PermissionStore.Insert(ctx, perm)
 			util.PanicErr(err)
 			perm.ID = id
 			permChan <- perm
 		}(&wg)
 	}
 	wg.Wait()
-	time.Sleep(10 * time.Millisecond)
 	close(permChan)
+	<-collected
 
 	roles := make([]models.Role, 0, 10)
 	partSize := len(permsIn) / 10
@@ -255,7 +285,7 @@ func TestRbac_GetUserPermissions(t *testing.T) {
 		for j := startIndex; j < endIndex; j++ {
 			role.Permissions = append(role.Permissions, permsIn[j].ID)
 		}
-		id, err := rbac.RoleStore.Insert(role)
+		id, err := rbac.AddRole(ctx, role)
 		util.PanicErr(err)
 		role.ID = id
 		roles = append(roles, role)
@@ -271,7 +301,7 @@ func TestRbac_GetUserPermissions(t *testing.T) {
 		for j := start; j < end; j++ {
 			user.Roles = append(user.Roles, roles[j].ID)
 		}
-		id, err := rbac.UserStore.Insert(user)
+		id, err := rbac.AddUser(ctx, user)
 		util.PanicErr(err)
 		user.ID = id
 		users = append(users, user)
@@ -281,9 +311,161 @@ func TestRbac_GetUserPermissions(t *testing.T) {
 		start := i * 20
 		end := (i + 1) * 20
 
-		perms, err := rbac.GetUserPermissions(users[i].UserID)
+		perms, err := rbac.GetUserPermissions(ctx, users[i].UserID)
 		util.PanicErr(err)
-		assert.ElementsMatch(perms, permsIn[start:end])
+		assert.ElementsMatch(unstampedPermissions(perms), permsIn[start:end])
 	}
 
 }
+
+func TestRbac_RunInTx(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rbac := newTestRbac(t)
+
+	errAbort := errors.New("abort")
+	err := rbac.RunInTx(ctx, func(tx *Rbac) error {
+		roleID, err := tx.RoleStore.Insert(ctx, models.Role{Name: "umpire"})
+		if err != nil {
+			return err
+		}
+		_, err = tx.AddUser(ctx, models.User{UserID: "alice", Roles: []int64{roleID}})
+		if err != nil {
+			return err
+		}
+		return errAbort
+	})
+	assert.ErrorIs(err, errAbort)
+	roles, err := rbac.RoleStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Empty(roles)
+	users, err := rbac.UserStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Empty(users)
+
+	err = rbac.RunInTx(ctx, func(tx *Rbac) error {
+		roleID, err := tx.RoleStore.Insert(ctx, models.Role{Name: "umpire"})
+		if err != nil {
+			return err
+		}
+		return tx.RunInTx(ctx, func(tx *Rbac) error {
+			_, err := tx.AddUser(ctx, models.User{UserID: "alice", Roles: []int64{roleID}})
+			return err
+		})
+	})
+	util.PanicErr(err)
+	roles, err = rbac.RoleStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Len(roles, 1)
+	users, err = rbac.UserStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Len(users, 1)
+}
+
+func TestRbac_JoinTables(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rbac := newTestRbac(t)
+	permissionTrash, err := store.AsSoftDeleter(rbac.PermissionStore)
+	util.PanicErr(err)
+	roleTrash, err := store.AsSoftDeleter(rbac.RoleStore)
+	util.PanicErr(err)
+
+	read, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "read"})
+	util.PanicErr(err)
+	write, err := rbac.PermissionStore.Insert(ctx, models.Permission{Name: "write"})
+	util.PanicErr(err)
+	umpire, err := rbac.AddRole(ctx, models.Role{Name: "umpire", Permissions: []int64{read, write, read}})
+	util.PanicErr(err)
+	referee, err := rbac.AddRole(ctx, models.Role{Name: "referee", Permissions: []int64{read}})
+	util.PanicErr(err)
+	_, err = rbac.AddUser(ctx, models.User{UserID: "alice", Roles: []int64{umpire}})
+	util.PanicErr(err)
+
+	_, err = rbac.AddRole(ctx, models.Role{Name: "ghost", Permissions: []int64{write + 100}})
+	assert.Error(err)
+
+	roles, err := rbac.RolesWithPermission(ctx, read)
+	util.PanicErr(err)
+	assert.Len(roles, 2)
+
+	err = rbac.UpdateRole(ctx, referee, models.Role{ID: referee, Name: "referee", Permissions: []int64{write}})
+	assert.ErrorIs(err, store.ErrStaleVersion)
+	util.PanicErr(rbac.UpdateRole(ctx, referee, models.Role{ID: referee, Name: "referee", Permissions: []int64{write}, Version: 1}))
+	updated, err := rbac.RoleStore.GetOne(ctx, referee)
+	util.PanicErr(err)
+	assert.Equal(int64(2), updated.Version)
+	roles, err = rbac.RoleStore.GetMulti(ctx, []int64{umpire, referee})
+	util.PanicErr(err)
+	util.PanicErr(rbac.LoadRolePermissions(ctx, roles))
+	assert.ElementsMatch([]models.Role{
+		{ID: umpire, Name: "umpire", Permissions: []int64{read, write}},
+		{ID: referee, Name: "referee", Permissions: []int64{write}},
+	}, unstampedRoles(roles))
+
+	// purging a permission or a role removes it from every role and user
+	util.PanicErr(rbac.PermissionStore.DeleteMulti(ctx, []int64{write}))
+	roles, err = rbac.RoleStore.GetMulti(ctx, []int64{umpire})
+	util.PanicErr(err)
+	util.PanicErr(rbac.LoadRolePermissions(ctx, roles))
+	assert.Equal([]int64{read}, roles[0].Permissions)
+	_, err = permissionTrash.Purge(ctx, time.Now().Add(time.Second))
+	util.PanicErr(err)
+	links, err := rbac.RolePermissionStore.FindWhere(ctx, store.Eq(models.RolePermissionColPermissionID, write))
+	util.PanicErr(err)
+	assert.Empty(links)
+	roles, err = rbac.RolesWithPermission(ctx, write)
+	util.PanicErr(err)
+	assert.Empty(roles)
+	hasPerm, err := rbac.HasPermission(ctx, "alice", read)
+	util.PanicErr(err)
+	assert.True(hasPerm)
+
+	util.PanicErr(rbac.RoleStore.DeleteMulti(ctx, []int64{umpire}))
+	hasPerm, err = rbac.HasPermission(ctx, "alice", read)
+	util.PanicErr(err)
+	assert.False(hasPerm)
+	_, err = roleTrash.Purge(ctx, time.Now().Add(time.Second))
+	util.PanicErr(err)
+	userLinks, err := rbac.UserRoleStore.FindWhere(ctx)
+	util.PanicErr(err)
+	assert.Empty(userLinks)
+}
+
+func TestMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrations.db")
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// the schema from before the join tables, when ids were kept in JSON arrays
+	legacy, err := sql.Open("sqlite", path)
+	util.PanicErr(err)
+	_, err = legacy.ExecContext(ctx, `
+		CREATE TABLE permission (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, description TEXT NOT NULL);
+		CREATE TABLE role (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, description TEXT NOT NULL, permissions TEXT NOT NULL);
+		CREATE TABLE user (id INTEGER NOT NULL PRIMARY KEY, user_id TEXT NOT NULL, roles TEXT NOT NULL);
+		INSERT INTO permission (id, name, description) VALUES (1, 'read', ''), (2, 'write', '');
+		INSERT INTO role (id, name, description, permissions) VALUES (1, 'umpire', '', CAST('[1,2,9]' AS BLOB)), (2, 'referee', '', 'null');
+		INSERT INTO user (id, user_id, roles) VALUES (1, 'alice', '[1,2]');`)
+	util.PanicErr(err)
+	util.PanicErr(legacy.Close())
+
+	rbac := openTestRbac(t, path)
+
+	// the dangling permission 9 is dropped
+	roles, err := rbac.RoleStore.FindWhere(ctx)
+	util.PanicErr(err)
+	util.PanicErr(rbac.LoadRolePermissions(ctx, roles))
+	assert.ElementsMatch([]models.Role{
+		{ID: 1, Name: "umpire", Permissions: []int64{1, 2}},
+		{ID: 2, Name: "referee", Permissions: []int64{}},
+	}, roles)
+	perms, err := rbac.GetUserPermissions(ctx, "alice")
+	util.PanicErr(err)
+	assert.Len(perms, 2)
+
+	_, err = rbac.RolePermissionStore.Insert(ctx, models.RolePermission{RoleID: 1, PermissionID: 1})
+	assert.ErrorIs(err, store.ErrConflicted)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recoverMiddleware turns a panic in a handler into a logged 500, so that one
+// bad request cannot take the whole server down.
+func recoverMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				// the client went away; net/http handles this panic quietly
+				panic(p)
+			}
+			slog.Error("panic recovered", "method", ctx.Request.Method, "path", ctx.Request.URL.Path,
+				"panic", p, "stack", string(debug.Stack()))
+			if ctx.Writer.Written() {
+				ctx.Abort()
+				return
+			}
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+		}()
+		ctx.Next()
+	}
+}
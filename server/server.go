@@ -21,7 +21,8 @@ import (
 func main() {
 	initLogger()
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), recoverMiddleware())
 	router.Use(static.Serve("/", static.LocalFile("views/assets", false)))
 
 	env := os.Getenv("GIN_MODE")
@@ -1,42 +1,120 @@
 package api
 
 import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/yinloo-ola/tt-app/common/rbac"
 	"github.com/yinloo-ola/tt-app/common/rbac/models"
 	"github.com/yinloo-ola/tt-app/util"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/audit"
+	"github.com/yinloo-ola/tt-app/util/store/cache"
 	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
 	"github.com/yinloo-ola/tt-app/util/template"
 )
 
+// storeCache sizes the caches of the permission and role stores, which are read
+// by every page of access control.
+var storeCache = cache.Options{Size: 1000, TTL: time.Minute}
+
 func AddAPIs(routerGroup *gin.RouterGroup, templates template.TemplateExecutor) {
 	path := "rbac.db"
-	permissionStore, err := sqlitestore.NewStore[models.Permission](path)
+	util.PanicErr(restoreFromEnv(path))
+	db, err := sqlitestore.Open(path)
+	util.PanicErr(err)
+	err = db.Migrate(context.Background(), rbac.Migrations)
+	util.PanicErr(err)
+	permissionStore, err := sqlitestore.NewStoreFromDB[models.Permission](db)
 	util.PanicErr(err)
-	roleStore, err := sqlitestore.NewStore[models.Role](path)
+	roleStore, err := sqlitestore.NewStoreFromDB[models.Role](db)
 	util.PanicErr(err)
-	userStore, err := sqlitestore.NewStore[models.User](path)
+	userStore, err := sqlitestore.NewStoreFromDB[models.User](db)
 	util.PanicErr(err)
-	rbacStore := rbac.NewRbac(
-		permissionStore, roleStore, userStore,
+	rolePermissionStore, err := sqlitestore.NewStoreFromDB[models.RolePermission](db)
+	util.PanicErr(err)
+	userRoleStore, err := sqlitestore.NewStoreFromDB[models.UserRole](db)
+	util.PanicErr(err)
+	auditLog, err := sqlitestore.NewStoreFromDB[audit.Entry](db)
+	util.PanicErr(err)
+	// every change goes to the audit log, see GetAudit
+	permissions := cache.NewStore(audit.NewStore(db, permissionStore, auditLog, "permission"), storeCache)
+	roles := cache.NewStore(audit.NewStore(db, roleStore, auditLog, "role"), storeCache)
+	rbacStore := rbac.NewRbac(db, permissions, roles,
+		audit.NewStore(db, userStore, auditLog, "user"),
+		audit.NewStore(db, rolePermissionStore, auditLog, "role_permission"),
+		audit.NewStore(db, userRoleStore, auditLog, "user_role"),
 	)
+	go store.PurgeEvery(context.Background(), trashPurgeInterval, trashRetention, permissions, roles)
+	go db.SnapshotEvery(context.Background(), backupDir, backupInterval, backupKeep)
 	ctrl := &APIAccessController{
 		RbacStore: rbacStore,
+		AuditLog:  auditLog,
+		DB:        db,
 		templates: templates,
 	}
+	trustedProxies, err := parseTrustedProxies(os.Getenv(trustedProxiesEnv))
+	util.PanicErr(err)
+	routerGroup.Use(auditActor(trustedProxies))
 	routerGroup.GET("/permissions", ctrl.GetPermissions)
 	routerGroup.POST("/permissions", ctrl.AddPermission)
 	routerGroup.PUT("/permissions", ctrl.UpdatePermission)
 	routerGroup.GET("/permission_modal", ctrl.PermissionModal)
+	routerGroup.GET("/permission_delete_modal", ctrl.PermissionDeleteModal)
 	routerGroup.DELETE("/permissions/:id", ctrl.DeletePermission)
+	routerGroup.POST("/permissions/:id/restore", ctrl.RestorePermission)
 
 	routerGroup.GET("/roles", ctrl.GetRoles)
 	routerGroup.POST("/roles", ctrl.AddRole)
 	routerGroup.PUT("/roles", ctrl.UpdateRole)
+	routerGroup.GET("/role_modal", ctrl.RoleModal)
+	routerGroup.GET("/role_delete_modal", ctrl.RoleDeleteModal)
 	routerGroup.DELETE("/roles/:id", ctrl.DeleteRole)
+	routerGroup.POST("/roles/:id/restore", ctrl.RestoreRole)
+
+	routerGroup.GET("/trash", ctrl.GetTrash)
+
+	routerGroup.GET("/audit", ctrl.GetAudit)
+	routerGroup.GET("/audit/export", ctrl.ExportAudit)
+
+	routerGroup.GET("/search", ctrl.Search)
+
+	routerGroup.GET("/backups", ctrl.GetBackups)
+	routerGroup.POST("/backups", ctrl.AddBackup)
+	routerGroup.GET("/backups/:name", ctrl.DownloadBackup)
 }
 
 type APIAccessController struct {
 	RbacStore *rbac.Rbac
+	AuditLog  audit.Log
+	// DB is the database of the stores, see GetBackups.
+	DB        *sqlitestore.DB
 	templates template.TemplateExecutor
 }
+
+// deleteModalError shows err in the delete modal and leaves the row in place.
+func (o *APIAccessController) deleteModalError(ctx *gin.Context, err error) {
+	ctx.Header("HX-Reswap", "none")
+	ctx.HTML(http.StatusConflict, "error", gin.H{
+		"ElementID": "delete-modal-error",
+		"Body":      err.Error(),
+	})
+}
+
+// staleVersionError tells the user of an edit modal that the kind they are
+// editing was changed by someone else since the modal was opened, and offers
+// to reload the modal from reloadURL. The row is left in place.
+func (o *APIAccessController) staleVersionError(ctx *gin.Context, elementID, kind, modalID, reloadURL string) {
+	ctx.Header("HX-Reswap", "none")
+	ctx.HTML(http.StatusConflict, "error", gin.H{
+		"ElementID": elementID,
+		"Body": o.templates.TemplateHTML("stale_version", gin.H{
+			"Kind":      kind,
+			"ModalID":   modalID,
+			"ReloadURL": reloadURL,
+		}),
+	})
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/audit"
+)
+
+const auditPageSize = 50
+
+// trustedProxiesEnv lists the addresses of the authenticating proxies whose
+// X-Forwarded-User header names the actor of a request, separated by commas.
+// An entry may be a CIDR range, e.g. 10.0.0.1,192.168.0.0/24.
+const trustedProxiesEnv = "RBAC_TRUSTED_PROXIES"
+
+// parseTrustedProxies parses the value of trustedProxiesEnv.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", trustedProxiesEnv, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", trustedProxiesEnv, err)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return proxies, nil
+}
+
+// auditActor makes the user named by the X-Forwarded-User header of an
+// authenticating proxy the actor of the changes of a request. The header is
+// only read from the proxies listed in trustedProxies, as any client can send
+// it: the actor of the requests of other clients is their address.
+func auditActor(trustedProxies []netip.Prefix) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		actor := ctx.RemoteIP()
+		if isTrustedProxy(trustedProxies, actor) {
+			actor = ctx.GetHeader("X-Forwarded-User")
+			if len(actor) == 0 {
+				actor = ctx.ClientIP()
+			}
+		}
+		ctx.Request = ctx.Request.WithContext(audit.WithActor(ctx.Request.Context(), actor))
+		ctx.Next()
+	}
+}
+
+func isTrustedProxy(trustedProxies []netip.Prefix, remoteIP string) bool {
+	addr, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditQuery reads the filters and the order of the audit log from the url.
+func auditQuery(ctx *gin.Context) (store.Query, error) {
+	q, err := audit.EntryWhitelist.ParseQuery(ctx.Request.URL.Query())
+	if err != nil {
+		return q, err
+	}
+	if len(q.OrderBy) == 0 {
+		q.OrderBy = []store.Order{{Field: audit.EntryColAt, Dir: store.Desc}}
+	}
+	return q, nil
+}
+
+func (o *APIAccessController) GetAudit(ctx *gin.Context) {
+	slog.Debug("GetAudit")
+	q, err := auditQuery(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "auditQuery()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid audit filter"))
+		return
+	}
+	q.Limit = auditPageSize
+	q.After = store.Cursor(ctx.Query("cursor"))
+	page, err := o.AuditLog.FindPage(ctx.Request.Context(), q)
+	if err != nil {
+		slog.ErrorContext(ctx, "AuditLog.FindPage()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrUnknownField) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid audit query"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve audit log"))
+		return
+	}
+	entries := make([]gin.H, 0, len(page.Items))
+	for _, entry := range page.Items {
+		entries = append(entries, gin.H{
+			"At":       time.UnixMilli(entry.At).Format(time.DateTime),
+			"Actor":    entry.Actor,
+			"Action":   entry.Action,
+			"Entity":   entry.Entity,
+			"EntityID": entry.EntityID,
+			"Before":   entry.Before,
+			"After":    entry.After,
+		})
+	}
+
+	if ctx.GetHeader("Hx-Target") == "audit-list" {
+		pageContent := gin.H{
+			"Entries":  entries,
+			"Total":    o.auditListTotal(page.Total, true),
+			"LoadMore": o.auditLoadMore(page.Next, true),
+		}
+		// the summary only changes with the filters, not with the page
+		if len(q.After) == 0 {
+			if pageContent["Summary"], err = o.auditSummary(ctx, q.Where, true); err != nil {
+				slog.ErrorContext(ctx, "auditSummary()", slog.String("error", err.Error()))
+				_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve audit log"))
+				return
+			}
+		}
+		ctx.HTML(200, "audit_page", pageContent)
+		return
+	}
+
+	summary, err := o.auditSummary(ctx, q.Where, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "auditSummary()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve audit log"))
+		return
+	}
+	auditContent := gin.H{
+		"Entries":  entries,
+		"Summary":  summary,
+		"Total":    o.auditListTotal(page.Total, false),
+		"LoadMore": o.auditLoadMore(page.Next, false),
+		"Filter": gin.H{
+			"Actor":    ctx.Query(audit.EntryColActor + "__contains"),
+			"Action":   ctx.Query(audit.EntryColAction),
+			"Entity":   ctx.Query(audit.EntryColEntity),
+			"EntityID": ctx.Query(audit.EntryColEntityID),
+		},
+		"Actions": []audit.Action{
+			audit.ActionCreate, audit.ActionUpdate, audit.ActionDelete, audit.ActionRestore, audit.ActionPurge,
+		},
+		"Entities": []string{"permission", "role", "user", "role_permission", "user_role"},
+	}
+
+	isHx := ctx.GetHeader("HX-Request")
+	if isHx == "true" {
+		if ctx.GetHeader("Hx-Target") == "ac-contents" {
+			ctx.HTML(200, "audit", auditContent)
+			return
+		}
+		ctx.HTML(200, "access_control", gin.H{
+			"Body": o.templates.TemplateHTML("audit", auditContent),
+		})
+		return
+	}
+
+	ctx.HTML(200, "base", gin.H{
+		"Title": "TT App - Access Control",
+		"App":   "Table Tennis App",
+		"Main": o.templates.TemplateHTML("access_control", gin.H{
+			"Body": o.templates.TemplateHTML("audit", auditContent),
+		}),
+	})
+}
+
+// ExportAudit downloads every entry matching the filters of GetAudit, as csv
+// or as json. The entries are streamed in the order they were recorded, so
+// that a large export is never loaded at once.
+func (o *APIAccessController) ExportAudit(ctx *gin.Context) {
+	slog.Debug("ExportAudit")
+	format := ctx.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid export format"))
+		return
+	}
+	q, err := auditQuery(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "auditQuery()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid audit filter"))
+		return
+	}
+
+	var write func(entry audit.Entry) error
+	var end func() error
+	if format == "json" {
+		ctx.Header("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(ctx.Writer)
+		sep := "["
+		write = func(entry audit.Entry) error {
+			if _, err := ctx.Writer.WriteString(sep); err != nil {
+				return err
+			}
+			sep = ","
+			return enc.Encode(entry)
+		}
+		end = func() error {
+			if sep == "[" {
+				_, err := ctx.Writer.WriteString("[]")
+				return err
+			}
+			_, err := ctx.Writer.WriteString("]")
+			return err
+		}
+	} else {
+		ctx.Header("Content-Type", "text/csv; charset=utf-8")
+		// the csv writer buffers, so a failing query still gets its status
+		w := csv.NewWriter(ctx.Writer)
+		_ = w.Write([]string{"at", "actor", "action", "entity", "entity_id", "before", "after"})
+		write = func(entry audit.Entry) error {
+			return w.Write([]string{
+				time.UnixMilli(entry.At).UTC().Format(time.RFC3339Nano),
+				entry.Actor,
+				string(entry.Action),
+				entry.Entity,
+				strconv.FormatInt(entry.EntityID, 10),
+				entry.Before,
+				entry.After,
+			})
+		}
+		end = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=audit-log.%s", format))
+	err = o.AuditLog.Each(ctx.Request.Context(), write, q.Where...)
+	if err == nil {
+		err = end()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "AuditLog.Each()", slog.String("error", err.Error()))
+		// once the download started, the client only sees it cut short
+		if ctx.Writer.Written() {
+			return
+		}
+		ctx.Header("Content-Disposition", "")
+		if errors.Is(err, store.ErrUnknownField) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid audit query"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve audit log"))
+	}
+}
+
+// auditSummary counts the entries matching where by action.
+func (o *APIAccessController) auditSummary(ctx *gin.Context, where []store.Cond, oob bool) (gin.H, error) {
+	groups, err := o.AuditLog.Aggregate(ctx.Request.Context(), store.Aggregation{
+		Func:    store.AggCount,
+		GroupBy: []string{audit.EntryColAction},
+		Where:   where,
+	})
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]gin.H, 0, len(groups))
+	for _, group := range groups {
+		actions = append(actions, gin.H{"Action": group.Keys[0], "Count": int64(group.Value)})
+	}
+	return gin.H{"ElementID": "audit-summary", "Actions": actions, "OOB": oob}, nil
+}
+
+func (o *APIAccessController) auditListTotal(total int64, oob bool) gin.H {
+	return gin.H{"ElementID": "audit-total", "Total": total, "Noun": "changes", "OOB": oob}
+}
+
+func (o *APIAccessController) auditLoadMore(next store.Cursor, oob bool) gin.H {
+	return gin.H{
+		"ElementID": "audit-load-more",
+		"URL":       "/access_control/audit",
+		"Target":    "audit-list",
+		"Include":   "audit-filter",
+		"Next":      next,
+		"OOB":       oob,
+	}
+}
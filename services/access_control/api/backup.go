@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
+)
+
+// backupDir holds the snapshots of rbac.db, taken every backupInterval. The
+// newest backupKeep are kept, a week's worth.
+const (
+	backupDir      = "backups"
+	backupInterval = 6 * time.Hour
+	backupKeep     = 28
+)
+
+// restoreEnv names the snapshot to restore rbac.db from at startup: either the
+// path of a snapshot, or a time in RFC 3339, e.g. 2026-10-18T09:00:00Z, which
+// restores the newest snapshot in backupDir taken at or before it. The restore
+// runs once: later startups skip it while the file it replaced is kept.
+const restoreEnv = "RBAC_RESTORE"
+
+func restoreFromEnv(path string) error {
+	from := os.Getenv(restoreEnv)
+	if len(from) == 0 {
+		return nil
+	}
+	restored, err := sqlitestore.Restored(path)
+	if err != nil {
+		return err
+	}
+	if restored {
+		slog.Warn("skipped restore, the file replaced by an earlier restore is kept",
+			slog.String("path", path), slog.String(restoreEnv, from))
+		return nil
+	}
+	if at, err := time.Parse(time.RFC3339, from); err == nil {
+		snapshot, err := sqlitestore.SnapshotAt(backupDir, filepath.Base(path), at)
+		if err != nil {
+			return err
+		}
+		from = snapshot.Path
+	}
+	if err := sqlitestore.Restore(path, from); err != nil {
+		return err
+	}
+	slog.Info("restored snapshot", slog.String("path", path), slog.String("snapshot", from))
+	return nil
+}
+
+func (o *APIAccessController) GetBackups(ctx *gin.Context) {
+	slog.Debug("GetBackups")
+	snapshots, err := sqlitestore.Snapshots(backupDir, filepath.Base(o.DB.Path()))
+	if err != nil {
+		slog.ErrorContext(ctx, "sqlitestore.Snapshots()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve backups"))
+		return
+	}
+	items := make([]gin.H, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		items = append(items, backupItem(snapshot))
+	}
+	backupsContent := gin.H{
+		"IntervalHours": int(backupInterval.Hours()),
+		"Keep":          backupKeep,
+		"RestoreEnv":    restoreEnv,
+		"Items":         items,
+	}
+
+	isHx := ctx.GetHeader("HX-Request")
+	if isHx == "true" {
+		if ctx.GetHeader("Hx-Target") == "ac-contents" {
+			ctx.HTML(200, "backups", backupsContent)
+			return
+		}
+		ctx.HTML(200, "access_control", gin.H{
+			"Body": o.templates.TemplateHTML("backups", backupsContent),
+		})
+		return
+	}
+
+	ctx.HTML(200, "base", gin.H{
+		"Title": "TT App - Access Control",
+		"App":   "Table Tennis App",
+		"Main": o.templates.TemplateHTML("access_control", gin.H{
+			"Body": o.templates.TemplateHTML("backups", backupsContent),
+		}),
+	})
+}
+
+// AddBackup takes a snapshot right away, e.g. before a risky change, and
+// returns its row.
+func (o *APIAccessController) AddBackup(ctx *gin.Context) {
+	slog.Debug("AddBackup")
+	snapshot, err := o.DB.Snapshot(ctx.Request.Context(), backupDir)
+	if err != nil {
+		slog.ErrorContext(ctx, "DB.Snapshot()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to back up"))
+		return
+	}
+	if _, err = sqlitestore.PruneSnapshots(backupDir, filepath.Base(o.DB.Path()), backupKeep); err != nil {
+		slog.ErrorContext(ctx, "sqlitestore.PruneSnapshots()", slog.String("error", err.Error()))
+	}
+	ctx.HTML(200, "backup_row", backupItem(snapshot))
+}
+
+// DownloadBackup serves a snapshot listed by GetBackups. Only the names of the
+// snapshots are accepted, so that no other file can be read.
+func (o *APIAccessController) DownloadBackup(ctx *gin.Context) {
+	slog.Debug("DownloadBackup")
+	name := ctx.Param("name")
+	snapshots, err := sqlitestore.Snapshots(backupDir, filepath.Base(o.DB.Path()))
+	if err != nil {
+		slog.ErrorContext(ctx, "sqlitestore.Snapshots()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve backups"))
+		return
+	}
+	for _, snapshot := range snapshots {
+		if filepath.Base(snapshot.Path) == name {
+			ctx.FileAttachment(snapshot.Path, name)
+			return
+		}
+	}
+	_ = ctx.AbortWithError(http.StatusNotFound, errors.New("backup not found"))
+}
+
+func backupItem(snapshot sqlitestore.Snapshot) gin.H {
+	name := filepath.Base(snapshot.Path)
+	return gin.H{
+		"Name":        name,
+		"TakenAt":     snapshot.Time.Local().Format(time.DateTime),
+		"SizeKB":      (snapshot.Size + 1023) / 1024,
+		"DownloadURL": "/access_control/backups/" + name,
+	}
+}
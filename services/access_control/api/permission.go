@@ -9,6 +9,7 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yinloo-ola/tt-app/common/rbac"
 	"github.com/yinloo-ola/tt-app/common/rbac/models"
 
 	"github.com/yinloo-ola/tt-app/util/store"
@@ -24,7 +25,7 @@ func (o *APIAccessController) PermissionModal(ctx *gin.Context) {
 	}
 	actionType, _ := ctx.GetQuery("actionType")
 	slog.Debug("PermissionModal", "id", permissionID, "actionType", actionType)
-	permission, err := o.RbacStore.PermissionStore.GetOne(permissionID)
+	permission, err := o.RbacStore.PermissionStore.GetOne(ctx.Request.Context(), permissionID)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			ctx.AbortWithError(http.StatusNotFound, fmt.Errorf("permission not found: %d", permissionID))
@@ -42,21 +43,50 @@ func (o *APIAccessController) PermissionModal(ctx *gin.Context) {
 			"Name":        permission.Name,
 			"Description": permission.Description,
 			"ID":          permission.ID,
+			"Version":     permission.Version,
 		}),
 	})
 }
 
+const permissionsPageSize = 24
+
 func (o *APIAccessController) GetPermissions(ctx *gin.Context) {
 	slog.Debug("GetPermissions")
-	permissions, err := o.RbacStore.PermissionStore.FindWhere()
+	q, err := models.PermissionWhitelist.ParseQuery(ctx.Request.URL.Query())
 	if err != nil {
-		slog.ErrorContext(ctx, "RbacStore.PermissionStore.FindWhere()", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "models.PermissionWhitelist.ParseQuery()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid permission filter"))
+		return
+	}
+	if len(q.OrderBy) == 0 {
+		q.OrderBy = []store.Order{{Field: models.PermissionColName, Dir: store.Asc}}
+	}
+	q.Limit = permissionsPageSize
+	q.After = store.Cursor(ctx.Query("cursor"))
+	page, err := o.RbacStore.PermissionStore.FindPage(ctx.Request.Context(), q)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.PermissionStore.FindPage()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrUnknownField) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid permission query"))
+			return
+		}
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve permissions"))
 		return
 	}
 
+	if ctx.GetHeader("Hx-Target") == "permission-list" {
+		ctx.HTML(200, "permission_page", gin.H{
+			"Permissions": page.Items,
+			"Total":       o.permissionListTotal(page.Total, true),
+			"LoadMore":    o.permissionLoadMore(page.Next, true),
+		})
+		return
+	}
+
 	permissionsContent := gin.H{
-		"Permissions": permissions,
+		"Permissions": page.Items,
+		"Total":       o.permissionListTotal(page.Total, false),
+		"LoadMore":    o.permissionLoadMore(page.Next, false),
 		"NewPermissionModal": gin.H{
 			"IsHidden":  true,
 			"ElementID": "new-permission-modal",
@@ -97,7 +127,7 @@ func (o *APIAccessController) AddPermission(ctx *gin.Context) {
 		return
 	}
 	slog.Debug("permission to add", "permission", permission)
-	id, err := o.RbacStore.PermissionStore.Insert(permission)
+	id, err := o.RbacStore.PermissionStore.Insert(ctx.Request.Context(), permission)
 	if err != nil {
 		slog.ErrorContext(ctx, "RbacStore.PermissionStore.Insert()", slog.String("error", err.Error()))
 		if errors.Is(err, store.ErrConflicted) {
@@ -125,7 +155,7 @@ func (o *APIAccessController) UpdatePermission(ctx *gin.Context) {
 		return
 	}
 	slog.Debug("update permission", "permission", permission)
-	err = o.RbacStore.PermissionStore.Update(permission.ID, permission)
+	err = o.RbacStore.PermissionStore.Update(ctx.Request.Context(), permission.ID, permission)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
 			slog.ErrorContext(ctx, "RbacStore.PermissionStore.Update()", slog.String("error", err.Error()))
@@ -139,13 +169,67 @@ func (o *APIAccessController) UpdatePermission(ctx *gin.Context) {
 			})
 			return
 		}
+		if errors.Is(err, store.ErrStaleVersion) {
+			slog.InfoContext(ctx, "RbacStore.PermissionStore.Update()", slog.String("error", err.Error()))
+			o.staleVersionError(ctx, "permission-form-error", "permission", "update-permission-modal",
+				fmt.Sprintf("/access_control/permission_modal?id=%d&actionType=update", permission.ID))
+			return
+		}
 		slog.ErrorContext(ctx, "RbacStore.PermissionStore.Update()", slog.String("error", err.Error()))
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to insert permission"))
 		return
 	}
+	// the store bumps the version, so render the row as saved
+	permission, err = o.RbacStore.PermissionStore.GetOne(ctx.Request.Context(), permission.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.PermissionStore.GetOne()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve permission"))
+		return
+	}
 	ctx.HTML(200, "permission_row", permission)
 }
 
+func (o *APIAccessController) PermissionDeleteModal(ctx *gin.Context) {
+	slog.Debug("PermissionDeleteModal")
+	permissionID, err := strconv.ParseInt(ctx.Query("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	permission, err := o.RbacStore.PermissionStore.GetOne(ctx.Request.Context(), permissionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			ctx.AbortWithError(http.StatusNotFound, fmt.Errorf("permission not found: %d", permissionID))
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve permission: %d. Error: %v", permissionID, err))
+		return
+	}
+	roles, err := o.RbacStore.RolesWithPermission(ctx.Request.Context(), permissionID)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.RolesWithPermission()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve roles of permission"))
+		return
+	}
+	affected := make([]string, 0, len(roles))
+	for _, role := range roles {
+		affected = append(affected, role.Name)
+	}
+
+	ctx.HTML(200, "modal_once", gin.H{
+		"IsHidden":  false,
+		"ElementID": "delete-permission-modal",
+		"Body": o.templates.TemplateHTML("delete_modal", gin.H{
+			"Kind":         "permission",
+			"Name":         permission.Name,
+			"URL":          fmt.Sprintf("/access_control/permissions/%d", permission.ID),
+			"RowTarget":    fmt.Sprintf("#permission-row-%d", permission.ID),
+			"Affected":     affected,
+			"AffectedNoun": "roles",
+		}),
+	})
+}
+
 func (o *APIAccessController) DeletePermission(ctx *gin.Context) {
 	slog.Debug("DeletePermission")
 	idStr := ctx.Param("id")
@@ -155,15 +239,39 @@ func (o *APIAccessController) DeletePermission(ctx *gin.Context) {
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to delete permission"))
 		return
 	}
-	err = o.RbacStore.PermissionStore.DeleteMulti([]int64{id})
+	policy, err := rbac.ParseDeletePolicy(ctx.Query("policy"))
+	if err != nil {
+		slog.ErrorContext(ctx, "rbac.ParseDeletePolicy()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = o.RbacStore.DeletePermission(ctx.Request.Context(), id, policy)
 	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.DeletePermission()", slog.String("error", err.Error()))
 		if errors.Is(err, store.ErrNotFound) {
-			slog.ErrorContext(ctx, "RbacStore.PermissionStore.DeleteMulti()", slog.String("error", err.Error()))
 			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("permission not found"))
 			return
 		}
-		slog.ErrorContext(ctx, "RbacStore.PermissionStore.DeleteMulti()", slog.String("error", err.Error()))
+		if errors.Is(err, rbac.ErrInUse) {
+			o.deleteModalError(ctx, err)
+			return
+		}
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to delete permission"))
 		return
 	}
 }
+
+func (o *APIAccessController) permissionListTotal(total int64, oob bool) gin.H {
+	return gin.H{"ElementID": "permission-total", "Total": total, "Noun": "permissions", "OOB": oob}
+}
+
+func (o *APIAccessController) permissionLoadMore(next store.Cursor, oob bool) gin.H {
+	return gin.H{
+		"ElementID": "permission-load-more",
+		"URL":       "/access_control/permissions",
+		"Target":    "permission-list",
+		"Include":   "permission-filter",
+		"Next":      next,
+		"OOB":       oob,
+	}
+}
@@ -1,55 +1,85 @@
 package api
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"html/template"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yinloo-ola/tt-app/common/rbac"
 	"github.com/yinloo-ola/tt-app/common/rbac/models"
 	"github.com/yinloo-ola/tt-app/util/store"
 )
 
+const rolesPageSize = 24
+
 func (o *APIAccessController) GetRoles(ctx *gin.Context) {
 	slog.Debug("GetRoles")
-	// role, err := o.RbacStore.RoleStore.FindWhere()
-	// if err != nil {
-	// 	slog.ErrorContext(ctx, "RbacStore.RoleStore.FindWhere()", slog.String("error", err.Error()))
-	// 	_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve roles"))
-	// 	return
-	// }
+	q, err := models.RoleWhitelist.ParseQuery(ctx.Request.URL.Query())
+	if err != nil {
+		slog.ErrorContext(ctx, "models.RoleWhitelist.ParseQuery()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid role filter"))
+		return
+	}
+	if len(q.OrderBy) == 0 {
+		q.OrderBy = []store.Order{{Field: models.RoleColName, Dir: store.Asc}}
+	}
+	q.Limit = rolesPageSize
+	q.After = store.Cursor(ctx.Query("cursor"))
+	page, err := o.RbacStore.RoleStore.FindPage(ctx.Request.Context(), q)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.RoleStore.FindPage()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrUnknownField) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid role query"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve roles"))
+		return
+	}
+	err = o.RbacStore.LoadRolePermissions(ctx.Request.Context(), page.Items)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.LoadRolePermissions()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve roles"))
+		return
+	}
+
+	if ctx.GetHeader("Hx-Target") == "role-list" {
+		ctx.HTML(200, "role_page", gin.H{
+			"Roles":    page.Items,
+			"Total":    o.roleListTotal(page.Total, true),
+			"LoadMore": o.roleLoadMore(page.Next, true),
+		})
+		return
+	}
+
+	rolesContent := gin.H{
+		"Roles":    page.Items,
+		"Total":    o.roleListTotal(page.Total, false),
+		"LoadMore": o.roleLoadMore(page.Next, false),
+	}
 
 	isHx := ctx.GetHeader("HX-Request")
 	if isHx == "true" {
 		if ctx.GetHeader("Hx-Target") == "ac-contents" {
-			ctx.HTML(200, "roles", nil)
+			ctx.HTML(200, "roles", rolesContent)
 			return
 		}
-		rolesBuf := bytes.NewBufferString("")
-		o.templates.ExecuteTemplate(rolesBuf, "roles", nil)
-
 		ctx.HTML(200, "access_control", gin.H{
-			"Body": template.HTML(rolesBuf.String()),
+			"Body": o.templates.TemplateHTML("roles", rolesContent),
 		})
 		return
 	}
-	rolesBuf := bytes.NewBufferString("")
-	o.templates.ExecuteTemplate(rolesBuf, "roles", nil)
-	buf := bytes.NewBufferString("")
-	o.templates.ExecuteTemplate(buf, "access_control", gin.H{
-		"Body": template.HTML(rolesBuf.String()),
-	})
+
 	ctx.HTML(200, "base", gin.H{
 		"Title": "TT App - Access Control",
 		"App":   "Table Tennis App",
-		"Main":  template.HTML(buf.String()),
+		"Main": o.templates.TemplateHTML("access_control", gin.H{
+			"Body": o.templates.TemplateHTML("roles", rolesContent),
+		}),
 	})
-
-	// ctx.JSON(http.StatusOK, role)
 }
 
 func (o *APIAccessController) AddRole(ctx *gin.Context) {
@@ -61,9 +91,9 @@ func (o *APIAccessController) AddRole(ctx *gin.Context) {
 		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("fail to bind body to role"))
 		return
 	}
-	id, err := o.RbacStore.RoleStore.Insert(role)
+	id, err := o.RbacStore.AddRole(ctx.Request.Context(), role)
 	if err != nil {
-		slog.ErrorContext(ctx, "RbacStore.RoleStore.Insert()", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "RbacStore.AddRole()", slog.String("error", err.Error()))
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to insert role"))
 		return
 	}
@@ -71,25 +101,156 @@ func (o *APIAccessController) AddRole(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, role)
 }
 
+// RoleModal shows the form editing the role of the id query parameter.
+func (o *APIAccessController) RoleModal(ctx *gin.Context) {
+	slog.Debug("RoleModal")
+	roleID, err := strconv.ParseInt(ctx.Query("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	role, err := o.RbacStore.RoleStore.GetOne(ctx.Request.Context(), roleID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			ctx.AbortWithError(http.StatusNotFound, fmt.Errorf("role not found: %d", roleID))
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve role: %d. Error: %v", roleID, err))
+		return
+	}
+	roles := []models.Role{role}
+	err = o.RbacStore.LoadRolePermissions(ctx.Request.Context(), roles)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.LoadRolePermissions()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve permissions of role"))
+		return
+	}
+	// the picker only shows the names, so leave the rest of the rows undecoded
+	permissions, err := store.Project[struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}](ctx.Request.Context(), o.RbacStore.PermissionStore)
+	if err != nil {
+		slog.ErrorContext(ctx, "store.Project()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve permissions"))
+		return
+	}
+	options := make([]gin.H, 0, len(permissions))
+	for _, permission := range permissions {
+		options = append(options, gin.H{
+			"ID":      permission.ID,
+			"Name":    permission.Name,
+			"Checked": slices.Contains(roles[0].Permissions, permission.ID),
+		})
+	}
+
+	ctx.HTML(200, "modal_once", gin.H{
+		"IsHidden":  false,
+		"ElementID": "update-role-modal",
+		"Body": o.templates.TemplateHTML("role_modal", gin.H{
+			"ID":          role.ID,
+			"Name":        role.Name,
+			"Description": role.Description,
+			"Version":     role.Version,
+			"Permissions": options,
+		}),
+	})
+}
+
+// UpdateRole saves a role sent as JSON or by the form of RoleModal, to which
+// it answers with the updated row.
 func (o *APIAccessController) UpdateRole(ctx *gin.Context) {
 	var role models.Role
-	err := ctx.BindJSON(&role)
+	err := ctx.Bind(&role)
 	if err != nil {
-		slog.ErrorContext(ctx, "ctx.BindJSON()", slog.String("error", err.Error()))
+		slog.ErrorContext(ctx, "ctx.Bind()", slog.String("error", err.Error()))
 		_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("fail to bind body to role"))
 		return
 	}
-	err = o.RbacStore.RoleStore.Update(role.ID, role)
+	err = o.RbacStore.UpdateRole(ctx.Request.Context(), role.ID, role)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			slog.ErrorContext(ctx, "RbacStore.RoleStore.Insert()", slog.String("error", err.Error()))
+			slog.ErrorContext(ctx, "RbacStore.UpdateRole()", slog.String("error", err.Error()))
 			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("role not found"))
 			return
 		}
-		slog.ErrorContext(ctx, "RbacStore.RoleStore.Insert()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrConflicted) {
+			ctx.Header("HX-Reswap", "none")
+			ctx.HTML(409, "error", gin.H{
+				"ElementID": "role-form-error",
+				"Body":      "Role with the same name exists",
+			})
+			return
+		}
+		if errors.Is(err, store.ErrStaleVersion) {
+			slog.InfoContext(ctx, "RbacStore.UpdateRole()", slog.String("error", err.Error()))
+			o.staleVersionError(ctx, "role-form-error", "role", "update-role-modal",
+				fmt.Sprintf("/access_control/role_modal?id=%d", role.ID))
+			return
+		}
+		slog.ErrorContext(ctx, "RbacStore.UpdateRole()", slog.String("error", err.Error()))
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to insert role"))
 		return
 	}
+	if ctx.GetHeader("HX-Request") != "true" {
+		return
+	}
+	// the store bumps the version, so render the row as saved
+	role, err = o.RbacStore.RoleStore.GetOne(ctx.Request.Context(), role.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.RoleStore.GetOne()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve role"))
+		return
+	}
+	roles := []models.Role{role}
+	err = o.RbacStore.LoadRolePermissions(ctx.Request.Context(), roles)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.LoadRolePermissions()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve permissions of role"))
+		return
+	}
+	ctx.HTML(200, "role_row", roles[0])
+}
+
+func (o *APIAccessController) RoleDeleteModal(ctx *gin.Context) {
+	slog.Debug("RoleDeleteModal")
+	roleID, err := strconv.ParseInt(ctx.Query("id"), 10, 64)
+	if err != nil {
+		ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	role, err := o.RbacStore.RoleStore.GetOne(ctx.Request.Context(), roleID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			ctx.AbortWithError(http.StatusNotFound, fmt.Errorf("role not found: %d", roleID))
+			return
+		}
+		ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to retrieve role: %d. Error: %v", roleID, err))
+		return
+	}
+	users, err := o.RbacStore.UsersWithRole(ctx.Request.Context(), roleID)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.UsersWithRole()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve users of role"))
+		return
+	}
+	affected := make([]string, 0, len(users))
+	for _, user := range users {
+		affected = append(affected, user.UserID)
+	}
+
+	ctx.HTML(200, "modal_once", gin.H{
+		"IsHidden":  false,
+		"ElementID": "delete-role-modal",
+		"Body": o.templates.TemplateHTML("delete_modal", gin.H{
+			"Kind":         "role",
+			"Name":         role.Name,
+			"URL":          fmt.Sprintf("/access_control/roles/%d", role.ID),
+			"RowTarget":    fmt.Sprintf("#role-row-%d", role.ID),
+			"Affected":     affected,
+			"AffectedNoun": "users",
+		}),
+	})
 }
 
 func (o *APIAccessController) DeleteRole(ctx *gin.Context) {
@@ -101,15 +262,39 @@ func (o *APIAccessController) DeleteRole(ctx *gin.Context) {
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to delete role"))
 		return
 	}
-	err = o.RbacStore.RoleStore.DeleteMulti([]int64{id})
+	policy, err := rbac.ParseDeletePolicy(ctx.Query("policy"))
 	if err != nil {
+		slog.ErrorContext(ctx, "rbac.ParseDeletePolicy()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	err = o.RbacStore.DeleteRole(ctx.Request.Context(), id, policy)
+	if err != nil {
+		slog.ErrorContext(ctx, "RbacStore.DeleteRole()", slog.String("error", err.Error()))
 		if errors.Is(err, store.ErrNotFound) {
-			slog.ErrorContext(ctx, "RbacStore.RoleStore.DeleteMulti()", slog.String("error", err.Error()))
 			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("role not found"))
 			return
 		}
-		slog.ErrorContext(ctx, "RbacStore.RoleStore.DeleteMulti()", slog.String("error", err.Error()))
+		if errors.Is(err, rbac.ErrInUse) {
+			o.deleteModalError(ctx, err)
+			return
+		}
 		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to delete role"))
 		return
 	}
 }
+
+func (o *APIAccessController) roleListTotal(total int64, oob bool) gin.H {
+	return gin.H{"ElementID": "role-total", "Total": total, "Noun": "roles", "OOB": oob}
+}
+
+func (o *APIAccessController) roleLoadMore(next store.Cursor, oob bool) gin.H {
+	return gin.H{
+		"ElementID": "role-load-more",
+		"URL":       "/access_control/roles",
+		"Target":    "role-list",
+		"Include":   "role-filter",
+		"Next":      next,
+		"OOB":       oob,
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yinloo-ola/tt-app/common/rbac/models"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// searchLimit is the number of results of each kind shown by the search box.
+const searchLimit = 5
+
+// Search serves the search box of the base page, finding permissions and roles
+// by name and description.
+func (o *APIAccessController) Search(ctx *gin.Context) {
+	slog.Debug("Search")
+	query := strings.TrimSpace(ctx.Query("q"))
+	if len(query) == 0 {
+		ctx.HTML(200, "search_results", gin.H{})
+		return
+	}
+	opts := store.SearchOptions{Limit: searchLimit}
+	permissions, err := store.Search(ctx.Request.Context(), o.RbacStore.PermissionStore, query, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "PermissionStore.Search()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to search"))
+		return
+	}
+	roles, err := store.Search(ctx.Request.Context(), o.RbacStore.RoleStore, query, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "RoleStore.Search()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to search"))
+		return
+	}
+
+	sections := make([]gin.H, 0, 2)
+	if len(permissions) > 0 {
+		items := make([]gin.H, 0, len(permissions))
+		for _, permission := range permissions {
+			items = append(items, searchItem(permission.Snippets[models.PermissionColName], permission.Snippets[models.PermissionColDescription]))
+		}
+		sections = append(sections, gin.H{"Title": "Permissions", "URL": "/access_control/permissions", "Items": items})
+	}
+	if len(roles) > 0 {
+		items := make([]gin.H, 0, len(roles))
+		for _, role := range roles {
+			items = append(items, searchItem(role.Snippets[models.RoleColName], role.Snippets[models.RoleColDescription]))
+		}
+		sections = append(sections, gin.H{"Title": "Roles", "URL": "/access_control/roles", "Items": items})
+	}
+	ctx.HTML(200, "search_results", gin.H{
+		"Query":    query,
+		"Sections": sections,
+	})
+}
+
+// searchItem splits the snippets of a result for the template to mark up the
+// matches.
+func searchItem(name, description string) gin.H {
+	return gin.H{
+		"Name":        store.Highlights(name),
+		"Description": store.Highlights(description),
+	}
+}
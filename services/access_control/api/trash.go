@@ -0,0 +1,155 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// trashRetention is how long deleted permissions and roles stay in the trash
+// before they are purged.
+const trashRetention = 30 * 24 * time.Hour
+
+const trashPurgeInterval = time.Hour
+
+func (o *APIAccessController) GetTrash(ctx *gin.Context) {
+	slog.Debug("GetTrash")
+	permissionTrash, err := store.AsSoftDeleter(o.RbacStore.PermissionStore)
+	if err != nil {
+		slog.ErrorContext(ctx, "store.AsSoftDeleter()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve trash"))
+		return
+	}
+	permissions, err := permissionTrash.FindDeleted(ctx.Request.Context())
+	if err != nil {
+		slog.ErrorContext(ctx, "PermissionStore.FindDeleted()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve trash"))
+		return
+	}
+	roleTrash, err := store.AsSoftDeleter(o.RbacStore.RoleStore)
+	if err != nil {
+		slog.ErrorContext(ctx, "store.AsSoftDeleter()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve trash"))
+		return
+	}
+	roles, err := roleTrash.FindDeleted(ctx.Request.Context())
+	if err != nil {
+		slog.ErrorContext(ctx, "RoleStore.FindDeleted()", slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to retrieve trash"))
+		return
+	}
+
+	permissionItems := make([]gin.H, 0, len(permissions))
+	for _, permission := range permissions {
+		permissionItems = append(permissionItems, trashItem("permission", permission.ID, permission.Name, permission.DeletedAt))
+	}
+	roleItems := make([]gin.H, 0, len(roles))
+	for _, role := range roles {
+		roleItems = append(roleItems, trashItem("role", role.ID, role.Name, role.DeletedAt))
+	}
+	trashContent := gin.H{
+		"RetentionDays": int(trashRetention.Hours() / 24),
+		"Sections": []gin.H{
+			{"Title": "Permissions", "Noun": "permissions", "Items": permissionItems},
+			{"Title": "Roles", "Noun": "roles", "Items": roleItems},
+		},
+	}
+
+	isHx := ctx.GetHeader("HX-Request")
+	if isHx == "true" {
+		if ctx.GetHeader("Hx-Target") == "ac-contents" {
+			ctx.HTML(200, "trash", trashContent)
+			return
+		}
+		ctx.HTML(200, "access_control", gin.H{
+			"Body": o.templates.TemplateHTML("trash", trashContent),
+		})
+		return
+	}
+
+	ctx.HTML(200, "base", gin.H{
+		"Title": "TT App - Access Control",
+		"App":   "Table Tennis App",
+		"Main": o.templates.TemplateHTML("access_control", gin.H{
+			"Body": o.templates.TemplateHTML("trash", trashContent),
+		}),
+	})
+}
+
+func trashItem(kind string, id int64, name string, deletedAt int64) gin.H {
+	return gin.H{
+		"Kind":       kind,
+		"ID":         id,
+		"Name":       name,
+		"DeletedAt":  time.Unix(deletedAt, 0).Format(time.DateTime),
+		"RestoreURL": fmt.Sprintf("/access_control/%ss/%d/restore", kind, id),
+	}
+}
+
+func (o *APIAccessController) RestorePermission(ctx *gin.Context) {
+	slog.Debug("RestorePermission")
+	idStr := ctx.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		slog.ErrorContext(ctx, "strconv.ParseInt", slog.String("id", idStr), slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	permissionTrash, err := store.AsSoftDeleter(o.RbacStore.PermissionStore)
+	if err == nil {
+		err = permissionTrash.Restore(ctx.Request.Context(), []int64{id})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "PermissionStore.Restore()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrNotFound) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("permission not in trash"))
+			return
+		}
+		if errors.Is(err, store.ErrConflicted) {
+			_ = ctx.AbortWithError(http.StatusConflict, fmt.Errorf("a permission with the same name exists"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to restore permission"))
+		return
+	}
+	// Restore runs no hooks, so the cached permissions of users miss the
+	// restored permission
+	o.RbacStore.InvalidatePermissionCache()
+}
+
+func (o *APIAccessController) RestoreRole(ctx *gin.Context) {
+	slog.Debug("RestoreRole")
+	idStr := ctx.Param("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		slog.ErrorContext(ctx, "strconv.ParseInt", slog.String("id", idStr), slog.String("error", err.Error()))
+		_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid id"))
+		return
+	}
+	roleTrash, err := store.AsSoftDeleter(o.RbacStore.RoleStore)
+	if err == nil {
+		err = roleTrash.Restore(ctx.Request.Context(), []int64{id})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "RoleStore.Restore()", slog.String("error", err.Error()))
+		if errors.Is(err, store.ErrNotFound) {
+			_ = ctx.AbortWithError(http.StatusBadRequest, fmt.Errorf("role not in trash"))
+			return
+		}
+		if errors.Is(err, store.ErrConflicted) {
+			_ = ctx.AbortWithError(http.StatusConflict, fmt.Errorf("a role with the same name exists"))
+			return
+		}
+		_ = ctx.AbortWithError(http.StatusInternalServerError, fmt.Errorf("fail to restore role"))
+		return
+	}
+	// Restore runs no hooks, so the cached permissions of users miss the
+	// restored role
+	o.RbacStore.InvalidatePermissionCache()
+}
@@ -0,0 +1,35 @@
+package store
+
+import "errors"
+
+// AggFunc is an SQL aggregate function.
+type AggFunc string
+
+const (
+	AggCount AggFunc = "count"
+	AggMin   AggFunc = "min"
+	AggMax   AggFunc = "max"
+	AggSum   AggFunc = "sum"
+	AggAvg   AggFunc = "avg"
+)
+
+// Aggregation computes Func over Field of the rows matching Where, for each
+// group of rows sharing the values of the GroupBy fields, or over all of them
+// if GroupBy is empty. AggCount with an empty Field counts rows. Min, max, sum
+// and avg take a numeric Field.
+type Aggregation struct {
+	Func    AggFunc
+	Field   string
+	GroupBy []string
+	Where   []Cond
+}
+
+// Group is one result of an Aggregation.
+type Group struct {
+	// Keys holds the values of the GroupBy fields of the group, in order.
+	Keys []any
+	// Value is the aggregate of the group, 0 if it has no rows to aggregate.
+	Value float64
+}
+
+var ErrInvalidAggregation error = errors.New("invalid aggregation")
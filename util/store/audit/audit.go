@@ -0,0 +1,434 @@
+// Package audit records every change made through a store.Store in a log of
+// Entry rows.
+//
+// Wrap a store with NewStore and give it the actor of each change with
+// WithActor on the context:
+//
+//	permissions := audit.NewStore(db, permissionStore, log, "permission")
+//	ctx = audit.WithActor(ctx, "alice")
+//	id, err := permissions.Insert(ctx, permission) // logs a create by alice
+//
+// The change and its entry are written in one transaction.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Log is the store the entries are written to.
+type Log = store.Store[Entry, *Entry]
+
+type actorKey struct{}
+
+// SystemActor is recorded for changes made without an actor, e.g. by the
+// purge job.
+const SystemActor = "system"
+
+// WithActor returns a copy of ctx carrying the actor of the changes made with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor carried by ctx, or SystemActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && len(actor) > 0 {
+		return actor
+	}
+	return SystemActor
+}
+
+// Store is a store.Store that logs every row written by Insert, Update,
+// Upsert and DeleteMulti and their batch versions, and the Restore and Purge
+// of soft deleting stores, to a Log.
+type Store[T any, R store.Row[T]] struct {
+	inner  store.Store[T, R]
+	log    Log
+	db     store.Transactor
+	entity string
+	// pk is the index of the primary key field of T.
+	pk   int
+	inTx bool
+}
+
+// NewStore returns s with its changes logged to log as changes of entity. db
+// must be the database s and log were opened on; it is used to write a change
+// and its entry atomically.
+func NewStore[T any, R store.Row[T]](db store.Transactor, s store.Store[T, R], log Log, entity string) *Store[T, R] {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	pk := -1
+	for i := 0; i < typ.NumField(); i++ {
+		_, opts, _ := strings.Cut(typ.Field(i).Tag.Get("db"), ",")
+		if strings.Contains(","+opts+",", ",pk,") {
+			pk = i
+			break
+		}
+	}
+	if pk < 0 {
+		panic(entity + ": no field tagged pk")
+	}
+	return &Store[T, R]{inner: s, log: log, db: db, entity: entity, pk: pk}
+}
+
+// WithTx returns a view of the store whose changes and entries are written
+// inside tx.
+func (o *Store[T, R]) WithTx(tx store.Tx) (store.Store[T, R], error) {
+	inner, err := store.WithTx[T, R](o.inner, tx)
+	if err != nil {
+		return nil, err
+	}
+	log, err := store.WithTx(o.log, tx)
+	if err != nil {
+		return nil, err
+	}
+	return &Store[T, R]{inner: inner, log: log, db: o.db, entity: o.entity, pk: o.pk, inTx: true}, nil
+}
+
+// runInTx calls fn with a view of the store bound to a transaction, or with the
+// store itself if it is already bound to one.
+func (o *Store[T, R]) runInTx(ctx context.Context, fn func(tx *Store[T, R]) error) error {
+	if o.inTx {
+		return fn(o)
+	}
+	return o.db.RunInTx(ctx, func(tx store.Tx) error {
+		view, err := o.WithTx(tx)
+		if err != nil {
+			return fmt.Errorf("%s audit bind to tx failed: %w", o.entity, err)
+		}
+		return fn(view.(*Store[T, R]))
+	})
+}
+
+func (o *Store[T, R]) Insert(ctx context.Context, obj T) (int64, error) {
+	var id int64
+	err := o.runInTx(ctx, func(tx *Store[T, R]) error {
+		var err error
+		id, err = tx.inner.Insert(ctx, obj)
+		if err != nil {
+			return err
+		}
+		after, err := tx.inner.GetOne(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%s audit GetOne failed: %w", o.entity, err)
+		}
+		return tx.record(ctx, ActionCreate, id, nil, &after)
+	})
+	return id, err
+}
+
+func (o *Store[T, R]) Update(ctx context.Context, id int64, obj T) error {
+	return o.runInTx(ctx, func(tx *Store[T, R]) error {
+		before, err := tx.inner.GetOne(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = tx.inner.Update(ctx, id, obj); err != nil {
+			return err
+		}
+		after, err := tx.inner.GetOne(ctx, id)
+		if err != nil {
+			return fmt.Errorf("%s audit GetOne failed: %w", o.entity, err)
+		}
+		return tx.record(ctx, ActionUpdate, id, &before, &after)
+	})
+}
+
+func (o *Store[T, R]) DeleteMulti(ctx context.Context, ids []int64) error {
+	return o.runInTx(ctx, func(tx *Store[T, R]) error {
+		befores, err := tx.inner.GetMulti(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+		}
+		if err = tx.inner.DeleteMulti(ctx, ids); err != nil {
+			return err
+		}
+		for i := range befores {
+			id := tx.id(&befores[i])
+			if err := tx.record(ctx, ActionDelete, id, &befores[i], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (o *Store[T, R]) InsertMulti(ctx context.Context, objs []T) ([]int64, error) {
+	var ids []int64
+	err := o.runInTx(ctx, func(tx *Store[T, R]) error {
+		var err error
+		ids, err = tx.inner.InsertMulti(ctx, objs)
+		if err != nil {
+			return err
+		}
+		afters, err := tx.inner.GetMulti(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+		}
+		for i := range afters {
+			if err := tx.record(ctx, ActionCreate, tx.id(&afters[i]), nil, &afters[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (o *Store[T, R]) UpdateMulti(ctx context.Context, ids []int64, objs []T) error {
+	return o.runInTx(ctx, func(tx *Store[T, R]) error {
+		befores, err := tx.byID(ctx, ids)
+		if err != nil {
+			return err
+		}
+		if err = tx.inner.UpdateMulti(ctx, ids, objs); err != nil {
+			return err
+		}
+		afters, err := tx.inner.GetMulti(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+		}
+		for i := range afters {
+			id := tx.id(&afters[i])
+			before := befores[id]
+			if err := tx.record(ctx, ActionUpdate, id, &before, &afters[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Upsert logs a create for each inserted row and an update for each updated
+// one.
+func (o *Store[T, R]) Upsert(ctx context.Context, field string, objs []T) ([]int64, error) {
+	var ids []int64
+	err := o.runInTx(ctx, func(tx *Store[T, R]) error {
+		keys, err := tx.fieldValues(field, objs)
+		if err != nil {
+			return err
+		}
+		existing, err := tx.inner.FindWhere(ctx, store.In(field, keys))
+		if err != nil {
+			return fmt.Errorf("%s audit FindWhere failed: %w", o.entity, err)
+		}
+		befores := make(map[int64]T, len(existing))
+		for i := range existing {
+			befores[tx.id(&existing[i])] = existing[i]
+		}
+		if ids, err = tx.inner.Upsert(ctx, field, objs); err != nil {
+			return err
+		}
+		afters, err := tx.inner.GetMulti(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+		}
+		for i := range afters {
+			id := tx.id(&afters[i])
+			if before, ok := befores[id]; ok {
+				err = tx.record(ctx, ActionUpdate, id, &before, &afters[i])
+			} else {
+				err = tx.record(ctx, ActionCreate, id, nil, &afters[i])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// Restore restores the soft deleted rows ids and logs them. It returns
+// store.ErrSoftDeleteNotSupported if the wrapped store does not soft delete.
+func (o *Store[T, R]) Restore(ctx context.Context, ids []int64) error {
+	return o.runInTx(ctx, func(tx *Store[T, R]) error {
+		deleter, err := store.AsSoftDeleter[T, R](tx.inner)
+		if err != nil {
+			return err
+		}
+		if err = deleter.Restore(ctx, ids); err != nil {
+			return err
+		}
+		afters, err := tx.inner.GetMulti(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+		}
+		for i := range afters {
+			id := tx.id(&afters[i])
+			if err := tx.record(ctx, ActionRestore, id, nil, &afters[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (o *Store[T, R]) FindDeleted(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	deleter, err := store.AsSoftDeleter[T, R](o.inner)
+	if err != nil {
+		return nil, err
+	}
+	return deleter.FindDeleted(ctx, conds...)
+}
+
+// Purge purges the rows soft deleted before before. A purge that removes rows
+// is logged as one entry with entity id 0, whose After holds their number.
+func (o *Store[T, R]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := o.runInTx(ctx, func(tx *Store[T, R]) error {
+		deleter, err := store.AsSoftDeleter[T, R](tx.inner)
+		if err != nil {
+			return err
+		}
+		n, err = deleter.Purge(ctx, before)
+		if err != nil || n == 0 {
+			return err
+		}
+		summary := map[string]int64{"before": before.Unix(), "count": n}
+		return tx.record(ctx, ActionPurge, 0, nil, summary)
+	})
+	return n, err
+}
+
+// On registers hook on the wrapped store. It is a no-op if the wrapped store
+// does not support hooks.
+func (o *Store[T, R]) On(event store.Event, hook store.Hook[T]) {
+	_ = store.On[T, R](o.inner, event, hook)
+}
+
+func (o *Store[T, R]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	return o.inner.GetMulti(ctx, ids)
+}
+
+func (o *Store[T, R]) GetOne(ctx context.Context, id int64) (T, error) {
+	return o.inner.GetOne(ctx, id)
+}
+
+func (o *Store[T, R]) FindWhere(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	return o.inner.FindWhere(ctx, conds...)
+}
+
+func (o *Store[T, R]) Each(ctx context.Context, fn func(obj T) error, conds ...store.Cond) error {
+	return o.inner.Each(ctx, fn, conds...)
+}
+
+func (o *Store[T, R]) FindPage(ctx context.Context, q store.Query) (store.Page[T], error) {
+	return o.inner.FindPage(ctx, q)
+}
+
+func (o *Store[T, R]) Count(ctx context.Context, conds ...store.Cond) (int64, error) {
+	return o.inner.Count(ctx, conds...)
+}
+
+func (o *Store[T, R]) Exists(ctx context.Context, conds ...store.Cond) (bool, error) {
+	return o.inner.Exists(ctx, conds...)
+}
+
+func (o *Store[T, R]) Aggregate(ctx context.Context, agg store.Aggregation) ([]store.Group, error) {
+	return o.inner.Aggregate(ctx, agg)
+}
+
+// Project reads a subset of the columns of the wrapped store, see
+// store.Project.
+func (o *Store[T, R]) Project(ctx context.Context, fields []string, scan func(row store.RowScanner) error, conds ...store.Cond) error {
+	projector, ok := o.inner.(store.Projector)
+	if !ok {
+		return store.ErrProjectionNotSupported
+	}
+	return projector.Project(ctx, fields, scan, conds...)
+}
+
+// Search runs a full-text search on the wrapped store, see store.Search.
+func (o *Store[T, R]) Search(ctx context.Context, query string, opts store.SearchOptions) ([]store.SearchResult[T], error) {
+	searcher, ok := o.inner.(store.Searcher[T])
+	if !ok {
+		return nil, store.ErrSearchNotSupported
+	}
+	return searcher.Search(ctx, query, opts)
+}
+
+// Close closes the wrapped store. The log is left open, as it is usually
+// shared by several stores.
+func (o *Store[T, R]) Close() error {
+	if o.inTx {
+		return nil
+	}
+	return o.inner.Close()
+}
+
+// byID returns the rows ids by id.
+func (o *Store[T, R]) byID(ctx context.Context, ids []int64) (map[int64]T, error) {
+	objs, err := o.inner.GetMulti(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("%s audit GetMulti failed: %w", o.entity, err)
+	}
+	byID := make(map[int64]T, len(objs))
+	for i := range objs {
+		byID[o.id(&objs[i])] = objs[i]
+	}
+	return byID, nil
+}
+
+// fieldValues returns the values of the column field of objs.
+func (o *Store[T, R]) fieldValues(field string, objs []T) ([]any, error) {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("db"), ",")
+		if name != field {
+			continue
+		}
+		vals := make([]any, 0, len(objs))
+		for j := range objs {
+			vals = append(vals, reflect.ValueOf(&objs[j]).Elem().Field(i).Interface())
+		}
+		return vals, nil
+	}
+	return nil, &store.UnknownFieldError{Table: o.entity, Field: field}
+}
+
+// id returns the primary key of obj.
+func (o *Store[T, R]) id(obj *T) int64 {
+	return reflect.ValueOf(obj).Elem().Field(o.pk).Int()
+}
+
+// record writes an entry for a change of the entity id. before and after are
+// encoded as JSON unless nil.
+func (o *Store[T, R]) record(ctx context.Context, action Action, id int64, before, after any) error {
+	entry := Entry{
+		At:       time.Now().UnixMilli(),
+		Actor:    ActorFrom(ctx),
+		Action:   action,
+		Entity:   o.entity,
+		EntityID: id,
+	}
+	var err error
+	if entry.Before, err = encode(before); err != nil {
+		return fmt.Errorf("%s audit encode failed: %w", o.entity, err)
+	}
+	if entry.After, err = encode(after); err != nil {
+		return fmt.Errorf("%s audit encode failed: %w", o.entity, err)
+	}
+	if _, err = o.log.Insert(ctx, entry); err != nil {
+		return fmt.Errorf("%s audit log Insert failed: %w", o.entity, err)
+	}
+	return nil
+}
+
+func encode(val any) (string, error) {
+	if val == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
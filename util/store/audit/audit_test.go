@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util"
+	"github.com/yinloo-ola/tt-app/util/store"
+	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
+)
+
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Item
+
+type Item struct {
+	Name      string `db:"name,uniq"`
+	ID        int64  `db:"id,pk"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+
+	assert := assert.New(t)
+	db, err := sqlitestore.Open(path)
+	util.PanicErr(err)
+	defer db.Close()
+	itemStore, err := sqlitestore.NewStoreFromDB[Item](db)
+	util.PanicErr(err)
+	log, err := sqlitestore.NewStoreFromDB[Entry](db)
+	util.PanicErr(err)
+	defer log.Close()
+	items := NewStore(db, itemStore, log, "item")
+	defer items.Close()
+
+	ctx := WithActor(context.Background(), "alice")
+	id, err := items.Insert(ctx, Item{Name: "net"})
+	util.PanicErr(err)
+	util.PanicErr(items.Update(ctx, id, Item{Name: "ball"}))
+	_, err = items.Insert(ctx, Item{Name: "ball"})
+	assert.ErrorIs(err, store.ErrConflicted)
+	util.PanicErr(items.DeleteMulti(context.Background(), []int64{id}))
+	util.PanicErr(items.Restore(ctx, []int64{id}))
+
+	// a change that fails inside a transaction leaves no entry behind
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		txItems, err := store.WithTx(store.Store[Item, *Item](items), tx)
+		util.PanicErr(err)
+		util.PanicErr(txItems.Update(ctx, id, Item{Name: "bat"}))
+		return store.ErrConflicted
+	})
+	assert.ErrorIs(err, store.ErrConflicted)
+
+	entries, err := log.FindPage(ctx, store.Query{OrderBy: []store.Order{{Field: EntryColID}}})
+	util.PanicErr(err)
+	if !assert.Len(entries.Items, 4) {
+		return
+	}
+	type change struct {
+		Actor  string
+		Action Action
+		Before string
+		After  string
+	}
+	changes := make([]change, 0, len(entries.Items))
+	for _, entry := range entries.Items {
+		assert.Equal("item", entry.Entity)
+		assert.Equal(id, entry.EntityID)
+		assert.InDelta(time.Now().UnixMilli(), entry.At, 5000)
+		changes = append(changes, change{entry.Actor, entry.Action, entry.Before, entry.After})
+	}
+	net := `{"Name":"net","ID":1,"DeletedAt":0}`
+	ball := `{"Name":"ball","ID":1,"DeletedAt":0}`
+	assert.Equal([]change{
+		{"alice", ActionCreate, "", net},
+		{"alice", ActionUpdate, net, ball},
+		{SystemActor, ActionDelete, ball, ""},
+		{"alice", ActionRestore, "", ball},
+	}, changes)
+
+	util.PanicErr(items.DeleteMulti(ctx, []int64{id}))
+	n, err := items.Purge(context.Background(), time.Now().Add(time.Second))
+	util.PanicErr(err)
+	assert.Equal(int64(1), n)
+	purges, err := log.FindWhere(ctx, store.Eq(EntryColAction, ActionPurge))
+	util.PanicErr(err)
+	if assert.Len(purges, 1) {
+		var summary map[string]int64
+		util.PanicErr(json.Unmarshal([]byte(purges[0].After), &summary))
+		assert.Equal(int64(1), summary["count"])
+	}
+}
+
+func TestStore_Bulk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit_bulk.db")
+
+	assert := assert.New(t)
+	db, err := sqlitestore.Open(path)
+	util.PanicErr(err)
+	defer db.Close()
+	itemStore, err := sqlitestore.NewStoreFromDB[Item](db)
+	util.PanicErr(err)
+	log, err := sqlitestore.NewStoreFromDB[Entry](db)
+	util.PanicErr(err)
+	defer log.Close()
+	items := NewStore(db, itemStore, log, "item")
+	defer items.Close()
+
+	ctx := WithActor(context.Background(), "bob")
+	ids, err := items.InsertMulti(ctx, []Item{{Name: "net"}, {Name: "ball"}})
+	util.PanicErr(err)
+	util.PanicErr(items.UpdateMulti(ctx, ids, []Item{{Name: "net2"}, {Name: "ball2"}}))
+	upserted, err := items.Upsert(ctx, ItemColName, []Item{{Name: "net2"}, {Name: "bat"}})
+	util.PanicErr(err)
+	assert.Equal(ids[0], upserted[0])
+
+	// a failing batch leaves no entry behind
+	_, err = items.InsertMulti(ctx, []Item{{Name: "table"}, {Name: "bat"}})
+	assert.ErrorIs(err, store.ErrConflicted)
+
+	entries, err := log.FindPage(ctx, store.Query{OrderBy: []store.Order{{Field: EntryColID}}})
+	util.PanicErr(err)
+	actions := make([]Action, 0, len(entries.Items))
+	for _, entry := range entries.Items {
+		assert.Equal("bob", entry.Actor)
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal([]Action{
+		ActionCreate, ActionCreate, ActionUpdate, ActionUpdate, ActionUpdate, ActionCreate,
+	}, actions)
+	assert.Equal(upserted[1], entries.Items[5].EntityID)
+}
@@ -0,0 +1,39 @@
+package audit
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Entry
+
+// Entry records one change made through a Store.
+type Entry struct {
+	ID int64 `db:"id,pk"`
+	// At is the unix time of the change in milliseconds.
+	At       int64  `db:"at,idx_desc"`
+	Actor    string `db:"actor,idx_asc"`
+	Action   Action `db:"action,idx_asc"`
+	Entity   string `db:"entity,idx_asc"`
+	EntityID int64  `db:"entity_id,idx_asc"`
+	// Before and After hold the entity as JSON before and after the change,
+	// or "" where it did not exist or is not known.
+	Before string `db:"before"`
+	After  string `db:"after"`
+}
+
+func (o *Entry) TableName() string { return "audit_log" }
+
+// EntryWhitelist lists the fields users may filter and sort the log on.
+var EntryWhitelist = store.Whitelist{
+	Filter: []string{EntryColAt, EntryColActor, EntryColAction, EntryColEntity, EntryColEntityID},
+	Sort:   []string{EntryColID, EntryColAt},
+}
+
+// Action is the kind of change an Entry records.
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionUpdate  Action = "update"
+	ActionDelete  Action = "delete"
+	ActionRestore Action = "restore"
+	ActionPurge   Action = "purge"
+)
@@ -0,0 +1,25 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package audit
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Entry.
+const (
+	EntryColID       = "id"
+	EntryColAt       = "at"
+	EntryColActor    = "actor"
+	EntryColAction   = "action"
+	EntryColEntity   = "entity"
+	EntryColEntityID = "entity_id"
+	EntryColBefore   = "before"
+	EntryColAfter    = "after"
+)
+
+func (o *Entry) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.At, o.Actor, o.Action, o.Entity, o.EntityID, o.Before, o.After}, nil
+}
+
+func (o *Entry) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.At, &o.Actor, &o.Action, &o.Entity, &o.EntityID, &o.Before, &o.After)
+}
@@ -0,0 +1,20 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package audit
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Item.
+const (
+	ItemColName      = "name"
+	ItemColID        = "id"
+	ItemColDeletedAt = "deleted_at"
+)
+
+func (o *Item) FieldsVals() ([]any, error) {
+	return []any{o.Name, o.ID, o.DeletedAt}, nil
+}
+
+func (o *Item) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Name, &o.ID, &o.DeletedAt)
+}
@@ -0,0 +1,321 @@
+// Package cache wraps a store.Store with a read-through cache.
+//
+// Wrap a store with NewStore:
+//
+//	permissions := cache.NewStore(permissionStore, cache.Options{Size: 1000, TTL: time.Minute})
+//	permission, err := permissions.GetOne(ctx, id) // reads the store once
+//	permission, err = permissions.GetOne(ctx, id)  // served from the cache
+//
+// Rows are cached by id for GetOne and GetMulti, and the results of FindWhere,
+// Count and Exists by their conditions. A change made through the Store drops
+// the rows it wrote and every cached result. Changes made to the wrapped store
+// directly, or by another process, are only seen once the cached entries
+// expire.
+package cache
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Options sizes the caches of a Store.
+type Options struct {
+	// Size is the number of rows cached by id, and also the number of results
+	// of FindWhere, Count and Exists cached by their conditions.
+	Size int
+	// TTL is how long an entry is served from the cache. 0 keeps entries
+	// until they are evicted or invalidated.
+	TTL time.Duration
+}
+
+// Store is a store.Store that caches the reads of the store it wraps. It is
+// safe for concurrent use if the wrapped store is.
+type Store[T any, R store.Row[T]] struct {
+	inner store.Store[T, R]
+	rows  *LRU[int64, T]
+	// queries holds the results of FindWhere, Count and Exists, by queryKey.
+	queries *LRU[string, any]
+	// pk is the index of the primary key field of T.
+	pk int
+	// tx is the transaction of a view returned by WithTx.
+	tx store.Tx
+}
+
+// NewStore returns s with its reads cached as set by opts.
+func NewStore[T any, R store.Row[T]](s store.Store[T, R], opts Options) *Store[T, R] {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	pk := -1
+	for i := 0; i < typ.NumField(); i++ {
+		_, opts, _ := strings.Cut(typ.Field(i).Tag.Get("db"), ",")
+		if strings.Contains(","+opts+",", ",pk,") {
+			pk = i
+			break
+		}
+	}
+	if pk < 0 {
+		panic(typ.Name() + ": no field tagged pk")
+	}
+	return &Store[T, R]{
+		inner:   s,
+		rows:    NewLRU[int64, T](opts.Size, opts.TTL),
+		queries: NewLRU[string, any](opts.Size, opts.TTL),
+		pk:      pk,
+	}
+}
+
+// WithTx returns a view of the store whose methods run inside tx. The view
+// reads the wrapped store directly, as rows read inside the transaction may
+// not be committed. Its changes invalidate the cache of the store once tx
+// commits, see store.OnCommit, so that a read made through the store before
+// the commit does not keep the rows as they were before tx.
+func (o *Store[T, R]) WithTx(tx store.Tx) (store.Store[T, R], error) {
+	inner, err := store.WithTx[T, R](o.inner, tx)
+	if err != nil {
+		return nil, err
+	}
+	return &Store[T, R]{inner: inner, rows: o.rows, queries: o.queries, pk: o.pk, tx: tx}, nil
+}
+
+// Stats returns the counters of the caches of rows and of query results
+// added together. The views returned by WithTx share the counters of the
+// store.
+func (o *Store[T, R]) Stats() Stats {
+	return o.rows.Stats().Add(o.queries.Stats())
+}
+
+func (o *Store[T, R]) Insert(ctx context.Context, obj T) (int64, error) {
+	defer o.invalidate()
+	return o.inner.Insert(ctx, obj)
+}
+
+func (o *Store[T, R]) Update(ctx context.Context, id int64, obj T) error {
+	defer o.invalidate(id)
+	return o.inner.Update(ctx, id, obj)
+}
+
+func (o *Store[T, R]) InsertMulti(ctx context.Context, objs []T) ([]int64, error) {
+	defer o.invalidate()
+	return o.inner.InsertMulti(ctx, objs)
+}
+
+func (o *Store[T, R]) UpdateMulti(ctx context.Context, ids []int64, objs []T) error {
+	defer o.invalidate(ids...)
+	return o.inner.UpdateMulti(ctx, ids, objs)
+}
+
+func (o *Store[T, R]) Upsert(ctx context.Context, field string, objs []T) ([]int64, error) {
+	// the ids of the updated rows are only known once Upsert returns
+	ids, err := o.inner.Upsert(ctx, field, objs)
+	o.invalidate(ids...)
+	return ids, err
+}
+
+func (o *Store[T, R]) DeleteMulti(ctx context.Context, ids []int64) error {
+	defer o.invalidate(ids...)
+	return o.inner.DeleteMulti(ctx, ids)
+}
+
+// Restore restores the soft deleted rows ids. It returns
+// store.ErrSoftDeleteNotSupported if the wrapped store does not soft delete.
+func (o *Store[T, R]) Restore(ctx context.Context, ids []int64) error {
+	deleter, err := store.AsSoftDeleter[T, R](o.inner)
+	if err != nil {
+		return err
+	}
+	defer o.invalidate(ids...)
+	return deleter.Restore(ctx, ids)
+}
+
+func (o *Store[T, R]) FindDeleted(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	deleter, err := store.AsSoftDeleter[T, R](o.inner)
+	if err != nil {
+		return nil, err
+	}
+	return deleter.FindDeleted(ctx, conds...)
+}
+
+// Purge purges the rows soft deleted before before. Soft deleted rows are not
+// cached, so Purge leaves the cache alone.
+func (o *Store[T, R]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	deleter, err := store.AsSoftDeleter[T, R](o.inner)
+	if err != nil {
+		return 0, err
+	}
+	return deleter.Purge(ctx, before)
+}
+
+// On registers hook on the wrapped store. It is a no-op if the wrapped store
+// does not support hooks.
+func (o *Store[T, R]) On(event store.Event, hook store.Hook[T]) {
+	_ = store.On[T, R](o.inner, event, hook)
+}
+
+func (o *Store[T, R]) GetOne(ctx context.Context, id int64) (T, error) {
+	if o.tx != nil {
+		return o.inner.GetOne(ctx, id)
+	}
+	obj, err := o.rows.Load(id, func() (T, error) {
+		return o.inner.GetOne(ctx, id)
+	})
+	if err != nil {
+		return obj, err
+	}
+	return clone(obj), nil
+}
+
+// GetMulti reads the rows of ids that are not cached from the wrapped store,
+// and returns the rows in the order of their ids.
+func (o *Store[T, R]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	if o.tx != nil {
+		return o.inner.GetMulti(ctx, ids)
+	}
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	objs := make([]T, 0, len(ids))
+	missing := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if obj, ok := o.rows.Get(id); ok {
+			objs = append(objs, clone(obj))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return objs, nil
+	}
+
+	generation := o.rows.generationNow()
+	loaded, err := o.inner.GetMulti(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]T, len(loaded))
+	for i := range loaded {
+		byID[o.id(&loaded[i])] = loaded[i]
+	}
+	o.rows.addSince(generation, byID)
+	objs = append(objs, cloneAll(loaded)...)
+	slices.SortFunc(objs, func(a, b T) int { return cmp.Compare(o.id(&a), o.id(&b)) })
+	return objs, nil
+}
+
+func (o *Store[T, R]) FindWhere(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	if o.tx != nil {
+		return o.inner.FindWhere(ctx, conds...)
+	}
+	objs, err := o.queries.Load(queryKey("FindWhere", conds), func() (any, error) {
+		return o.inner.FindWhere(ctx, conds...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneAll(objs.([]T)), nil
+}
+
+func (o *Store[T, R]) Count(ctx context.Context, conds ...store.Cond) (int64, error) {
+	if o.tx != nil {
+		return o.inner.Count(ctx, conds...)
+	}
+	n, err := o.queries.Load(queryKey("Count", conds), func() (any, error) {
+		return o.inner.Count(ctx, conds...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n.(int64), nil
+}
+
+func (o *Store[T, R]) Exists(ctx context.Context, conds ...store.Cond) (bool, error) {
+	if o.tx != nil {
+		return o.inner.Exists(ctx, conds...)
+	}
+	exists, err := o.queries.Load(queryKey("Exists", conds), func() (any, error) {
+		return o.inner.Exists(ctx, conds...)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.(bool), nil
+}
+
+func (o *Store[T, R]) Each(ctx context.Context, fn func(obj T) error, conds ...store.Cond) error {
+	return o.inner.Each(ctx, fn, conds...)
+}
+
+func (o *Store[T, R]) FindPage(ctx context.Context, q store.Query) (store.Page[T], error) {
+	return o.inner.FindPage(ctx, q)
+}
+
+func (o *Store[T, R]) Aggregate(ctx context.Context, agg store.Aggregation) ([]store.Group, error) {
+	return o.inner.Aggregate(ctx, agg)
+}
+
+// Project reads a subset of the columns of the wrapped store, see
+// store.Project. Projections are not cached.
+func (o *Store[T, R]) Project(ctx context.Context, fields []string, scan func(row store.RowScanner) error, conds ...store.Cond) error {
+	projector, ok := o.inner.(store.Projector)
+	if !ok {
+		return store.ErrProjectionNotSupported
+	}
+	return projector.Project(ctx, fields, scan, conds...)
+}
+
+// Search runs a full-text search on the wrapped store, see store.Search. Results
+// are not cached.
+func (o *Store[T, R]) Search(ctx context.Context, query string, opts store.SearchOptions) ([]store.SearchResult[T], error) {
+	searcher, ok := o.inner.(store.Searcher[T])
+	if !ok {
+		return nil, store.ErrSearchNotSupported
+	}
+	return searcher.Search(ctx, query, opts)
+}
+
+// Close closes the wrapped store.
+func (o *Store[T, R]) Close() error {
+	if o.tx != nil {
+		return nil
+	}
+	return o.inner.Close()
+}
+
+// invalidate drops the rows ids and every cached query result, which a change
+// of any row may affect. Inside a transaction, they are dropped once it
+// commits: the rows read until then are still those committed before it.
+func (o *Store[T, R]) invalidate(ids ...int64) {
+	drop := func() {
+		if len(ids) > 0 {
+			o.rows.Remove(ids...)
+		}
+		o.queries.Purge()
+	}
+	if o.tx != nil {
+		store.OnCommit(o.tx, drop)
+		return
+	}
+	drop()
+}
+
+// id returns the primary key of obj.
+func (o *Store[T, R]) id(obj *T) int64 {
+	f := reflect.ValueOf(obj).Elem().Field(o.pk)
+	if f.CanUint() {
+		return int64(f.Uint())
+	}
+	return f.Int()
+}
+
+// queryKey identifies the result of op on conds. Conditions are plain values,
+// so their Go syntax representation tells them apart.
+func queryKey(op string, conds []store.Cond) string {
+	return fmt.Sprintf("%s%#v", op, conds)
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+	memstore "github.com/yinloo-ola/tt-app/util/store/mem-store"
+	sqlitestore "github.com/yinloo-ola/tt-app/util/store/sqlite-store"
+	"github.com/yinloo-ola/tt-app/util/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Players: func(t *testing.T) store.Store[storetest.Player, *storetest.Player] {
+			return newStore[storetest.Player](t)
+		},
+		Notes: func(t *testing.T) store.Store[storetest.Note, *storetest.Note] {
+			return newStore[storetest.Note](t)
+		},
+	})
+}
+
+func newStore[T any, R store.Row[T]](t *testing.T) *Store[T, R] {
+	s, err := memstore.NewStore[T, R]()
+	if err != nil {
+		t.Fatalf("fail to create store %v", err)
+	}
+	return NewStore[T, R](s, Options{Size: 100, TTL: time.Minute})
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	players := newStore[storetest.Player](t)
+	ids, err := players.InsertMulti(ctx, []storetest.Player{
+		{Name: "ma long", Club: "bayi", Tags: []string{"olympian"}},
+		{Name: "timo boll", Club: "Dusseldorf"},
+	})
+	assert.NoError(t, err)
+
+	_, err = players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	ma, err := players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Invalidations: 1, Size: 1}, players.Stats())
+	// the caller's copy of a cached row is not the cache's
+	ma.Tags[0] = "changed"
+	ma, err = players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"olympian"}, ma.Tags)
+
+	multi, err := players.GetMulti(ctx, []int64{ids[1], ids[0], ids[1]})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ma long", "timo boll"}, []string{multi[0].Name, multi[1].Name})
+	multi, err = players.GetMulti(ctx, ids)
+	assert.NoError(t, err)
+	assert.Len(t, multi, 2)
+
+	bayi, err := players.FindWhere(ctx, store.Eq(storetest.PlayerColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.Len(t, bayi, 1)
+	before := players.Stats()
+	_, err = players.FindWhere(ctx, store.Eq(storetest.PlayerColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.Equal(t, before.Hits+1, players.Stats().Hits)
+
+	// a change through the store drops the row and the query results
+	ma.Club = "shandong"
+	assert.NoError(t, players.Update(ctx, ids[0], ma))
+	got, err := players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "shandong", got.Club)
+	bayi, err = players.FindWhere(ctx, store.Eq(storetest.PlayerColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.Empty(t, bayi)
+	n, err := players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.NoError(t, players.DeleteMulti(ctx, []int64{ids[1]}))
+	_, err = players.GetOne(ctx, ids[1])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	n, err = players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestStore_Tx(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlitestore.Open(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	inner, err := sqlitestore.NewStoreFromDB[storetest.Player](db)
+	if err != nil {
+		t.Fatalf("fail to create store %v", err)
+	}
+	t.Cleanup(func() {
+		_ = inner.Close()
+		_ = db.Close()
+	})
+	players := NewStore[storetest.Player](inner, Options{Size: 100, TTL: time.Minute})
+	ids, err := players.InsertMulti(ctx, []storetest.Player{{Name: "ma long", Club: "bayi"}, {Name: "timo boll"}})
+	assert.NoError(t, err)
+
+	// reads made while the transaction is open see, and cache, the rows as
+	// they were before it, which its commit drops
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		view, err := store.WithTx(store.Store[storetest.Player, *storetest.Player](players), tx)
+		if err != nil {
+			return err
+		}
+		if err = view.Update(ctx, ids[0], storetest.Player{Name: "ma long", Club: "shandong"}); err != nil {
+			return err
+		}
+		if err = view.DeleteMulti(ctx, ids[1:]); err != nil {
+			return err
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ma, err := players.GetOne(ctx, ids[0])
+			assert.NoError(t, err)
+			assert.Equal(t, "bayi", ma.Club)
+			n, err := players.Count(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), n)
+		}()
+		<-done
+		return nil
+	})
+	assert.NoError(t, err)
+	ma, err := players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "shandong", ma.Club)
+	_, err = players.GetOne(ctx, ids[1])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	n, err := players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// a rolled back transaction leaves the cache alone
+	before := players.Stats().Invalidations
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		view, err := store.WithTx(store.Store[storetest.Player, *storetest.Player](players), tx)
+		if err != nil {
+			return err
+		}
+		if err = view.DeleteMulti(ctx, ids[:1]); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+	assert.Equal(t, before, players.Stats().Invalidations)
+	_, err = players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewLRU[string, int](2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+	// b is the least recently used
+	c.Add("c", 3)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	val, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+
+	now = now.Add(2 * time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, Stats{Hits: 2, Misses: 2, Evictions: 1, Size: 1}, c.Stats())
+
+	// a failed load is not cached
+	_, err := c.Load("d", func() (int, error) { return 0, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+
+	// a load that raced an invalidation is not cached
+	val, err = c.Load("d", func() (int, error) {
+		c.Remove("c")
+		return 4, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, val)
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+	val, err = c.Load("d", func() (int, error) { return 5, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 5, val)
+	val, ok = c.Get("d")
+	assert.True(t, ok)
+	assert.Equal(t, 5, val)
+
+	c.Purge()
+	assert.Equal(t, 0, c.Stats().Size)
+}
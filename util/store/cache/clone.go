@@ -0,0 +1,72 @@
+package cache
+
+import "reflect"
+
+// clone returns a deep copy of obj, so that callers may change the slices,
+// maps and pointers of the rows they get without changing the cached rows.
+// Unexported fields are copied as they are.
+func clone[T any](obj T) T {
+	v := reflect.ValueOf(&obj).Elem()
+	out := reflect.New(v.Type()).Elem()
+	copyValue(out, v)
+	return out.Interface().(T)
+}
+
+// cloneAll returns a deep copy of objs.
+func cloneAll[T any](objs []T) []T {
+	out := make([]T, 0, len(objs))
+	for _, obj := range objs {
+		out = append(out, clone(obj))
+	}
+	return out
+}
+
+func copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Pointer:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.New(src.Type().Elem()))
+		copyValue(dst.Elem(), src.Elem())
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		dst.Set(reflect.MakeMapWithSize(src.Type(), src.Len()))
+		iter := src.MapRange()
+		for iter.Next() {
+			val := reflect.New(src.Type().Elem()).Elem()
+			copyValue(val, iter.Value())
+			dst.SetMapIndex(iter.Key(), val)
+		}
+	case reflect.Struct:
+		dst.Set(src)
+		for i := 0; i < src.NumField(); i++ {
+			if dst.Field(i).CanSet() {
+				copyValue(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		val := reflect.New(src.Elem().Type()).Elem()
+		copyValue(val, src.Elem())
+		dst.Set(val)
+	default:
+		dst.Set(src)
+	}
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Stats counts the lookups of a cache since it was created.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	// Evictions counts the entries dropped to make room for new ones. Expired
+	// entries are counted as misses, not evictions.
+	Evictions int64
+	// Invalidations counts the calls to Remove and Purge.
+	Invalidations int64
+	// Size is the number of entries in the cache.
+	Size int
+}
+
+// HitRatio returns the share of the lookups that were hits, or 0 if there
+// were none.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Add returns the sum of s and other, e.g. for the caches of a Store.
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		Hits:          s.Hits + other.Hits,
+		Misses:        s.Misses + other.Misses,
+		Evictions:     s.Evictions + other.Evictions,
+		Invalidations: s.Invalidations + other.Invalidations,
+		Size:          s.Size + other.Size,
+	}
+}
+
+// LRU is a cache of at most size entries that evicts the least recently used
+// entry to make room, and drops entries older than its TTL. It is safe for
+// concurrent use.
+type LRU[K comparable, V any] struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	// order holds the entries, most recently used first.
+	order *list.List
+	// generation is incremented by Remove and Purge, see Load.
+	generation uint64
+	stats      Stats
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time
+}
+
+// NewLRU returns an LRU of size entries. A ttl of 0 keeps entries until they
+// are evicted or removed.
+func NewLRU[K comparable, V any](size int, ttl time.Duration) *LRU[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &LRU[K, V]{size: size, ttl: ttl, now: time.Now, entries: map[K]*list.Element{}, order: list.New()}
+}
+
+// Get returns the value of key, if it is cached and has not expired.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(key)
+}
+
+func (c *LRU[K, V]) get(key K) (V, bool) {
+	var zero V
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return zero, false
+	}
+	e := elem.Value.(*entry[K, V])
+	if c.ttl > 0 && c.now().After(e.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.stats.Misses++
+		return zero, false
+	}
+	c.order.MoveToFront(elem)
+	c.stats.Hits++
+	return e.val, true
+}
+
+// Add caches val as the value of key.
+func (c *LRU[K, V]) Add(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, val)
+}
+
+func (c *LRU[K, V]) add(key K, val V) {
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry[K, V])
+		e.val, e.expires = val, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry[K, V]{key: key, val: val, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry[K, V]).key)
+		c.stats.Evictions++
+	}
+}
+
+// Load returns the value of key, calling load and caching its result on a
+// miss. The result is not cached if load fails, or if Remove or Purge was
+// called while load ran: the value it read may predate the change that
+// caused the invalidation.
+func (c *LRU[K, V]) Load(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if val, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return val, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	val, err := load()
+	if err != nil {
+		return val, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.add(key, val)
+	}
+	return val, nil
+}
+
+// generationNow returns the generation for addSince.
+func (c *LRU[K, V]) generationNow() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// addSince caches vals as Load does for a single value: unless Remove or
+// Purge was called since generation was read.
+func (c *LRU[K, V]) addSince(generation uint64, vals map[K]V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+	for key, val := range vals {
+		c.add(key, val)
+	}
+}
+
+// Remove drops keys from the cache.
+func (c *LRU[K, V]) Remove(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+	c.generation++
+	c.stats.Invalidations++
+}
+
+// Purge drops every entry.
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[K]*list.Element{}
+	c.order.Init()
+	c.generation++
+	c.stats.Invalidations++
+}
+
+// Stats returns the counters of the cache.
+func (c *LRU[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
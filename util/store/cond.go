@@ -0,0 +1,297 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Cond is a node of a WHERE condition tree.
+type Cond interface {
+	// GetQueryWithArgs validates the condition and returns its SQL and args.
+	GetQueryWithArgs() (string, []any, error)
+	// Fields returns every field named by the condition, so that a store can
+	// check them against its columns before the field reaches the SQL text.
+	Fields() []string
+}
+
+var ErrInvalidCond error = errors.New("invalid condition")
+
+type WhereCond struct {
+	Field string
+	Op    op
+	Val   any
+}
+
+type op string
+
+const OpEqual op = "="
+const OpNotEqual op = "<>"
+const OpGte op = ">="
+const OpGt op = ">"
+const OpLte op = "<="
+const OpLt op = "<"
+
+// OpIn and OpNotIn take a slice of any element type as Val.
+const OpIn op = "in"
+const OpNotIn op = "not in"
+const OpLike op = "like"
+const OpNotLike op = "not like"
+const OpGlob op = "glob"
+
+// OpBetween takes a slice of exactly two values, the inclusive bounds, as Val.
+const OpBetween op = "between"
+
+// OpJSONContains matches rows whose JSON column, an array or an object, holds
+// Val as one of its elements.
+const OpJSONContains op = "json contains"
+
+// OpIsNull and OpIsNotNull ignore Val.
+const OpIsNull op = "is null"
+const OpIsNotNull op = "is not null"
+
+func (o WhereCond) GetQueryWithArgs() (string, []any, error) {
+	if len(o.Field) == 0 {
+		return "", nil, fmt.Errorf("%w: empty field", ErrInvalidCond)
+	}
+	switch o.Op {
+	case OpEqual, OpNotEqual, OpGte, OpGt, OpLte, OpLt, OpLike, OpNotLike, OpGlob:
+		return fmt.Sprintf("%s %s ?", o.Field, o.Op), []any{o.Val}, nil
+	case OpIn, OpNotIn:
+		vals, ok := ToSlice(o.Val)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s %s expects a slice but got %T", ErrInvalidCond, o.Field, o.Op, o.Val)
+		}
+		if len(vals) == 0 {
+			// "x in ()" is not portable SQL
+			if o.Op == OpIn {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		qnMarks := make([]string, 0, len(vals))
+		for range vals {
+			qnMarks = append(qnMarks, "?")
+		}
+		return fmt.Sprintf("%s %s (%s)", o.Field, o.Op, strings.Join(qnMarks, ",")), vals, nil
+	case OpBetween:
+		vals, ok := ToSlice(o.Val)
+		if !ok || len(vals) != 2 {
+			return "", nil, fmt.Errorf("%w: %s between expects 2 bounds but got %#v", ErrInvalidCond, o.Field, o.Val)
+		}
+		return fmt.Sprintf("%s between ? and ?", o.Field), vals, nil
+	case OpJSONContains:
+		return fmt.Sprintf("exists (select 1 from json_each(%s) where json_each.value = ?)", o.Field), []any{o.Val}, nil
+	case OpIsNull, OpIsNotNull:
+		return fmt.Sprintf("%s %s", o.Field, o.Op), []any{}, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidCond, o.Op)
+	}
+}
+
+func (o WhereCond) Fields() []string {
+	return []string{o.Field}
+}
+
+// ToSlice returns the elements of val, the slice or array of an In, NotIn or
+// Between condition, and false if val is neither.
+func ToSlice(val any) ([]any, bool) {
+	if vals, ok := val.([]any); ok {
+		return vals, true
+	}
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	vals := make([]any, v.Len())
+	for i := range vals {
+		vals[i] = v.Index(i).Interface()
+	}
+	return vals, true
+}
+
+// QueryJoiner joins the conditions passed to FindWhere as a flat list.
+// Inside a condition tree use And and Or instead.
+type QueryJoiner string
+
+const QueryJoinerAnd QueryJoiner = "and"
+const QueryJoinerOr QueryJoiner = "or"
+
+func (o QueryJoiner) GetQueryWithArgs() (string, []any, error) {
+	return "", nil, fmt.Errorf("%w: %q outside a flat condition list", ErrInvalidCond, string(o))
+}
+
+func (o QueryJoiner) Fields() []string {
+	return nil
+}
+
+// GroupCond joins its conditions with Joiner and wraps them in parentheses.
+type GroupCond struct {
+	Joiner QueryJoiner
+	Conds  []Cond
+}
+
+func (o GroupCond) GetQueryWithArgs() (string, []any, error) {
+	if o.Joiner != QueryJoinerAnd && o.Joiner != QueryJoinerOr {
+		return "", nil, fmt.Errorf("%w: unknown joiner %q", ErrInvalidCond, string(o.Joiner))
+	}
+	if len(o.Conds) == 0 {
+		// an empty and matches everything, an empty or matches nothing
+		if o.Joiner == QueryJoinerAnd {
+			return "1 = 1", []any{}, nil
+		}
+		return "1 = 0", []any{}, nil
+	}
+	stmts := make([]string, 0, len(o.Conds))
+	args := make([]any, 0, len(o.Conds))
+	for _, cond := range o.Conds {
+		if cond == nil {
+			return "", nil, fmt.Errorf("%w: nil condition in %s group", ErrInvalidCond, o.Joiner)
+		}
+		s, arg, err := cond.GetQueryWithArgs()
+		if err != nil {
+			return "", nil, err
+		}
+		stmts = append(stmts, s)
+		args = append(args, arg...)
+	}
+	return "(" + strings.Join(stmts, " "+string(o.Joiner)+" ") + ")", args, nil
+}
+
+func (o GroupCond) Fields() []string {
+	fields := make([]string, 0, len(o.Conds))
+	for _, cond := range o.Conds {
+		if cond != nil {
+			fields = append(fields, cond.Fields()...)
+		}
+	}
+	return fields
+}
+
+// NotCond negates Cond.
+type NotCond struct {
+	Cond Cond
+}
+
+func (o NotCond) GetQueryWithArgs() (string, []any, error) {
+	if o.Cond == nil {
+		return "", nil, fmt.Errorf("%w: nil condition in not", ErrInvalidCond)
+	}
+	s, args, err := o.Cond.GetQueryWithArgs()
+	if err != nil {
+		return "", nil, err
+	}
+	return "not (" + s + ")", args, nil
+}
+
+func (o NotCond) Fields() []string {
+	if o.Cond == nil {
+		return nil
+	}
+	return o.Cond.Fields()
+}
+
+func And(conds ...Cond) Cond { return GroupCond{Joiner: QueryJoinerAnd, Conds: conds} }
+func Or(conds ...Cond) Cond  { return GroupCond{Joiner: QueryJoinerOr, Conds: conds} }
+func Not(cond Cond) Cond     { return NotCond{Cond: cond} }
+
+func Eq(field string, val any) Cond  { return WhereCond{Field: field, Op: OpEqual, Val: val} }
+func Ne(field string, val any) Cond  { return WhereCond{Field: field, Op: OpNotEqual, Val: val} }
+func Gt(field string, val any) Cond  { return WhereCond{Field: field, Op: OpGt, Val: val} }
+func Gte(field string, val any) Cond { return WhereCond{Field: field, Op: OpGte, Val: val} }
+func Lt(field string, val any) Cond  { return WhereCond{Field: field, Op: OpLt, Val: val} }
+func Lte(field string, val any) Cond { return WhereCond{Field: field, Op: OpLte, Val: val} }
+
+// In matches rows whose field equals one of vals, which may be a slice of any type.
+func In(field string, vals any) Cond    { return WhereCond{Field: field, Op: OpIn, Val: vals} }
+func NotIn(field string, vals any) Cond { return WhereCond{Field: field, Op: OpNotIn, Val: vals} }
+
+// Like matches field against an SQL LIKE pattern, e.g. "ref%".
+func Like(field string, pattern string) Cond {
+	return WhereCond{Field: field, Op: OpLike, Val: pattern}
+}
+
+// Glob matches field against a case-sensitive glob pattern, e.g. "ref*".
+func Glob(field string, pattern string) Cond {
+	return WhereCond{Field: field, Op: OpGlob, Val: pattern}
+}
+
+// Between matches rows whose field lies within [lo, hi].
+func Between(field string, lo, hi any) Cond {
+	return WhereCond{Field: field, Op: OpBetween, Val: []any{lo, hi}}
+}
+
+// JSONContains matches rows whose JSON column field holds val, e.g. roles
+// whose permissions contain 5.
+func JSONContains(field string, val any) Cond {
+	return WhereCond{Field: field, Op: OpJSONContains, Val: val}
+}
+
+func IsNull(field string) Cond    { return WhereCond{Field: field, Op: OpIsNull} }
+func IsNotNull(field string) Cond { return WhereCond{Field: field, Op: OpIsNotNull} }
+
+// Where validates conds, the arguments of FindWhere, and returns the body of the
+// WHERE clause. conds is either a flat list of conditions alternating with
+// QueryJoiners, as in (a, QueryJoinerOr, b, QueryJoinerAnd, c), or conditions
+// built with And, Or and Not. Consecutive conditions without a joiner are ANDed.
+func Where(conds ...Cond) (string, []any, error) {
+	tree, err := Tree(conds...)
+	if err != nil || tree == nil {
+		return "", []any{}, err
+	}
+	return tree.GetQueryWithArgs()
+}
+
+// Tree returns conds, the arguments of FindWhere, as a single condition built
+// with And and Or, or nil if conds is empty. Stores that do not build SQL
+// evaluate the tree instead. Tree only checks the joiners of conds, Where
+// checks the conditions too.
+func Tree(conds ...Cond) (Cond, error) {
+	if len(conds) == 0 {
+		return nil, nil
+	}
+	// and binds tighter than or, so the flat list is an or of and groups
+	ors := make([]Cond, 0, 1)
+	ands := make([]Cond, 0, len(conds))
+	expectCond := true
+	for i, cond := range conds {
+		joiner, isJoiner := cond.(QueryJoiner)
+		if !isJoiner {
+			if cond == nil {
+				return nil, fmt.Errorf("%w: nil condition at position %d", ErrInvalidCond, i)
+			}
+			ands = append(ands, cond)
+			expectCond = false
+			continue
+		}
+		if expectCond {
+			return nil, fmt.Errorf("%w: joiner %q at position %d must follow a condition", ErrInvalidCond, string(joiner), i)
+		}
+		switch joiner {
+		case QueryJoinerAnd:
+		case QueryJoinerOr:
+			ors = append(ors, andOf(ands))
+			ands = make([]Cond, 0, len(conds)-i)
+		default:
+			return nil, fmt.Errorf("%w: unknown joiner %q", ErrInvalidCond, string(joiner))
+		}
+		expectCond = true
+	}
+	if expectCond {
+		return nil, fmt.Errorf("%w: condition list ends with a joiner", ErrInvalidCond)
+	}
+	ors = append(ors, andOf(ands))
+
+	if len(ors) == 1 {
+		return ors[0], nil
+	}
+	return Or(ors...), nil
+}
+
+func andOf(conds []Cond) Cond {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return And(conds...)
+}
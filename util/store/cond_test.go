@@ -0,0 +1,73 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		name  string
+		conds []Cond
+		query string
+		args  []any
+	}{
+		{"empty", nil, "", []any{}},
+		{"single", []Cond{Eq("name", "admin")}, "name = ?", []any{"admin"}},
+		{
+			"flat list keeps and above or",
+			[]Cond{Eq("a", 1), QueryJoinerOr, Eq("b", 2), QueryJoinerAnd, &WhereCond{Field: "c", Op: OpLt, Val: 3}},
+			"(a = ? or (b = ? and c < ?))", []any{1, 2, 3},
+		},
+		{"implicit and", []Cond{Eq("a", 1), Eq("b", 2)}, "(a = ? and b = ?)", []any{1, 2}},
+		{
+			"tree",
+			[]Cond{And(Or(Like("name", "ref%"), Glob("name", "Ump*")), Not(Between("age", 10, 20)), IsNull("deleted_at"))},
+			"((name like ? or name glob ?) and not (age between ? and ?) and deleted_at is null)",
+			[]any{"ref%", "Ump*", 10, 20},
+		},
+		{"typed in", []Cond{In("id", []int64{1, 2})}, "id in (?,?)", []any{int64(1), int64(2)}},
+		{"not in", []Cond{NotIn("name", []string{"a"})}, "name not in (?)", []any{"a"}},
+		{"empty in", []Cond{In("id", []int64{})}, "1 = 0", nil},
+		{"empty and", []Cond{And()}, "1 = 1", []any{}},
+		{
+			"json contains",
+			[]Cond{JSONContains("permissions", 5)},
+			"exists (select 1 from json_each(permissions) where json_each.value = ?)", []any{5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := Where(tt.conds...)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.query, query)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestWhere_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		conds []Cond
+	}{
+		{"leading joiner", []Cond{QueryJoinerAnd, Eq("a", 1)}},
+		{"trailing joiner", []Cond{Eq("a", 1), QueryJoinerOr}},
+		{"double joiner", []Cond{Eq("a", 1), QueryJoinerOr, QueryJoinerAnd, Eq("b", 2)}},
+		{"unknown joiner", []Cond{Eq("a", 1), QueryJoiner("xor"), Eq("b", 2)}},
+		{"joiner in group", []Cond{And(Eq("a", 1), QueryJoinerOr, Eq("b", 2))}},
+		{"nil in group", []Cond{Or(Eq("a", 1), nil)}},
+		{"nil not", []Cond{Not(nil)}},
+		{"in without slice", []Cond{In("id", 1)}},
+		{"between with one bound", []Cond{WhereCond{Field: "age", Op: OpBetween, Val: []int{1}}}},
+		{"unknown op", []Cond{WhereCond{Field: "age", Op: "~", Val: 1}}},
+		{"empty field", []Cond{Eq("", 1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Where(tt.conds...)
+			assert.ErrorIs(t, err, ErrInvalidCond)
+		})
+	}
+}
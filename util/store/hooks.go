@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// Event names a point in the life of a row at which hooks run.
+type Event string
+
+const (
+	BeforeInsert Event = "before_insert"
+	AfterInsert  Event = "after_insert"
+	BeforeUpdate Event = "before_update"
+	AfterUpdate  Event = "after_update"
+	BeforeDelete Event = "before_delete"
+	AfterDelete  Event = "after_delete"
+)
+
+// Hook is called with the row of an Insert, Update or DeleteMulti. The primary
+// key of the row is set, except before an insert. Hooks may change the row
+// before it is written. An error aborts the change, which is rolled back.
+type Hook[T any] func(ctx context.Context, obj *T) error
+
+// The hook interfaces may be implemented by a model to run code around its own
+// changes. The model's hooks run before the hooks registered on the store.
+type (
+	BeforeInserter interface {
+		BeforeInsert(ctx context.Context) error
+	}
+	AfterInserter interface {
+		AfterInsert(ctx context.Context) error
+	}
+	BeforeUpdater interface {
+		BeforeUpdate(ctx context.Context) error
+	}
+	AfterUpdater interface {
+		AfterUpdate(ctx context.Context) error
+	}
+	BeforeDeleter interface {
+		BeforeDelete(ctx context.Context) error
+	}
+	AfterDeleter interface {
+		AfterDelete(ctx context.Context) error
+	}
+)
+
+// ModelHook returns the method of model, one of the hook interfaces above,
+// that runs at event, or nil.
+func ModelHook(event Event, model any) func(ctx context.Context) error {
+	switch event {
+	case BeforeInsert:
+		if m, ok := model.(BeforeInserter); ok {
+			return m.BeforeInsert
+		}
+	case AfterInsert:
+		if m, ok := model.(AfterInserter); ok {
+			return m.AfterInsert
+		}
+	case BeforeUpdate:
+		if m, ok := model.(BeforeUpdater); ok {
+			return m.BeforeUpdate
+		}
+	case AfterUpdate:
+		if m, ok := model.(AfterUpdater); ok {
+			return m.AfterUpdate
+		}
+	case BeforeDelete:
+		if m, ok := model.(BeforeDeleter); ok {
+			return m.BeforeDelete
+		}
+	case AfterDelete:
+		if m, ok := model.(AfterDeleter); ok {
+			return m.AfterDelete
+		}
+	}
+	return nil
+}
+
+// Hooker is implemented by stores that accept hooks.
+type Hooker[T any] interface {
+	// On registers hook to run at event. Hooks should be registered before
+	// the store is used.
+	On(event Event, hook Hook[T])
+}
+
+// On registers hook on s to run at event.
+func On[T any, R Row[T]](s Store[T, R], event Event, hook Hook[T]) error {
+	hooker, ok := s.(Hooker[T])
+	if !ok {
+		return ErrHooksNotSupported
+	}
+	hooker.On(event, hook)
+	return nil
+}
+
+var ErrHooksNotSupported error = errors.New("store does not support hooks")
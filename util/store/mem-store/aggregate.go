@@ -0,0 +1,125 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func (o *MemStore[T, R]) Count(ctx context.Context, conds ...store.Cond) (int64, error) {
+	rows, err := o.find(ctx, conds, "Count")
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(rows)), nil
+}
+
+func (o *MemStore[T, R]) Exists(ctx context.Context, conds ...store.Cond) (bool, error) {
+	rows, err := o.find(ctx, conds, "Exists")
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+// Aggregate computes the groups as SQLite does: min and max compare the
+// values, sum and avg read text as numbers, and the keys are ordered by
+// compare.
+func (o *MemStore[T, R]) Aggregate(ctx context.Context, agg store.Aggregation) ([]store.Group, error) {
+	switch agg.Func {
+	case store.AggCount, store.AggMin, store.AggMax, store.AggSum, store.AggAvg:
+	default:
+		return nil, fmt.Errorf("%s Aggregate function %q: %w", o.tablename, agg.Func, store.ErrInvalidAggregation)
+	}
+	field := -1
+	if len(agg.Field) > 0 {
+		var ok bool
+		if field, ok = o.column(agg.Field); !ok {
+			return nil, &store.UnknownFieldError{Table: o.tablename, Field: agg.Field}
+		}
+	} else if agg.Func != store.AggCount {
+		return nil, fmt.Errorf("%s Aggregate %s without field: %w", o.tablename, agg.Func, store.ErrInvalidAggregation)
+	}
+	groupBy := make([]int, 0, len(agg.GroupBy))
+	for _, name := range agg.GroupBy {
+		i, ok := o.column(name)
+		if !ok {
+			return nil, &store.UnknownFieldError{Table: o.tablename, Field: name}
+		}
+		groupBy = append(groupBy, i)
+	}
+	rows, err := o.find(ctx, agg.Where, "Aggregate")
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		keys []any
+		rows [][]any
+	}
+	var groups []*group
+	byKeys := map[string]*group{}
+	for _, row := range rows {
+		keys := make([]any, 0, len(groupBy))
+		for _, i := range groupBy {
+			keys = append(keys, row[i])
+		}
+		// %#v quotes text and tells it from blobs, and prints equal numbers
+		// alike, which SQLite puts in the same group
+		k := fmt.Sprintf("%#v", keys)
+		g, ok := byKeys[k]
+		if !ok {
+			g = &group{keys: keys}
+			byKeys[k] = g
+			groups = append(groups, g)
+		}
+		g.rows = append(g.rows, row)
+	}
+	if len(groupBy) == 0 && len(groups) == 0 {
+		// without GROUP BY an aggregate has one row even if nothing matches
+		groups = append(groups, &group{keys: []any{}})
+	}
+	slices.SortFunc(groups, func(a, b *group) int {
+		for i := range a.keys {
+			if c := compare(a.keys[i], b.keys[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+
+	var out []store.Group
+	for _, g := range groups {
+		out = append(out, store.Group{Keys: g.keys, Value: aggregate(agg.Func, field, g.rows)})
+	}
+	return out, nil
+}
+
+// aggregate computes fn over the column field of rows, or counts rows if field
+// is -1. An aggregate of no row is 0.
+func aggregate(fn store.AggFunc, field int, rows [][]any) float64 {
+	if fn == store.AggCount || len(rows) == 0 {
+		return float64(len(rows))
+	}
+	switch fn {
+	case store.AggMin, store.AggMax:
+		best := rows[0][field]
+		for _, row := range rows[1:] {
+			c := compare(row[field], best)
+			if fn == store.AggMin && c < 0 || fn == store.AggMax && c > 0 {
+				best = row[field]
+			}
+		}
+		return numberOf(best)
+	}
+	sum := 0.0
+	for _, row := range rows {
+		sum += numberOf(row[field])
+	}
+	if fn == store.AggAvg {
+		return sum / float64(len(rows))
+	}
+	return sum
+}
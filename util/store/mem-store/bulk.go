@@ -0,0 +1,114 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// InsertMulti inserts objs under a single lock, so no reader sees a part of
+// them.
+func (o *MemStore[T, R]) InsertMulti(ctx context.Context, objs []T) ([]int64, error) {
+	ids := make([]int64, 0, len(objs))
+	err := o.write(ctx, func(s *MemStore[T, R]) error {
+		for _, obj := range objs {
+			id, err := s.insert(ctx, obj)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	}, store.BeforeInsert, store.AfterInsert)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (o *MemStore[T, R]) UpdateMulti(ctx context.Context, ids []int64, objs []T) error {
+	if len(ids) != len(objs) {
+		return fmt.Errorf("%s UpdateMulti got %d ids for %d rows", o.tablename, len(ids), len(objs))
+	}
+	return o.write(ctx, func(s *MemStore[T, R]) error {
+		for i, obj := range objs {
+			if err := s.update(ctx, ids[i], obj, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, store.BeforeUpdate, store.AfterUpdate)
+}
+
+// Upsert updates the live row that has the value of field of obj, keeping
+// its created_at and incrementing its version, or else inserts obj. A row in
+// the trash is left alone, as it is by Insert. The insert or the update hooks
+// run depending on whether a live row has the value of field of obj before the
+// BeforeInsert or BeforeUpdate hooks run.
+func (o *MemStore[T, R]) Upsert(ctx context.Context, field string, objs []T) ([]int64, error) {
+	col, ok := o.column(field)
+	if !ok {
+		return nil, &store.UnknownFieldError{Table: o.tablename, Field: field}
+	}
+	if !o.columns[col].IsIdxUniq {
+		return nil, fmt.Errorf("%s Upsert on %s: %w", o.tablename, field, store.ErrNotUnique)
+	}
+
+	ids := make([]int64, 0, len(objs))
+	err := o.write(ctx, func(s *MemStore[T, R]) error {
+		for _, obj := range objs {
+			id, err := s.upsert(ctx, col, obj)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	}, store.BeforeInsert, store.AfterInsert, store.BeforeUpdate, store.AfterUpdate)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (o *MemStore[T, R]) upsert(ctx context.Context, col int, obj T) (int64, error) {
+	row, err := o.encode(&obj)
+	if err != nil {
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	before, after := store.BeforeInsert, store.AfterInsert
+	if id, ok := o.data().unique[col][uniqueKey(row[col])]; ok {
+		before, after = store.BeforeUpdate, store.AfterUpdate
+		setInt(o.field(&obj, o.pk), id)
+	}
+	if before == store.BeforeInsert {
+		// insert runs the insert hooks itself
+		return o.insert(ctx, obj)
+	}
+	if err = o.runHooks(ctx, before, &obj); err != nil {
+		return 0, err
+	}
+
+	o.stamp(&obj, time.Now(), false)
+	if row, err = o.encode(&obj); err != nil {
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	id, ok := o.data().unique[col][uniqueKey(row[col])]
+	if !ok {
+		// a hook changed the value of field
+		return o.insertRow(ctx, &obj, row, after)
+	}
+	old, _ := o.live(id)
+	version, err := o.replace(id, old, row)
+	if err != nil {
+		return 0, err
+	}
+	setInt(o.field(&obj, o.pk), id)
+	o.setVersion(&obj, version)
+	if err = o.runHooks(ctx, after, &obj); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,394 @@
+package memstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// truth is the value of a condition on a row, which SQL makes unknown when it
+// compares a NULL.
+type truth int8
+
+const (
+	isFalse truth = iota
+	isTrue
+	isUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return isTrue
+	}
+	return isFalse
+}
+
+func (t truth) not() truth {
+	switch t {
+	case isTrue:
+		return isFalse
+	case isFalse:
+		return isTrue
+	}
+	return isUnknown
+}
+
+// predicate evaluates a condition on an encoded row.
+type predicate func(row []any) truth
+
+// compile checks conds, the arguments of FindWhere, and returns their
+// predicate, or nil if there is no condition.
+func (o *MemStore[T, R]) compile(conds []store.Cond, op string) (predicate, error) {
+	if err := o.checkFields(conds); err != nil {
+		return nil, err
+	}
+	// Where checks the conditions the way the SQLite store does
+	if _, _, err := store.Where(conds...); err != nil {
+		return nil, fmt.Errorf("%s %s: %w", o.tablename, op, err)
+	}
+	tree, err := store.Tree(conds...)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+	pred, err := o.compileCond(tree)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", o.tablename, op, err)
+	}
+	return pred, nil
+}
+
+func (o *MemStore[T, R]) compileCond(cond store.Cond) (predicate, error) {
+	switch cond := cond.(type) {
+	case store.WhereCond:
+		return o.compileWhere(cond)
+	case store.NotCond:
+		pred, err := o.compileCond(cond.Cond)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []any) truth { return pred(row).not() }, nil
+	case store.GroupCond:
+		preds := make([]predicate, 0, len(cond.Conds))
+		for _, c := range cond.Conds {
+			pred, err := o.compileCond(c)
+			if err != nil {
+				return nil, err
+			}
+			preds = append(preds, pred)
+		}
+		// and is false if one condition is, or is true if they all are;
+		// or is the reverse
+		stop, all := isFalse, isTrue
+		if cond.Joiner == store.QueryJoinerOr {
+			stop, all = isTrue, isFalse
+		}
+		return func(row []any) truth {
+			result := all
+			for _, pred := range preds {
+				switch pred(row) {
+				case stop:
+					return stop
+				case isUnknown:
+					result = isUnknown
+				}
+			}
+			return result
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported condition %T", store.ErrInvalidCond, cond)
+	}
+}
+
+func (o *MemStore[T, R]) compileWhere(cond store.WhereCond) (predicate, error) {
+	i, _ := o.column(cond.Field)
+	aff := o.columns[i].Affinity
+	arg := func(val any) (any, error) {
+		v, err := normalize(val)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", store.ErrInvalidCond, cond.Field, err)
+		}
+		return aff.convert(v), nil
+	}
+
+	switch cond.Op {
+	case store.OpEqual, store.OpNotEqual, store.OpGt, store.OpGte, store.OpLt, store.OpLte:
+		val, err := arg(cond.Val)
+		if err != nil {
+			return nil, err
+		}
+		op := cond.Op
+		return func(row []any) truth {
+			if val == nil || row[i] == nil {
+				return isUnknown
+			}
+			c := compare(row[i], val)
+			switch op {
+			case store.OpEqual:
+				return truthOf(c == 0)
+			case store.OpNotEqual:
+				return truthOf(c != 0)
+			case store.OpGt:
+				return truthOf(c > 0)
+			case store.OpGte:
+				return truthOf(c >= 0)
+			case store.OpLt:
+				return truthOf(c < 0)
+			}
+			return truthOf(c <= 0)
+		}, nil
+	case store.OpIn, store.OpNotIn:
+		list := reflect.ValueOf(cond.Val)
+		vals := make([]any, 0, list.Len())
+		for j := 0; j < list.Len(); j++ {
+			val, err := arg(list.Index(j).Interface())
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, val)
+		}
+		in := func(row []any) truth {
+			result := isFalse
+			for _, val := range vals {
+				if val == nil || row[i] == nil {
+					result = isUnknown
+				} else if compare(row[i], val) == 0 {
+					return isTrue
+				}
+			}
+			return result
+		}
+		if cond.Op == store.OpNotIn {
+			return func(row []any) truth { return in(row).not() }, nil
+		}
+		return in, nil
+	case store.OpBetween:
+		bounds := reflect.ValueOf(cond.Val)
+		lo, err := arg(bounds.Index(0).Interface())
+		if err != nil {
+			return nil, err
+		}
+		hi, err := arg(bounds.Index(1).Interface())
+		if err != nil {
+			return nil, err
+		}
+		return func(row []any) truth {
+			if row[i] == nil || lo == nil || hi == nil {
+				return isUnknown
+			}
+			return truthOf(compare(row[i], lo) >= 0 && compare(row[i], hi) <= 0)
+		}, nil
+	case store.OpLike, store.OpNotLike, store.OpGlob:
+		val, err := normalize(cond.Val)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", store.ErrInvalidCond, cond.Field, err)
+		}
+		pattern := compilePattern(textOf(val), cond.Op == store.OpGlob)
+		negate := cond.Op == store.OpNotLike
+		return func(row []any) truth {
+			if val == nil || row[i] == nil {
+				return isUnknown
+			}
+			return truthOf(pattern.match(textOf(row[i])) != negate)
+		}, nil
+	case store.OpJSONContains:
+		val, err := normalize(cond.Val)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", store.ErrInvalidCond, cond.Field, err)
+		}
+		return func(row []any) truth {
+			if val == nil {
+				return isFalse
+			}
+			for _, elem := range jsonElements(textOf(row[i])) {
+				if elem != nil && compare(elem, val) == 0 {
+					return isTrue
+				}
+			}
+			return isFalse
+		}, nil
+	case store.OpIsNull, store.OpIsNotNull:
+		isNull := cond.Op == store.OpIsNull
+		return func(row []any) truth { return truthOf((row[i] == nil) == isNull) }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown operator %q", store.ErrInvalidCond, cond.Op)
+}
+
+// jsonElements returns the values json_each yields for the JSON text s: the
+// elements of an array, the values of an object or s itself, encoded as
+// SQLite returns them. Invalid JSON has no element.
+func jsonElements(s string) []any {
+	var doc any
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil
+	}
+	var elems []any
+	switch doc := doc.(type) {
+	case []any:
+		elems = doc
+	case map[string]any:
+		for _, v := range doc {
+			elems = append(elems, v)
+		}
+	default:
+		elems = []any{doc}
+	}
+	for i, elem := range elems {
+		switch v := elem.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				elems[i] = n
+			} else {
+				elems[i], _ = v.Float64()
+			}
+		case bool:
+			elems[i] = int64(0)
+			if v {
+				elems[i] = int64(1)
+			}
+		case []any, map[string]any:
+			b, _ := json.Marshal(v)
+			elems[i] = string(b)
+		}
+	}
+	return elems
+}
+
+// pattern is a compiled LIKE or GLOB pattern.
+type pattern struct {
+	tokens []token
+	glob   bool
+	// invalid marks a glob with an unclosed [, which matches nothing.
+	invalid bool
+}
+
+type token struct {
+	kind  tokenKind
+	r     rune
+	class []classRange
+	// negate inverts class, for [^...].
+	negate bool
+}
+
+type tokenKind int8
+
+const (
+	tokenLiteral tokenKind = iota
+	// tokenAny is % of LIKE or * of GLOB.
+	tokenAny
+	// tokenOne is _ of LIKE or ? of GLOB.
+	tokenOne
+	tokenClass
+)
+
+type classRange struct{ lo, hi rune }
+
+// compilePattern compiles a LIKE pattern, whose % and _ match any text and any
+// character and whose letters match either case, or with glob a GLOB pattern,
+// with *, ?, and [...] classes, matching case.
+func compilePattern(s string, glob bool) pattern {
+	p := pattern{glob: glob}
+	runes := []rune(s)
+	for j := 0; j < len(runes); j++ {
+		r := runes[j]
+		switch {
+		case glob && r == '*' || !glob && r == '%':
+			p.tokens = append(p.tokens, token{kind: tokenAny})
+		case glob && r == '?' || !glob && r == '_':
+			p.tokens = append(p.tokens, token{kind: tokenOne})
+		case glob && r == '[':
+			tok := token{kind: tokenClass}
+			k := j + 1
+			if k < len(runes) && runes[k] == '^' {
+				tok.negate = true
+				k++
+			}
+			// a ] right after [ or [^ is part of the class
+			start := k
+			for ; k < len(runes) && (runes[k] != ']' || k == start); k++ {
+				lo := runes[k]
+				if k+2 < len(runes) && runes[k+1] == '-' && runes[k+2] != ']' {
+					tok.class = append(tok.class, classRange{lo, runes[k+2]})
+					k += 2
+					continue
+				}
+				tok.class = append(tok.class, classRange{lo, lo})
+			}
+			if k >= len(runes) {
+				p.invalid = true
+				return p
+			}
+			p.tokens = append(p.tokens, tok)
+			j = k
+		default:
+			p.tokens = append(p.tokens, token{kind: tokenLiteral, r: r})
+		}
+	}
+	return p
+}
+
+// match reports whether the whole of s matches p, backtracking to the last
+// wildcard on a mismatch.
+func (p pattern) match(s string) bool {
+	if p.invalid {
+		return false
+	}
+	text := []rune(s)
+	t, i := 0, 0
+	star, starI := -1, 0
+	for i < len(text) {
+		if t < len(p.tokens) && p.tokens[t].kind == tokenAny {
+			star, starI = t, i
+			t++
+			continue
+		}
+		if t < len(p.tokens) && p.matchOne(p.tokens[t], text[i]) {
+			t++
+			i++
+			continue
+		}
+		if star < 0 {
+			return false
+		}
+		t = star + 1
+		starI++
+		i = starI
+	}
+	for t < len(p.tokens) && p.tokens[t].kind == tokenAny {
+		t++
+	}
+	return t == len(p.tokens)
+}
+
+func (p pattern) matchOne(tok token, r rune) bool {
+	switch tok.kind {
+	case tokenOne:
+		return true
+	case tokenClass:
+		in := false
+		for _, cr := range tok.class {
+			if cr.lo <= r && r <= cr.hi {
+				in = true
+				break
+			}
+		}
+		return in != tok.negate
+	default:
+		if p.glob {
+			return tok.r == r
+		}
+		return foldASCII(tok.r) == foldASCII(r)
+	}
+}
+
+// foldASCII lowers ASCII letters only, like SQLite's LIKE.
+func foldASCII(r rune) rune {
+	if 'A' <= r && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
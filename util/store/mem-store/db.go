@@ -0,0 +1,201 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// DB holds the tables of the stores opened on it, see NewStoreFromDB, so that
+// their writes can run in one transaction.
+//
+// A transaction writes to copies of the tables it changes, which replace the
+// tables when it commits: until then, the stores that are not bound to it read
+// the rows as they were before it. Writes outside of the transaction wait for
+// it to end, as they do for the single writer of the SQLite store.
+type DB struct {
+	// writer is held by a transaction, and by each write outside of one.
+	writer sync.Mutex
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+// NewDB returns an empty database.
+func NewDB() *DB {
+	return &DB{tables: map[string]*table{}}
+}
+
+// table returns the table tableName, created on first use. The stores of a
+// table must have the same columns.
+func (d *DB) table(tableName string, columns []column) (*table, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, col.Name)
+	}
+	if t, ok := d.tables[tableName]; ok {
+		if !slices.Equal(t.columns, names) {
+			return nil, fmt.Errorf("%s is open with the columns %v", tableName, t.columns)
+		}
+		return t, nil
+	}
+	t := &table{columns: names, data: newTableData(columns)}
+	d.tables[tableName] = t
+	return t, nil
+}
+
+// RunInTx calls fn inside a transaction. Stores opened on d join it with
+// WithTx. The transaction holds the writer until it ends: fn must write
+// through stores bound to the transaction, as a write through an unbound
+// store waits for the transaction.
+func (d *DB) RunInTx(ctx context.Context, fn func(tx store.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("memstore begin tx failed: %w", err)
+	}
+	d.writer.Lock()
+	defer d.writer.Unlock()
+
+	tx := &Tx{db: d, pending: map[*table]*tableData{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	// the tables are all locked, so that no read sees a part of the changes
+	for t := range tx.pending {
+		t.mu.Lock()
+	}
+	for t, data := range tx.pending {
+		t.data = data
+		t.mu.Unlock()
+	}
+	for _, onCommit := range tx.onCommit {
+		onCommit()
+	}
+	return nil
+}
+
+// Close is a no-op: the tables live as long as d.
+func (d *DB) Close() error {
+	return nil
+}
+
+// Tx is a transaction opened by DB.RunInTx.
+type Tx struct {
+	db *DB
+	mu sync.Mutex
+	// pending holds the tables changed by the transaction, see tableData.
+	pending  map[*table]*tableData
+	onCommit []func()
+}
+
+// data returns the copy of t changed by the transaction, made by the first
+// change if create.
+func (t *Tx) data(tbl *table, create bool) (*tableData, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, ok := t.pending[tbl]
+	if !ok && create {
+		data, ok = tbl.data.clone(), true
+		t.pending[tbl] = data
+	}
+	return data, ok
+}
+
+func (t *Tx) Database() string {
+	return fmt.Sprintf("memstore %p", t.db)
+}
+
+// OnCommit calls fn after the transaction commits, see store.CommitNotifier.
+func (t *Tx) OnCommit(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onCommit = append(t.onCommit, fn)
+}
+
+// table is a table of a DB, shared by the stores opened on it.
+type table struct {
+	// mu guards the rows: reads hold it shared, writes and commits alone.
+	mu      sync.RWMutex
+	columns []string
+	// data holds the committed rows.
+	data *tableData
+	// undo records the changes of the write in progress, see MemStore.write.
+	undo []change
+}
+
+// tableData holds the rows of a table. The rows are replaced but never
+// changed in place, so that readers may keep them after unlocking.
+type tableData struct {
+	// rows maps the primary keys to the encoded rows.
+	rows map[int64][]any
+	// ids holds the keys of rows in ascending order.
+	ids []int64
+	// unique maps each unique column to an index from value to primary key.
+	// Rows in the trash are left out, as by the partial unique indexes of the
+	// SQLite store.
+	unique map[int]map[any]int64
+	// softDelete is the index of the soft_delete column, or -1.
+	softDelete int
+}
+
+func newTableData(columns []column) *tableData {
+	data := &tableData{rows: map[int64][]any{}, unique: map[int]map[any]int64{}, softDelete: -1}
+	for i, col := range columns {
+		if col.IsIdxUniq {
+			data.unique[i] = map[any]int64{}
+		}
+		if col.IsSoftDelete {
+			data.softDelete = i
+		}
+	}
+	return data
+}
+
+// clone returns a copy of data that a transaction can change.
+func (data *tableData) clone() *tableData {
+	unique := make(map[int]map[any]int64, len(data.unique))
+	for i, index := range data.unique {
+		unique[i] = maps.Clone(index)
+	}
+	return &tableData{rows: maps.Clone(data.rows), ids: slices.Clone(data.ids), unique: unique, softDelete: data.softDelete}
+}
+
+func (data *tableData) set(id int64, row []any) {
+	if old, ok := data.rows[id]; ok {
+		data.unindex(id, old)
+	} else {
+		i, _ := slices.BinarySearch(data.ids, id)
+		data.ids = slices.Insert(data.ids, i, id)
+	}
+	data.rows[id] = row
+	if data.softDelete >= 0 && row[data.softDelete] != int64(0) {
+		return
+	}
+	for i, index := range data.unique {
+		index[uniqueKey(row[i])] = id
+	}
+}
+
+func (data *tableData) unset(id int64) {
+	old, ok := data.rows[id]
+	if !ok {
+		return
+	}
+	data.unindex(id, old)
+	delete(data.rows, id)
+	if i, found := slices.BinarySearch(data.ids, id); found {
+		data.ids = slices.Delete(data.ids, i, i+1)
+	}
+}
+
+func (data *tableData) unindex(id int64, row []any) {
+	for i, index := range data.unique {
+		if key := uniqueKey(row[i]); index[key] == id {
+			delete(index, key)
+		}
+	}
+}
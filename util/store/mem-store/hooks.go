@@ -0,0 +1,46 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// On registers hook to run at event, after the hooks of the model. Hooks run
+// while the DB is locked, so they must not use the stores of the DB, except
+// through the transaction they get in ctx. On is not safe to call while the
+// store is in use.
+func (o *MemStore[T, R]) On(event store.Event, hook store.Hook[T]) {
+	o.hooks[event] = append(o.hooks[event], hook)
+}
+
+// hasHooks reports whether a hook runs at one of events.
+func (o *MemStore[T, R]) hasHooks(events ...store.Event) bool {
+	var obj T
+	for _, event := range events {
+		if len(o.hooks[event]) > 0 || store.ModelHook(event, R(&obj)) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// runHooks runs the hooks of event on obj, stopping at the first error. The
+// hooks get the transaction of the change in ctx, see store.TxFromContext.
+func (o *MemStore[T, R]) runHooks(ctx context.Context, event store.Event, obj *T) error {
+	if o.tx != nil {
+		ctx = store.ContextWithTx(ctx, o.tx)
+	}
+	if hook := store.ModelHook(event, R(obj)); hook != nil {
+		if err := hook(ctx); err != nil {
+			return fmt.Errorf("%s %s hook failed: %w", o.tablename, event, err)
+		}
+	}
+	for _, hook := range o.hooks[event] {
+		if err := hook(ctx, obj); err != nil {
+			return fmt.Errorf("%s %s hook failed: %w", o.tablename, event, err)
+		}
+	}
+	return nil
+}
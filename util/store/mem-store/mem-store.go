@@ -0,0 +1,640 @@
+// Package memstore is an in-memory implementation of store.Store, for tests
+// and for data that need not outlive the process. It follows the semantics of
+// the SQLite store: the same conditions, unique indexes, stamps, versions,
+// soft delete and hooks, checked by the storetest conformance suite.
+package memstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+	"unicode"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// MemStore keeps the rows of T in memory. Rows are stored encoded, the way the
+// SQLite store writes them, so that callers never share memory with the store.
+// Every write is all or nothing: a failing row or hook undoes the changes of
+// the call. The stores opened on the same DB can write in one transaction, see
+// DB.RunInTx.
+type MemStore[T any, R store.Row[T]] struct {
+	tablename string
+	columns   []column
+	// pk, softDelete, createdAt, updatedAt and version are the positions of
+	// these columns in columns, or -1 if the model has none.
+	pk, softDelete, createdAt, updatedAt, version int
+
+	db    *DB
+	table *table
+	// tx is the transaction of a view returned by WithTx.
+	tx    *Tx
+	hooks map[store.Event][]store.Hook[T]
+}
+
+// change is the state of row id before a write: row is nil if it did not exist.
+type change struct {
+	id  int64
+	row []any
+}
+
+// NewStore returns an empty store for T on a DB of its own. The columns of T
+// are declared by db tags as for the SQLite store, which must include a pk
+// column.
+func NewStore[T any, R store.Row[T]]() (*MemStore[T, R], error) {
+	return NewStoreFromDB[T, R](NewDB())
+}
+
+// NewStoreFromDB returns a store for the table of T in db, which is created
+// empty unless another store of db uses it.
+func NewStoreFromDB[T any, R store.Row[T]](db *DB) (*MemStore[T, R], error) {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	tableName := toSnakeCase(typ.Name())
+	if namer, ok := any(R(&obj)).(store.TableNamer); ok {
+		tableName = namer.TableName()
+	}
+	columns, err := getColumns(typ)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tableName, err)
+	}
+
+	o := &MemStore[T, R]{
+		tablename: tableName, columns: columns,
+		pk: -1, softDelete: -1, createdAt: -1, updatedAt: -1, version: -1,
+		db: db, hooks: map[store.Event][]store.Hook[T]{},
+	}
+	for i, col := range columns {
+		switch {
+		case col.IsPK:
+			o.pk = i
+		case col.IsSoftDelete:
+			o.softDelete = i
+		case col.IsCreatedAt:
+			o.createdAt = i
+		case col.IsUpdatedAt:
+			o.updatedAt = i
+		case col.IsVersion:
+			o.version = i
+		}
+	}
+	if o.pk < 0 {
+		return nil, fmt.Errorf("%s has no pk column", tableName)
+	}
+	if o.table, err = db.table(tableName, columns); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// WithTx returns a view of the store whose methods run inside tx. tx must have
+// been opened on the same DB as the store.
+func (o *MemStore[T, R]) WithTx(tx store.Tx) (store.Store[T, R], error) {
+	memTx, ok := tx.(*Tx)
+	if !ok || memTx.db != o.db {
+		return nil, store.ErrTxMismatch
+	}
+	view := *o
+	view.tx = memTx
+	return &view, nil
+}
+
+func (o *MemStore[T, R]) Insert(ctx context.Context, obj T) (int64, error) {
+	var id int64
+	err := o.write(ctx, func(s *MemStore[T, R]) error {
+		var err error
+		id, err = s.insert(ctx, obj)
+		return err
+	}, store.BeforeInsert, store.AfterInsert)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insert adds obj under the next primary key, the largest one plus 1 as in
+// SQLite.
+func (o *MemStore[T, R]) insert(ctx context.Context, obj T) (int64, error) {
+	if err := o.runHooks(ctx, store.BeforeInsert, &obj); err != nil {
+		return 0, err
+	}
+	o.stamp(&obj, time.Now(), false)
+	row, err := o.encode(&obj)
+	if err != nil {
+		return 0, fmt.Errorf("%s insert failed: %w", o.tablename, err)
+	}
+	return o.insertRow(ctx, &obj, row, store.AfterInsert)
+}
+
+// insertRow adds row, the encoding of obj, and runs the hooks of after on obj.
+func (o *MemStore[T, R]) insertRow(ctx context.Context, obj *T, row []any, after store.Event) (int64, error) {
+	data := o.data()
+	id := int64(1)
+	if len(data.ids) > 0 {
+		id = data.ids[len(data.ids)-1] + 1
+	}
+	row[o.pk] = id
+	if o.softDelete >= 0 {
+		row[o.softDelete] = int64(0)
+	}
+	if o.conflicts(row, id) {
+		return 0, store.ErrConflicted
+	}
+	o.put(id, row)
+
+	setInt(o.field(obj, o.pk), id)
+	if err := o.runHooks(ctx, after, obj); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (o *MemStore[T, R]) Update(ctx context.Context, id int64, obj T) error {
+	return o.write(ctx, func(s *MemStore[T, R]) error {
+		return s.update(ctx, id, obj, true)
+	}, store.BeforeUpdate, store.AfterUpdate)
+}
+
+// update replaces the live row id with obj, keeping its created_at and
+// incrementing its version, which must be the one of obj if checkVersion.
+func (o *MemStore[T, R]) update(ctx context.Context, id int64, obj T, checkVersion bool) error {
+	setInt(o.field(&obj, o.pk), id)
+	if err := o.runHooks(ctx, store.BeforeUpdate, &obj); err != nil {
+		return err
+	}
+	o.stamp(&obj, time.Now(), true)
+	row, err := o.encode(&obj)
+	if err != nil {
+		return fmt.Errorf("%s update failed: %w", o.tablename, err)
+	}
+	old, ok := o.live(id)
+	if !ok {
+		return store.ErrNotFound
+	}
+	if o.version >= 0 && checkVersion && row[o.version] != old[o.version] {
+		return store.ErrStaleVersion
+	}
+	version, err := o.replace(id, old, row)
+	if err != nil {
+		return err
+	}
+	o.setVersion(&obj, version)
+	return o.runHooks(ctx, store.AfterUpdate, &obj)
+}
+
+// replace writes row over old, the live row id, keeping the columns that an
+// update does not change, and returns the new version of the row, or 0 if the
+// model has no version column.
+func (o *MemStore[T, R]) replace(id int64, old, row []any) (int64, error) {
+	for _, i := range []int{o.pk, o.softDelete, o.createdAt} {
+		if i >= 0 {
+			row[i] = old[i]
+		}
+	}
+	version := int64(0)
+	if o.version >= 0 {
+		version = old[o.version].(int64) + 1
+		row[o.version] = version
+	}
+	if o.conflicts(row, id) {
+		return 0, store.ErrConflicted
+	}
+	o.put(id, row)
+	return version, nil
+}
+
+func (o *MemStore[T, R]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	rows, err := o.read(ctx, func() ([][]any, error) {
+		rows := make([][]any, 0, len(ids))
+		for _, id := range o.data().ids {
+			if row, ok := o.live(id); ok && slices.Contains(ids, id) {
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.decodeAll(rows, "GetMulti")
+}
+
+func (o *MemStore[T, R]) GetOne(ctx context.Context, id int64) (T, error) {
+	var obj T
+	rows, err := o.read(ctx, func() ([][]any, error) {
+		row, ok := o.live(id)
+		if !ok {
+			return nil, store.ErrNotFound
+		}
+		return [][]any{row}, nil
+	})
+	if err != nil {
+		return obj, err
+	}
+	if err = o.decode(rows[0], &obj); err != nil {
+		return obj, fmt.Errorf("%s GetOne row.Scan error: %w", o.tablename, err)
+	}
+	return obj, nil
+}
+
+// DeleteMulti deletes the rows ids. If the model has a soft_delete column, the
+// rows are only marked as deleted, see Restore and Purge.
+func (o *MemStore[T, R]) DeleteMulti(ctx context.Context, ids []int64) error {
+	return o.write(ctx, func(s *MemStore[T, R]) error {
+		return s.deleteMulti(ctx, ids)
+	}, store.BeforeDelete, store.AfterDelete)
+}
+
+func (o *MemStore[T, R]) deleteMulti(ctx context.Context, ids []int64) error {
+	// the delete hooks get the rows as they were before the delete
+	var objs []T
+	var deleted []int64
+	for _, id := range o.data().ids {
+		row, ok := o.live(id)
+		if !ok || !slices.Contains(ids, id) {
+			continue
+		}
+		var obj T
+		if err := o.decode(row, &obj); err != nil {
+			return fmt.Errorf("%s DeleteMulti row.Scan error: %w", o.tablename, err)
+		}
+		objs = append(objs, obj)
+		deleted = append(deleted, id)
+	}
+	for i := range objs {
+		if err := o.runHooks(ctx, store.BeforeDelete, &objs[i]); err != nil {
+			return err
+		}
+	}
+	if len(deleted) == 0 {
+		return store.ErrNotFound
+	}
+	now := time.Now().Unix()
+	for _, id := range deleted {
+		if o.softDelete < 0 {
+			o.remove(id)
+			continue
+		}
+		row := slices.Clone(o.data().rows[id])
+		row[o.softDelete] = now
+		o.put(id, row)
+	}
+	for i := range objs {
+		if err := o.runHooks(ctx, store.AfterDelete, &objs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *MemStore[T, R]) FindWhere(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	rows, err := o.find(ctx, conds, "FindWhere")
+	if err != nil {
+		return nil, err
+	}
+	return o.decodeAll(rows, "FindWhere")
+}
+
+// Each decodes the rows after unlocking the store, so fn may write to it
+// without changing the rows being read.
+func (o *MemStore[T, R]) Each(ctx context.Context, fn func(obj T) error, conds ...store.Cond) error {
+	rows, err := o.find(ctx, conds, "Each")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var obj T
+		if err = o.decode(row, &obj); err != nil {
+			return fmt.Errorf("%s Each row.Scan error: %w", o.tablename, err)
+		}
+		if err = fn(obj); err != nil {
+			if errors.Is(err, store.ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *MemStore[T, R]) FindPage(ctx context.Context, q store.Query) (store.Page[T], error) {
+	var page store.Page[T]
+
+	pred, err := o.compile(q.Where, "FindPage")
+	if err != nil {
+		return page, err
+	}
+	orders, err := o.orderColumns(q.OrderBy)
+	if err != nil {
+		return page, err
+	}
+	var after predicate
+	if len(q.After) > 0 {
+		vals, err := q.After.Values()
+		if err != nil {
+			return page, err
+		}
+		if len(vals) != len(orders) {
+			return page, store.ErrInvalidCursor
+		}
+		if after, err = o.compile([]store.Cond{keysetCond(orders, vals)}, "FindPage"); err != nil {
+			return page, store.ErrInvalidCursor
+		}
+	}
+
+	rows, err := o.read(ctx, func() ([][]any, error) {
+		return o.match(pred), nil
+	})
+	if err != nil {
+		return page, err
+	}
+	page.Total = int64(len(rows))
+
+	indexes := make([]int, 0, len(orders))
+	for _, order := range orders {
+		i, _ := o.column(order.Field)
+		indexes = append(indexes, i)
+	}
+	slices.SortStableFunc(rows, func(a, b []any) int {
+		for j, i := range indexes {
+			if c := compare(a[i], b[i]); c != 0 {
+				if orders[j].Dir == store.Desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+	if after != nil {
+		rows = slices.DeleteFunc(rows, func(row []any) bool { return after(row) != isTrue })
+	}
+	rows = rows[min(q.Offset, len(rows)):]
+	hasNext := q.Limit > 0 && len(rows) > q.Limit
+	if hasNext {
+		rows = rows[:q.Limit]
+	}
+	if page.Items, err = o.decodeAll(rows, "FindPage"); err != nil {
+		return page, err
+	}
+
+	if hasNext {
+		last := rows[len(rows)-1]
+		vals := make([]any, 0, len(indexes))
+		for _, i := range indexes {
+			vals = append(vals, last[i])
+		}
+		page.Next, err = store.NewCursor(vals)
+		if err != nil {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// orderColumns validates orders and appends the primary key as a tie-breaker
+// so that keyset cursors identify a unique row.
+func (o *MemStore[T, R]) orderColumns(orders []store.Order) ([]store.Order, error) {
+	out := make([]store.Order, 0, len(orders)+1)
+	hasPK := false
+	for _, order := range orders {
+		i, ok := o.column(order.Field)
+		if !ok {
+			return nil, &store.UnknownFieldError{Table: o.tablename, Field: order.Field}
+		}
+		switch order.Dir {
+		case "":
+			order.Dir = store.Asc
+		case store.Asc, store.Desc:
+		default:
+			return nil, fmt.Errorf("%s invalid order direction %q", o.tablename, order.Dir)
+		}
+		if i == o.pk {
+			hasPK = true
+		}
+		out = append(out, order)
+	}
+	if !hasPK {
+		out = append(out, store.Order{Field: o.columns[o.pk].Name, Dir: store.Asc})
+	}
+	return out, nil
+}
+
+// keysetCond returns the condition selecting rows that sort after vals under
+// orders, e.g. (a > x) or (a = x and b < y) for "a asc, b desc".
+func keysetCond(orders []store.Order, vals []any) store.Cond {
+	ors := make([]store.Cond, 0, len(orders))
+	for i, order := range orders {
+		ands := make([]store.Cond, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, store.Eq(orders[j].Field, vals[j]))
+		}
+		if order.Dir == store.Desc {
+			ands = append(ands, store.Lt(order.Field, vals[i]))
+		} else {
+			ands = append(ands, store.Gt(order.Field, vals[i]))
+		}
+		ors = append(ors, store.And(ands...))
+	}
+	return store.Or(ors...)
+}
+
+// Close is a no-op: the rows live as long as the store.
+func (o *MemStore[T, R]) Close() error {
+	return nil
+}
+
+// find returns the live rows matching conds, in primary key order.
+func (o *MemStore[T, R]) find(ctx context.Context, conds []store.Cond, op string) ([][]any, error) {
+	pred, err := o.compile(conds, op)
+	if err != nil {
+		return nil, err
+	}
+	return o.read(ctx, func() ([][]any, error) {
+		return o.match(pred), nil
+	})
+}
+
+// match returns the live rows for which pred is true. pred is nil for no
+// condition.
+func (o *MemStore[T, R]) match(pred predicate) [][]any {
+	data := o.data()
+	rows := make([][]any, 0, len(data.ids))
+	for _, id := range data.ids {
+		row, ok := o.live(id)
+		if ok && (pred == nil || pred(row) == isTrue) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// read calls fn with the store locked for reading.
+func (o *MemStore[T, R]) read(ctx context.Context, fn func() ([][]any, error)) ([][]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s read failed: %w", o.tablename, err)
+	}
+	o.table.mu.RLock()
+	defer o.table.mu.RUnlock()
+	return fn()
+}
+
+// write calls fn with the store locked for writing, and undoes the changes of
+// fn if it fails. fn gets the store to write through: outside of a
+// transaction, a write that runs hooks at one of events runs in a transaction
+// of its own, which the hooks get in ctx, so that they can bind other stores
+// to it. Hooks run inside fn, so they must not use the store itself.
+func (o *MemStore[T, R]) write(ctx context.Context, fn func(s *MemStore[T, R]) error, events ...store.Event) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s write failed: %w", o.tablename, err)
+	}
+	if o.tx == nil {
+		if o.hasHooks(events...) {
+			return o.db.RunInTx(ctx, func(tx store.Tx) error {
+				view := *o
+				view.tx = tx.(*Tx)
+				return view.write(ctx, fn)
+			})
+		}
+		o.db.writer.Lock()
+		defer o.db.writer.Unlock()
+	}
+	o.table.mu.Lock()
+	defer o.table.mu.Unlock()
+	if o.tx != nil {
+		_, _ = o.tx.data(o.table, true)
+	}
+	data := o.data()
+	o.table.undo = o.table.undo[:0]
+	err := fn(o)
+	if err != nil {
+		for i := len(o.table.undo) - 1; i >= 0; i-- {
+			if c := o.table.undo[i]; c.row == nil {
+				data.unset(c.id)
+			} else {
+				data.set(c.id, c.row)
+			}
+		}
+	}
+	o.table.undo = o.table.undo[:0]
+	return err
+}
+
+// data returns the rows the store works on: the copy of its transaction if
+// the transaction changed the table, or else the committed rows. The caller
+// holds the lock of the table.
+func (o *MemStore[T, R]) data() *tableData {
+	if o.tx != nil {
+		if data, ok := o.tx.data(o.table, false); ok {
+			return data
+		}
+	}
+	return o.table.data
+}
+
+// put writes row id and records its previous state for write.
+func (o *MemStore[T, R]) put(id int64, row []any) {
+	data := o.data()
+	o.table.undo = append(o.table.undo, change{id: id, row: data.rows[id]})
+	data.set(id, row)
+}
+
+// remove deletes row id and records its previous state for write.
+func (o *MemStore[T, R]) remove(id int64) {
+	data := o.data()
+	o.table.undo = append(o.table.undo, change{id: id, row: data.rows[id]})
+	data.unset(id)
+}
+
+// conflicts reports whether another live row than id has a value of a unique
+// column of row.
+func (o *MemStore[T, R]) conflicts(row []any, id int64) bool {
+	for i, index := range o.data().unique {
+		if other, ok := index[uniqueKey(row[i])]; ok && other != id {
+			return true
+		}
+	}
+	return false
+}
+
+// live returns row id unless it does not exist or is soft deleted.
+func (o *MemStore[T, R]) live(id int64) ([]any, bool) {
+	row, ok := o.data().rows[id]
+	if !ok || o.softDelete >= 0 && row[o.softDelete] != int64(0) {
+		return nil, false
+	}
+	return row, true
+}
+
+func (o *MemStore[T, R]) column(name string) (int, bool) {
+	i := slices.IndexFunc(o.columns, func(col column) bool { return col.Name == name })
+	return i, i >= 0
+}
+
+// checkFields rejects conditions on fields that are not columns of the table.
+func (o *MemStore[T, R]) checkFields(conds []store.Cond) error {
+	for _, cond := range conds {
+		if cond == nil {
+			continue
+		}
+		for _, field := range cond.Fields() {
+			if _, ok := o.column(field); !ok {
+				return &store.UnknownFieldError{Table: o.tablename, Field: field}
+			}
+		}
+	}
+	return nil
+}
+
+// stamp sets the fields of obj that the store maintains: created_at and
+// version for an Insert, and updated_at for an Insert or an Update.
+func (o *MemStore[T, R]) stamp(obj *T, now time.Time, update bool) {
+	if o.updatedAt >= 0 {
+		setInt(o.field(obj, o.updatedAt), now.Unix())
+	}
+	if update {
+		return
+	}
+	if o.createdAt >= 0 {
+		setInt(o.field(obj, o.createdAt), now.Unix())
+	}
+	if o.version >= 0 {
+		setInt(o.field(obj, o.version), 1)
+	}
+}
+
+// setVersion sets the version field of obj, if the model has one.
+func (o *MemStore[T, R]) setVersion(obj *T, version int64) {
+	if o.version >= 0 {
+		setInt(o.field(obj, o.version), version)
+	}
+}
+
+// field returns the field of obj holding column i.
+func (o *MemStore[T, R]) field(obj *T, i int) reflect.Value {
+	return reflect.ValueOf(obj).Elem().Field(o.columns[i].Index)
+}
+
+func setInt(f reflect.Value, val int64) {
+	if f.CanInt() {
+		f.SetInt(val)
+	} else if f.CanUint() {
+		f.SetUint(uint64(val))
+	}
+}
+
+func toSnakeCase(input string) string {
+	var result []rune
+
+	for i, char := range input {
+		if i > 0 && (unicode.IsUpper(char) || unicode.IsDigit(char)) {
+			result = append(result, '_')
+		}
+		result = append(result, unicode.ToLower(char))
+	}
+
+	return string(result)
+}
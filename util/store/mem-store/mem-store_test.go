@@ -0,0 +1,100 @@
+package memstore
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Players: func(t *testing.T) store.Store[storetest.Player, *storetest.Player] {
+			return newStore[storetest.Player](t)
+		},
+		Notes: func(t *testing.T) store.Store[storetest.Note, *storetest.Note] {
+			return newStore[storetest.Note](t)
+		},
+		DB: func(t *testing.T) (store.Transactor, store.Store[storetest.Player, *storetest.Player], store.Store[storetest.Note, *storetest.Note]) {
+			db := NewDB()
+			players, err := NewStoreFromDB[storetest.Player](db)
+			if err != nil {
+				t.Fatalf("fail to create store %v", err)
+			}
+			notes, err := NewStoreFromDB[storetest.Note](db)
+			if err != nil {
+				t.Fatalf("fail to create store %v", err)
+			}
+			return db, players, notes
+		},
+	})
+}
+
+func newStore[T any, R store.Row[T]](t *testing.T) *MemStore[T, R] {
+	s, err := NewStore[T, R]()
+	if err != nil {
+		t.Fatalf("fail to create store %v", err)
+	}
+	return s
+}
+
+func TestPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		glob    bool
+		text    string
+		match   bool
+	}{
+		{"ma%", false, "MA LONG", true},
+		{"%long", false, "ma long", true},
+		{"%l_ng%", false, "ma long", true},
+		{"m_", false, "ma long", false},
+		{"%", false, "", true},
+		{"é%", false, "É", false},
+		{"*ong", true, "ma long", true},
+		{"*ONG", true, "ma long", false},
+		{"ma?long", true, "ma long", true},
+		{"[a-c]*", true, "bayi", true},
+		{"[^a-c]*", true, "bayi", false},
+		{"[]]", true, "]", true},
+		{"[a", true, "a", false},
+		{"a*b*c", true, "aXbYbZc", true},
+		{"a*b*c", true, "aXbYbZ", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, compilePattern(c.pattern, c.glob).match(c.text), "%q matching %q", c.pattern, c.text)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	players := newStore[storetest.Player](t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := players.Insert(ctx, storetest.Player{Name: string(rune('a' + i))})
+			assert.NoError(t, err)
+			_, err = players.Count(ctx)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	n, err := players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+
+	// fn may write to the store being read
+	assert.NoError(t, players.Each(ctx, func(player storetest.Player) error {
+		player.Age++
+		return players.Update(ctx, player.ID, player)
+	}))
+	n, err = players.Count(ctx, store.Eq(storetest.PlayerColAge, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+}
@@ -0,0 +1,39 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Project decodes only fields, after unlocking the store like Each.
+func (o *MemStore[T, R]) Project(ctx context.Context, fields []string, scan func(row store.RowScanner) error, conds ...store.Cond) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%s Project without fields", o.tablename)
+	}
+	indexes := make([]int, 0, len(fields))
+	columns := make([]column, 0, len(fields))
+	for _, field := range fields {
+		i, ok := o.column(field)
+		if !ok {
+			return &store.UnknownFieldError{Table: o.tablename, Field: field}
+		}
+		indexes = append(indexes, i)
+		columns = append(columns, o.columns[i])
+	}
+	rows, err := o.find(ctx, conds, "Project")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		vals := make([]any, 0, len(indexes))
+		for _, i := range indexes {
+			vals = append(vals, row[i])
+		}
+		if err = scan(scanner{row: vals, columns: columns}); err != nil {
+			return fmt.Errorf("%s Project row.Scan error: %w", o.tablename, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package memstore
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Restore unmarks the soft deleted rows ids. It returns store.ErrNotFound if
+// none of them is deleted, and store.ErrConflicted if a live row has taken a
+// unique value of one of them. Restore runs no hooks.
+func (o *MemStore[T, R]) Restore(ctx context.Context, ids []int64) error {
+	if o.softDelete < 0 {
+		return store.ErrSoftDeleteNotSupported
+	}
+	return o.write(ctx, func(s *MemStore[T, R]) error {
+		restored := 0
+		for _, id := range ids {
+			row, ok := s.data().rows[id]
+			if !ok || row[s.softDelete] == int64(0) {
+				continue
+			}
+			row = slices.Clone(row)
+			row[s.softDelete] = int64(0)
+			if s.conflicts(row, id) {
+				return store.ErrConflicted
+			}
+			s.put(id, row)
+			restored++
+		}
+		if restored == 0 {
+			return store.ErrNotFound
+		}
+		return nil
+	})
+}
+
+// FindDeleted returns the soft deleted rows matching conds, most recently
+// deleted first.
+func (o *MemStore[T, R]) FindDeleted(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	if o.softDelete < 0 {
+		return nil, store.ErrSoftDeleteNotSupported
+	}
+	pred, err := o.compile(conds, "FindDeleted")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := o.read(ctx, func() ([][]any, error) {
+		var rows [][]any
+		data := o.data()
+		for _, id := range data.ids {
+			row := data.rows[id]
+			if row[o.softDelete] != int64(0) && (pred == nil || pred(row) == isTrue) {
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(rows, func(a, b []any) int {
+		if c := compare(b[o.softDelete], a[o.softDelete]); c != 0 {
+			return c
+		}
+		return compare(b[o.pk], a[o.pk])
+	})
+	return o.decodeAll(rows, "FindDeleted")
+}
+
+// Purge removes the rows soft deleted before before. Purge runs no hooks, the
+// delete hooks ran when the rows were soft deleted.
+func (o *MemStore[T, R]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if o.softDelete < 0 {
+		return 0, store.ErrSoftDeleteNotSupported
+	}
+	var n int64
+	err := o.write(ctx, func(s *MemStore[T, R]) error {
+		data := s.data()
+		for _, id := range slices.Clone(data.ids) {
+			if deletedAt := data.rows[id][s.softDelete].(int64); deletedAt != 0 && deletedAt < before.Unix() {
+				s.remove(id)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,423 @@
+package memstore
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type column struct {
+	Name  string
+	Index int
+	// Affinity is the SQLite type affinity of the column, which converts the
+	// values compared to it, see affinity.convert.
+	Affinity     affinity
+	IsPK         bool
+	IsIdxUniq    bool
+	IsJSON       bool
+	IsSoftDelete bool
+	IsCreatedAt  bool
+	IsUpdatedAt  bool
+	IsVersion    bool
+}
+
+type affinity int
+
+const (
+	affinityInt affinity = iota
+	affinityReal
+	affinityText
+)
+
+// getColumns reads the columns of typ from its db tags, like the SQLite store.
+// Index tags other than uniq are ignored.
+func getColumns(typ reflect.Type) ([]column, error) {
+	var columns []column
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("db")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		opts := strings.Split(tag, ",")[1:]
+
+		col := column{
+			Name:         name,
+			Index:        i,
+			IsPK:         slices.Contains(opts, "pk"),
+			IsIdxUniq:    slices.Contains(opts, "uniq"),
+			IsJSON:       slices.Contains(opts, "json"),
+			IsSoftDelete: slices.Contains(opts, "soft_delete"),
+			IsCreatedAt:  slices.Contains(opts, "created_at"),
+			IsUpdatedAt:  slices.Contains(opts, "updated_at"),
+			IsVersion:    slices.Contains(opts, "version"),
+		}
+		if !col.IsJSON && isJSONField(field.Type) {
+			return nil, fmt.Errorf("column %s of type %s must be tagged json", name, field.Type)
+		}
+		switch kind := field.Type.Kind(); {
+		case col.IsJSON, kind == reflect.String, kind == reflect.Slice:
+			col.Affinity = affinityText
+		case kind == reflect.Float32, kind == reflect.Float64:
+			col.Affinity = affinityReal
+		case kind == reflect.Bool, field.Type.ConvertibleTo(reflect.TypeOf(int64(0))):
+			col.Affinity = affinityInt
+		default:
+			return nil, fmt.Errorf("column %s has unsupported type %s", name, field.Type)
+		}
+		// the store writes these columns itself
+		if (col.IsSoftDelete || col.IsCreatedAt || col.IsUpdatedAt || col.IsVersion) &&
+			(col.Affinity != affinityInt || field.Type.Kind() == reflect.Bool) {
+			return nil, fmt.Errorf("column %s must be an integer", name)
+		}
+		if col.IsPK && col.Affinity != affinityInt {
+			return nil, fmt.Errorf("pk column %s must be an integer", name)
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+// isJSONField reports whether a field of type typ can only be stored as JSON,
+// which the field must then opt into with the json tag. []byte is stored as is.
+func isJSONField(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Array, reflect.Map:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// encode returns the row of obj as SQLite stores it: integers and booleans as
+// int64, floats as float64, strings, []byte and JSON columns as JSON text.
+func (o *MemStore[T, R]) encode(obj *T) ([]any, error) {
+	vals, err := R(obj).FieldsVals()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != len(o.columns) {
+		return nil, fmt.Errorf("FieldsVals returned %d values for %d columns", len(vals), len(o.columns))
+	}
+	row := make([]any, len(vals))
+	for i, col := range o.columns {
+		if col.IsJSON {
+			b, err := json.Marshal(vals[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", col.Name, err)
+			}
+			row[i] = string(b)
+			continue
+		}
+		if row[i], err = normalize(vals[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", col.Name, err)
+		}
+	}
+	return row, nil
+}
+
+// decode reads row into obj through its ScanRow.
+func (o *MemStore[T, R]) decode(row []any, obj *T) error {
+	return R(obj).ScanRow(scanner{row: row, columns: o.columns})
+}
+
+func (o *MemStore[T, R]) decodeAll(rows [][]any, op string) ([]T, error) {
+	objs := make([]T, len(rows))
+	for i, row := range rows {
+		if err := o.decode(row, &objs[i]); err != nil {
+			return nil, fmt.Errorf("%s %s row.Scan error: %w", o.tablename, op, err)
+		}
+	}
+	return objs, nil
+}
+
+// scanner reads an encoded row into the pointers passed to Scan, converting
+// the values as database/sql does. JSON columns are decoded.
+type scanner struct {
+	row     []any
+	columns []column
+}
+
+func (s scanner) Scan(dest ...any) error {
+	if len(dest) != len(s.row) {
+		return fmt.Errorf("expected %d destination arguments in Scan, not %d", len(s.row), len(dest))
+	}
+	for i, d := range dest {
+		var err error
+		if s.columns[i].IsJSON {
+			err = json.Unmarshal([]byte(s.row[i].(string)), d)
+		} else {
+			err = assign(d, s.row[i])
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.columns[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// assign stores src, an encoded value, into dest, a pointer.
+func assign(dest any, src any) error {
+	if d, ok := dest.(*any); ok {
+		if b, ok := src.([]byte); ok {
+			src = bytes.Clone(b)
+		}
+		*d = src
+		return nil
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("destination not a pointer: %T", dest)
+	}
+	v = v.Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(textOf(src))
+		return nil
+	case reflect.Bool:
+		n, err := intOf(src, v.Type())
+		if err != nil {
+			return err
+		}
+		v.SetBool(n != 0)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := intOf(src, v.Type())
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("converting %v to %s: value out of range", src, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := intOf(src, v.Type())
+		if err != nil {
+			return err
+		}
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("converting %v to %s: value out of range", src, v.Type())
+		}
+		v.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch src := src.(type) {
+		case int64:
+			v.SetFloat(float64(src))
+		case float64:
+			v.SetFloat(src)
+		default:
+			f, err := strconv.ParseFloat(textOf(src), 64)
+			if err != nil {
+				return fmt.Errorf("converting %q to %s: %w", textOf(src), v.Type(), err)
+			}
+			v.SetFloat(f)
+		}
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := src.([]byte)
+			if !ok {
+				b = []byte(textOf(src))
+			}
+			v.SetBytes(bytes.Clone(b))
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported Scan, storing %T into %T", src, dest)
+}
+
+// intOf converts src to an integer for a destination of type typ.
+func intOf(src any, typ reflect.Type) (int64, error) {
+	switch src := src.(type) {
+	case int64:
+		return src, nil
+	case float64:
+		if src == math.Trunc(src) {
+			return int64(src), nil
+		}
+	default:
+		if n, err := strconv.ParseInt(textOf(src), 10, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("converting %v to %s: invalid syntax", src, typ)
+}
+
+// normalize returns val, a field or an argument of a condition, as the
+// SQLite driver binds it: nil, int64, float64, string or []byte.
+func normalize(val any) (any, error) {
+	switch v := val.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999999-07:00"), nil
+	case driver.Valuer:
+		dv, err := v.Value()
+		if err != nil {
+			return nil, err
+		}
+		return normalize(dv)
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("uint64 value %d is out of range", v.Uint())
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return bytes.Clone(v.Bytes()), nil
+		}
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return normalize(v.Elem().Interface())
+	}
+	return nil, fmt.Errorf("unsupported type %T", val)
+}
+
+// convert returns val, a normalized argument compared to a column of
+// affinity a, as SQLite does: text that looks like a number becomes a number
+// for a numeric column, and a number becomes text for a text column.
+func (a affinity) convert(val any) any {
+	switch v := val.(type) {
+	case string:
+		if a == affinityText {
+			return v
+		}
+		s := strings.TrimSpace(v)
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case int64, float64:
+		if a == affinityText {
+			return textOf(v)
+		}
+	}
+	return val
+}
+
+// textOf returns the text of an encoded value, formatted as SQLite does.
+func textOf(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', 15, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// numberOf returns an encoded value as a float, reading text as a number or 0
+// like SQLite's sum and avg.
+func numberOf(val any) float64 {
+	switch v := val.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(textOf(v)), 64)
+		return f
+	}
+}
+
+// compare orders two encoded values as SQLite does with the BINARY collation:
+// NULL before numbers, numbers before text, text before blobs.
+func compare(a, b any) int {
+	ca, cb := class(a), class(b)
+	if ca != cb {
+		return ca - cb
+	}
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmpOrdered(a, b)
+		}
+		return cmpOrdered(float64(a), b.(float64))
+	case float64:
+		if b, ok := b.(int64); ok {
+			return cmpOrdered(a, float64(b))
+		}
+		return cmpOrdered(a, b.(float64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+	return 0
+}
+
+func class(val any) int {
+	switch val.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func cmpOrdered[N int64 | float64](a, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// uniqueKey returns an encoded value as a map key. A column holds values of a
+// single type, so a blob cannot be mistaken for text.
+func uniqueKey(val any) any {
+	if b, ok := val.([]byte); ok {
+		return string(b)
+	}
+	return val
+}
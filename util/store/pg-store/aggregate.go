@@ -0,0 +1,115 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func (o *PGStore[T, R]) Count(ctx context.Context, conds ...store.Cond) (int64, error) {
+	return o.count(ctx, conds)
+}
+
+// count is Count on o, which may be a transaction view.
+func (o *PGStore[T, R]) count(ctx context.Context, conds []store.Cond) (int64, error) {
+	where, args, err := o.where(conds, "Count")
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	query := fmt.Sprintf("SELECT count(*) from %s%s", o.table, where)
+	if err = o.q().QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("%s Count error: %w", o.tablename, err)
+	}
+	return n, nil
+}
+
+func (o *PGStore[T, R]) Exists(ctx context.Context, conds ...store.Cond) (bool, error) {
+	where, args, err := o.where(conds, "Exists")
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 from %s%s)", o.table, where)
+	if err = o.q().QueryRowContext(ctx, query, args...).Scan(&exists); err != nil {
+		return false, fmt.Errorf("%s Exists error: %w", o.tablename, err)
+	}
+	return exists, nil
+}
+
+func (o *PGStore[T, R]) Aggregate(ctx context.Context, agg store.Aggregation) ([]store.Group, error) {
+	switch agg.Func {
+	case store.AggCount, store.AggMin, store.AggMax, store.AggSum, store.AggAvg:
+	default:
+		return nil, fmt.Errorf("%s Aggregate function %q: %w", o.tablename, agg.Func, store.ErrInvalidAggregation)
+	}
+	arg := "*"
+	if len(agg.Field) > 0 {
+		if _, ok := o.column(agg.Field); !ok {
+			return nil, &store.UnknownFieldError{Table: o.tablename, Field: agg.Field}
+		}
+		arg = quote(agg.Field)
+	} else if agg.Func != store.AggCount {
+		return nil, fmt.Errorf("%s Aggregate %s without field: %w", o.tablename, agg.Func, store.ErrInvalidAggregation)
+	}
+	for _, field := range agg.GroupBy {
+		if _, ok := o.column(field); !ok {
+			return nil, &store.UnknownFieldError{Table: o.tablename, Field: field}
+		}
+	}
+	where, args, err := o.where(agg.Where, "Aggregate")
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy := make([]string, 0, len(agg.GroupBy))
+	for _, field := range agg.GroupBy {
+		groupBy = append(groupBy, quote(field))
+	}
+	// sum and avg of integers are numeric, which the driver reads as text
+	selects := append(slices.Clone(groupBy), fmt.Sprintf("%s(%s)::double precision", agg.Func, arg))
+	query := fmt.Sprintf("SELECT %s from %s%s", strings.Join(selects, ", "), o.table, where)
+	if len(groupBy) > 0 {
+		groupBy := strings.Join(groupBy, ", ")
+		query += fmt.Sprintf(" GROUP BY %s ORDER BY %s", groupBy, groupBy)
+	}
+	rows, err := o.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s Aggregate Query error: %w", o.tablename, err)
+	}
+	defer rows.Close()
+
+	var groups []store.Group
+	for rows.Next() {
+		keys := make([]any, len(agg.GroupBy))
+		var value sql.NullFloat64
+		dest := make([]any, 0, len(keys)+1)
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		if err = rows.Scan(append(dest, &value)...); err != nil {
+			return nil, fmt.Errorf("%s Aggregate row.Scan error: %w", o.tablename, err)
+		}
+		for i, key := range keys {
+			switch key := key.(type) {
+			case []byte:
+				keys[i] = string(key)
+			case bool:
+				// SQLite stores booleans as integers
+				keys[i] = int64(0)
+				if key {
+					keys[i] = int64(1)
+				}
+			}
+		}
+		groups = append(groups, store.Group{Keys: keys, Value: value.Float64})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s Aggregate rows error: %w", o.tablename, err)
+	}
+	return groups, nil
+}
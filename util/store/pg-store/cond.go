@@ -0,0 +1,130 @@
+package pgstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// where checks conds and returns them, with the condition skipping soft
+// deleted rows, as a WHERE clause and its args.
+func (o *PGStore[T, R]) where(conds []store.Cond, op string) (string, []any, error) {
+	whereStmt, args, err := o.condSQL(conds, op)
+	if err != nil {
+		return "", nil, err
+	}
+	return joinWhere(o.sqlTable.LiveConds(whereStmt)), args, nil
+}
+
+// condSQL checks conds, the arguments of FindWhere, and returns them as SQL for
+// Postgres. store.Where writes the SQL of SQLite, so the store translates the
+// condition tree itself.
+func (o *PGStore[T, R]) condSQL(conds []store.Cond, op string) (string, []any, error) {
+	if err := o.sqlTable.CheckFields(conds); err != nil {
+		return "", nil, err
+	}
+	tree, err := store.Tree(conds...)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s %s: %w", o.tablename, op, err)
+	}
+	if tree == nil {
+		return "", []any{}, nil
+	}
+	s, args, err := o.treeSQL(tree)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s %s: %w", o.tablename, op, err)
+	}
+	return s, args, nil
+}
+
+func (o *PGStore[T, R]) treeSQL(cond store.Cond) (string, []any, error) {
+	switch cond := cond.(type) {
+	case store.WhereCond:
+		return o.whereCondSQL(cond)
+	case store.NotCond:
+		s, args, err := o.treeSQL(cond.Cond)
+		if err != nil {
+			return "", nil, err
+		}
+		return "not (" + s + ")", args, nil
+	case store.GroupCond:
+		if len(cond.Conds) == 0 {
+			if cond.Joiner == store.QueryJoinerAnd {
+				return "1 = 1", []any{}, nil
+			}
+			return "1 = 0", []any{}, nil
+		}
+		stmts := make([]string, 0, len(cond.Conds))
+		args := make([]any, 0, len(cond.Conds))
+		for _, c := range cond.Conds {
+			s, arg, err := o.treeSQL(c)
+			if err != nil {
+				return "", nil, err
+			}
+			stmts = append(stmts, s)
+			args = append(args, arg...)
+		}
+		return "(" + strings.Join(stmts, " "+string(cond.Joiner)+" ") + ")", args, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unsupported condition %T", store.ErrInvalidCond, cond)
+	}
+}
+
+// whereCondSQL follows the semantics of SQLite: like ignores the case of its
+// pattern and has no escape character, and glob is translated into a regular
+// expression.
+func (o *PGStore[T, R]) whereCondSQL(cond store.WhereCond) (string, []any, error) {
+	if len(cond.Field) == 0 {
+		return "", nil, fmt.Errorf("%w: empty field", store.ErrInvalidCond)
+	}
+	field := quote(cond.Field)
+	switch cond.Op {
+	case store.OpEqual, store.OpNotEqual, store.OpGte, store.OpGt, store.OpLte, store.OpLt:
+		return fmt.Sprintf("%s %s ?", field, cond.Op), []any{cond.Val}, nil
+	case store.OpIn, store.OpNotIn:
+		vals, ok := store.ToSlice(cond.Val)
+		if !ok {
+			return "", nil, fmt.Errorf("%w: %s %s expects a slice but got %T", store.ErrInvalidCond, cond.Field, cond.Op, cond.Val)
+		}
+		if len(vals) == 0 {
+			if cond.Op == store.OpIn {
+				return "1 = 0", []any{}, nil
+			}
+			return "1 = 1", []any{}, nil
+		}
+		return fmt.Sprintf("%s %s (%s)", field, cond.Op, placeholders(len(vals))), vals, nil
+	case store.OpBetween:
+		vals, ok := store.ToSlice(cond.Val)
+		if !ok || len(vals) != 2 {
+			return "", nil, fmt.Errorf("%w: %s between expects 2 bounds but got %#v", store.ErrInvalidCond, cond.Field, cond.Val)
+		}
+		return field + " between ? and ?", vals, nil
+	case store.OpLike:
+		return field + "::text ilike ? escape ''", []any{cond.Val}, nil
+	case store.OpNotLike:
+		return field + "::text not ilike ? escape ''", []any{cond.Val}, nil
+	case store.OpGlob:
+		pattern, ok := globToRegexp(fmt.Sprint(cond.Val))
+		if !ok {
+			return "1 = 0", []any{}, nil
+		}
+		return field + "::text ~ ?", []any{pattern}, nil
+	case store.OpJSONContains:
+		// json_each of SQLite yields the elements of an array, the values of
+		// an object, or a scalar itself
+		elem, err := json.Marshal(cond.Val)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s: %v", store.ErrInvalidCond, cond.Field, err)
+		}
+		doc := fmt.Sprintf("%s::jsonb", field)
+		return fmt.Sprintf("(case jsonb_typeof(%s) when 'array' then %s @> ?::jsonb "+
+				"when 'object' then exists (select 1 from jsonb_each(%s) e where e.value = ?::jsonb) "+
+				"else %s = ?::jsonb end)", doc, doc, doc, doc),
+			[]any{"[" + string(elem) + "]", string(elem), string(elem)}, nil
+	case store.OpIsNull, store.OpIsNotNull:
+		return fmt.Sprintf("%s %s", field, cond.Op), []any{}, nil
+	}
+	return "", nil, fmt.Errorf("%w: unknown operator %q", store.ErrInvalidCond, cond.Op)
+}
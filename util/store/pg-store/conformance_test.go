@@ -0,0 +1,131 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/storetest"
+)
+
+var (
+	// testDSN is the database of the conformance suite: PGSTORE_TEST_DSN, e.g.
+	// postgres://postgres@localhost:5432/tt_test, or else an embedded Postgres
+	// started by TestMain.
+	testDSN string
+	// testDSNErr tells why there is no database to test against.
+	testDSNErr error
+)
+
+func TestMain(m *testing.M) {
+	testDSN = os.Getenv("PGSTORE_TEST_DSN")
+	if len(testDSN) > 0 {
+		os.Exit(m.Run())
+	}
+
+	runtime, err := os.MkdirTemp("", "pgstore")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	pg, dsn, err := startEmbedded(runtime)
+	if err != nil {
+		testDSNErr = fmt.Errorf("fail to start an embedded postgres: %w", err)
+	} else {
+		testDSN = dsn
+	}
+	code := m.Run()
+	if pg != nil {
+		if err := pg.Stop(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	_ = os.RemoveAll(runtime)
+	os.Exit(code)
+}
+
+// startEmbedded downloads, unless cached, and starts a Postgres on a free
+// port with its data in runtime.
+func startEmbedded(runtime string) (*embeddedpostgres.EmbeddedPostgres, string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	port := uint32(l.Addr().(*net.TCPAddr).Port)
+	_ = l.Close()
+
+	config := embeddedpostgres.DefaultConfig().
+		Port(port).
+		Database("tt_test").
+		RuntimePath(runtime).
+		Logger(io.Discard)
+	pg := embeddedpostgres.NewDatabase(config)
+	if err = pg.Start(); err != nil {
+		return nil, "", err
+	}
+	return pg, config.GetConnectionURL() + "?sslmode=disable", nil
+}
+
+// conformanceDSN returns testDSN, skipping t when neither PGSTORE_TEST_DSN is
+// set nor the embedded Postgres could be started, e.g. with no network to
+// download its binaries. In CI, where the CI variable is set, t fails instead
+// so that the suite cannot pass without having run.
+func conformanceDSN(t *testing.T) string {
+	if testDSNErr != nil {
+		if len(os.Getenv("CI")) > 0 {
+			t.Fatal(testDSNErr)
+		}
+		t.Skip(testDSNErr)
+	}
+	return testDSN
+}
+
+// TestConformance runs the storetest suite against the Postgres of testDSN.
+// The tables of the suite are dropped before each test.
+func TestConformance(t *testing.T) {
+	dsn := conformanceDSN(t)
+	storetest.Run(t, storetest.Backend{
+		Players: func(t *testing.T) store.Store[storetest.Player, *storetest.Player] {
+			return openConformanceStore[storetest.Player](t, dsn)
+		},
+		Notes: func(t *testing.T) store.Store[storetest.Note, *storetest.Note] {
+			return openConformanceStore[storetest.Note](t, dsn)
+		},
+		DB: func(t *testing.T) (store.Transactor, store.Store[storetest.Player, *storetest.Player], store.Store[storetest.Note, *storetest.Note]) {
+			players := openConformanceStore[storetest.Player](t, dsn)
+			notes := openConformanceStore[storetest.Note](t, dsn)
+			db, err := Open(dsn)
+			if err != nil {
+				t.Fatalf("fail to open db %v", err)
+			}
+			t.Cleanup(func() { _ = db.Close() })
+			return db, players, notes
+		},
+	})
+}
+
+func openConformanceStore[T any, R store.Row[T]](t *testing.T, dsn string) store.Store[T, R] {
+	db, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	defer db.Close()
+	var obj T
+	table := quote(toSnakeCase(reflect.TypeOf(obj).Name()))
+	if _, err = db.db.ExecContext(context.Background(), "DROP TABLE IF EXISTS "+table); err != nil {
+		t.Fatalf("fail to drop table %v", err)
+	}
+	s, err := NewStoreFromDB[T, R](db)
+	if err != nil {
+		t.Fatalf("fail to create store %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
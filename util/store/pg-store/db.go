@@ -0,0 +1,167 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// DB holds the connection pool to a Postgres database. Every store opened on
+// the same DSN shares one DB, so that their writes can run in one transaction.
+type DB struct {
+	dsn string
+	// name identifies the database without the credentials of the DSN.
+	name string
+	db   *sql.DB
+	refs int
+}
+
+var (
+	dbsMu sync.Mutex
+	dbs   = map[string]*DB{}
+)
+
+// Open returns the shared DB for dsn, a URL or a key=value connection string,
+// connecting if no store uses it yet. Every call to Open must be paired with a
+// call to Close.
+func Open(dsn string) (*DB, error) {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	if d, ok := dbs[dsn]; ok {
+		d.refs++
+		return d, nil
+	}
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid postgres dsn: %w", err)
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	d := &DB{dsn: dsn, name: fmt.Sprintf("%s:%d/%s", config.Host, config.Port, config.Database), db: db, refs: 1}
+	dbs[dsn] = d
+	return d, nil
+}
+
+func (d *DB) acquire() {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	d.refs++
+}
+
+// Close releases one reference to d. The pool is closed once the last
+// reference is released.
+func (d *DB) Close() error {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+	delete(dbs, d.dsn)
+	return d.db.Close()
+}
+
+// Name returns the host, port and database of d.
+func (d *DB) Name() string {
+	return d.name
+}
+
+// RunInTx calls fn inside a transaction. Stores opened on d join it with
+// WithTx. Unlike SQLite, Postgres aborts the whole transaction when one of its
+// statements fails, e.g. with store.ErrConflicted, so fn should return the
+// error instead of carrying on.
+func (d *DB) RunInTx(ctx context.Context, fn func(tx store.Tx) error) (err error) {
+	sqlTx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s begin tx failed: %w", d.name, err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	tx := &Tx{db: d, tx: sqlTx}
+	if err = fn(tx); err != nil {
+		if errRollback := sqlTx.Rollback(); errRollback != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, errRollback)
+		}
+		return err
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("%s commit failed: %w", d.name, err)
+	}
+	for _, onCommit := range tx.onCommit {
+		onCommit()
+	}
+	return nil
+}
+
+// Tx is a transaction opened by DB.RunInTx.
+type Tx struct {
+	db *DB
+	tx *sql.Tx
+	// onCommit holds the functions to call once tx commits, see OnCommit.
+	onCommit []func()
+	mu       sync.Mutex
+}
+
+// OnCommit calls fn after the transaction commits, see store.CommitNotifier.
+func (t *Tx) OnCommit(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onCommit = append(t.onCommit, fn)
+}
+
+func (t *Tx) Database() string {
+	return t.db.name
+}
+
+// querier is the subset of methods shared by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// rebinder runs the queries the store builds with ? placeholders, see rebind.
+type rebinder struct {
+	q querier
+}
+
+func (r rebinder) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.q.ExecContext(ctx, rebind(query), args...)
+}
+
+func (r rebinder) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return r.q.QueryContext(ctx, rebind(query), args...)
+}
+
+func (r rebinder) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return r.q.QueryRowContext(ctx, rebind(query), args...)
+}
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+func isDupError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
@@ -0,0 +1,375 @@
+package pgstore
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// column describes a column of the table. The JSON columns are stored as JSONB.
+type column struct {
+	store.SQLColumn
+	IsIdxAsc  bool
+	IsIdxDesc bool
+	IsIdxUniq bool
+	FK        foreignKey
+	PgType    pgType
+	// Default is the SQL literal used to fill the column in existing rows when
+	// a migration adds it: the zero value of the Go field.
+	Default string
+}
+
+type pgType string
+
+const (
+	pgTypeBigint  pgType = "BIGINT"
+	pgTypeBool    pgType = "BOOLEAN"
+	pgTypeDouble  pgType = "DOUBLE PRECISION"
+	pgTypeText    pgType = `TEXT COLLATE "C"`
+	pgTypeBytea   pgType = "BYTEA"
+	pgTypeJSONB   pgType = "JSONB"
+	pgTypeInvalid pgType = ""
+)
+
+// foreignKey is declared by the fk=table.column tag, and its action by
+// on_delete=cascade, restrict, set_null or set_default. The zero value means no
+// foreign key.
+type foreignKey struct {
+	Table    string
+	Column   string
+	OnDelete string
+}
+
+// onDeleteActions maps the on_delete tag values to their SQL.
+var onDeleteActions = map[string]string{
+	"cascade":     "CASCADE",
+	"restrict":    "RESTRICT",
+	"set_null":    "SET NULL",
+	"set_default": "SET DEFAULT",
+	"no_action":   "NO ACTION",
+}
+
+// getColumns reads the columns of typ from its db tags, which are those of the
+// SQLite store.
+func getColumns(typ reflect.Type) ([]column, error) {
+	var columns []column
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("db")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		opts := strings.Split(tag, ",")[1:]
+
+		col := column{
+			SQLColumn: store.SQLColumn{
+				Name:         name,
+				Index:        i,
+				IsPK:         slices.Contains(opts, "pk"),
+				IsJSON:       slices.Contains(opts, "json"),
+				IsSoftDelete: slices.Contains(opts, "soft_delete"),
+				IsCreatedAt:  slices.Contains(opts, "created_at"),
+				IsUpdatedAt:  slices.Contains(opts, "updated_at"),
+				IsVersion:    slices.Contains(opts, "version"),
+			},
+			IsIdxAsc:  slices.Contains(opts, "idx_asc"),
+			IsIdxDesc: slices.Contains(opts, "idx_desc") && !slices.Contains(opts, "idx_asc"),
+			IsIdxUniq: slices.Contains(opts, "uniq"),
+		}
+		if !col.IsJSON && isJSONField(field.Type) {
+			return nil, fmt.Errorf("column %s of type %s must be tagged json", name, field.Type)
+		}
+		fk, err := getForeignKey(opts)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		col.FK = fk
+		col.PgType = getPgType(field.Type)
+		if col.IsJSON {
+			col.PgType = pgTypeJSONB
+		}
+		if col.PgType == pgTypeInvalid {
+			return nil, fmt.Errorf("column %s has unsupported type %s", name, field.Type)
+		}
+		// the store writes these columns itself
+		if (col.IsPK || col.IsSoftDelete || col.IsCreatedAt || col.IsUpdatedAt || col.IsVersion) && col.PgType != pgTypeBigint {
+			return nil, fmt.Errorf("column %s must be an integer", name)
+		}
+		col.Default = getDefault(col.PgType)
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+func getForeignKey(opts []string) (foreignKey, error) {
+	var fk foreignKey
+	for _, opt := range opts {
+		if ref, ok := strings.CutPrefix(opt, "fk="); ok {
+			fk.Table, fk.Column, _ = strings.Cut(ref, ".")
+			if len(fk.Column) == 0 {
+				fk.Column = "id"
+			}
+		} else if action, ok := strings.CutPrefix(opt, "on_delete="); ok {
+			sqlAction, ok := onDeleteActions[action]
+			if !ok {
+				return fk, fmt.Errorf("unsupported on_delete action %s", action)
+			}
+			fk.OnDelete = sqlAction
+		}
+	}
+	if len(fk.Table) == 0 && len(fk.OnDelete) > 0 {
+		return fk, fmt.Errorf("on_delete without fk")
+	}
+	return fk, nil
+}
+
+func getPgType(typ reflect.Type) pgType {
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return pgTypeBigint
+	case reflect.Bool:
+		return pgTypeBool
+	case reflect.Float32, reflect.Float64:
+		return pgTypeDouble
+	case reflect.String:
+		return pgTypeText
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return pgTypeBytea
+		}
+		return pgTypeJSONB
+	case reflect.Struct, reflect.Pointer, reflect.Array, reflect.Map:
+		return pgTypeJSONB
+	default:
+		return pgTypeInvalid
+	}
+}
+
+func getDefault(typ pgType) string {
+	switch typ {
+	case pgTypeBigint, pgTypeDouble:
+		return "0"
+	case pgTypeBool:
+		return "false"
+	case pgTypeBytea:
+		return "''::bytea"
+	case pgTypeJSONB:
+		return "'null'::jsonb"
+	default:
+		return "''"
+	}
+}
+
+// isJSONField reports whether a field of type typ can only be stored as JSON,
+// which the field must then opt into with the json tag. []byte is stored as is.
+func isJSONField(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Array, reflect.Map:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Uint8
+	default:
+		return false
+	}
+}
+
+// generateColumnSQL returns the definition of col for CREATE TABLE and ALTER
+// TABLE ADD COLUMN.
+func generateColumnSQL(col column) string {
+	if col.IsPK {
+		return quote(col.Name) + " BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY"
+	}
+	s := fmt.Sprintf("%s %s NOT NULL DEFAULT %s", quote(col.Name), col.PgType, col.Default)
+	if len(col.FK.Table) > 0 {
+		s += fmt.Sprintf(" REFERENCES %s(%s)", quote(col.FK.Table), quote(col.FK.Column))
+		if len(col.FK.OnDelete) > 0 {
+			s += " ON DELETE " + col.FK.OnDelete
+		}
+	}
+	return s
+}
+
+func generateCreateTableSQL(tableName string, columns []column) string {
+	defs := make([]string, 0, len(columns))
+	for _, col := range columns {
+		defs = append(defs, generateColumnSQL(col))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quote(tableName), strings.Join(defs, ", "))
+}
+
+// index is a single-column index declared by the idx_asc, idx_desc and uniq tags.
+type index struct {
+	Name   string
+	Column string
+	Unique bool
+	Desc   bool
+	// Where is the condition of a partial index, e.g. "deleted_at" = 0, or
+	// empty.
+	Where string
+}
+
+// indexPrefix marks the indexes managed by the store. Migrations drop indexes
+// with this prefix that no longer match a tag, and leave any other index alone.
+const indexPrefix = "idx_"
+
+// getIndexes returns the indexes declared by the tags of columns. The unique
+// indexes of a table with a soft_delete column only cover the live rows, so
+// that a row in the trash does not keep its value from being used again.
+func getIndexes(tableName string, columns []column) []index {
+	liveRows := ""
+	for _, col := range columns {
+		if col.IsSoftDelete {
+			liveRows = quote(col.Name) + " = 0"
+		}
+	}
+	indexes := make([]index, 0, len(columns))
+	for _, col := range columns {
+		if !col.IsIdxAsc && !col.IsIdxDesc && !col.IsIdxUniq {
+			continue
+		}
+		idx := index{
+			// index names are global to the schema, so they include the table name
+			Name:   fmt.Sprintf("%s%s_%s", indexPrefix, tableName, col.Name),
+			Column: col.Name,
+			Unique: col.IsIdxUniq,
+			Desc:   col.IsIdxDesc,
+		}
+		if idx.Unique {
+			idx.Where = liveRows
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes
+}
+
+func generateCreateIdxSQL(tableName string, idx index) string {
+	uniq := ""
+	if idx.Unique {
+		uniq = "UNIQUE "
+	}
+	dir := "asc"
+	if idx.Desc {
+		dir = "desc"
+	}
+	stmt := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s %s)", uniq, quote(idx.Name), quote(tableName), quote(idx.Column), dir)
+	if len(idx.Where) > 0 {
+		stmt += " WHERE " + idx.Where
+	}
+	return stmt
+}
+
+// quote returns name as a Postgres identifier, so that table and column names
+// such as user, which are keywords, can be used.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// rebind replaces the ? placeholders of query, which the store builds as for
+// SQLite, with the numbered placeholders of Postgres. The store never writes a
+// ? inside a literal or an identifier.
+func rebind(query string) string {
+	n := strings.Count(query, "?")
+	if n == 0 {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 2*n)
+	i := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		i++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// placeholders returns n ? placeholders separated by commas.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// sqlColumns returns the parts of columns shared by the SQL stores.
+func sqlColumns(columns []column) []store.SQLColumn {
+	shared := make([]store.SQLColumn, 0, len(columns))
+	for _, col := range columns {
+		shared = append(shared, col.SQLColumn)
+	}
+	return shared
+}
+
+// joinWhere ANDs conds into a WHERE clause, or returns "" if there are none.
+func joinWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(conds, " and ")
+}
+
+// globToRegexp translates an SQLite GLOB pattern into an anchored Postgres
+// regular expression. It reports false for a pattern with an unclosed [, which
+// SQLite matches against nothing.
+func globToRegexp(glob string) (string, bool) {
+	var b strings.Builder
+	b.WriteString("^(?:")
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			j := i + 1
+			class := "["
+			if j < len(runes) && runes[j] == '^' {
+				class += "^"
+				j++
+			}
+			// a ] right after [ or [^ is part of the class
+			start := j
+			for ; j < len(runes) && (runes[j] != ']' || j == start); j++ {
+				if runes[j] == '\\' || runes[j] == '[' {
+					class += `\`
+				}
+				class += string(runes[j])
+			}
+			if j >= len(runes) {
+				return "", false
+			}
+			b.WriteString(class + "]")
+			i = j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(")$")
+	return b.String(), true
+}
+
+func toSnakeCase(input string) string {
+	var result []rune
+
+	for i, char := range input {
+		if i > 0 && (unicode.IsUpper(char) || unicode.IsDigit(char)) {
+			result = append(result, '_')
+		}
+		result = append(result, unicode.ToLower(char))
+	}
+
+	return string(result)
+}
@@ -0,0 +1,115 @@
+package pgstore
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/storetest"
+)
+
+func TestRebind(t *testing.T) {
+	assert.Equal(t, "SELECT 1", rebind("SELECT 1"))
+	assert.Equal(t, `UPDATE "t" SET "a"=$1 where "id" IN ($2,$3)`, rebind(`UPDATE "t" SET "a"=? where "id" IN (?,?)`))
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		glob  string
+		match []string
+		miss  []string
+	}{
+		{glob: "ma*", match: []string{"ma", "ma long"}, miss: []string{"Ma long", "xma"}},
+		{glob: "?an*", match: []string{"fan", "dang qiu"}, miss: []string{"an"}},
+		{glob: "[bd]*", match: []string{"bayi", "dang"}, miss: []string{"Dusseldorf"}},
+		{glob: "[^b]*", match: []string{"dang"}, miss: []string{"bayi"}},
+		{glob: "[]]", match: []string{"]"}, miss: []string{"["}},
+		{glob: "a.b+c", match: []string{"a.b+c"}, miss: []string{"axbbc"}},
+	} {
+		pattern, ok := globToRegexp(tc.glob)
+		assert.True(t, ok, tc.glob)
+		re := regexp.MustCompile(pattern)
+		for _, s := range tc.match {
+			assert.True(t, re.MatchString(s), "%s should match %s", tc.glob, s)
+		}
+		for _, s := range tc.miss {
+			assert.False(t, re.MatchString(s), "%s should not match %s", tc.glob, s)
+		}
+	}
+	_, ok := globToRegexp("[ab")
+	assert.False(t, ok)
+}
+
+func TestGenerateSQL(t *testing.T) {
+	columns, err := getColumns(reflect.TypeOf(storetest.Player{}))
+	assert.NoError(t, err)
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "player" (`+
+		`"id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY, `+
+		`"name" TEXT COLLATE "C" NOT NULL DEFAULT '', `+
+		`"club" TEXT COLLATE "C" NOT NULL DEFAULT '', `+
+		`"age" BIGINT NOT NULL DEFAULT 0, `+
+		`"rating" DOUBLE PRECISION NOT NULL DEFAULT 0, `+
+		`"active" BOOLEAN NOT NULL DEFAULT false, `+
+		`"tags" JSONB NOT NULL DEFAULT 'null'::jsonb)`, generateCreateTableSQL("player", columns))
+
+	indexes := getIndexes("player", columns)
+	assert.Equal(t, []string{
+		`CREATE UNIQUE INDEX IF NOT EXISTS "idx_player_name" ON "player" ("name" asc)`,
+		`CREATE INDEX IF NOT EXISTS "idx_player_club" ON "player" ("club" asc)`,
+	}, []string{generateCreateIdxSQL("player", indexes[0]), generateCreateIdxSQL("player", indexes[1])})
+
+	type user struct {
+		ID      int64  `db:"id,pk"`
+		RoleID  int64  `db:"role_id,fk=role,on_delete=cascade"`
+		Deleted string `db:"deleted_at,soft_delete"`
+	}
+	_, err = getColumns(reflect.TypeOf(user{}))
+	assert.EqualError(t, err, "column deleted_at must be an integer")
+
+	type event struct {
+		ID int64     `db:"id,pk"`
+		At time.Time `db:"at"`
+	}
+	_, err = getColumns(reflect.TypeOf(event{}))
+	assert.EqualError(t, err, "column at of type time.Time must be tagged json")
+}
+
+func TestCondSQL(t *testing.T) {
+	columns, err := getColumns(reflect.TypeOf(storetest.Player{}))
+	assert.NoError(t, err)
+	o := &PGStore[storetest.Player, *storetest.Player]{tablename: "player", columns: columns,
+		sqlTable: &store.SQLTable[storetest.Player, *storetest.Player]{Name: "player", Columns: sqlColumns(columns), Quote: quote}}
+
+	where, args, err := o.where([]store.Cond{
+		store.Eq(storetest.PlayerColClub, "bayi"),
+		store.Or(store.Like(storetest.PlayerColName, "ma%"), store.In(storetest.PlayerColAge, []int{})),
+	}, "FindWhere")
+	assert.NoError(t, err)
+	assert.Equal(t, ` where (("club" = ? and ("name"::text ilike ? escape '' or 1 = 0)))`, where)
+	assert.Equal(t, []any{"bayi", "ma%"}, args)
+
+	where, args, err = o.where([]store.Cond{store.Glob(storetest.PlayerColName, "[ab")}, "FindWhere")
+	assert.NoError(t, err)
+	assert.Equal(t, ` where (1 = 0)`, where)
+	assert.Empty(t, args)
+
+	where, args, err = o.where([]store.Cond{store.JSONContains(storetest.PlayerColTags, "lefty")}, "FindWhere")
+	assert.NoError(t, err)
+	assert.Contains(t, where, `"tags"::jsonb @> ?::jsonb`)
+	assert.Equal(t, []any{`["lefty"]`, `"lefty"`, `"lefty"`}, args)
+
+	_, _, err = o.where([]store.Cond{store.Eq("rank", 1)}, "FindWhere")
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	where, args, err = o.where([]store.Cond{store.Between(storetest.PlayerColAge, 20, 30)}, "FindWhere")
+	assert.NoError(t, err)
+	assert.Equal(t, ` where ("age" between ? and ?)`, where)
+	assert.Equal(t, []any{20, 30}, args)
+	_, _, err = o.treeSQL(store.WhereCond{Field: storetest.PlayerColAge, Op: store.OpIn, Val: 3})
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+	_, _, err = o.treeSQL(store.WhereCond{Field: storetest.PlayerColAge, Op: store.OpBetween, Val: []int{1}})
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+}
@@ -0,0 +1,90 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// migrateTable creates the table of a store, or brings an existing one in line
+// with columns: missing columns are added, filled with their default, and the
+// indexes of the store are created, dropped or recreated to match the tags,
+// e.g. to make the unique index of a soft deleted table partial. Columns that
+// are no longer in the model are left in place, as are changes of type, which
+// need an explicit ALTER TABLE. Stores opening the same table concurrently
+// wait for each other's migration.
+func (d *DB) migrateTable(ctx context.Context, tableName string, columns []column) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := rebinder{q: tx}
+
+	if _, err = q.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(?))", tableName); err != nil {
+		return err
+	}
+	if _, err = q.ExecContext(ctx, generateCreateTableSQL(tableName, columns)); err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if col.IsPK {
+			continue
+		}
+		if _, err = q.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s", quote(tableName), generateColumnSQL(col))); err != nil {
+			return fmt.Errorf("add column %s: %w", col.Name, err)
+		}
+	}
+
+	indexes := getIndexes(tableName, columns)
+	existing, err := tableIndexes(ctx, q, tableName)
+	if err != nil {
+		return err
+	}
+	for _, have := range existing {
+		if !strings.HasPrefix(have.Name, indexPrefix) || slices.ContainsFunc(indexes, func(idx index) bool {
+			return idx.Name == have.Name && idx.Unique == have.Unique && (len(idx.Where) > 0) == have.Partial
+		}) {
+			continue
+		}
+		if _, err = q.ExecContext(ctx, "DROP INDEX IF EXISTS "+quote(have.Name)); err != nil {
+			return fmt.Errorf("drop index %s: %w", have.Name, err)
+		}
+	}
+	for _, idx := range indexes {
+		if _, err = q.ExecContext(ctx, generateCreateIdxSQL(tableName, idx)); err != nil {
+			return fmt.Errorf("create index %s: %w", idx.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// tableIndex is an index of a table, as found by tableIndexes.
+type tableIndex struct {
+	Name    string
+	Unique  bool
+	Partial bool
+}
+
+// tableIndexes returns the indexes of tableName in the current schema.
+func tableIndexes(ctx context.Context, q querier, tableName string) ([]tableIndex, error) {
+	rows, err := q.QueryContext(ctx, `SELECT ic.relname, i.indisunique, i.indpred IS NOT NULL
+		FROM pg_index i
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		JOIN pg_class tc ON tc.oid = i.indrelid
+		WHERE tc.relnamespace = current_schema()::regnamespace AND tc.relname = ?`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []tableIndex
+	for rows.Next() {
+		var idx tableIndex
+		if err = rows.Scan(&idx.Name, &idx.Unique, &idx.Partial); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
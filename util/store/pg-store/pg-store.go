@@ -0,0 +1,413 @@
+// Package pgstore implements store.Store on Postgres. Models are declared with
+// the db tags of the SQLite store, and the stores of both backends pass the
+// storetest conformance suite: they differ only in what SQL itself allows.
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+type PGStore[T any, R store.Row[T]] struct {
+	db        *DB
+	tx        *Tx
+	tablename string
+	// table is the quoted tablename.
+	table   string
+	pk      string
+	columns []column
+	// sqlTable holds the parts of the store shared with the SQLite store.
+	sqlTable *store.SQLTable[T, R]
+	// softDelete is the quoted name of the soft_delete column, or "" if the
+	// model has none.
+	softDelete string
+	// version is the quoted name of the version column, or "" if the model has
+	// none.
+	version string
+	// hooks is shared with the views returned by WithTx.
+	hooks map[store.Event][]store.Hook[T]
+
+	insertQuery string
+	updateQuery string
+	getOneQuery string
+}
+
+// NewStore connects to the Postgres database at dsn and creates or migrates the
+// table for T. Stores opened on the same dsn share one connection pool.
+func NewStore[T any, R store.Row[T]](dsn string) (*PGStore[T, R], error) {
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return NewStoreFromDB[T, R](db)
+}
+
+// NewStoreFromDB creates or migrates the table for T in db and returns a store
+// for it. The tables referenced by the foreign keys of T must already exist.
+func NewStoreFromDB[T any, R store.Row[T]](db *DB) (*PGStore[T, R], error) {
+	var obj T
+	typ := reflect.TypeOf(obj)
+	tableName := toSnakeCase(typ.Name())
+	if namer, ok := any(R(&obj)).(store.TableNamer); ok {
+		tableName = namer.TableName()
+	}
+	columns, err := getColumns(typ)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tableName, err)
+	}
+
+	o := &PGStore[T, R]{
+		db: db, tablename: tableName, table: quote(tableName), columns: columns,
+		sqlTable: &store.SQLTable[T, R]{
+			Name: tableName, Columns: sqlColumns(columns), Quote: quote, SelectList: selectList(columns),
+		},
+		hooks: map[store.Event][]store.Hook[T]{},
+	}
+	for _, col := range columns {
+		switch {
+		case col.IsPK:
+			o.pk = quote(col.Name)
+		case col.IsSoftDelete:
+			o.softDelete = quote(col.Name)
+		case col.IsVersion:
+			o.version = quote(col.Name)
+		}
+	}
+	if len(o.pk) == 0 {
+		return nil, fmt.Errorf("%s has no pk column", tableName)
+	}
+
+	if err = db.migrateTable(context.Background(), tableName, columns); err != nil {
+		return nil, fmt.Errorf("%s migration failed: %w", tableName, err)
+	}
+
+	names := make([]string, 0, len(columns))
+	updates := make([]string, 0, len(columns))
+	for _, col := range columns {
+		// the store sets the soft_delete column itself, see DeleteMulti
+		if col.IsPK || col.IsSoftDelete {
+			continue
+		}
+		name := quote(col.Name)
+		names = append(names, name)
+		switch {
+		case col.IsVersion:
+			updates = append(updates, name+"="+name+"+1")
+		case !col.IsCreatedAt:
+			updates = append(updates, name+"=?")
+		}
+	}
+	if len(names) == 0 {
+		o.insertQuery = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES RETURNING %s", o.table, o.pk)
+	} else {
+		o.insertQuery = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) RETURNING %s",
+			o.table, strings.Join(names, ", "), placeholders(len(names)), o.pk)
+	}
+	if len(updates) == 0 {
+		updates = append(updates, o.pk+"="+o.pk)
+	}
+	live := ""
+	if len(o.softDelete) > 0 {
+		live = fmt.Sprintf(" and %s = 0", o.softDelete)
+	}
+	o.getOneQuery = fmt.Sprintf("SELECT %s from %s where %s=?%s", o.columnList(), o.table, o.pk, live)
+	if len(o.version) > 0 {
+		// optimistic locking: the row must still have the version read by the caller
+		live += fmt.Sprintf(" and %s=?", o.version)
+	}
+	o.updateQuery = fmt.Sprintf("UPDATE %s SET %s where %s=?%s", o.table, strings.Join(updates, ", "), o.pk, live)
+
+	db.acquire()
+	return o, nil
+}
+
+// WithTx returns a view of the store whose methods run inside tx. tx must have
+// been opened on the same DB as the store.
+func (o *PGStore[T, R]) WithTx(tx store.Tx) (store.Store[T, R], error) {
+	pgTx, ok := tx.(*Tx)
+	if !ok || pgTx.db != o.db {
+		return nil, store.ErrTxMismatch
+	}
+	view := *o
+	view.tx = pgTx
+	return &view, nil
+}
+
+// q returns the querier of the store: the transaction, or else the pool.
+func (o *PGStore[T, R]) q() querier {
+	if o.tx != nil {
+		return rebinder{q: o.tx.tx}
+	}
+	return rebinder{q: o.db.db}
+}
+
+func (o *PGStore[T, R]) Insert(ctx context.Context, obj T) (int64, error) {
+	var id int64
+	err := o.hookTx(ctx, func(s *PGStore[T, R]) error {
+		var err error
+		id, err = s.insert(ctx, obj)
+		return err
+	}, store.BeforeInsert, store.AfterInsert)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insert is Insert on o, which may be a transaction view.
+func (o *PGStore[T, R]) insert(ctx context.Context, obj T) (int64, error) {
+	if err := o.runHooks(ctx, store.BeforeInsert, &obj); err != nil {
+		return 0, err
+	}
+	o.stamp(&obj, time.Now(), false)
+	values, err := o.sqlTable.RowValues(R(&obj), false)
+	if err != nil {
+		return 0, fmt.Errorf("%s insert failed: %w", o.tablename, err)
+	}
+
+	var id int64
+	if err = o.q().QueryRowContext(ctx, o.insertQuery, values...).Scan(&id); err != nil {
+		if isDupError(err) {
+			return 0, store.ErrConflicted
+		}
+		return 0, fmt.Errorf("%s insert failed: %w", o.tablename, err)
+	}
+	o.setPK(&obj, id)
+	if err = o.runHooks(ctx, store.AfterInsert, &obj); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (o *PGStore[T, R]) Update(ctx context.Context, id int64, obj T) error {
+	return o.hookTx(ctx, func(s *PGStore[T, R]) error {
+		return s.update(ctx, id, obj)
+	}, store.BeforeUpdate, store.AfterUpdate)
+}
+
+// update is Update on o, which may be a transaction view.
+func (o *PGStore[T, R]) update(ctx context.Context, id int64, obj T) error {
+	o.setPK(&obj, id)
+	if err := o.runHooks(ctx, store.BeforeUpdate, &obj); err != nil {
+		return err
+	}
+	o.stamp(&obj, time.Now(), true)
+	values, err := o.sqlTable.RowValues(R(&obj), true)
+	if err != nil {
+		return fmt.Errorf("%s update failed: %w", o.tablename, err)
+	}
+	values = append(values, id)
+	version := o.versionOf(&obj)
+	if len(o.version) > 0 {
+		values = append(values, version)
+	}
+
+	res, err := o.q().ExecContext(ctx, o.updateQuery, values...)
+	if err != nil {
+		if isDupError(err) {
+			return store.ErrConflicted
+		}
+		return fmt.Errorf("%s update failed: %w", o.tablename, err)
+	}
+
+	if rowsAffected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("%s failed to get rows affected: %w", o.tablename, err)
+	} else if rowsAffected == 0 {
+		if len(o.version) == 0 {
+			return store.ErrNotFound
+		}
+		if ok, err := o.exists(ctx, id); err != nil {
+			return err
+		} else if ok {
+			return store.ErrStaleVersion
+		}
+		return store.ErrNotFound
+	}
+	o.setVersion(&obj, version+1)
+	return o.runHooks(ctx, store.AfterUpdate, &obj)
+}
+
+func (o *PGStore[T, R]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	if len(ids) == 0 {
+		return []T{}, nil
+	}
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("SELECT %s from %s%s", o.columnList(), o.table,
+		joinWhere(o.sqlTable.LiveConds(fmt.Sprintf("%s in (%s)", o.pk, placeholders(len(ids))))))
+	rows, err := o.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s GetMulti Query error: %w", o.tablename, err)
+	}
+	return o.sqlTable.ScanRows(rows, "GetMulti", len(ids))
+}
+
+func (o *PGStore[T, R]) GetOne(ctx context.Context, id int64) (T, error) {
+	var obj T
+	row := o.q().QueryRowContext(ctx, o.getOneQuery, id)
+	if err := R(&obj).ScanRow(o.sqlTable.Scanner(row)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return obj, store.ErrNotFound
+		}
+		return obj, fmt.Errorf("%s GetOne row.Scan error: %w", o.tablename, o.sqlTable.CorruptRow(&obj, err))
+	}
+	return obj, nil
+}
+
+// DeleteMulti deletes the rows ids. If the model has a soft_delete column, the
+// rows are only marked as deleted, see Restore and Purge.
+func (o *PGStore[T, R]) DeleteMulti(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return store.ErrNotFound
+	}
+	return o.hookTx(ctx, func(s *PGStore[T, R]) error {
+		// the delete hooks get the rows as they were before the delete
+		var objs []T
+		if s.hasHooks(store.BeforeDelete, store.AfterDelete) {
+			var err error
+			if objs, err = s.GetMulti(ctx, ids); err != nil {
+				return err
+			}
+		}
+		for i := range objs {
+			if err := s.runHooks(ctx, store.BeforeDelete, &objs[i]); err != nil {
+				return err
+			}
+		}
+
+		args := make([]any, 0, len(ids)+1)
+		query := fmt.Sprintf("DELETE from %s where %s IN (%s)", s.table, s.pk, placeholders(len(ids)))
+		if len(s.softDelete) > 0 {
+			query = fmt.Sprintf("UPDATE %s SET %s = ? where %s IN (%s) and %s = 0", s.table, s.softDelete, s.pk, placeholders(len(ids)), s.softDelete)
+			args = append(args, time.Now().Unix())
+		}
+		for _, id := range ids {
+			args = append(args, id)
+		}
+		res, err := s.q().ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("%s DeleteMulti exec failed: %w", s.tablename, err)
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("%s DeleteMulti RowsAffected failed: %w", s.tablename, err)
+		}
+		if rowsAffected == 0 {
+			return store.ErrNotFound
+		}
+
+		for i := range objs {
+			if err := s.runHooks(ctx, store.AfterDelete, &objs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, store.BeforeDelete, store.AfterDelete)
+}
+
+func (o *PGStore[T, R]) FindWhere(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	rows, err := o.query(ctx, conds, "FindWhere")
+	if err != nil {
+		return nil, err
+	}
+	return o.sqlTable.ScanRows(rows, "FindWhere", 0)
+}
+
+// Each reads the rows on a connection of the pool while fn runs, so fn may
+// write to the store. Inside a transaction fn must not use the transaction,
+// whose connection is busy reading the rows.
+func (o *PGStore[T, R]) Each(ctx context.Context, fn func(obj T) error, conds ...store.Cond) error {
+	rows, err := o.query(ctx, conds, "Each")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var obj T
+		if err = R(&obj).ScanRow(o.sqlTable.Scanner(rows)); err != nil {
+			return fmt.Errorf("%s Each row.Scan error: %w", o.tablename, o.sqlTable.CorruptRow(&obj, err))
+		}
+		if err = fn(obj); err != nil {
+			if errors.Is(err, store.ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("%s Each rows error: %w", o.tablename, err)
+	}
+	return nil
+}
+
+// query selects the live rows matching conds.
+func (o *PGStore[T, R]) query(ctx context.Context, conds []store.Cond, op string) (*sql.Rows, error) {
+	where, args, err := o.where(conds, op)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("SELECT %s from %s%s", o.columnList(), o.table, where)
+	rows, err := o.q().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s Query error: %w", o.tablename, op, err)
+	}
+	return rows, nil
+}
+
+func (o *PGStore[T, R]) FindPage(ctx context.Context, q store.Query) (store.Page[T], error) {
+	whereStmt, args, err := o.condSQL(q.Where, "FindPage")
+	if err != nil {
+		return store.Page[T]{}, err
+	}
+	return o.sqlTable.FindPage(ctx, q, whereStmt, args,
+		func(ctx context.Context) (int64, error) { return o.Count(ctx, q.Where...) },
+		func(ctx context.Context, query string, args ...any) (store.Rows, error) {
+			return o.q().QueryContext(ctx, query, args...)
+		})
+}
+
+func (o *PGStore[T, R]) column(name string) (column, bool) {
+	for _, col := range o.columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return column{}, false
+}
+
+// columnList returns the columns to select, with the JSON columns as text for
+// jsonScanner.
+func (o *PGStore[T, R]) columnList() string {
+	return o.sqlTable.SelectList
+}
+
+func selectList(columns []column) string {
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if col.IsJSON {
+			names = append(names, quote(col.Name)+"::text")
+		} else {
+			names = append(names, quote(col.Name))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Close releases the store's reference to the shared DB. Closing a view
+// returned by WithTx is a no-op.
+func (o *PGStore[T, R]) Close() error {
+	if o.tx != nil {
+		return nil
+	}
+	return o.db.Close()
+}
@@ -0,0 +1,83 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Restore unmarks the soft deleted rows ids. It returns store.ErrNotFound if
+// none of them is deleted, and store.ErrConflicted if a live row has taken a
+// unique value of one of them. Restore runs no hooks.
+func (o *PGStore[T, R]) Restore(ctx context.Context, ids []int64) error {
+	if len(o.softDelete) == 0 {
+		return store.ErrSoftDeleteNotSupported
+	}
+	if len(ids) == 0 {
+		return store.ErrNotFound
+	}
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s = 0 where %s IN (%s) and %s != 0", o.table, o.softDelete, o.pk, placeholders(len(ids)), o.softDelete)
+	res, err := o.q().ExecContext(ctx, query, args...)
+	if err != nil {
+		if isDupError(err) {
+			return store.ErrConflicted
+		}
+		return fmt.Errorf("%s Restore exec failed: %w", o.tablename, err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s Restore RowsAffected failed: %w", o.tablename, err)
+	}
+	if rowsAffected == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// FindDeleted returns the soft deleted rows matching conds, most recently
+// deleted first.
+func (o *PGStore[T, R]) FindDeleted(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	if len(o.softDelete) == 0 {
+		return nil, store.ErrSoftDeleteNotSupported
+	}
+	whereStmt, args, err := o.condSQL(conds, "FindDeleted")
+	if err != nil {
+		return nil, err
+	}
+	where := []string{o.softDelete + " != 0"}
+	if len(whereStmt) > 0 {
+		where = append(where, "("+whereStmt+")")
+	}
+	findQuery := fmt.Sprintf("SELECT %s from %s%s ORDER BY %s desc, %s desc",
+		o.columnList(), o.table, joinWhere(where), o.softDelete, o.pk)
+	rows, err := o.q().QueryContext(ctx, findQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s FindDeleted Query error: %w", o.tablename, err)
+	}
+	return o.sqlTable.ScanRows(rows, "FindDeleted", 0)
+}
+
+// Purge removes the rows soft deleted before before. Foreign keys referencing
+// them act on delete as for a hard delete. Purge runs no hooks, the delete
+// hooks ran when the rows were soft deleted.
+func (o *PGStore[T, R]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if len(o.softDelete) == 0 {
+		return 0, store.ErrSoftDeleteNotSupported
+	}
+	query := fmt.Sprintf("DELETE from %s where %s != 0 and %s < ?", o.table, o.softDelete, o.softDelete)
+	res, err := o.q().ExecContext(ctx, query, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("%s Purge exec failed: %w", o.tablename, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s Purge RowsAffected failed: %w", o.tablename, err)
+	}
+	return n, nil
+}
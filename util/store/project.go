@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Projector is implemented by stores that can read a subset of the columns of
+// their rows, without decoding the others.
+type Projector interface {
+	// Project calls scan for each row matching conds with a RowScanner that
+	// reads fields, in order. JSON fields are decoded into the pointers passed
+	// to Scan.
+	Project(ctx context.Context, fields []string, scan func(row RowScanner) error, conds ...Cond) error
+}
+
+var ErrProjectionNotSupported error = errors.New("store does not support projection")
+
+// Project returns the rows of s matching conds as P, a struct with a field for
+// each column to read, named by its db tag like the fields of the model:
+//
+//	type permissionOption struct {
+//		ID   int64  `db:"id"`
+//		Name string `db:"name"`
+//	}
+//	options, err := store.Project[permissionOption](ctx, permissionStore)
+func Project[P any, T any, R Row[T]](ctx context.Context, s Store[T, R], conds ...Cond) ([]P, error) {
+	projector, ok := s.(Projector)
+	if !ok {
+		return nil, ErrProjectionNotSupported
+	}
+	var p P
+	typ := reflect.TypeOf(p)
+	fields := make([]string, 0, typ.NumField())
+	indexes := make([]int, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("db"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = typ.Field(i).Name
+		}
+		fields = append(fields, name)
+		indexes = append(indexes, i)
+	}
+
+	var out []P
+	err := projector.Project(ctx, fields, func(row RowScanner) error {
+		var p P
+		v := reflect.ValueOf(&p).Elem()
+		dest := make([]any, 0, len(indexes))
+		for _, i := range indexes {
+			dest = append(dest, v.Field(i).Addr().Interface())
+		}
+		if err := row.Scan(dest...); err != nil {
+			return err
+		}
+		out = append(out, p)
+		return nil
+	}, conds...)
+	return out, err
+}
+
+// FindMaps returns fields of the rows of s matching conds, one map from field
+// to value per row.
+func FindMaps[T any, R Row[T]](ctx context.Context, s Store[T, R], fields []string, conds ...Cond) ([]map[string]any, error) {
+	projector, ok := s.(Projector)
+	if !ok {
+		return nil, ErrProjectionNotSupported
+	}
+	var out []map[string]any
+	err := projector.Project(ctx, fields, func(row RowScanner) error {
+		vals := make([]any, len(fields))
+		dest := make([]any, 0, len(fields))
+		for i := range vals {
+			dest = append(dest, &vals[i])
+		}
+		if err := row.Scan(dest...); err != nil {
+			return err
+		}
+		m := make(map[string]any, len(fields))
+		for i, field := range fields {
+			m[field] = vals[i]
+		}
+		out = append(out, m)
+		return nil
+	}, conds...)
+	return out, err
+}
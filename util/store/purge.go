@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// PurgeEvery purges the rows soft deleted more than retention ago from every
+// purger, once right away and then every interval, until ctx is done.
+func PurgeEvery(ctx context.Context, interval, retention time.Duration, purgers ...Purger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		before := time.Now().Add(-retention)
+		for _, purger := range purgers {
+			n, err := purger.Purge(ctx, before)
+			if err != nil {
+				slog.ErrorContext(ctx, "store.Purger.Purge()", slog.String("error", err.Error()))
+				continue
+			}
+			if n > 0 {
+				slog.InfoContext(ctx, "purged soft deleted rows", slog.Int64("count", n), slog.Time("before", before))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type Direction string
+
+const Asc Direction = "asc"
+const Desc Direction = "desc"
+
+// Order sorts results by Field in Dir. An empty Dir sorts ascending.
+type Order struct {
+	Field string
+	Dir   Direction
+}
+
+// Query selects a page of rows. The zero value selects every row in
+// primary key order.
+type Query struct {
+	Where   []Cond
+	OrderBy []Order
+	// Limit caps the number of rows returned. 0 means no limit.
+	Limit  int
+	Offset int
+	// After resumes a keyset scan from the Next cursor of a previous page.
+	// The query must keep the same Where and OrderBy as that page.
+	After Cursor
+}
+
+// Page is one page of the result of a Query.
+type Page[T any] struct {
+	Items []T
+	// Total is the number of rows matching Where, ignoring Limit, Offset and After.
+	Total int64
+	// Next is the cursor of the following page, or empty on the last page.
+	Next Cursor
+}
+
+// Cursor is an opaque position in an ordered result set. It holds the values
+// of the OrderBy fields (and the primary key) of the last row of a page.
+type Cursor string
+
+var ErrInvalidCursor error = errors.New("invalid cursor")
+
+// NewCursor encodes vals into a Cursor.
+func NewCursor(vals []any) (Cursor, error) {
+	b, err := json.Marshal(vals)
+	if err != nil {
+		return "", fmt.Errorf("fail to encode cursor: %w", err)
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(b)), nil
+}
+
+// Values decodes the values held by c. Whole numbers are returned as int64
+// and other numbers as float64.
+func (c Cursor) Values() ([]any, error) {
+	b, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var vals []any
+	if err = dec.Decode(&vals); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	for i, v := range vals {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if iv, err := n.Int64(); err == nil {
+			vals[i] = iv
+		} else if fv, err := n.Float64(); err == nil {
+			vals[i] = fv
+		} else {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return vals, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Searcher is implemented by stores whose model has full-text indexed
+// columns, tagged fts.
+type Searcher[T any] interface {
+	// Search returns the rows matching query, best match first. query is
+	// plain text: a row matches if its indexed columns hold every word of
+	// query, each word matching as a prefix.
+	Search(ctx context.Context, query string, opts SearchOptions) ([]SearchResult[T], error)
+}
+
+// SearchOptions narrows a Search.
+type SearchOptions struct {
+	// Limit is the maximum number of results, DefaultSearchLimit if 0.
+	Limit  int
+	Offset int
+	// Where filters the results, as the conds of FindWhere.
+	Where []Cond
+	// SnippetTokens is the maximum number of words of a snippet,
+	// DefaultSnippetTokens if 0.
+	SnippetTokens int
+}
+
+const (
+	DefaultSearchLimit   = 20
+	DefaultSnippetTokens = 12
+)
+
+// The words of a snippet that match the query are enclosed in HighlightStart
+// and HighlightEnd. They are control characters, so that the caller can escape
+// the snippet, e.g. for HTML, and mark up the matches, see Highlights.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// SearchResult is a row found by Search.
+type SearchResult[T any] struct {
+	Item T
+	// Rank orders the results: the lower, the better the match.
+	Rank float64
+	// Snippets holds, for each indexed column, the part of its text that best
+	// matches the query, with the matches highlighted.
+	Snippets map[string]string
+}
+
+var ErrSearchNotSupported error = errors.New("store does not support full-text search")
+
+// Search searches s, see Searcher.
+func Search[T any, R Row[T]](ctx context.Context, s Store[T, R], query string, opts SearchOptions) ([]SearchResult[T], error) {
+	searcher, ok := s.(Searcher[T])
+	if !ok {
+		return nil, ErrSearchNotSupported
+	}
+	return searcher.Search(ctx, query, opts)
+}
+
+// Highlight is a part of a snippet, see Highlights.
+type Highlight struct {
+	Text string
+	// Match is true for the words matching the query.
+	Match bool
+}
+
+// Highlights splits snippet at its highlight markers, e.g. for a template to
+// mark up the matches while escaping the text.
+func Highlights(snippet string) []Highlight {
+	var parts []Highlight
+	for len(snippet) > 0 {
+		before, rest, found := strings.Cut(snippet, HighlightStart)
+		if len(before) > 0 {
+			parts = append(parts, Highlight{Text: before})
+		}
+		if !found {
+			break
+		}
+		match, after, _ := strings.Cut(rest, HighlightEnd)
+		if len(match) > 0 {
+			parts = append(parts, Highlight{Text: match, Match: true})
+		}
+		snippet = after
+	}
+	return parts
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlights(t *testing.T) {
+	assert.Empty(t, Highlights(""))
+	assert.Equal(t, []Highlight{{Text: "no match"}}, Highlights("no match"))
+	assert.Equal(t, []Highlight{
+		{Text: "Umpire", Match: true},
+		{Text: " calls the "},
+		{Text: "score", Match: true},
+		{Text: " <b>"},
+	}, Highlights("\x02Umpire\x03 calls the \x02score\x03 <b>"))
+	// an unterminated highlight runs to the end
+	assert.Equal(t, []Highlight{{Text: "a "}, {Text: "b", Match: true}}, Highlights("a \x02b"))
+}
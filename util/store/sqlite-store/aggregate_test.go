@@ -0,0 +1,96 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestAggregate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_aggregate.db")
+	ctx := context.Background()
+	playerStore, err := NewStore[PlayerV2](path)
+	if err != nil {
+		t.Fatalf("fail to create playerStore %v", err)
+	}
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = playerStore.Close()
+		_ = noteStore.Close()
+	})
+
+	_, err = playerStore.InsertMulti(ctx, []PlayerV2{
+		{Name: "ma long", Age: 35, Club: "bayi"},
+		{Name: "fan zhendong", Age: 27, Club: "bayi"},
+		{Name: "timo boll", Age: 43, Club: "dusseldorf"},
+		{Name: "dang qiu", Age: 27, Club: "dusseldorf"},
+		{Name: "truls moregard", Age: 22, Club: "linkoping"},
+	})
+	assert.NoError(t, err)
+
+	n, err := playerStore.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = playerStore.Count(ctx, store.Eq(PlayerV2ColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	_, err = playerStore.Count(ctx, store.Eq("rating", 1))
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	ok, err := playerStore.Exists(ctx, store.Eq(PlayerV2ColName, "ma long"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = playerStore.Exists(ctx, store.Eq(PlayerV2ColName, "ding ning"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	groups, err := playerStore.Aggregate(ctx, store.Aggregation{Func: store.AggMax, Field: PlayerV2ColAge})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{{Keys: []any{}, Value: 43}}, groups)
+	groups, err = playerStore.Aggregate(ctx, store.Aggregation{
+		Func:    store.AggAvg,
+		Field:   PlayerV2ColAge,
+		GroupBy: []string{PlayerV2ColClub},
+		Where:   []store.Cond{store.Gt(PlayerV2ColAge, 25)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{
+		{Keys: []any{"bayi"}, Value: 31},
+		{Keys: []any{"dusseldorf"}, Value: 35},
+	}, groups)
+	groups, err = playerStore.Aggregate(ctx, store.Aggregation{
+		Func:    store.AggCount,
+		GroupBy: []string{PlayerV2ColClub, PlayerV2ColAge},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 5) {
+		assert.Equal(t, store.Group{Keys: []any{"bayi", int64(27)}, Value: 1}, groups[0])
+	}
+	groups, err = playerStore.Aggregate(ctx, store.Aggregation{Func: store.AggSum, Field: PlayerV2ColAge, Where: []store.Cond{store.Eq(PlayerV2ColClub, "none")}})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{{Keys: []any{}, Value: 0}}, groups)
+
+	_, err = playerStore.Aggregate(ctx, store.Aggregation{Func: "median", Field: PlayerV2ColAge})
+	assert.ErrorIs(t, err, store.ErrInvalidAggregation)
+	_, err = playerStore.Aggregate(ctx, store.Aggregation{Func: store.AggSum})
+	assert.ErrorIs(t, err, store.ErrInvalidAggregation)
+	_, err = playerStore.Aggregate(ctx, store.Aggregation{Func: store.AggCount, GroupBy: []string{"1; drop table player"}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	// soft deleted rows are not counted
+	ids, err := noteStore.InsertMulti(ctx, []Note{{Title: "a"}, {Title: "b"}})
+	assert.NoError(t, err)
+	assert.NoError(t, noteStore.DeleteMulti(ctx, ids[:1]))
+	n, err = noteStore.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	ok, err = noteStore.Exists(ctx, store.Eq(NoteColTitle, "a"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,302 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A snapshot of a database file is named after the file and the time it was
+// taken, e.g. rbac-20261018T093000.000Z.db for rbac.db, so that the snapshots
+// of a file sort by time.
+const snapshotTimeLayout = "20060102T150405.000Z"
+
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// ErrRestored is returned by Restore while the file replaced by an earlier
+// restore is kept, see Restored.
+var ErrRestored = errors.New("already restored")
+
+// Snapshot is a backup of a database file, see DB.Snapshot.
+type Snapshot struct {
+	Path string
+	Time time.Time
+	Size int64
+}
+
+// Backup writes a consistent copy of the database to dest while stores keep
+// using it. The copy is compacted by VACUUM INTO and only appears at dest once
+// it is complete. dest is overwritten if it exists.
+func (d *DB) Backup(ctx context.Context, dest string) error {
+	// the readers are query_only, which VACUUM INTO refuses, and the writer
+	// would hold up writes for the length of the copy: the backup reads
+	// through a connection of its own, which WAL lets run alongside both
+	conn, err := sql.Open("sqlite", dsn(d.path, "_pragma=busy_timeout(5000)"))
+	if err != nil {
+		return fmt.Errorf("%s Backup failed: %w", d.path, err)
+	}
+	defer conn.Close()
+
+	tmp := dest + ".tmp"
+	if err = os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s Backup failed: %w", d.path, err)
+	}
+	if _, err = conn.ExecContext(ctx, "VACUUM INTO ?", tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s Backup failed: %w", d.path, err)
+	}
+	if err = syncFile(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s Backup failed: %w", d.path, err)
+	}
+	if err = os.Rename(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s Backup failed: %w", d.path, err)
+	}
+	return nil
+}
+
+// Snapshot backs the database up into a new snapshot in dir, which is created
+// if needed.
+func (d *DB) Snapshot(ctx context.Context, dir string) (Snapshot, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Snapshot{}, fmt.Errorf("%s Snapshot failed: %w", d.path, err)
+	}
+	at := time.Now().UTC()
+	stem, ext := splitExt(filepath.Base(d.path))
+	path := filepath.Join(dir, stem+"-"+at.Format(snapshotTimeLayout)+ext)
+	if err := d.Backup(ctx, path); err != nil {
+		return Snapshot{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("%s Snapshot failed: %w", d.path, err)
+	}
+	return Snapshot{Path: path, Time: at.Truncate(time.Millisecond), Size: info.Size()}, nil
+}
+
+// Snapshots lists the snapshots of the database file named name, e.g. rbac.db,
+// found in dir, newest first. A missing dir has no snapshots.
+func Snapshots(dir, name string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stem, ext := splitExt(name)
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(fileName, stem+"-") || !strings.HasSuffix(fileName, ext) {
+			continue
+		}
+		at, err := time.Parse(snapshotTimeLayout, strings.TrimSuffix(strings.TrimPrefix(fileName, stem+"-"), ext))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Path: filepath.Join(dir, fileName), Time: at, Size: info.Size()})
+	}
+	slices.SortFunc(snapshots, func(a, b Snapshot) int { return b.Time.Compare(a.Time) })
+	return snapshots, nil
+}
+
+// SnapshotAt returns the newest snapshot of name in dir taken at or before at,
+// to restore the database as it was then. It fails with ErrNoSnapshot if there
+// is none.
+func SnapshotAt(dir, name string, at time.Time) (Snapshot, error) {
+	snapshots, err := Snapshots(dir, name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, snapshot := range snapshots {
+		if !snapshot.Time.After(at) {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, fmt.Errorf("%s at %s: %w", name, at.Format(time.RFC3339), ErrNoSnapshot)
+}
+
+// PruneSnapshots removes the snapshots of name in dir but the newest keep, and
+// returns how many it removed.
+func PruneSnapshots(dir, name string, keep int) (int, error) {
+	snapshots, err := Snapshots(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, snapshot := range snapshots[min(keep, len(snapshots)):] {
+		if err = os.Remove(snapshot.Path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// SnapshotEvery takes a snapshot of d into dir, once right away and then every
+// interval, keeping the newest keep snapshots, until ctx is done.
+func (d *DB) SnapshotEvery(ctx context.Context, dir string, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snapshot, err := d.Snapshot(ctx, dir)
+		if err != nil {
+			slog.ErrorContext(ctx, "sqlitestore.DB.Snapshot()", slog.String("error", err.Error()))
+		} else {
+			slog.InfoContext(ctx, "took snapshot", slog.String("path", snapshot.Path), slog.Int64("size", snapshot.Size))
+			n, err := PruneSnapshots(dir, filepath.Base(d.path), keep)
+			if err != nil {
+				slog.ErrorContext(ctx, "sqlitestore.PruneSnapshots()", slog.String("error", err.Error()))
+			} else if n > 0 {
+				slog.InfoContext(ctx, "pruned snapshots", slog.Int("count", n))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Restore replaces the database file at path with a copy of snapshot, once it
+// passes an integrity check. It must run before the file is opened, e.g. at
+// startup. The replaced file, and its WAL, are kept with the suffix
+// .pre-restore, so that a wrong restore can be undone by hand. Restore fails
+// with ErrRestored while they are kept, rather than overwrite them: they must
+// be removed before restoring again.
+func Restore(path, snapshot string) error {
+	for _, p := range []string{path, snapshot} {
+		if err := checkPath(p); err != nil {
+			return fmt.Errorf("%s Restore failed: %w", path, err)
+		}
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+	dbsMu.Lock()
+	_, open := dbs[key]
+	dbsMu.Unlock()
+	if open {
+		return fmt.Errorf("%s Restore failed: database is open", path)
+	}
+	restored, err := Restored(path)
+	if err != nil {
+		return fmt.Errorf("%s Restore failed: %w", path, err)
+	}
+	if restored {
+		return fmt.Errorf("%s Restore failed: %w, %s is kept", path, ErrRestored, path+preRestoreSuffix)
+	}
+	if err = checkIntegrity(snapshot); err != nil {
+		return fmt.Errorf("%s Restore from %s failed: %w", path, snapshot, err)
+	}
+
+	tmp := path + ".restore"
+	if err = copyFile(snapshot, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s Restore from %s failed: %w", path, snapshot, err)
+	}
+	// the WAL of the replaced file must not be applied to the snapshot
+	for _, suffix := range []string{"", "-wal"} {
+		err = os.Rename(path+suffix, path+preRestoreSuffix+suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("%s Restore failed: %w", path, err)
+		}
+	}
+	if err = os.Remove(path + "-shm"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%s Restore failed: %w", path, err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("%s Restore failed: %w", path, err)
+	}
+	return nil
+}
+
+// preRestoreSuffix is appended to the name of the file replaced by Restore.
+const preRestoreSuffix = ".pre-restore"
+
+// Restored reports whether the file replaced by a restore of path is kept,
+// which stops Restore from running again.
+func Restored(path string) (bool, error) {
+	_, err := os.Stat(path + preRestoreSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkIntegrity fails unless path is a sound SQLite database.
+func checkIntegrity(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", dsn(path, "mode=ro"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var result string
+	if err = db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check: %s", result)
+	}
+	return nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// splitExt splits rbac.db into rbac and .db.
+func splitExt(name string) (string, string) {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext), ext
+}
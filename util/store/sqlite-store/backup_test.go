@@ -0,0 +1,88 @@
+package sqlitestore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_backup.db")
+	dir := t.TempDir()
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	tags, err := NewStoreFromDB[Tag](db)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+
+	_, err = tags.Insert(ctx, Tag{Name: "first"})
+	assert.NoError(t, err)
+	first, err := db.Snapshot(ctx, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "rbac_backup-"+first.Time.Format(snapshotTimeLayout)+".db", filepath.Base(first.Path))
+	time.Sleep(2 * time.Millisecond)
+	_, err = tags.Insert(ctx, Tag{Name: "second"})
+	assert.NoError(t, err)
+	second, err := db.Snapshot(ctx, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "rbac_backup-notes.db"), []byte("not a database, but long enough to have a header"), 0o644))
+
+	snapshots, err := Snapshots(dir, "rbac_backup.db")
+	assert.NoError(t, err)
+	assert.Equal(t, []Snapshot{second, first}, snapshots)
+	at, err := SnapshotAt(dir, "rbac_backup.db", second.Time.Add(-time.Millisecond))
+	assert.NoError(t, err)
+	assert.Equal(t, first, at)
+	_, err = SnapshotAt(dir, "rbac_backup.db", first.Time.Add(-time.Millisecond))
+	assert.ErrorIs(t, err, ErrNoSnapshot)
+	snapshots, err = Snapshots(filepath.Join(dir, "missing"), "rbac_backup.db")
+	assert.NoError(t, err)
+	assert.Empty(t, snapshots)
+
+	// an open database is not restored
+	assert.ErrorContains(t, Restore(path, first.Path), "database is open")
+	_ = tags.Close()
+	assert.NoError(t, db.Close())
+
+	assert.NoError(t, Restore(path, first.Path))
+	tags, err = NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to reopen tagStore %v", err)
+	}
+	restored, err := tags.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Tag{{ID: 1, Name: "first"}}, restored)
+	_ = tags.Close()
+	_, err = os.Stat(path + ".pre-restore")
+	assert.NoError(t, err)
+
+	// the replaced file is not overwritten by another restore
+	kept, err := Restored(path)
+	assert.NoError(t, err)
+	assert.True(t, kept)
+	assert.ErrorIs(t, Restore(path, second.Path), ErrRestored)
+	assert.NoError(t, os.Remove(path+".pre-restore"))
+	kept, err = Restored(path)
+	assert.NoError(t, err)
+	assert.False(t, kept)
+
+	// a file that is not a database is not restored
+	assert.Error(t, Restore(path, filepath.Join(dir, "rbac_backup-notes.db")))
+	assert.Error(t, Restore(path, filepath.Join(dir, "missing.db")))
+	assert.ErrorIs(t, Restore(path, first.Path+"?mode=ro"), ErrNotAFile)
+
+	n, err := PruneSnapshots(dir, "rbac_backup.db", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	snapshots, err = Snapshots(dir, "rbac_backup.db")
+	assert.NoError(t, err)
+	assert.Equal(t, []Snapshot{second}, snapshots)
+}
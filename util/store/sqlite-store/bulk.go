@@ -0,0 +1,170 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// InsertMulti inserts objs one by one inside a single transaction, which saves
+// SQLite a commit per row.
+func (o *SQliteStore[T, R]) InsertMulti(ctx context.Context, objs []T) ([]int64, error) {
+	ids := make([]int64, 0, len(objs))
+	err := o.inTx(ctx, func(s *SQliteStore[T, R]) error {
+		for _, obj := range objs {
+			id, err := s.insert(ctx, obj)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (o *SQliteStore[T, R]) UpdateMulti(ctx context.Context, ids []int64, objs []T) error {
+	if len(ids) != len(objs) {
+		return fmt.Errorf("%s UpdateMulti got %d ids for %d rows", o.tablename, len(ids), len(objs))
+	}
+	return o.inTx(ctx, func(s *SQliteStore[T, R]) error {
+		for i, obj := range objs {
+			if err := s.update(ctx, ids[i], obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Upsert writes objs with INSERT ... ON CONFLICT(field) DO UPDATE. The
+// created_at column of an updated row is kept and its version incremented. A
+// row in the trash is left alone, as it is by Insert. The insert or the update
+// hooks run depending on whether a live row has the value of field of obj
+// before the BeforeInsert or BeforeUpdate hooks run.
+func (o *SQliteStore[T, R]) Upsert(ctx context.Context, field string, objs []T) ([]int64, error) {
+	col, ok := o.column(field)
+	if !ok {
+		return nil, &store.UnknownFieldError{Table: o.tablename, Field: field}
+	}
+	if !col.IsIdxUniq {
+		return nil, fmt.Errorf("%s Upsert on %s: %w", o.tablename, field, store.ErrNotUnique)
+	}
+	query := o.upsertQuery(field)
+
+	ids := make([]int64, 0, len(objs))
+	err := o.inTx(ctx, func(s *SQliteStore[T, R]) error {
+		for _, obj := range objs {
+			id, err := s.upsert(ctx, query, col, obj)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (o *SQliteStore[T, R]) upsertQuery(field string) string {
+	names := make([]string, 0, len(o.columns))
+	placeholders := make([]string, 0, len(o.columns))
+	sets := make([]string, 0, len(o.columns))
+	returning := []string{o.pk}
+	for _, col := range o.columns {
+		if col.IsPK || col.IsSoftDelete {
+			continue
+		}
+		names = append(names, col.Name)
+		placeholders = append(placeholders, "?")
+		switch {
+		case col.IsVersion:
+			sets = append(sets, col.Name+"="+col.Name+"+1")
+			returning = append(returning, col.Name)
+		case !col.IsCreatedAt:
+			sets = append(sets, col.Name+"=excluded."+col.Name)
+		}
+	}
+	// the conflict target matches the partial unique index of the live rows
+	target := "(" + field + ")"
+	if len(o.softDelete) > 0 {
+		target += fmt.Sprintf(" WHERE %s = 0", o.softDelete)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT%s DO UPDATE SET %s RETURNING %s",
+		o.tablename, strings.Join(names, ", "), strings.Join(placeholders, ", "), target, strings.Join(sets, ", "), strings.Join(returning, ", "))
+}
+
+// upsert writes obj with query, built by upsertQuery on col, in the
+// transaction of o.
+func (o *SQliteStore[T, R]) upsert(ctx context.Context, query string, col column, obj T) (int64, error) {
+	var existing int64
+	if o.hasHooks(store.BeforeInsert, store.AfterInsert, store.BeforeUpdate, store.AfterUpdate) {
+		var err error
+		if existing, err = o.idOf(ctx, col, &obj); err != nil {
+			return 0, err
+		}
+	}
+	before, after := store.BeforeInsert, store.AfterInsert
+	if existing != 0 {
+		before, after = store.BeforeUpdate, store.AfterUpdate
+		o.setPK(&obj, existing)
+	}
+	if err := o.runHooks(ctx, before, &obj); err != nil {
+		return 0, err
+	}
+
+	o.stamp(&obj, time.Now(), false)
+	values, err := o.sqlTable.RowValues(R(&obj), false)
+	if err != nil {
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	var id, version int64
+	dest := []any{&id}
+	if len(o.version) > 0 {
+		dest = append(dest, &version)
+	}
+	err = o.writer().QueryRowContext(ctx, query, values...).Scan(dest...)
+	if err != nil {
+		if isDupError(err) {
+			return 0, store.ErrConflicted
+		}
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	o.setPK(&obj, id)
+	o.setVersion(&obj, version)
+	if err = o.runHooks(ctx, after, &obj); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// idOf returns the id of the live row whose col has the value of obj, or 0.
+func (o *SQliteStore[T, R]) idOf(ctx context.Context, col column, obj *T) (int64, error) {
+	vals, err := R(obj).FieldsVals()
+	if err != nil {
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	val, err := col.Encode(vals[slices.IndexFunc(o.columns, func(c column) bool { return c.Name == col.Name })])
+	if err != nil {
+		return 0, fmt.Errorf("%s upsert failed: %w", o.tablename, err)
+	}
+	var id int64
+	query := fmt.Sprintf("SELECT %s from %s%s", o.pk, o.tablename, joinWhere(o.sqlTable.LiveConds(col.Name+" = ?")))
+	err = o.reader().QueryRowContext(ctx, query, val).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("%s upsert lookup failed: %w", o.tablename, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,110 @@
+package sqlitestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestBulk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_bulk.db")
+	ctx := context.Background()
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	fixtureStore, err := NewStore[Fixture](path)
+	if err != nil {
+		t.Fatalf("fail to create fixtureStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tagStore.Close()
+		_ = fixtureStore.Close()
+	})
+
+	ids, err := tagStore.InsertMulti(ctx, []Tag{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 3)
+	tags, err := tagStore.GetMulti(ctx, ids)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Tag{{ID: ids[0], Name: "a"}, {ID: ids[1], Name: "b"}, {ID: ids[2], Name: "c"}}, tags)
+
+	// one failing row rolls back the whole batch
+	_, err = tagStore.InsertMulti(ctx, []Tag{{Name: "d"}, {Name: "a"}})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	tags, err = tagStore.FindWhere(ctx, store.Eq(TagColName, "d"))
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+
+	assert.NoError(t, tagStore.UpdateMulti(ctx, ids[:2], []Tag{{Name: "a2"}, {Name: "b2"}}))
+	err = tagStore.UpdateMulti(ctx, ids, []Tag{{Name: "a3"}, {Name: "a3"}, {Name: "c3"}})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	assert.Error(t, tagStore.UpdateMulti(ctx, ids, []Tag{{Name: "x"}}))
+	tags, err = tagStore.GetMulti(ctx, ids)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Tag{{ID: ids[0], Name: "a2"}, {ID: ids[1], Name: "b2"}, {ID: ids[2], Name: "c"}}, tags)
+
+	// Upsert updates the row with the same value of the unique field
+	upserted, err := tagStore.Upsert(ctx, TagColName, []Tag{{Name: "c"}, {Name: "e"}})
+	assert.NoError(t, err)
+	if assert.Len(t, upserted, 2) {
+		assert.Equal(t, ids[2], upserted[0])
+		assert.NotContains(t, ids, upserted[1])
+	}
+	_, err = tagStore.Upsert(ctx, TagColID, []Tag{{Name: "f"}})
+	assert.ErrorIs(t, err, store.ErrNotUnique)
+	_, err = tagStore.Upsert(ctx, "label", []Tag{{Name: "f"}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	// Upsert keeps created_at, bumps the version and runs the update hooks
+	first, err := fixtureStore.Insert(ctx, Fixture{Venue: "hall a"})
+	assert.NoError(t, err)
+	before, err := fixtureStore.GetOne(ctx, first)
+	assert.NoError(t, err)
+	var updated []Fixture
+	fixtureStore.On(store.AfterUpdate, func(ctx context.Context, obj *Fixture) error {
+		updated = append(updated, *obj)
+		return nil
+	})
+	fixtureStore.On(store.BeforeInsert, func(ctx context.Context, obj *Fixture) error {
+		if obj.Venue == "hall b" {
+			return errors.New("hall b is closed")
+		}
+		return nil
+	})
+	upserted, err = fixtureStore.Upsert(ctx, FixtureColVenue, []Fixture{{Venue: "hall a", CreatedAt: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{first}, upserted)
+	after, err := fixtureStore.GetOne(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, before.CreatedAt, after.CreatedAt)
+	assert.Equal(t, int64(2), after.Version)
+	if assert.Len(t, updated, 1) {
+		assert.Equal(t, first, updated[0].ID)
+		assert.Equal(t, int64(2), updated[0].Version)
+	}
+	_, err = fixtureStore.Upsert(ctx, FixtureColVenue, []Fixture{{Venue: "hall c"}, {Venue: "hall b"}})
+	assert.ErrorContains(t, err, "hall b is closed")
+	fixtures, err := fixtureStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, fixtures, 1)
+
+	// a row in the trash is left alone
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	defer noteStore.Close()
+	trashed, err := noteStore.Insert(ctx, Note{Title: "trashed"})
+	assert.NoError(t, err)
+	assert.NoError(t, noteStore.DeleteMulti(ctx, []int64{trashed}))
+	ids, err = noteStore.Upsert(ctx, NoteColTitle, []Note{{Title: "trashed"}})
+	assert.NoError(t, err)
+	if assert.Len(t, ids, 1) {
+		assert.NotEqual(t, trashed, ids[0])
+	}
+}
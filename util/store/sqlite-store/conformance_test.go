@@ -0,0 +1,49 @@
+package sqlitestore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+	"github.com/yinloo-ola/tt-app/util/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, storetest.Backend{
+		Players: func(t *testing.T) store.Store[storetest.Player, *storetest.Player] {
+			return openConformanceStore[storetest.Player](t)
+		},
+		Notes: func(t *testing.T) store.Store[storetest.Note, *storetest.Note] {
+			return openConformanceStore[storetest.Note](t)
+		},
+		DB: func(t *testing.T) (store.Transactor, store.Store[storetest.Player, *storetest.Player], store.Store[storetest.Note, *storetest.Note]) {
+			db, err := Open(filepath.Join(t.TempDir(), "conformance.db"))
+			if err != nil {
+				t.Fatalf("fail to open db %v", err)
+			}
+			players, err := NewStoreFromDB[storetest.Player](db)
+			if err != nil {
+				t.Fatalf("fail to create store %v", err)
+			}
+			notes, err := NewStoreFromDB[storetest.Note](db)
+			if err != nil {
+				t.Fatalf("fail to create store %v", err)
+			}
+			t.Cleanup(func() {
+				_ = players.Close()
+				_ = notes.Close()
+				_ = db.Close()
+			})
+			return db, players, notes
+		},
+	})
+}
+
+func openConformanceStore[T any, R store.Row[T]](t *testing.T) store.Store[T, R] {
+	s, err := NewStore[T, R](filepath.Join(t.TempDir(), "conformance.db"))
+	if err != nil {
+		t.Fatalf("fail to create store %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,240 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// DB holds the connections to a single SQLite file. Every store opened on the
+// same path shares one DB, so that their writes can run in one transaction.
+//
+// Writes go through a single connection: SQLite allows one writer at a time,
+// and writers queueing for that connection do not contend for the file's
+// write lock. Reads go through a pool of read-only connections, which WAL mode
+// lets run alongside the writer.
+type DB struct {
+	path string
+	// db is the writer connection. Transactions run on it.
+	db *sql.DB
+	// read is the pool of read-only connections.
+	read *sql.DB
+	refs int
+}
+
+// busyRetries is the number of attempts of a statement that keeps failing with
+// SQLITE_BUSY, which busy_timeout leaves to the caller once it runs out.
+const busyRetries = 5
+
+var (
+	dbsMu sync.Mutex
+	dbs   = map[string]*DB{}
+)
+
+// ErrNotAFile is returned for a path that does not name a database file:
+// "", ":memory:", a file: URI or a path with ?query parameters. A DB opens its
+// file through several connections, with parameters of its own.
+var ErrNotAFile = errors.New("not the path of a database file")
+
+// checkPath fails with ErrNotAFile unless path names a database file.
+func checkPath(path string) error {
+	if len(path) == 0 || path == ":memory:" || strings.HasPrefix(path, "file:") || strings.Contains(path, "?") {
+		return fmt.Errorf("%q: %w", path, ErrNotAFile)
+	}
+	return nil
+}
+
+// dsn returns the data source name of the file at path, checked by checkPath,
+// with the query parameters params.
+func dsn(path, params string) string {
+	return path + "?" + params
+}
+
+// Open returns the shared DB for path, opening the file if no store uses it yet.
+// Every call to Open must be paired with a call to Close.
+func Open(path string) (*DB, error) {
+	if err := checkPath(path); err != nil {
+		return nil, err
+	}
+	key, err := filepath.Abs(path)
+	if err != nil {
+		key = path
+	}
+
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	if d, ok := dbs[key]; ok {
+		d.refs++
+		return d, nil
+	}
+
+	db, err := sql.Open("sqlite", dsn(path, "_pragma=journal_mode(wal)&_pragma=synchronous(1)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_txlock=immediate"))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	// the writer creates the file and switches it to WAL before the readers open it
+	if err = db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	read, err := sql.Open("sqlite", dsn(path, "_pragma=busy_timeout(5000)&_pragma=query_only(1)"))
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	readers := max(4, runtime.NumCPU())
+	read.SetMaxOpenConns(readers)
+	read.SetMaxIdleConns(readers)
+	if err = read.Ping(); err != nil {
+		_ = read.Close()
+		_ = db.Close()
+		return nil, err
+	}
+
+	d := &DB{path: key, db: db, read: read, refs: 1}
+	dbs[key] = d
+	return d, nil
+}
+
+func (d *DB) acquire() {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	d.refs++
+}
+
+// Close releases one reference to d. The pool is closed once the last
+// reference is released.
+func (d *DB) Close() error {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+	delete(dbs, d.path)
+	return errors.Join(d.read.Close(), d.db.Close())
+}
+
+// Path returns the absolute path of the database file.
+func (d *DB) Path() string {
+	return d.path
+}
+
+// RunInTx calls fn inside a transaction. Stores opened on d join it with WithTx.
+// The transaction takes the write lock when it begins, so its statements do
+// not fail with SQLITE_BUSY. It also holds the writer connection until it
+// ends: fn must write through stores bound to the transaction, as a write
+// through an unbound store waits for the transaction.
+func (d *DB) RunInTx(ctx context.Context, fn func(tx store.Tx) error) (err error) {
+	var sqlTx *sql.Tx
+	err = retryBusy(ctx, func() error {
+		sqlTx, err = d.db.BeginTx(ctx, nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s begin tx failed: %w", d.path, err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	tx := &Tx{db: d, tx: sqlTx}
+	if err = fn(tx); err != nil {
+		if errRollback := sqlTx.Rollback(); errRollback != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, errRollback)
+		}
+		return err
+	}
+	if err = sqlTx.Commit(); err != nil {
+		return fmt.Errorf("%s commit failed: %w", d.path, err)
+	}
+	for _, onCommit := range tx.onCommit {
+		onCommit()
+	}
+	return nil
+}
+
+// Tx is a transaction opened by DB.RunInTx.
+type Tx struct {
+	db *DB
+	tx *sql.Tx
+	// onCommit holds the functions to call once tx commits, see OnCommit.
+	onCommit []func()
+	mu       sync.Mutex
+}
+
+// OnCommit calls fn after the transaction commits, see store.CommitNotifier.
+func (t *Tx) OnCommit(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onCommit = append(t.onCommit, fn)
+}
+
+func (t *Tx) Database() string {
+	return t.db.path
+}
+
+// retryBusy calls fn until it does not fail with SQLITE_BUSY, at most
+// busyRetries times, waiting longer after each attempt.
+func retryBusy(ctx context.Context, fn func() error) error {
+	wait := 10 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == busyRetries || !isBusyError(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func isBusyError(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY
+}
+
+// busyRetrier runs the statements of the writer connection with retryBusy. The
+// errors of QueryRowContext only show on Scan, so it is not retried.
+type busyRetrier struct {
+	db *sql.DB
+}
+
+func (b busyRetrier) ExecContext(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	err = retryBusy(ctx, func() error {
+		res, err = b.db.ExecContext(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+func (b busyRetrier) QueryContext(ctx context.Context, query string, args ...any) (rows *sql.Rows, err error) {
+	err = retryBusy(ctx, func() error {
+		rows, err = b.db.QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, err
+}
+
+func (b busyRetrier) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return b.db.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,61 @@
+package sqlitestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestEach(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_each.db")
+	ctx := context.Background()
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = noteStore.Close()
+	})
+
+	ids, err := noteStore.InsertMulti(ctx, []Note{{Title: "a"}, {Title: "b"}, {Title: "c"}, {Title: "d"}})
+	assert.NoError(t, err)
+	assert.NoError(t, noteStore.DeleteMulti(ctx, ids[3:]))
+
+	var titles []string
+	collect := func(note Note) error {
+		titles = append(titles, note.Title)
+		return nil
+	}
+	assert.NoError(t, noteStore.Each(ctx, collect))
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, titles)
+	titles = nil
+	assert.NoError(t, noteStore.Each(ctx, collect, store.Gt(NoteColID, ids[0])))
+	assert.ElementsMatch(t, []string{"b", "c"}, titles)
+	assert.ErrorIs(t, noteStore.Each(ctx, collect, store.Eq("body", "x")), store.ErrUnknownField)
+
+	// ErrStop ends the iteration without an error, other errors are returned
+	n := 0
+	assert.NoError(t, noteStore.Each(ctx, func(note Note) error {
+		n++
+		return store.ErrStop
+	}))
+	assert.Equal(t, 1, n)
+	errAbort := errors.New("abort")
+	assert.ErrorIs(t, noteStore.Each(ctx, func(note Note) error { return errAbort }), errAbort)
+
+	// the store is not locked while fn runs, and the rows do not see its writes
+	titles = nil
+	assert.NoError(t, noteStore.Each(ctx, func(note Note) error {
+		titles = append(titles, note.Title)
+		_, err := noteStore.Insert(ctx, Note{Title: note.Title + "2"})
+		return err
+	}))
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, titles)
+	count, err := noteStore.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), count)
+}
@@ -0,0 +1,206 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// The fts columns of a table are indexed by an FTS5 table named after it with
+// ftsSuffix, which reads their text from the table itself. Triggers named
+// after the FTS5 table keep it up to date.
+const ftsSuffix = "_fts"
+
+// ftsSchema returns the statements creating the full-text index of the fts
+// columns of tableName, by name, or nil if it has none. They are written as
+// SQLite stores them in sqlite_master, so that syncFTS can compare them.
+func ftsSchema(tableName string, columns []column) map[string]string {
+	var pk string
+	var names, newVals, oldVals []string
+	for _, col := range columns {
+		if col.IsPK {
+			pk = col.Name
+		}
+		if col.IsFTS {
+			names = append(names, col.Name)
+			newVals = append(newVals, "new."+col.Name)
+			oldVals = append(oldVals, "old."+col.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	fts := tableName + ftsSuffix
+	cols := strings.Join(names, ", ")
+	insert := fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (new.%s, %s);", fts, cols, pk, strings.Join(newVals, ", "))
+	remove := fmt.Sprintf("INSERT INTO %s(%s, rowid, %s) VALUES ('delete', old.%s, %s);", fts, fts, cols, pk, strings.Join(oldVals, ", "))
+	return map[string]string{
+		// remove_diacritics lets "muller" find "Müller"
+		fts: fmt.Sprintf("CREATE VIRTUAL TABLE %s USING fts5(%s, content='%s', content_rowid='%s', tokenize='unicode61 remove_diacritics 2')",
+			fts, cols, tableName, pk),
+		fts + "_ai": fmt.Sprintf("CREATE TRIGGER %s_ai AFTER INSERT ON %s BEGIN %s END", fts, tableName, insert),
+		fts + "_ad": fmt.Sprintf("CREATE TRIGGER %s_ad AFTER DELETE ON %s BEGIN %s END", fts, tableName, remove),
+		fts + "_au": fmt.Sprintf("CREATE TRIGGER %s_au AFTER UPDATE OF %s ON %s BEGIN %s %s END", fts, cols, tableName, remove, insert),
+	}
+}
+
+// syncFTS creates, recreates or drops the full-text index of tableName to
+// match the fts columns. A new index is filled from the rows of the table.
+func syncFTS(ctx context.Context, tx *sql.Tx, tableName string, columns []column) (bool, error) {
+	fts := tableName + ftsSuffix
+	wanted := ftsSchema(tableName, columns)
+	rows, err := tx.QueryContext(ctx, "SELECT name, type, sql FROM sqlite_master WHERE name IN (?, ?, ?, ?)",
+		fts, fts+"_ai", fts+"_ad", fts+"_au")
+	if err != nil {
+		return false, err
+	}
+	existing := map[string]string{}
+	types := map[string]string{}
+	for rows.Next() {
+		var name, typ, stmt string
+		if err = rows.Scan(&name, &typ, &stmt); err != nil {
+			rows.Close()
+			return false, err
+		}
+		existing[name], types[name] = stmt, typ
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	upToDate := len(existing) == len(wanted)
+	for name, stmt := range wanted {
+		upToDate = upToDate && existing[name] == stmt
+	}
+	if upToDate {
+		return false, nil
+	}
+
+	// the triggers go first, as they write to the FTS5 table
+	for name, typ := range types {
+		if typ == "trigger" {
+			if _, err = tx.ExecContext(ctx, "DROP TRIGGER "+name); err != nil {
+				return false, err
+			}
+		}
+	}
+	if _, ok := types[fts]; ok {
+		if _, err = tx.ExecContext(ctx, "DROP TABLE "+fts); err != nil {
+			return false, err
+		}
+	}
+	if len(wanted) == 0 {
+		return true, nil
+	}
+	stmts := []string{wanted[fts], wanted[fts+"_ai"], wanted[fts+"_ad"], wanted[fts+"_au"],
+		fmt.Sprintf("INSERT INTO %s(%s) VALUES ('rebuild')", fts, fts)}
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Search runs query on the full-text index of the fts columns. The words of
+// query are matched as prefixes, so that "umpi" finds "umpire", and ranked
+// with bm25. Soft deleted rows are left out.
+func (o *SQliteStore[T, R]) Search(ctx context.Context, query string, opts store.SearchOptions) ([]store.SearchResult[T], error) {
+	var ftsColumns []string
+	for _, col := range o.columns {
+		if col.IsFTS {
+			ftsColumns = append(ftsColumns, col.Name)
+		}
+	}
+	if len(ftsColumns) == 0 {
+		return nil, store.ErrSearchNotSupported
+	}
+	match := matchQuery(query)
+	if len(match) == 0 {
+		return []store.SearchResult[T]{}, nil
+	}
+	if err := o.sqlTable.CheckFields(opts.Where); err != nil {
+		return nil, err
+	}
+	whereStmt, whereArgs, err := store.Where(opts.Where...)
+	if err != nil {
+		return nil, fmt.Errorf("%s Search: %w", o.tablename, err)
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = store.DefaultSearchLimit
+	}
+	tokens := opts.SnippetTokens
+	if tokens <= 0 {
+		tokens = store.DefaultSnippetTokens
+	}
+
+	// bm25 and snippet only work in the query that has the MATCH, hence the
+	// subquery, whose columns are prefixed to stay clear of those of the table
+	fts := o.tablename + ftsSuffix
+	selects := []string{"rowid AS _fts_rowid", fmt.Sprintf("bm25(%s) AS _fts_rank", fts)}
+	outer := []string{o.columnList(), "_fts_rank"}
+	for i := range ftsColumns {
+		selects = append(selects, fmt.Sprintf("snippet(%s, %d, char(2), char(3), '…', %d) AS _fts_snippet%d", fts, i, tokens, i))
+		outer = append(outer, fmt.Sprintf("_fts_snippet%d", i))
+	}
+	searchQuery := fmt.Sprintf("SELECT %s FROM %s JOIN (SELECT %s FROM %s WHERE %s MATCH ?) ON %s = _fts_rowid%s ORDER BY _fts_rank, %s LIMIT ? OFFSET ?",
+		strings.Join(outer, ", "), o.tablename, strings.Join(selects, ", "), fts, fts, o.pk,
+		joinWhere(o.sqlTable.LiveConds(whereStmt)), o.pk)
+	args := append([]any{match}, whereArgs...)
+	args = append(args, limit, opts.Offset)
+
+	rows, err := o.reader().QueryContext(ctx, searchQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s Search Query error: %w", o.tablename, err)
+	}
+	defer rows.Close()
+	results := make([]store.SearchResult[T], 0, limit)
+	for rows.Next() {
+		var result store.SearchResult[T]
+		snippets := make([]string, len(ftsColumns))
+		extra := []any{&result.Rank}
+		for i := range snippets {
+			extra = append(extra, &snippets[i])
+		}
+		if err = R(&result.Item).ScanRow(o.sqlTable.Scanner(extraScanner{row: rows, extra: extra})); err != nil {
+			return nil, fmt.Errorf("%s Search row.Scan error: %w", o.tablename, o.sqlTable.CorruptRow(&result.Item, err))
+		}
+		result.Snippets = make(map[string]string, len(ftsColumns))
+		for i, name := range ftsColumns {
+			result.Snippets[name] = snippets[i]
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s Search rows error: %w", o.tablename, err)
+	}
+	return results, nil
+}
+
+// matchQuery turns the words of query into an FTS5 query matching rows that
+// have every word as a prefix of one of theirs. Each word is quoted, so that
+// the FTS5 query syntax, e.g. AND or a stray quote, is taken literally.
+func matchQuery(query string) string {
+	words := strings.Fields(query)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// extraScanner scans the columns that follow those of the model into extra.
+type extraScanner struct {
+	row   store.RowScanner
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
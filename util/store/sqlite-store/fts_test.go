@@ -0,0 +1,138 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_search.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	articles, err := NewStoreFromDB[Article](db)
+	if err != nil {
+		t.Fatalf("fail to create articleStore %v", err)
+	}
+	ids, err := articles.InsertMulti(ctx, []Article{
+		{Title: "Umpire duties", Body: "The umpire calls the score after every rally.", Author: "alice"},
+		{Title: "Service rules", Body: "The umpire may fault a service that is hidden.", Author: "bob"},
+		{Title: "Müller wins", Body: "A comeback from two games down.", Author: "alice"},
+	})
+	assert.NoError(t, err)
+
+	// the match in the title ranks higher, as the title is shorter
+	results, err := store.Search(ctx, store.Store[Article, *Article](articles), "umpi", store.SearchOptions{})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, ids[0], results[0].Item.ID)
+		assert.Equal(t, ids[1], results[1].Item.ID)
+		assert.Less(t, results[0].Rank, results[1].Rank)
+		assert.Equal(t, "\x02Umpire\x03 duties", results[0].Snippets[ArticleColTitle])
+		assert.Equal(t, "The \x02umpire\x03 calls the score after every rally.", results[0].Snippets[ArticleColBody])
+	}
+
+	// every word must match, diacritics are ignored, and FTS5 syntax is text
+	results, err = articles.Search(ctx, "umpire service", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	results, err = articles.Search(ctx, "muller", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	results, err = articles.Search(ctx, `umpire" OR "games`, store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	results, err = articles.Search(ctx, "  ", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{Where: []store.Cond{store.Eq(ArticleColAuthor, "bob")}})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, ids[1], results[0].Item.ID)
+	}
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, ids[1], results[0].Item.ID)
+	}
+	_, err = articles.Search(ctx, "umpire", store.SearchOptions{Where: []store.Cond{store.Eq("rank", 1)}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	// the index follows updates and deletes, soft or not
+	assert.NoError(t, articles.Update(ctx, ids[1], Article{ID: ids[1], Title: "Service rules", Body: "A let is replayed.", Author: "bob"}))
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	results, err = articles.Search(ctx, "replayed", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.NoError(t, articles.DeleteMulti(ctx, ids[:1]))
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	trash, err := store.AsSoftDeleter[Article](articles)
+	assert.NoError(t, err)
+	assert.NoError(t, trash.Restore(ctx, ids[:1]))
+	assert.NoError(t, articles.DeleteMulti(ctx, ids[2:]))
+	_, err = trash.Purge(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, int64(0), ftsCount(t, db, "muller"))
+	_ = articles.Close()
+	version := tableVersion(t, db, "article")
+
+	// reopening is a no-op, and a model without fts columns drops the index
+	articles, err = NewStoreFromDB[Article](db)
+	if err != nil {
+		t.Fatalf("fail to reopen articleStore %v", err)
+	}
+	_ = articles.Close()
+	assert.Equal(t, version, tableVersion(t, db, "article"))
+	v2, err := NewStoreFromDB[ArticleV2](db)
+	if err != nil {
+		t.Fatalf("fail to create v2 store %v", err)
+	}
+	_, err = store.Search(ctx, store.Store[ArticleV2, *ArticleV2](v2), "umpire", store.SearchOptions{})
+	assert.ErrorIs(t, err, store.ErrSearchNotSupported)
+	_, err = v2.Insert(ctx, ArticleV2{Title: "Umpire training", Author: "carol"})
+	assert.NoError(t, err)
+	_ = v2.Close()
+	assert.Equal(t, version+1, tableVersion(t, db, "article"))
+	var n int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name LIKE 'article_fts%'").Scan(&n))
+	assert.Equal(t, 0, n)
+
+	// a new index is filled from the rows of the table
+	articles, err = NewStoreFromDB[Article](db)
+	if err != nil {
+		t.Fatalf("fail to reopen articleStore %v", err)
+	}
+	defer articles.Close()
+	results, err = articles.Search(ctx, "umpire", store.SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+}
+
+func ftsCount(t *testing.T, db *DB, query string) int64 {
+	t.Helper()
+	var n int64
+	err := db.db.QueryRow("SELECT count(*) FROM article_fts WHERE article_fts MATCH ?", matchQuery(query)).Scan(&n)
+	if err != nil {
+		t.Fatalf("fail to query article_fts: %v", err)
+	}
+	return n
+}
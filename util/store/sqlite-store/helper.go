@@ -3,22 +3,47 @@ package sqlitestore
 import (
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/yinloo-ola/tt-app/util/store"
 )
 
 type column struct {
-	Name       string
-	Index      int
-	IsPK       bool
-	IsIdxAsc   bool
-	IsIdxDesc  bool
-	IsIdxUniq  bool
+	store.SQLColumn
+	IsIdxAsc  bool
+	IsIdxDesc bool
+	IsIdxUniq bool
+	// IsFTS marks a text column indexed for full-text search, see Search.
+	IsFTS      bool
+	FK         foreignKey
 	SqLiteType sqliteType
+	// Default is the SQL literal used to fill the column in existing rows when
+	// a migration adds it: the zero value of the Go field.
+	Default string
 }
 type sqliteType string
 
+// foreignKey is declared by the fk=table.column tag, and its action by
+// on_delete=cascade, restrict, set_null or set_default. The zero value means no
+// foreign key.
+type foreignKey struct {
+	Table    string
+	Column   string
+	OnDelete string
+}
+
+// onDeleteActions maps the on_delete tag values to their SQL.
+var onDeleteActions = map[string]string{
+	"cascade":     "CASCADE",
+	"restrict":    "RESTRICT",
+	"set_null":    "SET NULL",
+	"set_default": "SET DEFAULT",
+	"no_action":   "NO ACTION",
+}
+
 const (
 	sqliteTypeText sqliteType = "TEXT"
 	sqliteTypeInt  sqliteType = "INTEGER"
@@ -29,22 +54,64 @@ func generateCreateTableSQL(tableName string, columns []column) string {
 	return fmt.Sprintf("CREATE TABLE if not exists %s (%s)", tableName, generateCreateColumnSQL(columns))
 }
 
-func generateCreateIdxSQL(tableName string, columns []column) string {
-	queries := make([]string, 0, len(columns))
+// index is a single-column index declared by the idx_asc, idx_desc and uniq tags.
+type index struct {
+	Name   string
+	Column string
+	Unique bool
+	Desc   bool
+	// Where is the condition of a partial index, e.g. deleted_at = 0, or empty.
+	Where string
+}
+
+// indexPrefix marks the indexes managed by the store. Migrations drop indexes
+// with this prefix that no longer match a tag, and leave any other index alone.
+const indexPrefix = "idx_"
+
+// getIndexes returns the indexes declared by the tags of columns. The unique
+// indexes of a table with a soft_delete column only cover the live rows, so
+// that a row in the trash does not keep its value from being used again.
+func getIndexes(tableName string, columns []column) []index {
+	liveRows := ""
+	for _, col := range columns {
+		if col.IsSoftDelete {
+			liveRows = col.Name + " = 0"
+		}
+	}
+	indexes := make([]index, 0, len(columns))
 	for _, col := range columns {
-		uniq := ""
-		if col.IsIdxUniq {
-			uniq = "UNIQUE "
+		if !col.IsIdxAsc && !col.IsIdxDesc && !col.IsIdxUniq {
+			continue
+		}
+		idx := index{
+			// index names are global to the database, so they include the table name
+			Name:   fmt.Sprintf("%s%s_%s", indexPrefix, tableName, col.Name),
+			Column: col.Name,
+			Unique: col.IsIdxUniq,
+			Desc:   col.IsIdxDesc,
 		}
-		if col.IsIdxAsc {
-			s := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS idx_%s ON %s (%s asc);", uniq, col.Name, tableName, col.Name)
-			queries = append(queries, s)
-		} else if col.IsIdxDesc {
-			s := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS idx_%s ON %s (%s desc);", uniq, col.Name, tableName, col.Name)
-			queries = append(queries, s)
+		if idx.Unique {
+			idx.Where = liveRows
 		}
+		indexes = append(indexes, idx)
 	}
-	return strings.Join(queries, " ")
+	return indexes
+}
+
+func generateCreateIdxSQL(tableName string, idx index) string {
+	uniq := ""
+	if idx.Unique {
+		uniq = "UNIQUE "
+	}
+	dir := "asc"
+	if idx.Desc {
+		dir = "desc"
+	}
+	stmt := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %s ON %s (%s %s)", uniq, idx.Name, tableName, idx.Column, dir)
+	if len(idx.Where) > 0 {
+		stmt += " WHERE " + idx.Where
+	}
+	return stmt
 }
 
 func generateCreateColumnSQL(columns []column) string {
@@ -53,6 +120,14 @@ func generateCreateColumnSQL(columns []column) string {
 		s := fmt.Sprintf("%s %s NOT NULL", col.Name, col.SqLiteType)
 		if col.IsPK {
 			s += " PRIMARY KEY"
+		} else {
+			s += " DEFAULT " + col.Default
+		}
+		if len(col.FK.Table) > 0 {
+			s += fmt.Sprintf(" REFERENCES %s(%s)", col.FK.Table, col.FK.Column)
+			if len(col.FK.OnDelete) > 0 {
+				s += " ON DELETE " + col.FK.OnDelete
+			}
 		}
 		colStrings = append(colStrings, s)
 	}
@@ -67,6 +142,9 @@ func getColumns(typ reflect.Type) []column {
 		tag := field.Tag.Get("db")
 
 		tagName, _, _ := strings.Cut(tag, ",")
+		if tagName == "-" {
+			continue
+		}
 
 		name := field.Name
 		if len(tagName) > 0 {
@@ -91,21 +169,76 @@ func getColumns(typ reflect.Type) []column {
 			isUniqIdx = true
 		}
 
+		opts := strings.Split(tag, ",")[1:]
+		isJSON := slices.Contains(opts, "json")
+		if !isJSON && isJSONField(field.Type) {
+			panic(name + " column of type " + field.Type.String() + " must be tagged json")
+		}
+		fk := getForeignKey(opts)
 		sqlType := getSQLiteType(field.Type)
+		if isJSON {
+			sqlType = sqliteTypeText
+		}
+		isSoftDelete := slices.Contains(opts, "soft_delete")
+		isCreatedAt := slices.Contains(opts, "created_at")
+		isUpdatedAt := slices.Contains(opts, "updated_at")
+		isVersion := slices.Contains(opts, "version")
+		isFTS := slices.Contains(opts, "fts")
+		if isFTS && (field.Type.Kind() != reflect.String || isJSON) {
+			panic("fts column must be a string")
+		}
+		// the store writes these columns itself
+		for _, opt := range []string{"soft_delete", "created_at", "updated_at", "version"} {
+			if slices.Contains(opts, opt) && (sqlType != sqliteTypeInt || field.Type.Kind() == reflect.Bool) {
+				panic(opt + " column must be an integer")
+			}
+		}
 
 		columns = append(columns, column{
-			Name:       name,
-			Index:      i,
-			IsPK:       isPK,
+			SQLColumn: store.SQLColumn{
+				Name:         name,
+				Index:        i,
+				IsPK:         isPK,
+				IsJSON:       isJSON,
+				IsSoftDelete: isSoftDelete,
+				IsCreatedAt:  isCreatedAt,
+				IsUpdatedAt:  isUpdatedAt,
+				IsVersion:    isVersion,
+			},
 			IsIdxAsc:   isIdxAsc,
 			IsIdxDesc:  isIdxDesc,
 			IsIdxUniq:  isUniqIdx,
+			IsFTS:      isFTS,
+			FK:         fk,
 			SqLiteType: sqlType,
+			Default:    getDefault(sqlType, isJSON),
 		})
 	}
 	return columns
 }
 
+func getForeignKey(opts []string) foreignKey {
+	var fk foreignKey
+	for _, opt := range opts {
+		if ref, ok := strings.CutPrefix(opt, "fk="); ok {
+			fk.Table, fk.Column, _ = strings.Cut(ref, ".")
+			if len(fk.Column) == 0 {
+				fk.Column = "id"
+			}
+		} else if action, ok := strings.CutPrefix(opt, "on_delete="); ok {
+			sqlAction, ok := onDeleteActions[action]
+			if !ok {
+				panic("unsupported on_delete action " + action)
+			}
+			fk.OnDelete = sqlAction
+		}
+	}
+	if len(fk.Table) == 0 && len(fk.OnDelete) > 0 {
+		panic("on_delete without fk")
+	}
+	return fk
+}
+
 func getSQLiteType(field reflect.Type) sqliteType {
 	switch field.Kind() {
 	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint8, reflect.Int16, reflect.Int32, reflect.Int8:
@@ -125,13 +258,37 @@ func getSQLiteType(field reflect.Type) sqliteType {
 		return sqliteTypeText
 	case reflect.Array:
 		return sqliteTypeText
-	case reflect.Slice:
+	case reflect.Slice, reflect.Map:
 		return sqliteTypeText
 	default:
 		panic("unsupported type")
 	}
 }
 
+func getDefault(sqlType sqliteType, isJSON bool) string {
+	switch {
+	case isJSON:
+		return "'null'"
+	case sqlType == sqliteTypeInt || sqlType == sqliteTypeReal:
+		return "0"
+	default:
+		return "''"
+	}
+}
+
+// isJSONField reports whether a field of type typ can only be stored as JSON,
+// which the field must then opt into with the json tag. []byte is stored as is.
+func isJSONField(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Struct, reflect.Pointer, reflect.Array, reflect.Map:
+		return true
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Uint8
+	default:
+		return false
+	}
+}
+
 func isPrimitive(kind reflect.Kind) bool {
 	switch kind {
 	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
@@ -174,3 +331,20 @@ func InArgs[T Column](tt []T) (string, []any) {
 	}
 	return strings.Join(qnMarks, ","), args
 }
+
+// sqlColumns returns the parts of columns shared by the SQL stores.
+func sqlColumns(columns []column) []store.SQLColumn {
+	shared := make([]store.SQLColumn, 0, len(columns))
+	for _, col := range columns {
+		shared = append(shared, col.SQLColumn)
+	}
+	return shared
+}
+
+// joinWhere ANDs conds into a WHERE clause, or returns "" if there are none.
+func joinWhere(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(conds, " and ")
+}
@@ -0,0 +1,81 @@
+package sqlitestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// On registers hook to run at event, after the hooks of the model. Hooks
+// registered on a store also run in its views returned by WithTx. On is not
+// safe to call while the store is in use.
+func (o *SQliteStore[T, R]) On(event store.Event, hook store.Hook[T]) {
+	o.hooks[event] = append(o.hooks[event], hook)
+}
+
+// hasHooks reports whether any hook, of the model or of the store, runs at one
+// of events.
+func (o *SQliteStore[T, R]) hasHooks(events ...store.Event) bool {
+	var obj T
+	for _, event := range events {
+		if len(o.hooks[event]) > 0 || store.ModelHook(event, R(&obj)) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// runHooks runs the hooks of event on obj, stopping at the first error. The
+// hooks get the transaction of the change in ctx, see store.TxFromContext.
+func (o *SQliteStore[T, R]) runHooks(ctx context.Context, event store.Event, obj *T) error {
+	if o.tx != nil {
+		ctx = store.ContextWithTx(ctx, o.tx)
+	}
+	if hook := store.ModelHook(event, R(obj)); hook != nil {
+		if err := hook(ctx); err != nil {
+			return fmt.Errorf("%s %s hook failed: %w", o.tablename, event, err)
+		}
+	}
+	for _, hook := range o.hooks[event] {
+		if err := hook(ctx, obj); err != nil {
+			return fmt.Errorf("%s %s hook failed: %w", o.tablename, event, err)
+		}
+	}
+	return nil
+}
+
+// hookTx calls fn with a view of the store bound to a new transaction if any
+// hook runs at one of events, so that an error of a hook rolls back the
+// change. Otherwise, or if the store is already bound to a transaction, fn is
+// called with the store itself.
+func (o *SQliteStore[T, R]) hookTx(ctx context.Context, fn func(s *SQliteStore[T, R]) error, events ...store.Event) error {
+	if !o.hasHooks(events...) {
+		return fn(o)
+	}
+	return o.inTx(ctx, fn)
+}
+
+// inTx calls fn with a view of the store bound to a new transaction, or with
+// the store itself if it is already bound to one.
+func (o *SQliteStore[T, R]) inTx(ctx context.Context, fn func(s *SQliteStore[T, R]) error) error {
+	if o.tx != nil {
+		return fn(o)
+	}
+	return o.db.RunInTx(ctx, func(tx store.Tx) error {
+		view := *o
+		view.tx = tx.(*Tx)
+		return fn(&view)
+	})
+}
+
+// setPK sets the primary key field of obj to id.
+func (o *SQliteStore[T, R]) setPK(obj *T, id int64) {
+	for _, col := range o.columns {
+		if !col.IsPK {
+			continue
+		}
+		setInt(reflect.ValueOf(obj).Elem().Field(col.Index), id)
+	}
+}
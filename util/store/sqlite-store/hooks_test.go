@@ -0,0 +1,101 @@
+package sqlitestore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestHooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_hooks.db")
+	ctx := context.Background()
+	postStore, err := NewStore[Post](path)
+	if err != nil {
+		t.Fatalf("fail to create postStore %v", err)
+	}
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = postStore.Close()
+		_ = tagStore.Close()
+	})
+
+	// model hooks change the row before it is written, and abort on error
+	id, err := postStore.Insert(ctx, Post{Title: "Hello World"})
+	assert.NoError(t, err)
+	got, err := postStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, Post{ID: id, Title: "Hello World", Slug: "hello-world"}, got)
+	_, err = postStore.Insert(ctx, Post{})
+	assert.ErrorIs(t, err, errNoTitle)
+	assert.ErrorIs(t, postStore.Update(ctx, id, Post{}), errNoTitle)
+
+	// store hooks run after the model hooks, with the primary key set
+	var events []string
+	record := func(event store.Event) store.Hook[Post] {
+		return func(ctx context.Context, obj *Post) error {
+			events = append(events, string(event)+" "+obj.Slug)
+			if event != store.BeforeInsert {
+				assert.NotZero(t, obj.ID)
+			}
+			return nil
+		}
+	}
+	for _, event := range []store.Event{
+		store.BeforeInsert, store.AfterInsert, store.BeforeUpdate,
+		store.AfterUpdate, store.BeforeDelete, store.AfterDelete,
+	} {
+		assert.NoError(t, store.On[Post](postStore, event, record(event)))
+	}
+	second, err := postStore.Insert(ctx, Post{Title: "Second"})
+	assert.NoError(t, err)
+	assert.NoError(t, postStore.Update(ctx, second, Post{Title: "Second Post"}))
+	assert.NoError(t, postStore.DeleteMulti(ctx, []int64{second}))
+	assert.Equal(t, []string{
+		"before_insert second", "after_insert second",
+		"before_update second-post", "after_update second-post",
+		"before_delete second-post", "after_delete second-post",
+	}, events)
+
+	// an error of an after hook rolls the change back
+	errAbort := errors.New("abort")
+	postStore.On(store.AfterUpdate, func(ctx context.Context, obj *Post) error {
+		return errAbort
+	})
+	err = postStore.Update(ctx, id, Post{Title: "Renamed"})
+	assert.ErrorIs(t, err, errAbort)
+	assert.EqualError(t, err, "post after_update hook failed: abort")
+	got, err = postStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello-world", got.Slug)
+
+	// hooks join the transaction of the change through the context
+	postStore.On(store.AfterDelete, func(ctx context.Context, obj *Post) error {
+		tx := store.TxFromContext(ctx)
+		if tx == nil {
+			return errors.New("no tx in context")
+		}
+		tags, err := store.WithTx[Tag](tagStore, tx)
+		if err != nil {
+			return err
+		}
+		_, err = tags.Insert(ctx, Tag{Name: obj.Slug})
+		return err
+	})
+	assert.NoError(t, postStore.DeleteMulti(ctx, []int64{id}))
+	tags, err := tagStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, tags, 1) {
+		assert.Equal(t, "hello-world", tags[0].Name)
+	}
+
+	// stores without hooks need no transaction
+	_, err = tagStore.Insert(ctx, Tag{Name: "plain"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,489 @@
+package sqlitestore
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metadata tables record the schema of every store's table and the
+// explicit migrations applied to the database.
+const (
+	schemaTablesTable     = "_schema_tables"
+	schemaMigrationsTable = "_schema_migrations"
+)
+
+// Migration is an explicit, versioned schema change for what migrateTable
+// cannot infer from the struct tags, such as moving data between tables.
+// Each migration runs once, in its own transaction, in Version order.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(ctx context.Context, tx *sql.Tx) error
+}
+
+var ErrInvalidMigration error = errors.New("invalid migration")
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// MigrationsFS reads the migration files in dir of fsys. Files are named
+// <version>_<name>.sql, e.g. 0002_add_role_index.sql, and may contain several
+// statements. Other files are ignored.
+func MigrationsFS(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidMigration, entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		query := string(content)
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    match[2],
+			Up: func(ctx context.Context, tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, query)
+				return err
+			},
+		})
+	}
+	return migrations, nil
+}
+
+// Migrate applies the migrations that have not been applied to d yet and
+// records them in the _schema_migrations table.
+func (d *DB) Migrate(ctx context.Context, migrations []Migration) error {
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return fmt.Errorf("%w: duplicate version %d", ErrInvalidMigration, migrations[i].Version)
+		}
+	}
+
+	_, err := d.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)",
+		schemaMigrationsTable))
+	if err != nil {
+		return fmt.Errorf("%s create %s failed: %w", d.path, schemaMigrationsTable, err)
+	}
+
+	for _, m := range migrations {
+		err = d.runInConnTx(ctx, func(tx *sql.Tx) error {
+			var applied int
+			err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) from %s where version=?", schemaMigrationsTable), m.Version).Scan(&applied)
+			if err != nil || applied > 0 {
+				return err
+			}
+			if err = m.Up(ctx, tx); err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES (?, ?, ?)", schemaMigrationsTable),
+				m.Version, m.Name, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s migration %d_%s failed: %w", d.path, m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// runInConnTx calls fn inside a transaction on a dedicated connection with
+// foreign key enforcement switched off, as SQLite requires for table rebuilds.
+// Foreign keys are checked once fn returns, before committing.
+func (d *DB) runInConnTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var foreignKeys int
+	if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if foreignKeys > 0 {
+		if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = conn.ExecContext(context.Background(), "PRAGMA foreign_keys=ON")
+		}()
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if foreignKeys > 0 {
+		if err = checkForeignKeys(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err = rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references missing %s", table, rowid.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// tableColumn is a column of an existing table as reported by PRAGMA table_info.
+type tableColumn struct {
+	Name    string
+	Type    string
+	NotNull bool
+	PK      bool
+}
+
+func getTableColumns(ctx context.Context, tx *sql.Tx, tableName string) ([]tableColumn, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name, type, \"notnull\", pk FROM pragma_table_info(?)", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []tableColumn
+	for rows.Next() {
+		var col tableColumn
+		var pk int
+		if err = rows.Scan(&col.Name, &col.Type, &col.NotNull, &pk); err != nil {
+			return nil, err
+		}
+		col.PK = pk > 0
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+func getTableIndexes(ctx context.Context, tx *sql.Tx, tableName string) ([]index, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT il.name, il."unique", ii.name, ii.desc, il.partial,
+			coalesce((SELECT sql FROM sqlite_master WHERE type = 'index' AND name = il.name), '')
+		FROM pragma_index_list(?) il, pragma_index_xinfo(il.name) ii
+		WHERE il.origin = 'c' AND ii.key = 1
+		ORDER BY il.name, ii.seqno`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []index
+	for rows.Next() {
+		var idx index
+		var column sql.NullString
+		var partial bool
+		var stmt string
+		if err = rows.Scan(&idx.Name, &idx.Unique, &column, &idx.Desc, &partial, &stmt); err != nil {
+			return nil, err
+		}
+		if partial {
+			// the condition as written by generateCreateIdxSQL
+			_, idx.Where, _ = strings.Cut(stmt, " WHERE ")
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Name == idx.Name {
+			// a multi-column index is never one of ours
+			indexes[n-1].Column = ""
+			continue
+		}
+		idx.Column = column.String
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+func getTableForeignKeys(ctx context.Context, tx *sql.Tx, tableName string) ([]foreignKeyColumn, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT "from", "table", "to", on_delete FROM pragma_foreign_key_list(?)`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fks []foreignKeyColumn
+	for rows.Next() {
+		var fk foreignKeyColumn
+		var to sql.NullString
+		if err = rows.Scan(&fk.Column, &fk.FK.Table, &to, &fk.FK.OnDelete); err != nil {
+			return nil, err
+		}
+		fk.FK.Column = to.String
+		fks = append(fks, fk)
+	}
+	return fks, rows.Err()
+}
+
+// foreignKeyColumn is a foreign key of an existing table.
+type foreignKeyColumn struct {
+	Column string
+	FK     foreignKey
+}
+
+// foreignKeysMatch reports whether the existing foreign keys are the ones
+// declared by the fk tags of columns.
+func foreignKeysMatch(existing []foreignKeyColumn, columns []column) bool {
+	var wanted []foreignKeyColumn
+	for _, col := range columns {
+		if len(col.FK.Table) == 0 {
+			continue
+		}
+		fk := col.FK
+		if len(fk.OnDelete) == 0 {
+			fk.OnDelete = "NO ACTION"
+		}
+		wanted = append(wanted, foreignKeyColumn{Column: col.Name, FK: fk})
+	}
+	if len(wanted) != len(existing) {
+		return false
+	}
+	for _, fk := range existing {
+		if !slices.ContainsFunc(wanted, func(w foreignKeyColumn) bool {
+			return w.Column == fk.Column && strings.EqualFold(w.FK.Table, fk.FK.Table) &&
+				w.FK.Column == fk.FK.Column && w.FK.OnDelete == fk.FK.OnDelete
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// migrateTable brings tableName in line with columns. It creates a missing
+// table, adds new columns with their zero value, and rebuilds the table when a
+// column is dropped or changes type, nullability, primary key or foreign key. Indexes with
+// the idx_ prefix are created, recreated or dropped to match the tags, as is
+// the full-text index of the fts columns. Every change bumps the table's
+// version in _schema_tables.
+func (d *DB) migrateTable(ctx context.Context, tableName string, columns []column) error {
+	return d.runInConnTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s (name TEXT NOT NULL PRIMARY KEY, version INTEGER NOT NULL, schema TEXT NOT NULL, migrated_at TEXT NOT NULL)",
+			schemaTablesTable))
+		if err != nil {
+			return err
+		}
+
+		existing, err := getTableColumns(ctx, tx, tableName)
+		if err != nil {
+			return err
+		}
+		changed := false
+		if len(existing) == 0 {
+			if _, err = tx.ExecContext(ctx, generateCreateTableSQL(tableName, columns)); err != nil {
+				return err
+			}
+			changed = true
+		} else {
+			adds, rebuild := diffColumns(existing, columns)
+			if !rebuild {
+				fks, err := getTableForeignKeys(ctx, tx, tableName)
+				if err != nil {
+					return err
+				}
+				rebuild = !foreignKeysMatch(fks, columns)
+			}
+			switch {
+			case rebuild:
+				if err = rebuildTable(ctx, tx, tableName, columns, existing); err != nil {
+					return fmt.Errorf("rebuild %s failed: %w", tableName, err)
+				}
+				changed = true
+			case len(adds) > 0:
+				for _, col := range adds {
+					_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", tableName, generateCreateColumnSQL([]column{col})))
+					if err != nil {
+						return fmt.Errorf("add column %s.%s failed: %w", tableName, col.Name, err)
+					}
+				}
+				changed = true
+			}
+			if err = textJSONColumns(ctx, tx, tableName, columns); err != nil {
+				return err
+			}
+		}
+
+		indexesChanged, err := syncIndexes(ctx, tx, tableName, getIndexes(tableName, columns))
+		if err != nil {
+			return err
+		}
+		ftsChanged, err := syncFTS(ctx, tx, tableName, columns)
+		if err != nil {
+			return fmt.Errorf("full-text index of %s failed: %w", tableName, err)
+		}
+		if !changed && !indexesChanged && !ftsChanged {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (name, version, schema, migrated_at) VALUES (?, 1, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET version=version+1, schema=excluded.schema, migrated_at=excluded.migrated_at`, schemaTablesTable),
+			tableName, generateCreateTableSQL(tableName, columns), time.Now().UTC().Format(time.RFC3339))
+		return err
+	})
+}
+
+// textJSONColumns converts JSON stored as blobs, as models that encoded their
+// own JSON columns used to do, into text that the JSON1 functions accept.
+func textJSONColumns(ctx context.Context, tx *sql.Tx, tableName string, columns []column) error {
+	for _, col := range columns {
+		if !col.IsJSON {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = CAST(%s AS TEXT) WHERE typeof(%s) = 'blob'",
+			tableName, col.Name, col.Name, col.Name))
+		if err != nil {
+			return fmt.Errorf("convert %s.%s to text failed: %w", tableName, col.Name, err)
+		}
+	}
+	return nil
+}
+
+// diffColumns returns the columns to add to the existing table, or rebuild if
+// the table cannot be migrated with ALTER TABLE ADD COLUMN.
+func diffColumns(existing []tableColumn, columns []column) ([]column, bool) {
+	byName := make(map[string]tableColumn, len(existing))
+	for _, col := range existing {
+		byName[col.Name] = col
+	}
+	var adds []column
+	for _, col := range columns {
+		old, ok := byName[col.Name]
+		if !ok {
+			// SQLite cannot add a primary key, nor a foreign key with a non-null default
+			if col.IsPK || len(col.FK.Table) > 0 {
+				return nil, true
+			}
+			adds = append(adds, col)
+			continue
+		}
+		delete(byName, col.Name)
+		if !strings.EqualFold(old.Type, string(col.SqLiteType)) || !old.NotNull || old.PK != col.IsPK {
+			return nil, true
+		}
+	}
+	// columns left in byName were removed from the struct
+	return adds, len(byName) > 0
+}
+
+// rebuildTable follows https://www.sqlite.org/lang_altertable.html#otheralter:
+// copy the rows into a table with the new schema, drop the old one and rename
+// the new one in its place. Columns absent from the old table take their default.
+// Indexes not managed by the tags, e.g. created by a Migration, are recreated;
+// the managed ones are left to syncIndexes.
+func rebuildTable(ctx context.Context, tx *sql.Tx, tableName string, columns []column, existing []tableColumn) error {
+	tmpName := "_migrate_" + tableName
+	if _, err := tx.ExecContext(ctx, generateCreateTableSQL(tmpName, columns)); err != nil {
+		return err
+	}
+	indexSQLs, err := getUnmanagedIndexSQL(ctx, tx, tableName)
+	if err != nil {
+		return err
+	}
+	common := make([]string, 0, len(columns))
+	for _, col := range columns {
+		if slices.ContainsFunc(existing, func(old tableColumn) bool { return old.Name == col.Name }) {
+			common = append(common, col.Name)
+		}
+	}
+	colList := strings.Join(common, ", ")
+	stmts := []string{
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", tmpName, colList, colList, tableName),
+		fmt.Sprintf("DROP TABLE %s", tableName),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tmpName, tableName),
+	}
+	stmts = append(stmts, indexSQLs...)
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getUnmanagedIndexSQL(ctx context.Context, tx *sql.Tx, tableName string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name, sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stmts []string
+	for rows.Next() {
+		var name, stmt string
+		if err = rows.Scan(&name, &stmt); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(name, indexPrefix) {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts, rows.Err()
+}
+
+// syncIndexes creates the wanted indexes and drops managed indexes that are
+// no longer wanted or whose definition changed.
+func syncIndexes(ctx context.Context, tx *sql.Tx, tableName string, wanted []index) (bool, error) {
+	existing, err := getTableIndexes(ctx, tx, tableName)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, idx := range existing {
+		if !strings.HasPrefix(idx.Name, indexPrefix) || slices.Contains(wanted, idx) {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, "DROP INDEX "+idx.Name); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	for _, idx := range wanted {
+		if slices.Contains(existing, idx) {
+			continue
+		}
+		if _, err = tx.ExecContext(ctx, generateCreateIdxSQL(tableName, idx)); err != nil {
+			return false, fmt.Errorf("create index %s failed: %w", idx.Name, err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,314 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestMigrateTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	v1, err := NewStoreFromDB[PlayerV1](db)
+	if err != nil {
+		t.Fatalf("fail to create v1 store %v", err)
+	}
+	_, err = v1.Insert(ctx, PlayerV1{Name: "ma long", Age: 35})
+	assert.NoError(t, err)
+	_, err = v1.Insert(ctx, PlayerV1{Name: "fan zhendong", Age: 26})
+	assert.NoError(t, err)
+	_ = v1.Close()
+	assert.Equal(t, 1, tableVersion(t, db, "player"))
+
+	// adding a column keeps the rows and fills the column with its zero value
+	v2, err := NewStoreFromDB[PlayerV2](db)
+	if err != nil {
+		t.Fatalf("fail to create v2 store %v", err)
+	}
+	players2, err := v2.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlayerV2{{1, "ma long", 35, ""}, {2, "fan zhendong", 26, ""}}, players2)
+	assert.NoError(t, v2.Update(ctx, 1, PlayerV2{ID: 1, Name: "ma long", Age: 35, Club: "bayi"}))
+	_, err = v2.Insert(ctx, PlayerV2{Name: "ma long"})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	_ = v2.Close()
+	assert.Equal(t, 2, tableVersion(t, db, "player"))
+	assert.Equal(t, []string{"idx_player_club", "idx_player_name"}, indexNames(t, db, "player"))
+
+	// reopening an unchanged model is a no-op
+	v2, err = NewStoreFromDB[PlayerV2](db)
+	if err != nil {
+		t.Fatalf("fail to reopen v2 store %v", err)
+	}
+	_ = v2.Close()
+	assert.Equal(t, 2, tableVersion(t, db, "player"))
+
+	// dropping a column rebuilds the table, and the name index loses uniq
+	v3, err := NewStoreFromDB[PlayerV3](db)
+	if err != nil {
+		t.Fatalf("fail to create v3 store %v", err)
+	}
+	players3, err := v3.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []PlayerV3{{1, "ma long", "bayi", 0}, {2, "fan zhendong", "", 0}}, players3)
+	_, err = v3.Insert(ctx, PlayerV3{Name: "ma long", Rating: 3000})
+	assert.NoError(t, err)
+	_ = v3.Close()
+	assert.Equal(t, 3, tableVersion(t, db, "player"))
+	assert.Equal(t, []string{"idx_player_club", "idx_player_name"}, indexNames(t, db, "player"))
+
+	var unique, desc bool
+	err = db.db.QueryRow(`SELECT il."unique", ii.desc FROM pragma_index_list('player') il, pragma_index_xinfo(il.name) ii
+		WHERE il.name = 'idx_player_name' AND ii.key = 1`).Scan(&unique, &desc)
+	assert.NoError(t, err)
+	assert.False(t, unique)
+	assert.True(t, desc)
+}
+
+func TestMigrateTable_LegacyIndexNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate_legacy.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// before migrations, index names were not prefixed by their table, so the
+	// second table with a "name" column silently got no index at all
+	_, err = db.db.Exec(`CREATE TABLE tag (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL);
+		CREATE UNIQUE INDEX idx_name ON tag (name asc);
+		CREATE INDEX by_hand ON tag (id, name);`)
+	if err != nil {
+		t.Fatalf("fail to create legacy table %v", err)
+	}
+	tagStore, err := NewStoreFromDB[Tag](db)
+	if err != nil {
+		t.Fatalf("fail to create tag store %v", err)
+	}
+	_ = tagStore.Close()
+	assert.Equal(t, []string{"by_hand", "idx_tag_name"}, indexNames(t, db, "tag"))
+}
+
+func TestMigrateTable_PartialUniqueIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate_partial.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// the unique indexes of soft deleted tables used to cover the trash too
+	_, err = db.db.ExecContext(ctx, `CREATE TABLE note (id INTEGER NOT NULL PRIMARY KEY, title TEXT NOT NULL DEFAULT '', deleted_at INTEGER NOT NULL DEFAULT 0);
+		CREATE UNIQUE INDEX idx_note_title ON note (title asc);
+		INSERT INTO note (title, deleted_at) VALUES ('first', 1700000000);`)
+	if err != nil {
+		t.Fatalf("fail to create legacy table %v", err)
+	}
+	noteStore, err := NewStoreFromDB[Note](db)
+	if err != nil {
+		t.Fatalf("fail to create note store %v", err)
+	}
+	defer noteStore.Close()
+
+	var stmt string
+	assert.NoError(t, db.db.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE name = 'idx_note_title'").Scan(&stmt))
+	assert.Equal(t, "CREATE UNIQUE INDEX idx_note_title ON note (title asc) WHERE deleted_at = 0", stmt)
+	_, err = noteStore.Insert(ctx, Note{Title: "first"})
+	assert.NoError(t, err)
+	_, err = noteStore.Insert(ctx, Note{Title: "first"})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+
+	// the partial index matches the tags, so the next migration keeps it
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("fail to begin tx %v", err)
+	}
+	defer tx.Rollback()
+	changed, err := syncIndexes(ctx, tx, "note", getIndexes("note", noteStore.columns))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestMigrateTable_JSONBlobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate_blobs.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// models used to encode their own JSON columns and stored them as blobs
+	_, err = db.db.ExecContext(ctx, "CREATE TABLE role (name TEXT NOT NULL, permissions TEXT NOT NULL, id INTEGER NOT NULL PRIMARY KEY)")
+	assert.NoError(t, err)
+	_, err = db.db.ExecContext(ctx, "INSERT INTO role (name, permissions) VALUES ('admin', CAST('[7]' AS BLOB))")
+	assert.NoError(t, err)
+
+	roleStore, err := NewStoreFromDB[Role](db)
+	assert.NoError(t, err)
+	defer roleStore.Close()
+
+	var typ string
+	assert.NoError(t, db.db.QueryRowContext(ctx, "SELECT typeof(permissions) FROM role").Scan(&typ))
+	assert.Equal(t, "text", typ)
+	roles, err := roleStore.FindWhere(ctx, store.JSONContains(RoleColPermissions, 7))
+	assert.NoError(t, err)
+	if assert.Len(t, roles, 1) {
+		assert.Equal(t, []int64{7}, roles[0].Permissions)
+	}
+}
+
+func TestMigrateTable_ForeignKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate_fk.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	// a member table from before the fk tag, with an index of its own
+	_, err = db.db.ExecContext(ctx, `CREATE TABLE member (id INTEGER NOT NULL PRIMARY KEY, team_id INTEGER NOT NULL DEFAULT 0, name TEXT NOT NULL DEFAULT '');
+		CREATE UNIQUE INDEX uniq_member_team_name ON member (team_id, name);
+		INSERT INTO member (team_id, name) VALUES (1, 'ma long')`)
+	assert.NoError(t, err)
+
+	teamStore, err := NewStoreFromDB[Team](db)
+	assert.NoError(t, err)
+	defer teamStore.Close()
+	teamID, err := teamStore.Insert(ctx, Team{Name: "bayi"})
+	assert.NoError(t, err)
+	memberStore, err := NewStoreFromDB[Member](db)
+	assert.NoError(t, err)
+	defer memberStore.Close()
+
+	// the fk tag rebuilds the table, keeping its rows and its unmanaged index
+	var onDelete string
+	assert.NoError(t, db.db.QueryRowContext(ctx, "SELECT on_delete FROM pragma_foreign_key_list('member') WHERE \"table\" = 'team'").Scan(&onDelete))
+	assert.Equal(t, "CASCADE", onDelete)
+	assert.Equal(t, []string{"idx_member_team_id", "uniq_member_team_name"}, indexNames(t, db, "member"))
+	_, err = memberStore.Insert(ctx, Member{TeamID: teamID, Name: "ma long"})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+
+	// fields tagged "-" are not stored
+	id, err := memberStore.Insert(ctx, Member{TeamID: teamID, Name: "xu xin", Note: "left"})
+	assert.NoError(t, err)
+	member, err := memberStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, Member{ID: id, TeamID: teamID, Name: "xu xin"}, member)
+
+	_, err = memberStore.Insert(ctx, Member{TeamID: teamID + 1, Name: "ghost"})
+	assert.Error(t, err)
+
+	// deleting the team cascades to its members
+	assert.NoError(t, teamStore.DeleteMulti(ctx, []int64{teamID}))
+	members, err := memberStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_migrate_files.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	fsys := fstest.MapFS{
+		"migrations/0001_create_club.sql": {Data: []byte(`CREATE TABLE club (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL);
+			INSERT INTO club (name) VALUES ('bayi');`)},
+		"migrations/0002_seed_club.sql": {Data: []byte(`INSERT INTO club (name) VALUES ('shandong');`)},
+		"migrations/README.md":          {Data: []byte("not a migration")},
+	}
+	migrations, err := MigrationsFS(fsys, "migrations")
+	assert.NoError(t, err)
+	assert.Len(t, migrations, 2)
+
+	custom := Migration{Version: 3, Name: "rename_club", Up: func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE club SET name = upper(name)")
+		return err
+	}}
+	// out of order on purpose: migrations run by version
+	migrations = append([]Migration{custom}, migrations...)
+	assert.NoError(t, db.Migrate(ctx, migrations))
+	assert.NoError(t, db.Migrate(ctx, migrations))
+
+	var names []string
+	rows, err := db.db.Query("SELECT name FROM club ORDER BY id")
+	assert.NoError(t, err)
+	for rows.Next() {
+		var name string
+		assert.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"BAYI", "SHANDONG"}, names)
+
+	var applied int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM _schema_migrations").Scan(&applied))
+	assert.Equal(t, 3, applied)
+
+	failing := Migration{Version: 4, Name: "broken", Up: func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO club (name) VALUES ('beijing'); INSERT INTO nowhere VALUES (1)")
+		return err
+	}}
+	assert.Error(t, db.Migrate(ctx, []Migration{failing}))
+	var clubs int
+	assert.NoError(t, db.db.QueryRow("SELECT count(*) FROM club").Scan(&clubs))
+	assert.Equal(t, 2, clubs, "a failed migration must roll back")
+
+	assert.ErrorIs(t, db.Migrate(ctx, []Migration{custom, custom}), ErrInvalidMigration)
+}
+
+func tableVersion(t *testing.T, db *DB, tableName string) int {
+	t.Helper()
+	var version int
+	err := db.db.QueryRow("SELECT version FROM _schema_tables WHERE name = ?", tableName).Scan(&version)
+	if err != nil {
+		t.Fatalf("fail to read version of %s: %v", tableName, err)
+	}
+	return version
+}
+
+func indexNames(t *testing.T, db *DB, tableName string) []string {
+	t.Helper()
+	rows, err := db.db.Query("SELECT name FROM pragma_index_list(?) WHERE origin = 'c' ORDER BY name", tableName)
+	if err != nil {
+		t.Fatalf("fail to list indexes of %s: %v", tableName, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			t.Fatalf("fail to scan index name: %v", err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
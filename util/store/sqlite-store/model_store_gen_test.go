@@ -0,0 +1,200 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package sqlitestore
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Role.
+const (
+	RoleColName         = "name"
+	RoleColIsHuman      = "isHuman"
+	RoleColPermissions  = "permissions"
+	RoleColAges         = "ages"
+	RoleColAlias        = "alias"
+	RoleColPrices       = "prices"
+	RoleColAddress      = "address"
+	RoleColAddressPtr   = "addressPtr"
+	RoleColAddresses    = "addresses"
+	RoleColAddressesPtr = "addressesPtr"
+	RoleColID           = "id"
+)
+
+func (o *Role) FieldsVals() ([]any, error) {
+	return []any{o.Name, o.IsHuman, o.Permissions, o.Ages, o.Alias, o.Prices, o.Address, o.AddressPtr, o.Addresses, o.AddressesPtr, o.ID}, nil
+}
+
+func (o *Role) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.Name, &o.IsHuman, &o.Permissions, &o.Ages, &o.Alias, &o.Prices, &o.Address, &o.AddressPtr, &o.Addresses, &o.AddressesPtr, &o.ID)
+}
+
+// Column names of Tag.
+const (
+	TagColID   = "id"
+	TagColName = "name"
+)
+
+func (o *Tag) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name}, nil
+}
+
+func (o *Tag) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name)
+}
+
+// Column names of PlayerV1.
+const (
+	PlayerV1ColID   = "id"
+	PlayerV1ColName = "name"
+	PlayerV1ColAge  = "age"
+)
+
+func (o *PlayerV1) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Age}, nil
+}
+
+func (o *PlayerV1) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Age)
+}
+
+// Column names of PlayerV2.
+const (
+	PlayerV2ColID   = "id"
+	PlayerV2ColName = "name"
+	PlayerV2ColAge  = "age"
+	PlayerV2ColClub = "club"
+)
+
+func (o *PlayerV2) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Age, o.Club}, nil
+}
+
+func (o *PlayerV2) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Age, &o.Club)
+}
+
+// Column names of PlayerV3.
+const (
+	PlayerV3ColID     = "id"
+	PlayerV3ColName   = "name"
+	PlayerV3ColClub   = "club"
+	PlayerV3ColRating = "rating"
+)
+
+func (o *PlayerV3) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Club, o.Rating}, nil
+}
+
+func (o *PlayerV3) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Club, &o.Rating)
+}
+
+// Column names of Team.
+const (
+	TeamColID   = "id"
+	TeamColName = "name"
+)
+
+func (o *Team) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name}, nil
+}
+
+func (o *Team) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name)
+}
+
+// Column names of Member.
+const (
+	MemberColID     = "id"
+	MemberColTeamID = "team_id"
+	MemberColName   = "name"
+)
+
+func (o *Member) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.TeamID, o.Name}, nil
+}
+
+func (o *Member) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.TeamID, &o.Name)
+}
+
+// Column names of Note.
+const (
+	NoteColID        = "id"
+	NoteColTitle     = "title"
+	NoteColDeletedAt = "deleted_at"
+)
+
+func (o *Note) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Title, o.DeletedAt}, nil
+}
+
+func (o *Note) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Title, &o.DeletedAt)
+}
+
+// Column names of Post.
+const (
+	PostColID    = "id"
+	PostColTitle = "title"
+	PostColSlug  = "slug"
+)
+
+func (o *Post) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Title, o.Slug}, nil
+}
+
+func (o *Post) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Title, &o.Slug)
+}
+
+// Column names of Fixture.
+const (
+	FixtureColID        = "id"
+	FixtureColVenue     = "venue"
+	FixtureColCreatedAt = "created_at"
+	FixtureColUpdatedAt = "updated_at"
+	FixtureColVersion   = "version"
+	FixtureColDeletedAt = "deleted_at"
+)
+
+func (o *Fixture) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Venue, o.CreatedAt, o.UpdatedAt, o.Version, o.DeletedAt}, nil
+}
+
+func (o *Fixture) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Venue, &o.CreatedAt, &o.UpdatedAt, &o.Version, &o.DeletedAt)
+}
+
+// Column names of Article.
+const (
+	ArticleColID        = "id"
+	ArticleColTitle     = "title"
+	ArticleColBody      = "body"
+	ArticleColAuthor    = "author"
+	ArticleColDeletedAt = "deleted_at"
+)
+
+func (o *Article) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Title, o.Body, o.Author, o.DeletedAt}, nil
+}
+
+func (o *Article) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Title, &o.Body, &o.Author, &o.DeletedAt)
+}
+
+// Column names of ArticleV2.
+const (
+	ArticleV2ColID        = "id"
+	ArticleV2ColTitle     = "title"
+	ArticleV2ColBody      = "body"
+	ArticleV2ColAuthor    = "author"
+	ArticleV2ColDeletedAt = "deleted_at"
+)
+
+func (o *ArticleV2) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Title, o.Body, o.Author, o.DeletedAt}, nil
+}
+
+func (o *ArticleV2) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Title, &o.Body, &o.Author, &o.DeletedAt)
+}
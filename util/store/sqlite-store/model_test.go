@@ -1,131 +1,131 @@
 package sqlitestore
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"io"
-
-	"github.com/yinloo-ola/tt-app/util/store"
+	"strings"
 )
 
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Role,Tag,PlayerV1,PlayerV2,PlayerV3,Team,Member,Note,Post,Fixture,Article,ArticleV2 -output=model_store_gen_test.go
+
 type Role struct {
 	Name         string     `db:"name,idx_desc,uniq"`
 	IsHuman      bool       `db:"isHuman,idx_asc"`
-	Permissions  []int64    `db:"permissions"`
-	Ages         []int16    `db:"ages"`
-	Alias        []string   `db:"alias"`
-	Prices       []float32  `db:"prices"`
-	Address      Address    `db:"address"`
-	AddressPtr   *Address   `db:"addressPtr"`
-	Addresses    []Address  `db:"addresses"`
-	AddressesPtr []*Address `db:"addressesPtr"`
+	Permissions  []int64    `db:"permissions,json"`
+	Ages         []int16    `db:"ages,json"`
+	Alias        []string   `db:"alias,json"`
+	Prices       []float32  `db:"prices,json"`
+	Address      Address    `db:"address,json"`
+	AddressPtr   *Address   `db:"addressPtr,json"`
+	Addresses    []Address  `db:"addresses,json"`
+	AddressesPtr []*Address `db:"addressesPtr,json"`
 	ID           int64      `db:"id,pk"`
 }
 
-func (o *Role) FieldsVals() []any {
-	out := make([]any, 0, 11)
-	out = append(out, o.Name, o.IsHuman)
-
-	buffer := bytes.NewBuffer(make([]byte, 0, 500))
-	enc := json.NewEncoder(buffer)
-
-	err := enc.Encode(o.Permissions)
-	panicErr(err)
-
-	err = enc.Encode(o.Ages)
-	panicErr(err)
+type Address struct {
+	Street string
+	City   string
+	Zip    []string
+}
 
-	err = enc.Encode(o.Alias)
-	panicErr(err)
+type Tag struct {
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name,idx_asc,uniq"`
+}
 
-	err = enc.Encode(o.Prices)
-	panicErr(err)
+// PlayerV1, PlayerV2 and PlayerV3 are successive versions of the player table.
+type PlayerV1 struct {
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name,idx_asc,uniq"`
+	Age  int    `db:"age"`
+}
 
-	err = enc.Encode(o.Address)
-	panicErr(err)
+func (o *PlayerV1) TableName() string { return "player" }
 
-	err = enc.Encode(o.AddressPtr)
-	panicErr(err)
+type PlayerV2 struct {
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name,idx_asc,uniq"`
+	Age  int    `db:"age"`
+	Club string `db:"club,idx_asc"`
+}
 
-	err = enc.Encode(o.Addresses)
-	panicErr(err)
+func (o *PlayerV2) TableName() string { return "player" }
 
-	err = enc.Encode(o.AddressesPtr)
-	panicErr(err)
+type PlayerV3 struct {
+	ID     int64   `db:"id,pk"`
+	Name   string  `db:"name,idx_desc"`
+	Club   string  `db:"club,idx_asc"`
+	Rating float64 `db:"rating"`
+}
 
-	for {
-		line, err := buffer.ReadString('\n')
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			panic("fail to read buffer: " + err.Error())
-		}
-		out = append(out, line)
-	}
+func (o *PlayerV3) TableName() string { return "player" }
 
-	out = append(out, o.ID)
-	return out
+type Team struct {
+	ID   int64  `db:"id,pk"`
+	Name string `db:"name"`
 }
 
-func panicErr(err error) {
-	if err != nil {
-		panic(err)
-	}
+type Member struct {
+	ID     int64  `db:"id,pk"`
+	TeamID int64  `db:"team_id,idx_asc,fk=team.id,on_delete=cascade"`
+	Name   string `db:"name"`
+	// Note is not stored
+	Note string `db:"-"`
 }
-func (o *Role) ScanRow(row store.RowScanner) error {
-	isHuman := 0
-	var permsStr, agesStr, aliasStr, pricesStr, addressStr, addressPtrStr, addressesStr, addressesPtrStr []byte
-	err := row.Scan(&o.Name, &isHuman, &permsStr, &agesStr, &aliasStr, &pricesStr, &addressStr, &addressPtrStr, &addressesStr, &addressesPtrStr, &o.ID)
-	if err != nil {
-		return err
-	}
-
-	o.IsHuman = isHuman > 0
 
-	total := len(permsStr) + len(agesStr) + len(aliasStr) + len(pricesStr) + len(addressStr) + len(addressPtrStr) + len(addressesStr) + len(addressesPtrStr)
-	buffer := bytes.NewBuffer(make([]byte, 0, total))
-	buffer.Write(permsStr)
-	buffer.Write(agesStr)
-	buffer.Write(aliasStr)
-	buffer.Write(pricesStr)
-	buffer.Write(addressStr)
-	buffer.Write(addressPtrStr)
-	buffer.Write(addressesStr)
-	buffer.Write(addressesPtrStr)
-
-	decoder := json.NewDecoder(buffer)
-
-	err = decoder.Decode(&o.Permissions)
-	panicErr(err)
-
-	err = decoder.Decode(&o.Ages)
-	panicErr(err)
-
-	err = decoder.Decode(&o.Alias)
-	panicErr(err)
+type Note struct {
+	ID        int64  `db:"id,pk"`
+	Title     string `db:"title,uniq"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
+}
 
-	err = decoder.Decode(&o.Prices)
-	panicErr(err)
+// Post implements the hook interfaces of the store.
+type Post struct {
+	ID    int64  `db:"id,pk"`
+	Title string `db:"title"`
+	Slug  string `db:"slug,uniq"`
+}
 
-	err = decoder.Decode(&o.Address)
-	panicErr(err)
+var errNoTitle = errors.New("post has no title")
 
-	err = decoder.Decode(&o.AddressPtr)
-	panicErr(err)
+func (o *Post) BeforeInsert(ctx context.Context) error {
+	if len(o.Title) == 0 {
+		return errNoTitle
+	}
+	o.Slug = strings.ReplaceAll(strings.ToLower(o.Title), " ", "-")
+	return nil
+}
 
-	err = decoder.Decode(&o.Addresses)
-	panicErr(err)
+func (o *Post) BeforeUpdate(ctx context.Context) error {
+	return o.BeforeInsert(ctx)
+}
 
-	err = decoder.Decode(&o.AddressesPtr)
-	panicErr(err)
+// Fixture has the columns maintained by the store.
+type Fixture struct {
+	ID        int64  `db:"id,pk"`
+	Venue     string `db:"venue,uniq"`
+	CreatedAt int64  `db:"created_at,created_at"`
+	UpdatedAt int64  `db:"updated_at,updated_at"`
+	Version   int64  `db:"version,version"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
+}
 
-	return nil
+// Article has full-text indexed columns. ArticleV2 is the same table without
+// them.
+type Article struct {
+	ID        int64  `db:"id,pk"`
+	Title     string `db:"title,fts"`
+	Body      string `db:"body,fts"`
+	Author    string `db:"author,idx_asc"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
 }
 
-type Address struct {
-	Street string
-	City   string
-	Zip    []string
+type ArticleV2 struct {
+	ID        int64  `db:"id,pk"`
+	Title     string `db:"title"`
+	Body      string `db:"body"`
+	Author    string `db:"author,idx_asc"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
 }
+
+func (o *ArticleV2) TableName() string { return "article" }
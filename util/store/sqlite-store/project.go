@@ -0,0 +1,49 @@
+package sqlitestore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Project selects only fields, and decodes only the JSON columns among them.
+func (o *SQliteStore[T, R]) Project(ctx context.Context, fields []string, scan func(row store.RowScanner) error, conds ...store.Cond) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("%s Project without fields", o.tablename)
+	}
+	columns := make([]column, 0, len(fields))
+	for _, field := range fields {
+		col, ok := o.column(field)
+		if !ok {
+			return &store.UnknownFieldError{Table: o.tablename, Field: field}
+		}
+		columns = append(columns, col)
+	}
+	where, args, err := o.where(conds, "Project")
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("SELECT %s from %s%s", strings.Join(fields, ","), o.tablename, where)
+	rows, err := o.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s Project Query error: %w", o.tablename, err)
+	}
+	defer rows.Close()
+	var scanner store.RowScanner = rows
+	if slices.ContainsFunc(columns, func(col column) bool { return col.IsJSON }) {
+		scanner = store.JSONScanner{Row: rows, Columns: sqlColumns(columns)}
+	}
+	for rows.Next() {
+		if err = scan(scanner); err != nil {
+			return fmt.Errorf("%s Project row.Scan error: %w", o.tablename, err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("%s Project rows error: %w", o.tablename, err)
+	}
+	return nil
+}
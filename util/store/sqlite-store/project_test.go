@@ -0,0 +1,73 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestProject(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_project.db")
+	ctx := context.Background()
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = noteStore.Close()
+	})
+
+	ids, err := roleStore.InsertMulti(ctx, []Role{
+		{Name: "admin", IsHuman: true, Permissions: []int64{1, 2}, Address: Address{City: "singapore"}},
+		{Name: "bot", Permissions: []int64{3}},
+	})
+	assert.NoError(t, err)
+
+	// a light struct reads only its fields, JSON columns included
+	type roleOption struct {
+		ID          int64   `db:"id"`
+		Name        string  `db:"name"`
+		Permissions []int64 `db:"permissions,json"`
+		Skipped     string  `db:"-"`
+	}
+	options, err := store.Project[roleOption](ctx, roleStore, store.Eq(RoleColIsHuman, true))
+	assert.NoError(t, err)
+	assert.Equal(t, []roleOption{{ID: ids[0], Name: "admin", Permissions: []int64{1, 2}}}, options)
+
+	// a map holds the raw values, and the decoded JSON columns
+	rows, err := store.FindMaps(ctx, roleStore, []string{RoleColName, RoleColAddress}, store.Eq(RoleColID, ids[0]))
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 1) {
+		assert.Equal(t, "admin", rows[0][RoleColName])
+		assert.Equal(t, map[string]any{"Street": "", "City": "singapore", "Zip": nil}, rows[0][RoleColAddress])
+	}
+
+	_, err = store.FindMaps(ctx, roleStore, []string{"1; drop table role"})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+	_, err = store.Project[struct {
+		Rank int `db:"rank"`
+	}](ctx, roleStore)
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+	_, err = store.FindMaps(ctx, roleStore, nil)
+	assert.Error(t, err)
+
+	// soft deleted rows are left out
+	noteIDs, err := noteStore.InsertMulti(ctx, []Note{{Title: "a"}, {Title: "b"}})
+	assert.NoError(t, err)
+	assert.NoError(t, noteStore.DeleteMulti(ctx, noteIDs[:1]))
+	titles, err := store.Project[struct {
+		Title string `db:"title"`
+	}](ctx, noteStore)
+	assert.NoError(t, err)
+	if assert.Len(t, titles, 1) {
+		assert.Equal(t, "b", titles[0].Title)
+	}
+}
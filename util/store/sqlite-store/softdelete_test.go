@@ -0,0 +1,92 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestSoftDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_soft_delete.db")
+	ctx := context.Background()
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = noteStore.Close()
+		_ = tagStore.Close()
+	})
+
+	deleter, err := store.AsSoftDeleter[Note](noteStore)
+	assert.NoError(t, err)
+
+	first, err := noteStore.Insert(ctx, Note{Title: "first", DeletedAt: 42})
+	assert.NoError(t, err)
+	second, err := noteStore.Insert(ctx, Note{Title: "second"})
+	assert.NoError(t, err)
+	got, err := noteStore.GetOne(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, Note{ID: first, Title: "first"}, got, "the store sets deleted_at")
+
+	assert.NoError(t, noteStore.DeleteMulti(ctx, []int64{first}))
+	assert.ErrorIs(t, noteStore.DeleteMulti(ctx, []int64{first}), store.ErrNotFound)
+	_, err = noteStore.GetOne(ctx, first)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, noteStore.Update(ctx, first, Note{Title: "renamed"}), store.ErrNotFound)
+	notes, err := noteStore.GetMulti(ctx, []int64{first, second})
+	assert.NoError(t, err)
+	assert.Equal(t, []Note{{ID: second, Title: "second"}}, notes)
+	notes, err = noteStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Note{{ID: second, Title: "second"}}, notes)
+	page, err := noteStore.FindPage(ctx, store.Query{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), page.Total)
+	assert.Equal(t, []Note{{ID: second, Title: "second"}}, page.Items)
+
+	deleted, err := deleter.FindDeleted(ctx, store.Eq(NoteColTitle, "first"))
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, first, deleted[0].ID)
+		assert.InDelta(t, time.Now().Unix(), deleted[0].DeletedAt, 5)
+	}
+
+	// a deleted row gives up its unique values, and is not restored while a
+	// live row has them
+	other, err := noteStore.Insert(ctx, Note{Title: "first"})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, deleter.Restore(ctx, []int64{first}), store.ErrConflicted)
+	assert.NoError(t, noteStore.DeleteMulti(ctx, []int64{other}))
+
+	assert.NoError(t, deleter.Restore(ctx, []int64{first}))
+	assert.ErrorIs(t, deleter.Restore(ctx, []int64{first, second}), store.ErrNotFound)
+	got, err = noteStore.GetOne(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, Note{ID: first, Title: "first"}, got)
+
+	assert.NoError(t, noteStore.DeleteMulti(ctx, []int64{first, second}))
+	n, err := deleter.Purge(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), n)
+	n, err = deleter.Purge(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	deleted, err = deleter.FindDeleted(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	// stores without a soft_delete column delete rows for good
+	tagDeleter, err := store.AsSoftDeleter[Tag](tagStore)
+	assert.NoError(t, err)
+	_, err = tagDeleter.FindDeleted(ctx)
+	assert.ErrorIs(t, err, store.ErrSoftDeleteNotSupported)
+}
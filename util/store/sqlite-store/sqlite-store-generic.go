@@ -1,22 +1,23 @@
 package sqlitestore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
-	"sync"
+	"time"
 
 	"modernc.org/sqlite"
-	_ "modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
 
 	"github.com/yinloo-ola/tt-app/util/store"
 )
 
 type SQliteStore[T any, R store.Row[T]] struct {
-	db         *sql.DB
+	db         *DB
+	tx         *Tx
 	tablename  string
 	pk         string
 	getOneStmt *sql.Stmt
@@ -24,46 +25,64 @@ type SQliteStore[T any, R store.Row[T]] struct {
 	updateStmt *sql.Stmt
 	getAllStmt *sql.Stmt
 	columns    []column
-	sync.RWMutex
+	// sqlTable holds the parts of the store shared with the Postgres store.
+	sqlTable *store.SQLTable[T, R]
+	// softDelete is the name of the soft_delete column, or "" if the model has
+	// none.
+	softDelete string
+	// version is the name of the version column, or "" if the model has none.
+	version string
+	// hooks is shared with the views returned by WithTx.
+	hooks map[store.Event][]store.Hook[T]
+	// getOneQuery is run instead of getOneStmt inside a transaction, as
+	// getOneStmt is prepared on the read pool.
+	getOneQuery string
 }
 
+// querier is the subset of methods shared by *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// NewStore opens the SQLite file at path and creates or migrates the table for T.
+// Stores opened on the same path share one connection pool.
 func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
-	db, err := sql.Open("sqlite", path)
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.Exec("PRAGMA journal_mode = wal;")
-	if err != nil {
-		return nil, err
-	}
-	_, err = db.Exec("PRAGMA synchronous=1;")
+	db, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer db.Close()
+	return NewStoreFromDB[T, R](db)
+}
 
+// NewStoreFromDB creates or migrates the table for T in db and returns a store for it.
+func NewStoreFromDB[T any, R store.Row[T]](db *DB) (*SQliteStore[T, R], error) {
 	var obj T
 	typ := reflect.TypeOf(obj)
 	tableName := toSnakeCase(typ.Name())
+	if namer, ok := any(R(&obj)).(store.TableNamer); ok {
+		tableName = namer.TableName()
+	}
 	columns := getColumns(typ)
 
-	pk := ""
+	pk, softDelete, version := "", "", ""
 	for _, col := range columns {
 		if col.IsPK {
 			pk = col.Name
-			break
+		}
+		if col.IsSoftDelete {
+			softDelete = col.Name
+		}
+		if col.IsVersion {
+			version = col.Name
 		}
 	}
 
-	stmt := generateCreateTableSQL(tableName, columns)
-	_, err = db.Exec(stmt)
-	if err != nil {
-		return nil, err
-	}
-
-	stmt = generateCreateIdxSQL(tableName, columns)
-	_, err = db.Exec(stmt)
+	err := db.migrateTable(context.Background(), tableName, columns)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s migration failed: %w", tableName, err)
 	}
 
 	placeholdersNoPK := make([]string, 0, len(columns))
@@ -72,15 +91,41 @@ func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
 	updates := make([]string, 0, len(columns))
 	for _, col := range columns {
 		columnNames = append(columnNames, col.Name)
-		if !col.IsPK {
-			columnNamesNoPK = append(columnNamesNoPK, col.Name)
-			placeholdersNoPK = append(placeholdersNoPK, "?")
+		// the store sets the soft_delete column itself, see DeleteMulti
+		if col.IsPK || col.IsSoftDelete {
+			continue
+		}
+		columnNamesNoPK = append(columnNamesNoPK, col.Name)
+		placeholdersNoPK = append(placeholdersNoPK, "?")
+		switch {
+		case col.IsVersion:
+			updates = append(updates, col.Name+"="+col.Name+"+1")
+		case !col.IsCreatedAt:
 			updates = append(updates, col.Name+"=?")
 		}
 	}
 
-	getOneQuery := fmt.Sprintf("SELECT %s from %s where %s=?", strings.Join(columnNames, ","), tableName, pk)
-	getOneStmt, err := db.Prepare(getOneQuery)
+	live := ""
+	if len(softDelete) > 0 {
+		live = fmt.Sprintf(" and %s = 0", softDelete)
+	}
+
+	// a failed Prepare closes the statements prepared before it
+	var stmts []*sql.Stmt
+	prepare := func(conn *sql.DB, query string) (*sql.Stmt, error) {
+		stmt, err := conn.Prepare(query)
+		if err != nil {
+			for _, prepared := range stmts {
+				_ = prepared.Close()
+			}
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		return stmt, nil
+	}
+
+	getOneQuery := fmt.Sprintf("SELECT %s from %s where %s=?%s", strings.Join(columnNames, ","), tableName, pk, live)
+	getOneStmt, err := prepare(db.read, getOneQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -90,50 +135,111 @@ func NewStore[T any, R store.Row[T]](path string) (*SQliteStore[T, R], error) {
 		strings.Join(columnNamesNoPK, ", "),
 		strings.Join(placeholdersNoPK, ", "),
 	)
-	insertStmt, err := db.Prepare(insertQuery)
+	insertStmt, err := prepare(db.db, insertQuery)
 	if err != nil {
 		return nil, err
 	}
 
-	updateQuery := fmt.Sprintf("UPDATE %s SET %s where %s=?",
+	if len(version) > 0 {
+		// optimistic locking: the row must still have the version read by the caller
+		live += fmt.Sprintf(" and %s=?", version)
+	}
+	updateQuery := fmt.Sprintf("UPDATE %s SET %s where %s=?%s",
 		tableName,
 		strings.Join(updates, ", "),
 		pk,
+		live,
 	)
-	updateStmt, err := db.Prepare(updateQuery)
+	updateStmt, err := prepare(db.db, updateQuery)
 	if err != nil {
 		return nil, err
 	}
 
 	getAllQuery := fmt.Sprintf("SELECT %s from %s", strings.Join(columnNames, ","), tableName)
-	getAllstmt, err := db.Prepare(getAllQuery)
+	getAllstmt, err := prepare(db.read, getAllQuery)
 	if err != nil {
 		return nil, err
 	}
 
+	db.acquire()
 	return &SQliteStore[T, R]{
-		db: db, tablename: tableName, columns: columns, pk: pk,
+		db: db, tablename: tableName, columns: columns, pk: pk, softDelete: softDelete, version: version,
+		sqlTable: &store.SQLTable[T, R]{
+			Name: tableName, Columns: sqlColumns(columns), SelectList: strings.Join(columnNames, ","), LimitAll: "LIMIT -1",
+		},
 		getOneStmt: getOneStmt, insertStmt: insertStmt, updateStmt: updateStmt,
-		getAllStmt: getAllstmt,
+		getAllStmt: getAllstmt, hooks: map[store.Event][]store.Hook[T]{}, getOneQuery: getOneQuery,
 	}, nil
 }
 
-func (o *SQliteStore[T, R]) Insert(obj T) (int64, error) {
-	o.Lock()
-	defer o.Unlock()
-	values := make([]any, 0, len(o.columns))
-	k := R(&obj)
+// WithTx returns a view of the store whose methods run inside tx. tx must have
+// been opened on the same DB as the store.
+func (o *SQliteStore[T, R]) WithTx(tx store.Tx) (store.Store[T, R], error) {
+	sqliteTx, ok := tx.(*Tx)
+	if !ok || sqliteTx.db != o.db {
+		return nil, store.ErrTxMismatch
+	}
+	view := *o
+	view.tx = sqliteTx
+	return &view, nil
+}
 
-	fieldPtrs := k.FieldsVals()
-	for _, col := range o.columns {
-		if col.IsPK {
-			continue
-		}
-		val := fieldPtrs[col.Index]
-		values = append(values, val)
+// reader returns the querier of the statements that only read: the
+// transaction, or else the read pool.
+func (o *SQliteStore[T, R]) reader() querier {
+	if o.tx != nil {
+		return o.tx.tx
 	}
+	return o.db.read
+}
+
+// writer returns the querier of the statements that write: the transaction,
+// or else the writer connection.
+func (o *SQliteStore[T, R]) writer() querier {
+	if o.tx != nil {
+		return o.tx.tx
+	}
+	return busyRetrier{db: o.db.db}
+}
 
-	res, err := o.insertStmt.Exec(values...)
+// execStmt runs stmt, prepared on the writer connection, in the transaction
+// or else with retryBusy.
+func (o *SQliteStore[T, R]) execStmt(ctx context.Context, stmt *sql.Stmt, args ...any) (res sql.Result, err error) {
+	if o.tx != nil {
+		return o.tx.tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	err = retryBusy(ctx, func() error {
+		res, err = stmt.ExecContext(ctx, args...)
+		return err
+	})
+	return res, err
+}
+
+func (o *SQliteStore[T, R]) Insert(ctx context.Context, obj T) (int64, error) {
+	var id int64
+	err := o.hookTx(ctx, func(s *SQliteStore[T, R]) error {
+		var err error
+		id, err = s.insert(ctx, obj)
+		return err
+	}, store.BeforeInsert, store.AfterInsert)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insert is Insert on o, which may be a transaction view.
+func (o *SQliteStore[T, R]) insert(ctx context.Context, obj T) (int64, error) {
+	if err := o.runHooks(ctx, store.BeforeInsert, &obj); err != nil {
+		return 0, err
+	}
+	o.stamp(&obj, time.Now(), false)
+	values, err := o.sqlTable.RowValues(R(&obj), false)
+	if err != nil {
+		return 0, fmt.Errorf("%s insert failed: %w", o.tablename, err)
+	}
+
+	res, err := o.execStmt(ctx, o.insertStmt, values...)
 	if err != nil {
 		if isDupError(err) {
 			return 0, store.ErrConflicted
@@ -145,25 +251,37 @@ func (o *SQliteStore[T, R]) Insert(obj T) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("%s fail to get last insert id: %w", o.tablename, err)
 	}
+	o.setPK(&obj, id)
+	if err = o.runHooks(ctx, store.AfterInsert, &obj); err != nil {
+		return 0, err
+	}
 	return id, nil
 }
 
-func (o *SQliteStore[T, R]) Update(id int64, obj T) error {
-	o.Lock()
-	defer o.Unlock()
-	values := make([]any, 0, len(o.columns))
-	k := R(&obj)
-	fieldPtrs := k.FieldsVals()
-	for _, col := range o.columns {
-		if col.IsPK {
-			continue
-		}
-		val := fieldPtrs[col.Index]
-		values = append(values, val)
+func (o *SQliteStore[T, R]) Update(ctx context.Context, id int64, obj T) error {
+	return o.hookTx(ctx, func(s *SQliteStore[T, R]) error {
+		return s.update(ctx, id, obj)
+	}, store.BeforeUpdate, store.AfterUpdate)
+}
+
+// update is Update on o, which may be a transaction view.
+func (o *SQliteStore[T, R]) update(ctx context.Context, id int64, obj T) error {
+	o.setPK(&obj, id)
+	if err := o.runHooks(ctx, store.BeforeUpdate, &obj); err != nil {
+		return err
+	}
+	o.stamp(&obj, time.Now(), true)
+	values, err := o.sqlTable.RowValues(R(&obj), true)
+	if err != nil {
+		return fmt.Errorf("%s update failed: %w", o.tablename, err)
 	}
 	values = append(values, id)
+	version := o.versionOf(&obj)
+	if len(o.version) > 0 {
+		values = append(values, version)
+	}
 
-	res, err := o.updateStmt.Exec(values...)
+	res, err := o.execStmt(ctx, o.updateStmt, values...)
 	if err != nil {
 		if isDupError(err) {
 			return store.ErrConflicted
@@ -174,132 +292,196 @@ func (o *SQliteStore[T, R]) Update(id int64, obj T) error {
 	if rowsAffected, err := res.RowsAffected(); err != nil {
 		return fmt.Errorf("%s failed to get rows affected: %w", o.tablename, err)
 	} else if rowsAffected == 0 {
+		if len(o.version) == 0 {
+			return store.ErrNotFound
+		}
+		if ok, err := o.exists(ctx, id); err != nil {
+			return err
+		} else if ok {
+			return store.ErrStaleVersion
+		}
 		return store.ErrNotFound
 	}
-	return nil
+	o.setVersion(&obj, version+1)
+	return o.runHooks(ctx, store.AfterUpdate, &obj)
 }
 
-func (o *SQliteStore[T, R]) GetMulti(ids []int64) ([]T, error) {
-	o.RLock()
-	defer o.RUnlock()
-	columnNames := make([]string, 0, len(o.columns))
-	for _, col := range o.columns {
-		columnNames = append(columnNames, col.Name)
-	}
+func (o *SQliteStore[T, R]) GetMulti(ctx context.Context, ids []int64) ([]T, error) {
+	return o.getMulti(ctx, ids)
+}
 
+// getMulti is GetMulti on o, which may be a transaction view.
+func (o *SQliteStore[T, R]) getMulti(ctx context.Context, ids []int64) ([]T, error) {
 	placeholders, args := InArgs(ids)
-	query := fmt.Sprintf("SELECT %s from %s where %s in (%s)", strings.Join(columnNames, ","), o.tablename, o.pk, placeholders)
+	query := fmt.Sprintf("SELECT %s from %s%s", o.columnList(), o.tablename,
+		joinWhere(o.sqlTable.LiveConds(fmt.Sprintf("%s in (%s)", o.pk, placeholders))))
 
-	rows, err := o.db.Query(query, args...)
+	rows, err := o.reader().QueryContext(ctx, query, args...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, store.ErrNotFound
-		}
 		return nil, fmt.Errorf("%s GetMulti Query error: %w", o.tablename, err)
 	}
-	defer rows.Close()
-
-	objs := make([]T, 0, len(ids))
-	for rows.Next() {
-		var obj T
-		k := R(&obj)
-		err = k.ScanRow(rows)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, store.ErrNotFound
-			}
-			return nil, fmt.Errorf("%s GetMulti row.Scan error: %w", o.tablename, err)
-		}
-		objs = append(objs, obj)
-	}
-	return objs, nil
+	return o.sqlTable.ScanRows(rows, "GetMulti", len(ids))
 }
 
-func (o *SQliteStore[T, R]) GetOne(id int64) (T, error) {
-	o.RLock()
-	defer o.RUnlock()
+func (o *SQliteStore[T, R]) GetOne(ctx context.Context, id int64) (T, error) {
 	var obj T
 	k := R(&obj)
 
-	row := o.getOneStmt.QueryRow(id)
+	var row *sql.Row
+	if o.tx != nil {
+		row = o.tx.tx.QueryRowContext(ctx, o.getOneQuery, id)
+	} else {
+		row = o.getOneStmt.QueryRowContext(ctx, id)
+	}
 	if row == nil {
 		return obj, store.ErrNotFound
 	}
 
-	err := k.ScanRow(row)
+	err := k.ScanRow(o.sqlTable.Scanner(row))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return obj, store.ErrNotFound
 		}
-		return obj, fmt.Errorf("%s GetOne row.Scan error: %w", o.tablename, err)
+		return obj, fmt.Errorf("%s GetOne row.Scan error: %w", o.tablename, o.sqlTable.CorruptRow(&obj, err))
 	}
 	return obj, nil
 }
 
-func (o *SQliteStore[T, R]) DeleteMulti(ids []int64) error {
-	o.Lock()
-	defer o.Unlock()
-	placeholder, args := InArgs(ids)
-	query := fmt.Sprintf("DELETE from %s where %s IN (%s)", o.tablename, o.pk, placeholder)
-	res, err := o.db.Exec(query, args...)
+// DeleteMulti deletes the rows ids. If the model has a soft_delete column, the
+// rows are only marked as deleted, see Restore and Purge.
+func (o *SQliteStore[T, R]) DeleteMulti(ctx context.Context, ids []int64) error {
+	return o.hookTx(ctx, func(s *SQliteStore[T, R]) error {
+		// the delete hooks get the rows as they were before the delete
+		var objs []T
+		if s.hasHooks(store.BeforeDelete, store.AfterDelete) {
+			var err error
+			if objs, err = s.getMulti(ctx, ids); err != nil {
+				return err
+			}
+		}
+		for i := range objs {
+			if err := s.runHooks(ctx, store.BeforeDelete, &objs[i]); err != nil {
+				return err
+			}
+		}
+
+		placeholder, args := InArgs(ids)
+		query := fmt.Sprintf("DELETE from %s where %s IN (%s)", s.tablename, s.pk, placeholder)
+		if len(s.softDelete) > 0 {
+			query = fmt.Sprintf("UPDATE %s SET %s = ? where %s IN (%s) and %s = 0", s.tablename, s.softDelete, s.pk, placeholder, s.softDelete)
+			args = append([]any{time.Now().Unix()}, args...)
+		}
+		res, err := s.writer().ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("%s DeleteMulti exec failed: %w", s.tablename, err)
+		}
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("%s DeleteMulti RowsAffected failed: %w", s.tablename, err)
+		}
+		if rowsAffected == 0 {
+			return store.ErrNotFound
+		}
+
+		for i := range objs {
+			if err := s.runHooks(ctx, store.AfterDelete, &objs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, store.BeforeDelete, store.AfterDelete)
+}
+
+func (o *SQliteStore[T, R]) FindWhere(ctx context.Context, conds ...store.Cond) ([]T, error) {
+	where, args, err := o.where(conds, "FindWhere")
 	if err != nil {
-		return fmt.Errorf("%s DeleteMulti exec failed: %w", o.tablename, err)
+		return nil, err
 	}
-	rowsAffected, err := res.RowsAffected()
+	findQuery := fmt.Sprintf("SELECT %s from %s%s", o.columnList(), o.tablename, where)
+	rows, err := o.reader().QueryContext(ctx, findQuery, args...)
 	if err != nil {
-		return fmt.Errorf("%s DeleteMulti RowsAffected failed: %w", o.tablename, err)
+		return nil, fmt.Errorf("%s FindWhere Query error: %w", o.tablename, err)
 	}
-	if rowsAffected == 0 {
-		return store.ErrNotFound
+	return o.sqlTable.ScanRows(rows, "FindWhere", 0)
+}
+
+// Each reads the rows in one SQLite read transaction of the read pool, so
+// they stay consistent while fn writes to the store. Inside a transaction fn
+// should not change the rows being read.
+func (o *SQliteStore[T, R]) Each(ctx context.Context, fn func(obj T) error, conds ...store.Cond) error {
+	rows, err := o.query(ctx, conds)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var obj T
+		if err = R(&obj).ScanRow(o.sqlTable.Scanner(rows)); err != nil {
+			return fmt.Errorf("%s Each row.Scan error: %w", o.tablename, o.sqlTable.CorruptRow(&obj, err))
+		}
+		if err = fn(obj); err != nil {
+			if errors.Is(err, store.ErrStop) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("%s Each rows error: %w", o.tablename, err)
 	}
 	return nil
 }
 
-func (o *SQliteStore[T, R]) FindWhere(conds ...store.Cond) ([]T, error) {
-	o.RLock()
-	defer o.RUnlock()
-	columnNames := make([]string, 0, len(o.columns))
-	for _, col := range o.columns {
-		columnNames = append(columnNames, col.Name)
+func (o *SQliteStore[T, R]) query(ctx context.Context, conds []store.Cond) (*sql.Rows, error) {
+	where, args, err := o.where(conds, "Each")
+	if err != nil {
+		return nil, err
 	}
-	whereStmt := ""
-	stmts := make([]string, 0, len(conds))
-	args := make([]any, 0, len(conds))
-	for _, cond := range conds {
-		s, arg := cond.GetQueryWithArgs()
-		stmts = append(stmts, s)
-		args = append(args, arg...)
+	query := fmt.Sprintf("SELECT %s from %s%s", o.columnList(), o.tablename, where)
+	rows, err := o.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s Each Query error: %w", o.tablename, err)
 	}
-	if len(stmts) > 0 {
-		whereStmt = " where " + strings.Join(stmts, " ")
+	return rows, nil
+}
+
+func (o *SQliteStore[T, R]) FindPage(ctx context.Context, q store.Query) (store.Page[T], error) {
+	if err := o.sqlTable.CheckFields(q.Where); err != nil {
+		return store.Page[T]{}, err
 	}
-	findQuery := fmt.Sprintf("SELECT %s from %s%s", strings.Join(columnNames, ","), o.tablename, whereStmt)
-	rows, err := o.db.Query(findQuery, args...)
+	whereStmt, args, err := store.Where(q.Where...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, store.ErrNotFound
-		}
-		return nil, fmt.Errorf("%s GetMulti Query error: %w", o.tablename, err)
+		return store.Page[T]{}, fmt.Errorf("%s FindPage: %w", o.tablename, err)
 	}
-	defer rows.Close()
+	return o.sqlTable.FindPage(ctx, q, whereStmt, args,
+		func(ctx context.Context) (int64, error) { return o.count(ctx, q.Where) },
+		func(ctx context.Context, query string, args ...any) (store.Rows, error) {
+			return o.reader().QueryContext(ctx, query, args...)
+		})
+}
 
-	var objs []T
-	for rows.Next() {
-		var obj T
-		k := R(&obj)
-		err = k.ScanRow(rows)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, store.ErrNotFound
-			}
-			return nil, fmt.Errorf("%s GetMulti row.Scan error: %w", o.tablename, err)
+func (o *SQliteStore[T, R]) column(name string) (column, bool) {
+	for _, col := range o.columns {
+		if col.Name == name {
+			return col, true
 		}
-		objs = append(objs, obj)
 	}
-	return objs, nil
+	return column{}, false
 }
 
+func (o *SQliteStore[T, R]) columnList() string {
+	return o.sqlTable.SelectList
+}
+
+// Close releases the store's prepared statements and its reference to the
+// shared DB. Closing a view returned by WithTx is a no-op.
 func (o *SQliteStore[T, R]) Close() error {
+	if o.tx != nil {
+		return nil
+	}
+	for _, stmt := range []*sql.Stmt{o.getOneStmt, o.insertStmt, o.updateStmt, o.getAllStmt} {
+		_ = stmt.Close()
+	}
 	return o.db.Close()
 }
 
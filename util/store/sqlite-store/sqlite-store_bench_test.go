@@ -2,7 +2,9 @@ package sqlitestore
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -24,7 +26,7 @@ import (
 // 	ctx := context.Background()
 // 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 // 	defer cancel()
-// 	err = roleStore.db.PingContext(ctx)
+// 	err = roleStore.db.db.PingContext(ctx)
 // 	if err != nil {
 // 		b.Fatalf("ping fail %v", err)
 // 	}
@@ -57,31 +59,16 @@ import (
 // }
 
 func BenchmarkJsonGetOne(b *testing.B) {
-	path := "rbac2.db"
+	path := filepath.Join(b.TempDir(), "rbac2.db")
 	roleStore, err := NewStore[Role](path)
 	if err != nil {
 		b.Fatalf("fail to create roleStore %v", err)
 	}
 
-	b.Cleanup(func() {
-		errRemove := os.Remove(path)
-		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-shm")
-		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-wal")
-		if errRemove != nil {
-			b.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-	})
-
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err = roleStore.db.PingContext(ctx)
+	err = roleStore.db.db.PingContext(ctx)
 	if err != nil {
 		b.Fatalf("ping fail %v", err)
 	}
@@ -97,7 +84,7 @@ func BenchmarkJsonGetOne(b *testing.B) {
 		Addresses:    []Address{{"street1", "city1", []string{"7", "8", "9"}}, {"street2", "city2", []string{"10", "11", "12"}}},
 		AddressesPtr: []*Address{{"streetPtr1", "cityPtr1", []string{"13", "14", "15"}}, {"streetPtr2", "cityPtr2", []string{"16", "17", "18"}}},
 	}
-	id, err := roleStore.Insert(role)
+	id, err := roleStore.Insert(ctx, role)
 	if err != nil {
 		b.Fatalf("fail to insert: %v", err)
 	}
@@ -106,10 +93,111 @@ func BenchmarkJsonGetOne(b *testing.B) {
 
 	var r Role
 	for i := 0; i < b.N; i++ {
-		r, err = roleStore.GetOne(id)
+		r, err = roleStore.GetOne(ctx, id)
 		if err != nil {
 			b.Fatalf("fail to get %d: %s", id, err)
 		}
 	}
 	_ = r
 }
+
+// BenchmarkInsert and BenchmarkInsertMulti insert 1000 tags per op, one
+// transaction per tag and one for all of them.
+func BenchmarkInsert(b *testing.B) {
+	benchmarkInsert(b, func(ctx context.Context, tagStore *SQliteStore[Tag, *Tag], tags []Tag) error {
+		for _, tag := range tags {
+			if _, err := tagStore.Insert(ctx, tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkInsertMulti(b *testing.B) {
+	benchmarkInsert(b, func(ctx context.Context, tagStore *SQliteStore[Tag, *Tag], tags []Tag) error {
+		_, err := tagStore.InsertMulti(ctx, tags)
+		return err
+	})
+}
+
+func benchmarkInsert(b *testing.B, insert func(ctx context.Context, tagStore *SQliteStore[Tag, *Tag], tags []Tag) error) {
+	path := filepath.Join(b.TempDir(), "rbac_bench_insert.db")
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		b.Fatalf("fail to create tagStore %v", err)
+	}
+	b.Cleanup(func() {
+		_ = tagStore.Close()
+	})
+
+	ctx := context.Background()
+	tags := make([]Tag, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range tags {
+			tags[j].Name = fmt.Sprintf("tag %d-%d", i, j)
+		}
+		if err = insert(ctx, tagStore, tags); err != nil {
+			b.Fatalf("fail to insert: %v", err)
+		}
+	}
+}
+
+// BenchmarkParallelRead reads one tag per op from GOMAXPROCS goroutines.
+func BenchmarkParallelRead(b *testing.B) {
+	benchmarkParallel(b, 0)
+}
+
+// BenchmarkParallelMixed makes one op in ten a write, from GOMAXPROCS
+// goroutines, across two stores on the same file.
+func BenchmarkParallelMixed(b *testing.B) {
+	benchmarkParallel(b, 10)
+}
+
+func benchmarkParallel(b *testing.B, writeEvery int) {
+	path := filepath.Join(b.TempDir(), "rbac_bench_parallel.db")
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		b.Fatalf("fail to create tagStore %v", err)
+	}
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		b.Fatalf("fail to create noteStore %v", err)
+	}
+	b.Cleanup(func() {
+		_ = tagStore.Close()
+		_ = noteStore.Close()
+	})
+
+	ctx := context.Background()
+	tags := make([]Tag, 1000)
+	for i := range tags {
+		tags[i].Name = fmt.Sprintf("tag %d", i)
+	}
+	ids, err := tagStore.InsertMulti(ctx, tags)
+	if err != nil {
+		b.Fatalf("fail to insert: %v", err)
+	}
+
+	var n atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := n.Add(1)
+			var err error
+			switch {
+			case writeEvery > 0 && i%int64(writeEvery) == 0:
+				_, err = noteStore.Insert(ctx, Note{Title: fmt.Sprintf("note %d", i)})
+			case writeEvery > 0 && i%int64(writeEvery) == 1:
+				_, err = tagStore.Insert(ctx, Tag{Name: fmt.Sprintf("new tag %d", i)})
+			default:
+				_, err = tagStore.GetOne(ctx, ids[i%int64(len(ids))])
+			}
+			if err != nil {
+				b.Errorf("op %d failed: %v", i, err)
+				return
+			}
+		}
+	})
+}
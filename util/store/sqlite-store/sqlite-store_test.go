@@ -2,10 +2,12 @@ package sqlitestore
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,7 +16,7 @@ import (
 )
 
 func TestNew(t *testing.T) {
-	path := "rbac.db"
+	path := filepath.Join(t.TempDir(), "rbac.db")
 	now := time.Now()
 	roleStore, err := NewStore[Role](path)
 	if err != nil {
@@ -22,25 +24,10 @@ func TestNew(t *testing.T) {
 	}
 	fmt.Printf("new store duration: %s\n", time.Since(now))
 
-	t.Cleanup(func() {
-		errRemove := os.Remove(path)
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-shm")
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-		errRemove = os.Remove(path + "-wal")
-		if errRemove != nil {
-			t.Fatalf("fail to clean up rbac.db. please clean up manually")
-		}
-	})
-
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err = roleStore.db.PingContext(ctx)
+	err = roleStore.db.db.PingContext(ctx)
 	if err != nil {
 		t.Fatalf("ping fail %v", err)
 	}
@@ -57,7 +44,7 @@ func TestNew(t *testing.T) {
 		AddressesPtr: []*Address{{"streetPtr1", "cityPtr1", []string{"13", "14", "15"}}, {"streetPtr2", "cityPtr2", []string{"16", "17", "18"}}},
 	}
 	now = time.Now()
-	id, err := roleStore.Insert(role)
+	id, err := roleStore.Insert(ctx, role)
 	if err != nil {
 		t.Fatalf("fail to insert: %v", err)
 	}
@@ -70,21 +57,21 @@ func TestNew(t *testing.T) {
 	role.Name = "super_admin"
 	role.Permissions = []int64{4, 5, 6}
 	now = time.Now()
-	err = roleStore.Update(id, role)
+	err = roleStore.Update(ctx, id, role)
 	if err != nil {
 		t.Fatalf("fail to update %v", err)
 	}
 	fmt.Printf("update duration: %s\n", time.Since(now))
 
 	now = time.Now()
-	err = roleStore.Update(100, role)
+	err = roleStore.Update(ctx, 100, role)
 	if err != store.ErrNotFound {
 		t.Fatalf("fail to update %v", err)
 	}
 	fmt.Printf("update failed duration: %s\n", time.Since(now))
 
 	now = time.Now()
-	roleOut, err := roleStore.GetOne(id)
+	roleOut, err := roleStore.GetOne(ctx, id)
 	if err != nil {
 		t.Fatalf("GetOne failed: %v", err)
 	}
@@ -95,7 +82,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	roleOut2, err := roleStore.GetOne(100)
+	roleOut2, err := roleStore.GetOne(ctx, 100)
 	if err != store.ErrNotFound {
 		t.Fatalf("expected error but gotten: %#v", roleOut2)
 	}
@@ -114,7 +101,7 @@ func TestNew(t *testing.T) {
 		AddressesPtr: []*Address{{"streetPtr1", "2cityPtr1", []string{"13", "14", "15"}}, {"2streetPtr2", "cityPtr2", []string{"16", "17", "18"}}},
 	}
 	now = time.Now()
-	id, err = roleStore.Insert(role2)
+	id, err = roleStore.Insert(ctx, role2)
 	if err != nil {
 		t.Fatalf("fail to insert: %v", err)
 	}
@@ -125,7 +112,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	rolesOut, err := roleStore.GetMulti([]int64{1, 2})
+	rolesOut, err := roleStore.GetMulti(ctx, []int64{1, 2})
 	if err != nil {
 		t.Fatalf("GetMulti failed: %v", err)
 	}
@@ -141,7 +128,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	rolesOut2, err := roleStore.GetMulti([]int64{3, 1})
+	rolesOut2, err := roleStore.GetMulti(ctx, []int64{3, 1})
 	if err != nil {
 		t.Fatalf("GetMulti failed: %v", err)
 	}
@@ -154,7 +141,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	rolesFindBoth, err := roleStore.FindWhere(
+	rolesFindBoth, err := roleStore.FindWhere(ctx,
 		&store.WhereCond{
 			Field: "name",
 			Val:   []any{"referee", "guli"},
@@ -176,7 +163,7 @@ func TestNew(t *testing.T) {
 	assert.ElementsMatch(t, rolesFindBoth, []Role{role, role2})
 
 	now = time.Now()
-	rolesOutAll, err := roleStore.FindWhere()
+	rolesOutAll, err := roleStore.FindWhere(ctx)
 	if err != nil {
 		t.Fatalf("GetAll failed: %v", err)
 	}
@@ -192,7 +179,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	err = roleStore.DeleteMulti([]int64{3, 4})
+	err = roleStore.DeleteMulti(ctx, []int64{3, 4})
 	if err == nil {
 		t.Fatalf("expected not found")
 	}
@@ -202,13 +189,13 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	err = roleStore.DeleteMulti([]int64{2, 4})
+	err = roleStore.DeleteMulti(ctx, []int64{2, 4})
 	if err != nil {
 		t.Fatalf("delete multi failed")
 	}
 	fmt.Printf("DeleteMulti duration: %s\n", time.Since(now))
 
-	rolesOutAll2, err := roleStore.FindWhere()
+	rolesOutAll2, err := roleStore.FindWhere(ctx)
 	if err != nil {
 		t.Fatalf("roleStore.GetAll failed %s", err)
 	}
@@ -220,7 +207,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	rolesFind, err := roleStore.FindWhere(&store.WhereCond{
+	rolesFind, err := roleStore.FindWhere(ctx, &store.WhereCond{
 		Field: "name",
 		Val:   "super_admin",
 		Op:    store.OpEqual,
@@ -237,7 +224,7 @@ func TestNew(t *testing.T) {
 	}
 
 	now = time.Now()
-	rolesFind2, err := roleStore.FindWhere(&store.WhereCond{
+	rolesFind2, err := roleStore.FindWhere(ctx, &store.WhereCond{
 		Field: "name",
 		Val:   "admin",
 		Op:    store.OpEqual,
@@ -250,7 +237,7 @@ func TestNew(t *testing.T) {
 	}
 	fmt.Printf("FindField duration: %s\n", time.Since(now))
 
-	_, err = roleStore.Insert(Role{
+	_, err = roleStore.Insert(ctx, Role{
 		Name: "super_admin",
 	})
 	if err == nil {
@@ -258,3 +245,394 @@ func TestNew(t *testing.T) {
 	}
 
 }
+
+func TestCancelledContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_cancel.db")
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = roleStore.Insert(ctx, Role{Name: "admin"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Insert: expected context.Canceled but gotten %v", err)
+	}
+	_, err = roleStore.GetOne(ctx, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("GetOne: expected context.Canceled but gotten %v", err)
+	}
+	_, err = roleStore.FindWhere(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("FindWhere: expected context.Canceled but gotten %v", err)
+	}
+	err = roleStore.DeleteMulti(ctx, []int64{1})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteMulti: expected context.Canceled but gotten %v", err)
+	}
+}
+
+func TestOpen_NotAFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_open.db")
+	for _, name := range []string{"", ":memory:", "file:" + path, path + "?_pragma=foreign_keys(0)"} {
+		_, err := Open(name)
+		assert.ErrorIs(t, err, ErrNotAFile, name)
+	}
+}
+
+func TestRunInTx(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_tx.db")
+	ctx := context.Background()
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	roleStore, err := NewStoreFromDB[Role](db)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+		_ = tagStore.Close()
+		_ = db.Close()
+	})
+	assert.Same(t, roleStore.db, tagStore.db, "stores on the same path should share one DB")
+
+	errAbort := errors.New("abort")
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		roles, err := store.WithTx[Role](roleStore, tx)
+		if err != nil {
+			return err
+		}
+		tags, err := store.WithTx[Tag](tagStore, tx)
+		if err != nil {
+			return err
+		}
+		if _, err = roles.Insert(ctx, Role{Name: "admin"}); err != nil {
+			return err
+		}
+		if _, err = tags.Insert(ctx, Tag{Name: "red"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	rolesOut, err := roleStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, rolesOut)
+	tagsOut, err := tagStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, tagsOut)
+
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		roles, err := store.WithTx[Role](roleStore, tx)
+		if err != nil {
+			return err
+		}
+		tags, err := store.WithTx[Tag](tagStore, tx)
+		if err != nil {
+			return err
+		}
+		if _, err = roles.Insert(ctx, Role{Name: "admin"}); err != nil {
+			return err
+		}
+		_, err = tags.Insert(ctx, Tag{Name: "red"})
+		return err
+	})
+	assert.NoError(t, err)
+	rolesOut, err = roleStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, rolesOut, 1)
+	tagsOut, err = tagStore.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, tagsOut, 1)
+
+	otherPath := filepath.Join(t.TempDir(), "rbac_tx_other.db")
+	otherDB, err := Open(otherPath)
+	if err != nil {
+		t.Fatalf("fail to open db %v", err)
+	}
+	t.Cleanup(func() {
+		_ = otherDB.Close()
+	})
+	err = otherDB.RunInTx(ctx, func(tx store.Tx) error {
+		_, err := store.WithTx[Role](roleStore, tx)
+		return err
+	})
+	assert.ErrorIs(t, err, store.ErrTxMismatch)
+}
+
+func TestConcurrentStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_concurrent.db")
+	ctx := context.Background()
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	noteStore, err := NewStore[Note](path)
+	if err != nil {
+		t.Fatalf("fail to create noteStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tagStore.Close()
+		_ = noteStore.Close()
+	})
+
+	// stores on one file write through one connection and read in parallel
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := tagStore.Insert(ctx, Tag{Name: fmt.Sprintf("tag %d-%d", i, j)}); err != nil {
+					errs <- err
+				}
+				if _, err := noteStore.Insert(ctx, Note{Title: fmt.Sprintf("note %d-%d", i, j)}); err != nil {
+					errs <- err
+				}
+				if _, err := tagStore.Count(ctx); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	n, err := tagStore.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), n)
+	n, err = noteStore.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), n)
+
+	// the read pool cannot write
+	_, err = tagStore.db.read.ExecContext(ctx, "DELETE FROM tag")
+	assert.Error(t, err)
+
+	// retryBusy waits out a write lock held by another process
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("fail to open %s %v", path, err)
+	}
+	defer other.Close()
+	holder, err := other.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = holder.ExecContext(ctx, "INSERT INTO tag (name) VALUES ('held')")
+	assert.NoError(t, err)
+	time.AfterFunc(30*time.Millisecond, func() { _ = holder.Commit() })
+	conn, err := other.Conn(ctx)
+	assert.NoError(t, err)
+	defer conn.Close()
+	insert := func() error {
+		_, err := conn.ExecContext(ctx, "INSERT INTO tag (name) VALUES ('waited')")
+		return err
+	}
+	err = insert()
+	assert.True(t, isBusyError(err), "expected SQLITE_BUSY but got %v", err)
+	assert.NoError(t, retryBusy(ctx, insert))
+}
+
+func TestFindPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_page.db")
+	ctx := context.Background()
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tagStore.Close()
+	})
+
+	tagsIn := make([]Tag, 0, 25)
+	for i := 0; i < 25; i++ {
+		tag := Tag{Name: fmt.Sprintf("tag %02d", i)}
+		tag.ID, err = tagStore.Insert(ctx, tag)
+		if err != nil {
+			t.Fatalf("fail to insert: %v", err)
+		}
+		tagsIn = append(tagsIn, tag)
+	}
+
+	q := store.Query{
+		Where:   []store.Cond{&store.WhereCond{Field: "name", Op: store.OpNotEqual, Val: "tag 03"}},
+		OrderBy: []store.Order{{Field: "name", Dir: store.Desc}},
+		Limit:   10,
+	}
+	var tagsOut []Tag
+	pages := 0
+	for {
+		page, err := tagStore.FindPage(ctx, q)
+		if err != nil {
+			t.Fatalf("FindPage failed: %v", err)
+		}
+		assert.EqualValues(t, 24, page.Total)
+		tagsOut = append(tagsOut, page.Items...)
+		pages++
+		if page.Next == "" {
+			break
+		}
+		q.After = page.Next
+	}
+	assert.Equal(t, 3, pages)
+	assert.Len(t, tagsOut, 24)
+	for i, tag := range tagsOut {
+		want := tagsIn[24-i]
+		if 24-i <= 3 {
+			want = tagsIn[23-i]
+		}
+		assert.Equal(t, want, tag)
+	}
+
+	page, err := tagStore.FindPage(ctx, store.Query{Limit: 5, Offset: 20})
+	if err != nil {
+		t.Fatalf("FindPage failed: %v", err)
+	}
+	assert.Equal(t, tagsIn[20:], page.Items)
+	assert.Empty(t, page.Next)
+
+	_, err = tagStore.FindPage(ctx, store.Query{After: "not a cursor"})
+	assert.ErrorIs(t, err, store.ErrInvalidCursor)
+
+	_, err = tagStore.FindPage(ctx, store.Query{OrderBy: []store.Order{{Field: "name; drop table tag"}}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+}
+
+func TestFindWhere_CondTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_cond.db")
+	ctx := context.Background()
+	tagStore, err := NewStore[Tag](path)
+	if err != nil {
+		t.Fatalf("fail to create tagStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tagStore.Close()
+	})
+
+	names := []string{"referee", "umpire", "player", "coach", "Referee Assistant"}
+	for _, name := range names {
+		_, err = tagStore.Insert(ctx, Tag{Name: name})
+		if err != nil {
+			t.Fatalf("fail to insert: %v", err)
+		}
+	}
+
+	tags, err := tagStore.FindWhere(ctx, store.And(
+		store.Or(store.Like("name", "ref%"), store.In("id", []int64{2, 3})),
+		store.Not(store.Between("id", 3, 4)),
+	))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Tag{{1, "referee"}, {2, "umpire"}, {5, "Referee Assistant"}}, tags)
+
+	tags, err = tagStore.FindWhere(ctx, store.Glob("name", "Ref*"), store.QueryJoinerOr, store.IsNull("name"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Tag{{5, "Referee Assistant"}}, tags)
+
+	_, err = tagStore.FindWhere(ctx, store.Eq("name", "coach"), store.QueryJoinerOr)
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+
+	_, err = tagStore.FindWhere(ctx, store.Or(store.Eq("name", "coach"), store.Eq("1=1 or name", "x")))
+	var fieldErr *store.UnknownFieldError
+	if assert.ErrorAs(t, err, &fieldErr) {
+		assert.Equal(t, "tag", fieldErr.Table)
+		assert.Equal(t, "1=1 or name", fieldErr.Field)
+	}
+	_, err = tagStore.FindPage(ctx, store.Query{Where: []store.Cond{store.Not(store.IsNull("password"))}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+}
+
+func TestCorruptRow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_corrupt.db")
+	ctx := context.Background()
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+	})
+
+	goodID, err := roleStore.Insert(ctx, Role{Name: "good", Permissions: []int64{1}})
+	assert.NoError(t, err)
+	badID, err := roleStore.Insert(ctx, Role{Name: "bad", Permissions: []int64{2}})
+	assert.NoError(t, err)
+	_, err = roleStore.db.db.ExecContext(ctx, "UPDATE role SET permissions = '[2,' WHERE id = ?", badID)
+	assert.NoError(t, err)
+
+	var corrupt *store.ErrCorruptRow
+	_, err = roleStore.GetOne(ctx, badID)
+	if assert.ErrorAs(t, err, &corrupt) {
+		assert.Equal(t, "role", corrupt.Table)
+		assert.Equal(t, badID, corrupt.ID)
+	}
+	_, err = roleStore.FindWhere(ctx, store.Eq(RoleColName, "bad"))
+	assert.ErrorAs(t, err, &corrupt)
+
+	role, err := roleStore.GetOne(ctx, goodID)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, role.Permissions)
+}
+
+func TestJSONColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_json.db")
+	ctx := context.Background()
+	roleStore, err := NewStore[Role](path)
+	if err != nil {
+		t.Fatalf("fail to create roleStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = roleStore.Close()
+	})
+
+	admin := Role{
+		Name:        "admin",
+		Permissions: []int64{1, 5},
+		Address:     Address{Street: "Main", Zip: []string{"123"}},
+		AddressPtr:  &Address{City: "Here"},
+	}
+	adminID, err := roleStore.Insert(ctx, admin)
+	assert.NoError(t, err)
+	_, err = roleStore.Insert(ctx, Role{Name: "guest", Permissions: []int64{1}})
+	assert.NoError(t, err)
+
+	var stored, typ string
+	err = roleStore.db.db.QueryRowContext(ctx, "SELECT permissions, typeof(permissions) FROM role WHERE id = ?", adminID).Scan(&stored, &typ)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1,5]", stored)
+	assert.Equal(t, "text", typ)
+
+	admin.ID = adminID
+	got, err := roleStore.GetOne(ctx, adminID)
+	assert.NoError(t, err)
+	assert.Equal(t, admin, got)
+
+	roles, err := roleStore.FindWhere(ctx, store.JSONContains(RoleColPermissions, 5))
+	assert.NoError(t, err)
+	assert.Equal(t, []Role{admin}, roles)
+
+	roles, err = roleStore.FindWhere(ctx, store.JSONContains(RoleColPermissions, 1))
+	assert.NoError(t, err)
+	assert.Len(t, roles, 2)
+
+	// composite fields are only stored as JSON when tagged so
+	type event struct {
+		ID int64     `db:"id,pk"`
+		At time.Time `db:"at"`
+	}
+	assert.PanicsWithValue(t, "at column of type time.Time must be tagged json", func() {
+		getColumns(reflect.TypeOf(event{}))
+	})
+}
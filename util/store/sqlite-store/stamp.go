@@ -0,0 +1,65 @@
+package sqlitestore
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// stamp sets the fields of obj that the store maintains: created_at and
+// version for an Insert, and updated_at for an Insert or an Update.
+func (o *SQliteStore[T, R]) stamp(obj *T, now time.Time, update bool) {
+	v := reflect.ValueOf(obj).Elem()
+	for _, col := range o.columns {
+		switch {
+		case col.IsUpdatedAt, col.IsCreatedAt && !update:
+			setInt(v.Field(col.Index), now.Unix())
+		case col.IsVersion && !update:
+			setInt(v.Field(col.Index), 1)
+		}
+	}
+}
+
+// versionOf returns the version field of obj, or 0 if the model has none.
+func (o *SQliteStore[T, R]) versionOf(obj *T) int64 {
+	for _, col := range o.columns {
+		if !col.IsVersion {
+			continue
+		}
+		f := reflect.ValueOf(obj).Elem().Field(col.Index)
+		if f.CanUint() {
+			return int64(f.Uint())
+		}
+		return f.Int()
+	}
+	return 0
+}
+
+// setVersion sets the version field of obj, if the model has one.
+func (o *SQliteStore[T, R]) setVersion(obj *T, version int64) {
+	for _, col := range o.columns {
+		if col.IsVersion {
+			setInt(reflect.ValueOf(obj).Elem().Field(col.Index), version)
+		}
+	}
+}
+
+// exists reports whether the live row id exists. Update uses it to tell a
+// stale version from a missing row.
+func (o *SQliteStore[T, R]) exists(ctx context.Context, id int64) (bool, error) {
+	var n int
+	query := fmt.Sprintf("SELECT count(*) from %s%s", o.tablename, joinWhere(o.sqlTable.LiveConds(o.pk+" = ?")))
+	if err := o.reader().QueryRowContext(ctx, query, id).Scan(&n); err != nil {
+		return false, fmt.Errorf("%s exists query failed: %w", o.tablename, err)
+	}
+	return n > 0, nil
+}
+
+func setInt(f reflect.Value, val int64) {
+	if f.CanInt() {
+		f.SetInt(val)
+	} else if f.CanUint() {
+		f.SetUint(uint64(val))
+	}
+}
@@ -0,0 +1,58 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+func TestStamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rbac_stamp.db")
+	ctx := context.Background()
+	fixtureStore, err := NewStore[Fixture](path)
+	if err != nil {
+		t.Fatalf("fail to create fixtureStore %v", err)
+	}
+	t.Cleanup(func() {
+		_ = fixtureStore.Close()
+	})
+
+	// Insert sets the timestamps and the first version, whatever obj holds
+	before := time.Now().Unix()
+	id, err := fixtureStore.Insert(ctx, Fixture{Venue: "hall a", CreatedAt: 1, UpdatedAt: 1, Version: 7})
+	assert.NoError(t, err)
+	got, err := fixtureStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, got.CreatedAt, before)
+	assert.Equal(t, got.CreatedAt, got.UpdatedAt)
+	assert.Equal(t, int64(1), got.Version)
+
+	// Update keeps created_at and bumps the version
+	fixtureStore.On(store.AfterUpdate, func(ctx context.Context, obj *Fixture) error {
+		assert.Equal(t, got.Version+1, obj.Version, "after update hooks see the new version")
+		return nil
+	})
+	assert.NoError(t, fixtureStore.Update(ctx, id, Fixture{Venue: "hall b", Version: got.Version}))
+	updated, err := fixtureStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "hall b", updated.Venue)
+	assert.Equal(t, got.CreatedAt, updated.CreatedAt)
+	assert.GreaterOrEqual(t, updated.UpdatedAt, got.UpdatedAt)
+	assert.Equal(t, int64(2), updated.Version)
+
+	// a second writer holding the old version loses
+	err = fixtureStore.Update(ctx, id, Fixture{Venue: "hall c", Version: got.Version})
+	assert.ErrorIs(t, err, store.ErrStaleVersion)
+	current, err := fixtureStore.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, current)
+
+	// a missing or deleted row is not found, whatever the version
+	assert.ErrorIs(t, fixtureStore.Update(ctx, 100, Fixture{Version: 1}), store.ErrNotFound)
+	assert.NoError(t, fixtureStore.DeleteMulti(ctx, []int64{id}))
+	assert.ErrorIs(t, fixtureStore.Update(ctx, id, Fixture{Version: 2}), store.ErrNotFound)
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// SQLColumn is what the SQL stores share about a column of their table. The
+// stores embed it in their own column type, next to the DDL of their dialect.
+type SQLColumn struct {
+	Name string
+	// Index is the index of the field of the column in the model.
+	Index int
+	IsPK  bool
+	// IsJSON marks a column whose field, tagged with json, the store encodes as
+	// JSON text.
+	IsJSON bool
+	// IsSoftDelete marks the soft_delete column, which holds the unix time the
+	// row was deleted at, or 0 while it is not.
+	IsSoftDelete bool
+	// IsCreatedAt and IsUpdatedAt mark the created_at and updated_at columns,
+	// which the store sets to the unix time of the Insert and of the last
+	// Update.
+	IsCreatedAt bool
+	IsUpdatedAt bool
+	// IsVersion marks the version column, which the store sets to 1 on Insert
+	// and increments on each Update, see ErrStaleVersion.
+	IsVersion bool
+}
+
+// Encode encodes the value of a JSON column into JSON text. Other values are
+// returned as is.
+func (col SQLColumn) Encode(val any) (any, error) {
+	if !col.IsJSON {
+		return val, nil
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", col.Name, err)
+	}
+	return string(b), nil
+}
+
+// Rows is the result set of a query, like *sql.Rows.
+type Rows interface {
+	RowScanner
+	Next() bool
+	Err() error
+	Close() error
+}
+
+// SQLTable holds the parts of a SQL store that do not depend on the dialect:
+// checking fields, writing the conditions on soft deleted rows and keyset
+// cursors, and converting between the rows and T.
+type SQLTable[T any, R Row[T]] struct {
+	// Name is the unquoted table name, used in errors.
+	Name    string
+	Columns []SQLColumn
+	// Quote quotes an identifier of the dialect. Identifiers are written as is
+	// if it is nil.
+	Quote func(string) string
+	// SelectList is the list of the columns selected to read rows into T.
+	SelectList string
+	// LimitAll, if set, is the LIMIT clause FindPage writes before an OFFSET
+	// without a Limit, for dialects that only take OFFSET after LIMIT.
+	LimitAll string
+}
+
+func (t *SQLTable[T, R]) quote(name string) string {
+	if t.Quote == nil {
+		return name
+	}
+	return t.Quote(name)
+}
+
+// Column returns the column called name.
+func (t *SQLTable[T, R]) Column(name string) (SQLColumn, bool) {
+	for _, col := range t.Columns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return SQLColumn{}, false
+}
+
+func (t *SQLTable[T, R]) pk() string {
+	for _, col := range t.Columns {
+		if col.IsPK {
+			return col.Name
+		}
+	}
+	return ""
+}
+
+// CheckFields rejects conditions on fields that are not columns of the table.
+// Field names are written into the SQL text, so this is what keeps a
+// user-chosen field from injecting SQL.
+func (t *SQLTable[T, R]) CheckFields(conds []Cond) error {
+	for _, cond := range conds {
+		if cond == nil {
+			continue
+		}
+		for _, field := range cond.Fields() {
+			if _, ok := t.Column(field); !ok {
+				return &UnknownFieldError{Table: t.Name, Field: field}
+			}
+		}
+	}
+	return nil
+}
+
+// OrderColumns validates orders and appends the primary key as a tie-breaker
+// so that keyset cursors identify a unique row.
+func (t *SQLTable[T, R]) OrderColumns(orders []Order) ([]Order, error) {
+	out := make([]Order, 0, len(orders)+1)
+	hasPK := false
+	for _, order := range orders {
+		col, ok := t.Column(order.Field)
+		if !ok {
+			return nil, &UnknownFieldError{Table: t.Name, Field: order.Field}
+		}
+		switch order.Dir {
+		case "":
+			order.Dir = Asc
+		case Asc, Desc:
+		default:
+			return nil, fmt.Errorf("%s invalid order direction %q", t.Name, order.Dir)
+		}
+		if col.IsPK {
+			hasPK = true
+		}
+		out = append(out, order)
+	}
+	if !hasPK {
+		out = append(out, Order{Field: t.pk(), Dir: Asc})
+	}
+	return out, nil
+}
+
+// KeysetCond returns the condition selecting rows that sort after vals under
+// orders, e.g. (a > ?) or (a = ? and b < ?) for "a asc, b desc".
+func (t *SQLTable[T, R]) KeysetCond(orders []Order, vals []any) (string, []any) {
+	ors := make([]string, 0, len(orders))
+	args := make([]any, 0, len(orders)*(len(orders)+1)/2)
+	for i, order := range orders {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, t.quote(orders[j].Field)+" = ?")
+			args = append(args, vals[j])
+		}
+		cmp := ">"
+		if order.Dir == Desc {
+			cmp = "<"
+		}
+		ands = append(ands, t.quote(order.Field)+" "+cmp+" ?")
+		args = append(args, vals[i])
+		ors = append(ors, "("+strings.Join(ands, " and ")+")")
+	}
+	return "(" + strings.Join(ors, " or ") + ")", args
+}
+
+// LiveConds returns whereStmt, if any, and the condition that skips soft
+// deleted rows, if the model has a soft_delete column, to be joined with and.
+func (t *SQLTable[T, R]) LiveConds(whereStmt string) []string {
+	conds := make([]string, 0, 2)
+	if len(whereStmt) > 0 {
+		conds = append(conds, "("+whereStmt+")")
+	}
+	for _, col := range t.Columns {
+		if col.IsSoftDelete {
+			conds = append(conds, t.quote(col.Name)+" = 0")
+		}
+	}
+	return conds
+}
+
+// RowValues returns the values of the columns of k written by Insert, i.e. all
+// but the pk and soft_delete columns, or by Update if update is true, which
+// also leaves out the created_at and version columns. The JSON columns are
+// encoded. FieldsVals lists the fields in column order, skipping the fields
+// tagged with "-".
+func (t *SQLTable[T, R]) RowValues(k R, update bool) ([]any, error) {
+	fieldVals, err := k.FieldsVals()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, 0, len(t.Columns))
+	for i, col := range t.Columns {
+		if col.IsPK || col.IsSoftDelete || update && (col.IsCreatedAt || col.IsVersion) {
+			continue
+		}
+		val, err := col.Encode(fieldVals[i])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// Scanner wraps row so that ScanRow can pass pointers to the fields of the JSON
+// columns, which are decoded after the scan.
+func (t *SQLTable[T, R]) Scanner(row RowScanner) RowScanner {
+	if !slices.ContainsFunc(t.Columns, func(col SQLColumn) bool { return col.IsJSON }) {
+		return row
+	}
+	return JSONScanner{Row: row, Columns: t.Columns}
+}
+
+// ScanRows reads every row of rows into a slice and closes rows.
+func (t *SQLTable[T, R]) ScanRows(rows Rows, op string, capacity int) ([]T, error) {
+	defer rows.Close()
+	objs := make([]T, 0, capacity)
+	for rows.Next() {
+		var obj T
+		if err := R(&obj).ScanRow(t.Scanner(rows)); err != nil {
+			return nil, fmt.Errorf("%s %s row.Scan error: %w", t.Name, op, t.CorruptRow(&obj, err))
+		}
+		objs = append(objs, obj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s %s rows error: %w", t.Name, op, err)
+	}
+	return objs, nil
+}
+
+// CorruptRow wraps an error of ScanRow with the table and the id of the row,
+// which ScanRow has already read into obj unless the id column itself failed.
+func (t *SQLTable[T, R]) CorruptRow(obj *T, err error) error {
+	var id int64
+	for _, col := range t.Columns {
+		if !col.IsPK {
+			continue
+		}
+		if f := reflect.ValueOf(obj).Elem().Field(col.Index); f.CanInt() {
+			id = f.Int()
+		}
+	}
+	return &ErrCorruptRow{Table: t.Name, ID: id, Err: err}
+}
+
+// FindPage implements Store.FindPage. whereStmt and args are q.Where in the SQL
+// of the dialect, which the caller has checked with CheckFields, count counts
+// the live rows matching q.Where and query runs the SELECT of the page.
+func (t *SQLTable[T, R]) FindPage(ctx context.Context, q Query, whereStmt string, args []any,
+	count func(ctx context.Context) (int64, error),
+	query func(ctx context.Context, query string, args ...any) (Rows, error),
+) (Page[T], error) {
+	var page Page[T]
+
+	orders, err := t.OrderColumns(q.OrderBy)
+	if err != nil {
+		return page, err
+	}
+	conds := t.LiveConds(whereStmt)
+
+	if page.Total, err = count(ctx); err != nil {
+		return page, err
+	}
+
+	if len(q.After) > 0 {
+		vals, err := q.After.Values()
+		if err != nil {
+			return page, err
+		}
+		if len(vals) != len(orders) {
+			return page, ErrInvalidCursor
+		}
+		keyset, keysetArgs := t.KeysetCond(orders, vals)
+		conds = append(conds, keyset)
+		args = append(args, keysetArgs...)
+	}
+
+	orderBy := make([]string, 0, len(orders))
+	for _, order := range orders {
+		orderBy = append(orderBy, t.quote(order.Field)+" "+string(order.Dir))
+	}
+	findQuery := fmt.Sprintf("SELECT %s from %s", t.SelectList, t.quote(t.Name))
+	if len(conds) > 0 {
+		findQuery += " where " + strings.Join(conds, " and ")
+	}
+	findQuery += " ORDER BY " + strings.Join(orderBy, ", ")
+	if q.Limit > 0 {
+		// one extra row tells us whether there is a next page
+		findQuery += " LIMIT ?"
+		args = append(args, q.Limit+1)
+	} else if q.Offset > 0 && len(t.LimitAll) > 0 {
+		findQuery += " " + t.LimitAll
+	}
+	if q.Offset > 0 {
+		findQuery += " OFFSET ?"
+		args = append(args, q.Offset)
+	}
+
+	rows, err := query(ctx, findQuery, args...)
+	if err != nil {
+		return page, fmt.Errorf("%s FindPage Query error: %w", t.Name, err)
+	}
+	page.Items, err = t.ScanRows(rows, "FindPage", q.Limit+1)
+	if err != nil {
+		return page, err
+	}
+
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		last, err := R(&page.Items[q.Limit-1]).FieldsVals()
+		if err != nil {
+			return page, fmt.Errorf("%s FindPage cursor error: %w", t.Name, err)
+		}
+		vals := make([]any, 0, len(orders))
+		for _, order := range orders {
+			i := slices.IndexFunc(t.Columns, func(col SQLColumn) bool { return col.Name == order.Field })
+			val, err := t.Columns[i].Encode(last[i])
+			if err != nil {
+				return page, fmt.Errorf("%s FindPage cursor error: %w", t.Name, err)
+			}
+			vals = append(vals, val)
+		}
+		page.Next, err = NewCursor(vals)
+		if err != nil {
+			return page, err
+		}
+	}
+	return page, nil
+}
+
+// JSONScanner scans a row whose columns are Columns, in order, decoding the
+// JSON columns into the pointers passed to Scan. Columns past the end of
+// Columns are scanned as is.
+type JSONScanner struct {
+	Row     RowScanner
+	Columns []SQLColumn
+}
+
+func (s JSONScanner) Scan(dest ...any) error {
+	args := make([]any, len(dest))
+	copy(args, dest)
+	raws := make([]*[]byte, len(dest))
+	for i, col := range s.Columns {
+		if col.IsJSON && i < len(dest) {
+			raws[i] = new([]byte)
+			args[i] = raws[i]
+		}
+	}
+	if err := s.Row.Scan(args...); err != nil {
+		return err
+	}
+	for i, raw := range raws {
+		if raw == nil || len(*raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(*raw, dest[i]); err != nil {
+			return fmt.Errorf("%s: %w", s.Columns[i].Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sqlTableRow struct {
+	ID        int64
+	Name      string
+	Tags      []string
+	DeletedAt int64
+}
+
+func (r *sqlTableRow) FieldsVals() ([]any, error) {
+	return []any{r.ID, r.Name, r.Tags, r.DeletedAt}, nil
+}
+
+func (r *sqlTableRow) ScanRow(row RowScanner) error {
+	return row.Scan(&r.ID, &r.Name, &r.Tags, &r.DeletedAt)
+}
+
+func TestSQLTable(t *testing.T) {
+	table := SQLTable[sqlTableRow, *sqlTableRow]{
+		Name: "row",
+		Columns: []SQLColumn{
+			{Name: "id", Index: 0, IsPK: true},
+			{Name: "name", Index: 1},
+			{Name: "tags", Index: 2, IsJSON: true},
+			{Name: "deleted_at", Index: 3, IsSoftDelete: true},
+		},
+		Quote: func(name string) string { return `"` + name + `"` },
+	}
+
+	orders, err := table.OrderColumns([]Order{{Field: "name", Dir: Desc}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Order{{Field: "name", Dir: Desc}, {Field: "id", Dir: Asc}}, orders)
+	_, err = table.OrderColumns([]Order{{Field: "rank"}})
+	assert.ErrorIs(t, err, ErrUnknownField)
+
+	keyset, args := table.KeysetCond(orders, []any{"b", 2})
+	assert.Equal(t, `(("name" < ?) or ("name" = ? and "id" > ?))`, keyset)
+	assert.Equal(t, []any{"b", "b", 2}, args)
+
+	assert.Equal(t, []string{`(name = ?)`, `"deleted_at" = 0`}, table.LiveConds("name = ?"))
+	assert.ErrorIs(t, table.CheckFields([]Cond{Eq("rank", 1)}), ErrUnknownField)
+
+	values, err := table.RowValues(&sqlTableRow{ID: 1, Name: "a", Tags: []string{"x"}, DeletedAt: 5}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{"a", `["x"]`}, values)
+}
@@ -1,9 +1,10 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
 )
 
 type RowScanner interface {
@@ -13,70 +14,207 @@ type RowScanner interface {
 // Row is a type constraint for types representing
 // a single database row.
 type Row[T any] interface {
-	// FieldsVals returns all fields of a struct for use with row.Scan.
-	FieldsVals() []any
+	// FieldsVals returns all fields of a struct for use with row.Scan. JSON
+	// columns, tagged with json, are returned as the fields themselves and
+	// the store encodes them.
+	FieldsVals() ([]any, error)
+	// ScanRow reads a row into the struct by passing a pointer to every field
+	// to row.Scan, including the JSON columns, which the store decodes.
 	ScanRow(row RowScanner) error
 	*T
 }
 
-type WhereCond struct {
-	Field string
-	Op    op
-	Val   any
-}
-
-type op string
-
-const OpEqual op = "="
-const OpNotEqual op = "<>"
-const OpGte op = ">="
-const OpGt op = ">"
-const OpLte op = "<="
-const OpLt op = "<"
-const OpIn op = "in"
-
-type QueryJoiner string
-
-const QueryJoinerAnd QueryJoiner = "and"
-const QueryJoinerOr QueryJoiner = "or"
-
-func (o QueryJoiner) GetQueryWithArgs() (string, []any) {
-	return string(o), []any{}
-}
-
-func (o WhereCond) GetQueryWithArgs() (string, []any) {
-	switch o.Op {
-	case OpIn:
-		vals, ok := o.Val.([]any)
-		if !ok {
-			panic("WhereCond with OpIn only accept []any as Val")
-		}
-		qnMarks := make([]string, 0, len(vals))
-		for range vals {
-			qnMarks = append(qnMarks, "?")
-		}
-		return fmt.Sprintf("%s %s (%s)", o.Field, o.Op, strings.Join(qnMarks, ",")), vals
-	default:
-		return fmt.Sprintf("%s %s ?", o.Field, o.Op), []any{o.Val}
-	}
-}
-
-type Cond interface {
-	GetQueryWithArgs() (string, []any)
+// TableNamer is implemented by models that choose their table name instead of
+// using the snake_case name of their type.
+type TableNamer interface {
+	TableName() string
 }
 
 // Store is a generic interface to create, insert, update, retrieve, delete O.
-// Note that O is a struct that might contain an array of primitive values or even structs
+// Note that O is a struct that might contain an array of primitive values or even structs.
+// Every method takes a context so that cancelling a request also cancels its database work.
 type Store[T any, R Row[T]] interface {
-	Insert(obj T) (int64, error)
-	Update(id int64, obj T) error
-	GetMulti(ids []int64) ([]T, error)
-	GetOne(id int64) (T, error)
-	// FindWhere WhereConds must be either empty or joined by QueryJoiners
-	FindWhere(...Cond) ([]T, error)
-	DeleteMulti(ids []int64) error
+	Insert(ctx context.Context, obj T) (int64, error)
+	// Update returns ErrStaleVersion if the model has a version column whose
+	// value in obj is not the one of the row.
+	Update(ctx context.Context, id int64, obj T) error
+	// InsertMulti inserts objs in one transaction and returns their ids, in
+	// the order of objs. If one insert fails, none of objs is inserted.
+	InsertMulti(ctx context.Context, objs []T) ([]int64, error)
+	// UpdateMulti updates the rows ids to objs, ids[i] to objs[i], in one
+	// transaction. If one update fails, none of the rows is updated.
+	UpdateMulti(ctx context.Context, ids []int64, objs []T) error
+	// Upsert inserts objs, or updates the rows that already have their value
+	// of the unique field, in one transaction. It returns the ids of the rows,
+	// in the order of objs. Upsert does not check versions.
+	Upsert(ctx context.Context, field string, objs []T) ([]int64, error)
+	GetMulti(ctx context.Context, ids []int64) ([]T, error)
+	GetOne(ctx context.Context, id int64) (T, error)
+	// FindWhere conds must be either empty, joined by QueryJoiners or built with
+	// And, Or and Not. See Where.
+	FindWhere(ctx context.Context, conds ...Cond) ([]T, error)
+	// Each calls fn for each row matching conds, reading the rows one by one
+	// instead of loading them all. Each stops at the first error returned by
+	// fn and returns it, unless it is ErrStop.
+	Each(ctx context.Context, fn func(obj T) error, conds ...Cond) error
+	// FindPage returns the page of rows selected by q, ordered by q.OrderBy
+	// with the primary key as the final tie-breaker.
+	FindPage(ctx context.Context, q Query) (Page[T], error)
+	// Count returns the number of rows matching conds.
+	Count(ctx context.Context, conds ...Cond) (int64, error)
+	// Exists reports whether a row matches conds.
+	Exists(ctx context.Context, conds ...Cond) (bool, error)
+	// Aggregate returns the groups of agg, ordered by their keys.
+	Aggregate(ctx context.Context, agg Aggregation) ([]Group, error)
+	DeleteMulti(ctx context.Context, ids []int64) error
 	Close() error
 }
 
 var ErrNotFound error = errors.New("record not found")
 var ErrConflicted error = errors.New("record violated unique constraint")
+
+// ErrNotUnique is returned by Upsert for a field without a unique index.
+var ErrNotUnique error = errors.New("field is not unique")
+
+// ErrStop is returned by the function passed to Each to stop the iteration
+// without an error.
+var ErrStop error = errors.New("stop iteration")
+
+// ErrStaleVersion is returned by Update when the row has a version column and
+// was changed since the version passed to Update was read.
+var ErrStaleVersion error = errors.New("record was modified since it was read")
+
+// Tx is an open database transaction. It is only valid inside the function
+// passed to Transactor.RunInTx.
+type Tx interface {
+	// Database identifies the database the transaction was opened on.
+	Database() string
+}
+
+// CommitNotifier is implemented by transactions that can call functions once
+// they commit, e.g. to drop the cached rows they changed.
+type CommitNotifier interface {
+	// OnCommit calls fn after the transaction commits. fn is not called if the
+	// transaction rolls back.
+	OnCommit(fn func())
+}
+
+// OnCommit calls fn once tx commits, or right away if tx is nil or is not a
+// CommitNotifier.
+func OnCommit(tx Tx, fn func()) {
+	if notifier, ok := tx.(CommitNotifier); ok {
+		notifier.OnCommit(fn)
+		return
+	}
+	fn()
+}
+
+type txKey struct{}
+
+// ContextWithTx returns a copy of ctx carrying tx. Stores pass their
+// transaction to hooks this way, so that hooks can bind other stores to it.
+func ContextWithTx(ctx context.Context, tx Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns the transaction carried by ctx, or nil.
+func TxFromContext(ctx context.Context) Tx {
+	tx, _ := ctx.Value(txKey{}).(Tx)
+	return tx
+}
+
+// Transactor opens transactions on a database that is shared by several stores.
+type Transactor interface {
+	// RunInTx calls fn inside a transaction. The transaction is committed if fn
+	// returns nil and rolled back otherwise.
+	RunInTx(ctx context.Context, fn func(tx Tx) error) error
+	Close() error
+}
+
+// TxBinder is implemented by stores that can take part in a transaction.
+type TxBinder[T any, R Row[T]] interface {
+	// WithTx returns a view of the store whose methods run inside tx.
+	WithTx(tx Tx) (Store[T, R], error)
+}
+
+// WithTx returns a view of s bound to tx.
+func WithTx[T any, R Row[T]](s Store[T, R], tx Tx) (Store[T, R], error) {
+	binder, ok := s.(TxBinder[T, R])
+	if !ok {
+		return nil, ErrTxNotSupported
+	}
+	return binder.WithTx(tx)
+}
+
+var ErrTxNotSupported error = errors.New("store does not support transactions")
+var ErrTxMismatch error = errors.New("transaction belongs to another database")
+
+// SoftDeleter is implemented by stores whose model has a soft delete column,
+// tagged soft_delete. Their DeleteMulti only marks rows as deleted, and the
+// other Store methods skip the marked rows.
+type SoftDeleter[T any] interface {
+	// Restore unmarks the deleted rows ids. It returns ErrNotFound if none of
+	// them is deleted, and ErrConflicted if a live row has taken a unique
+	// value of one of them, as the unique columns only cover the live rows.
+	Restore(ctx context.Context, ids []int64) error
+	// FindDeleted returns the deleted rows matching conds, most recently
+	// deleted first.
+	FindDeleted(ctx context.Context, conds ...Cond) ([]T, error)
+	Purger
+}
+
+// Purger permanently removes soft deleted rows.
+type Purger interface {
+	// Purge removes the rows deleted before before and returns their number.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AsSoftDeleter returns s as a SoftDeleter. The methods of the result return
+// ErrSoftDeleteNotSupported if the model of s has no soft delete column.
+func AsSoftDeleter[T any, R Row[T]](s Store[T, R]) (SoftDeleter[T], error) {
+	deleter, ok := s.(SoftDeleter[T])
+	if !ok {
+		return nil, ErrSoftDeleteNotSupported
+	}
+	return deleter, nil
+}
+
+var ErrSoftDeleteNotSupported error = errors.New("store does not support soft delete")
+
+var ErrUnknownField error = errors.New("unknown field")
+
+// UnknownFieldError is returned when a condition or an order names a field that
+// is not a column of the table, or that a Whitelist does not allow.
+type UnknownFieldError struct {
+	Table string
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	if len(e.Table) == 0 {
+		return fmt.Sprintf("unknown field %q", e.Field)
+	}
+	return fmt.Sprintf("%s: unknown field %q", e.Table, e.Field)
+}
+
+func (e *UnknownFieldError) Is(target error) bool {
+	return target == ErrUnknownField
+}
+
+// ErrCorruptRow is returned when a stored row cannot be decoded into its model,
+// e.g. because a JSON column holds invalid JSON. The rest of the table is still
+// readable.
+type ErrCorruptRow struct {
+	Table string
+	// ID is the primary key of the row, or 0 if it could not be read either.
+	ID  int64
+	Err error
+}
+
+func (e *ErrCorruptRow) Error() string {
+	return fmt.Sprintf("%s: corrupt row %d: %v", e.Table, e.ID, e.Err)
+}
+
+func (e *ErrCorruptRow) Unwrap() error {
+	return e.Err
+}
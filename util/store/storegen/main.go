@@ -0,0 +1,207 @@
+// Command storegen generates the FieldsVals and ScanRow methods that make a
+// struct satisfy store.Row, together with constants for its column names.
+//
+// It reads the db tags of the structs named by -type in the package of the
+// current directory, in the same way the SQLite store does: the column name is
+// the part of the tag before the first comma, or the field name if empty, and
+// fields tagged with "-" are skipped.
+// JSON columns are passed to the store as the fields themselves; the store
+// encodes and decodes them.
+//
+// Usage:
+//
+//	//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Role,User
+//
+// The output goes to <type in snake_case>_store_gen.go, or to the file named by
+// -output. Types declared in _test.go files are written to a _test.go file.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+type field struct {
+	Name   string
+	Column string
+}
+
+type model struct {
+	Name   string
+	Fields []field
+	InTest bool
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("storegen: ")
+	typeNames := flag.String("type", "", "comma-separated list of struct names; must be set")
+	output := flag.String("output", "", "output file name; default <type in snake_case>_store_gen.go")
+	flag.Parse()
+	if len(*typeNames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pkgName, models, err := parseModels(".", strings.Split(*typeNames, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(pkgName, models)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fileName := *output
+	if len(fileName) == 0 {
+		fileName = toSnakeCase(models[0].Name) + "_store_gen.go"
+		if models[0].InTest {
+			fileName = toSnakeCase(models[0].Name) + "_store_gen_test.go"
+		}
+	}
+	if err = os.WriteFile(fileName, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseModels finds the named structs in the Go files of dir.
+func parseModels(dir string, typeNames []string) (string, []model, error) {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pkgName := ""
+	specs := map[string]*ast.TypeSpec{}
+	inTest := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_store_gen.go") || strings.HasSuffix(name, "_store_gen_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", nil, err
+		}
+		isTest := strings.HasSuffix(name, "_test.go")
+		if !isTest || len(pkgName) == 0 {
+			pkgName = file.Name.Name
+		}
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				typeSpec := spec.(*ast.TypeSpec)
+				specs[typeSpec.Name.Name] = typeSpec
+				inTest[typeSpec.Name.Name] = isTest
+			}
+		}
+	}
+
+	models := make([]model, 0, len(typeNames))
+	for _, typeName := range typeNames {
+		typeName = strings.TrimSpace(typeName)
+		spec, ok := specs[typeName]
+		if !ok {
+			return "", nil, fmt.Errorf("type %s not found", typeName)
+		}
+		structType, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return "", nil, fmt.Errorf("type %s is not a struct", typeName)
+		}
+		m := model{Name: typeName, InTest: inTest[typeName]}
+		for _, f := range structType.Fields.List {
+			if len(f.Names) == 0 {
+				return "", nil, fmt.Errorf("%s: embedded fields are not supported", typeName)
+			}
+			tag := ""
+			if f.Tag != nil {
+				unquoted, err := strconv.Unquote(f.Tag.Value)
+				if err != nil {
+					return "", nil, fmt.Errorf("%s: bad tag %s", typeName, f.Tag.Value)
+				}
+				tag = reflect.StructTag(unquoted).Get("db")
+			}
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			for _, name := range f.Names {
+				column := tagName
+				if len(column) == 0 {
+					column = name.Name
+				}
+				m.Fields = append(m.Fields, field{Name: name.Name, Column: column})
+			}
+		}
+		models = append(models, m)
+	}
+	return pkgName, models, nil
+}
+
+// toSnakeCase names files the way the SQLite store names tables.
+func toSnakeCase(name string) string {
+	var out []rune
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return string(out)
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by storegen. DO NOT EDIT.
+
+package {{.Package}}
+
+import "github.com/yinloo-ola/tt-app/util/store"
+{{range .Models}}{{$m := .}}
+// Column names of {{.Name}}.
+const (
+{{- range .Fields}}
+	{{$m.Name}}Col{{.Name}} = "{{.Column}}"
+{{- end}}
+)
+
+func (o *{{.Name}}) FieldsVals() ([]any, error) {
+	return []any{ {{- range $i, $f := .Fields}}{{if $i}}, {{end}}o.{{.Name}}{{end -}} }, nil
+}
+
+func (o *{{.Name}}) ScanRow(row store.RowScanner) error {
+	return row.Scan({{range $i, $f := .Fields}}{{if $i}}, {{end}}&o.{{.Name}}{{end}})
+}
+{{end}}`))
+
+func generate(pkgName string, models []model) ([]byte, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]any{
+		"Package": pkgName,
+		"Models":  models,
+	})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated invalid code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const modelSrc = `package models
+
+type Level int
+
+type Team struct {
+	ID      int64    ` + "`db:\"id,pk\"`" + `
+	Name    string   ` + "`db:\"name,idx_asc,uniq\"`" + `
+	Level   Level    ` + "`db:\"level\"`" + `
+	Logo    []byte
+	Members []string ` + "`db:\"members\"`" + `
+	Meta    string   ` + "`db:\"meta,json\"`" + `
+	Scratch []int64  ` + "`db:\"-\"`" + `
+}
+
+type Plain struct {
+	ID int64 ` + "`db:\"id,pk\"`" + `
+}
+`
+
+func TestParseModels(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team.go"), []byte(modelSrc), 0o644))
+
+	pkg, models, err := parseModels(dir, []string{"Team", " Plain"})
+	require.NoError(t, err)
+	assert.Equal(t, "models", pkg)
+	require.Len(t, models, 2)
+	assert.Equal(t, []field{
+		{Name: "ID", Column: "id"},
+		{Name: "Name", Column: "name"},
+		{Name: "Level", Column: "level"},
+		{Name: "Logo", Column: "Logo"},
+		{Name: "Members", Column: "members"},
+		{Name: "Meta", Column: "meta"},
+	}, models[0].Fields)
+
+	_, _, err = parseModels(dir, []string{"Level"})
+	assert.ErrorContains(t, err, "not a struct")
+	_, _, err = parseModels(dir, []string{"Missing"})
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team.go"), []byte(modelSrc), 0o644))
+	pkg, models, err := parseModels(dir, []string{"Team", "Plain"})
+	require.NoError(t, err)
+
+	src, err := generate(pkg, models)
+	require.NoError(t, err)
+	out := string(src)
+	assert.True(t, strings.HasPrefix(out, "// Code generated by storegen. DO NOT EDIT."))
+	assert.Contains(t, out, `TeamColName    = "name"`)
+	assert.Contains(t, out, `return []any{o.ID, o.Name, o.Level, o.Logo, o.Members, o.Meta}, nil`)
+	assert.Contains(t, out, `return row.Scan(&o.ID, &o.Name, &o.Level, &o.Logo, &o.Members, &o.Meta)`)
+	assert.Contains(t, out, `return row.Scan(&o.ID)`)
+}
@@ -0,0 +1,26 @@
+package storetest
+
+//go:generate go run github.com/yinloo-ola/tt-app/util/store/storegen -type=Player,Note -output=models_store_gen.go
+
+// Player has a column of each basic type, a unique and a JSON column.
+type Player struct {
+	ID     int64    `db:"id,pk"`
+	Name   string   `db:"name,uniq"`
+	Club   string   `db:"club,idx_asc"`
+	Age    int      `db:"age"`
+	Rating float64  `db:"rating"`
+	Active bool     `db:"active"`
+	Tags   []string `db:"tags,json"`
+}
+
+// Note has the columns the store maintains: timestamps, a version and a soft
+// delete column.
+type Note struct {
+	ID        int64  `db:"id,pk"`
+	Title     string `db:"title,uniq"`
+	Body      string `db:"body"`
+	CreatedAt int64  `db:"created_at,created_at"`
+	UpdatedAt int64  `db:"updated_at,updated_at"`
+	Version   int64  `db:"version,version"`
+	DeletedAt int64  `db:"deleted_at,soft_delete"`
+}
@@ -0,0 +1,43 @@
+// Code generated by storegen. DO NOT EDIT.
+
+package storetest
+
+import "github.com/yinloo-ola/tt-app/util/store"
+
+// Column names of Player.
+const (
+	PlayerColID     = "id"
+	PlayerColName   = "name"
+	PlayerColClub   = "club"
+	PlayerColAge    = "age"
+	PlayerColRating = "rating"
+	PlayerColActive = "active"
+	PlayerColTags   = "tags"
+)
+
+func (o *Player) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Name, o.Club, o.Age, o.Rating, o.Active, o.Tags}, nil
+}
+
+func (o *Player) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Name, &o.Club, &o.Age, &o.Rating, &o.Active, &o.Tags)
+}
+
+// Column names of Note.
+const (
+	NoteColID        = "id"
+	NoteColTitle     = "title"
+	NoteColBody      = "body"
+	NoteColCreatedAt = "created_at"
+	NoteColUpdatedAt = "updated_at"
+	NoteColVersion   = "version"
+	NoteColDeletedAt = "deleted_at"
+)
+
+func (o *Note) FieldsVals() ([]any, error) {
+	return []any{o.ID, o.Title, o.Body, o.CreatedAt, o.UpdatedAt, o.Version, o.DeletedAt}, nil
+}
+
+func (o *Note) ScanRow(row store.RowScanner) error {
+	return row.Scan(&o.ID, &o.Title, &o.Body, &o.CreatedAt, &o.UpdatedAt, &o.Version, &o.DeletedAt)
+}
@@ -0,0 +1,655 @@
+// Package storetest is a conformance suite for the implementations of
+// store.Store. Each implementation runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, storetest.Backend{
+//			Players: func(t *testing.T) store.Store[storetest.Player, *storetest.Player] { ... },
+//			Notes:   func(t *testing.T) store.Store[storetest.Note, *storetest.Note] { ... },
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yinloo-ola/tt-app/util/store"
+)
+
+// Backend opens the stores of the implementation under test. Each call returns
+// a new empty store, which the backend closes when t ends.
+type Backend struct {
+	Players func(t *testing.T) store.Store[Player, *Player]
+	Notes   func(t *testing.T) store.Store[Note, *Note]
+	// DB opens a database with an empty players and notes store, for the
+	// transaction tests, which are skipped if DB is nil.
+	DB func(t *testing.T) (store.Transactor, store.Store[Player, *Player], store.Store[Note, *Note])
+}
+
+// Run runs the suite against backend. The optional interfaces, hooks and
+// projection, are tested if the stores implement them, and transactions if
+// the backend opens databases.
+func Run(t *testing.T, backend Backend) {
+	t.Run("CRUD", func(t *testing.T) { testCRUD(t, backend) })
+	t.Run("Unique", func(t *testing.T) { testUnique(t, backend) })
+	t.Run("FindWhere", func(t *testing.T) { testFindWhere(t, backend) })
+	t.Run("FindPage", func(t *testing.T) { testFindPage(t, backend) })
+	t.Run("Aggregate", func(t *testing.T) { testAggregate(t, backend) })
+	t.Run("Each", func(t *testing.T) { testEach(t, backend) })
+	t.Run("Upsert", func(t *testing.T) { testUpsert(t, backend) })
+	t.Run("Version", func(t *testing.T) { testVersion(t, backend) })
+	t.Run("SoftDelete", func(t *testing.T) { testSoftDelete(t, backend) })
+	t.Run("Hooks", func(t *testing.T) { testHooks(t, backend) })
+	t.Run("Projection", func(t *testing.T) { testProjection(t, backend) })
+	t.Run("Tx", func(t *testing.T) { testTx(t, backend) })
+}
+
+// seedPlayers inserts the players most tests query.
+func seedPlayers(t *testing.T, players store.Store[Player, *Player]) []int64 {
+	ids, err := players.InsertMulti(context.Background(), []Player{
+		{Name: "ma long", Club: "bayi", Age: 35, Rating: 3.5, Active: true, Tags: []string{"olympian"}},
+		{Name: "fan zhendong", Club: "bayi", Age: 27, Rating: 4, Active: true, Tags: []string{"olympian", "captain"}},
+		{Name: "timo boll", Club: "Dusseldorf", Age: 43, Rating: 2.5, Tags: []string{"lefty"}},
+		{Name: "dang qiu", Club: "Dusseldorf", Age: 27, Rating: 3},
+		{Name: "truls moregard", Club: "linkoping", Age: 22, Rating: 3.2, Active: true},
+	})
+	if err != nil {
+		t.Fatalf("fail to seed players %v", err)
+	}
+	return ids
+}
+
+func playerNames(players []Player) []string {
+	names := make([]string, 0, len(players))
+	for _, player := range players {
+		names = append(names, player.Name)
+	}
+	return names
+}
+
+func testCRUD(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+
+	ma := Player{Name: "ma long", Club: "bayi", Age: 35, Rating: 3.5, Active: true, Tags: []string{"olympian"}}
+	id, err := players.Insert(ctx, ma)
+	if err != nil {
+		t.Fatalf("fail to insert %v", err)
+	}
+	ma.ID = id
+	got, err := players.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, ma, got)
+	// the caller's copy of a row is not the store's
+	got.Tags[0] = "changed"
+	got, err = players.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, ma, got)
+	_, err = players.GetOne(ctx, id+100)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	fan := Player{Name: "fan zhendong", Club: "bayi", Age: 27}
+	fan.ID, err = players.Insert(ctx, fan)
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, fan.ID)
+	multi, err := players.GetMulti(ctx, []int64{fan.ID, id, id + 100})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Player{ma, fan}, multi)
+
+	ma.Age = 36
+	ma.Tags = nil
+	assert.NoError(t, players.Update(ctx, id, ma))
+	got, err = players.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, ma, got)
+	assert.ErrorIs(t, players.Update(ctx, id+100, Player{Name: "nobody"}), store.ErrNotFound)
+
+	assert.NoError(t, players.DeleteMulti(ctx, []int64{id}))
+	_, err = players.GetOne(ctx, id)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, players.DeleteMulti(ctx, []int64{id}), store.ErrNotFound)
+	all, err := players.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []Player{fan}, all)
+}
+
+func testUnique(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+
+	ids, err := players.InsertMulti(ctx, []Player{{Name: "a"}, {Name: "b"}})
+	if err != nil {
+		t.Fatalf("fail to insert %v", err)
+	}
+	_, err = players.Insert(ctx, Player{Name: "a"})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	assert.ErrorIs(t, players.Update(ctx, ids[1], Player{Name: "a"}), store.ErrConflicted)
+	// a row keeps its own value
+	assert.NoError(t, players.Update(ctx, ids[0], Player{Name: "a", Age: 1}))
+
+	// the bulk changes are all or nothing
+	_, err = players.InsertMulti(ctx, []Player{{Name: "c"}, {Name: "a"}})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	err = players.UpdateMulti(ctx, ids, []Player{{Name: "x"}, {Name: "x"}})
+	assert.ErrorIs(t, err, store.ErrConflicted)
+	assert.Error(t, players.UpdateMulti(ctx, ids, []Player{{Name: "y"}}))
+	all, err := players.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, playerNames(all))
+}
+
+func testFindWhere(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	seedPlayers(t, players)
+
+	find := func(conds ...store.Cond) []string {
+		t.Helper()
+		found, err := players.FindWhere(ctx, conds...)
+		assert.NoError(t, err)
+		names := playerNames(found)
+		slices.Sort(names)
+		return names
+	}
+	all := []string{"dang qiu", "fan zhendong", "ma long", "timo boll", "truls moregard"}
+	assert.Equal(t, all, find())
+	assert.Equal(t, []string{"fan zhendong", "ma long"}, find(store.Eq(PlayerColClub, "bayi")))
+	assert.Equal(t, []string{"dang qiu", "timo boll", "truls moregard"}, find(store.Ne(PlayerColClub, "bayi")))
+	assert.Equal(t, []string{"ma long", "timo boll"}, find(store.Gt(PlayerColAge, 27)))
+	assert.Equal(t, []string{"dang qiu", "fan zhendong", "ma long", "timo boll"}, find(store.Gte(PlayerColAge, 27)))
+	assert.Equal(t, []string{"truls moregard"}, find(store.Lt(PlayerColAge, 27)))
+	assert.Equal(t, []string{"dang qiu", "fan zhendong", "truls moregard"}, find(store.Lte(PlayerColAge, 27)))
+	assert.Equal(t, []string{"dang qiu", "fan zhendong", "ma long"}, find(store.Between(PlayerColAge, 27, 35)))
+	assert.Equal(t, []string{"fan zhendong", "ma long", "truls moregard"}, find(store.Eq(PlayerColActive, true)))
+	assert.Equal(t, []string{"fan zhendong", "ma long", "truls moregard"}, find(store.Gt(PlayerColRating, 3.1)))
+	assert.Equal(t, []string{"fan zhendong", "ma long", "truls moregard"}, find(store.Gt(PlayerColRating, 3)))
+
+	assert.Equal(t, []string{"fan zhendong", "ma long", "truls moregard"}, find(store.In(PlayerColClub, []string{"bayi", "linkoping"})))
+	assert.Empty(t, find(store.In(PlayerColAge, []int{})))
+	assert.Equal(t, []string{"ma long", "timo boll", "truls moregard"}, find(store.NotIn(PlayerColAge, []int{27})))
+	assert.Equal(t, all, find(store.NotIn(PlayerColAge, []int{})))
+
+	// like ignores case, glob does not
+	assert.Equal(t, []string{"fan zhendong", "ma long"}, find(store.Like(PlayerColName, "%ONG")))
+	assert.Equal(t, []string{"dang qiu", "timo boll"}, find(store.Like(PlayerColClub, "d_sseldorf")))
+	assert.Equal(t, []string{"dang qiu", "timo boll"}, find(store.Glob(PlayerColClub, "D*")))
+	assert.Empty(t, find(store.Glob(PlayerColClub, "d*")))
+	assert.Equal(t, []string{"ma long", "timo boll", "truls moregard"}, find(store.Glob(PlayerColName, "[mt]*")))
+	assert.Equal(t, []string{"fan zhendong", "ma long"}, find(store.JSONContains(PlayerColTags, "olympian")))
+	assert.Empty(t, find(store.IsNull(PlayerColName)))
+	assert.Equal(t, all, find(store.IsNotNull(PlayerColName)))
+
+	// condition trees and flat lists
+	assert.Equal(t, []string{"fan zhendong", "truls moregard"}, find(store.Or(
+		store.Eq(PlayerColClub, "linkoping"),
+		store.And(store.Eq(PlayerColClub, "bayi"), store.Lt(PlayerColAge, 30)),
+	)))
+	assert.Equal(t, []string{"dang qiu", "timo boll", "truls moregard"}, find(store.Not(store.Eq(PlayerColClub, "bayi"))))
+	assert.Equal(t, all, find(store.And()))
+	assert.Empty(t, find(store.Or()))
+	assert.Equal(t, []string{"ma long", "truls moregard"}, find(
+		store.Eq(PlayerColClub, "linkoping"), store.QueryJoinerOr, store.Eq(PlayerColClub, "bayi"), store.QueryJoinerAnd, store.Gt(PlayerColAge, 30),
+	))
+	assert.Equal(t, []string{"ma long"}, find(store.Eq(PlayerColClub, "bayi"), store.Gt(PlayerColAge, 30)))
+
+	_, err := players.FindWhere(ctx, store.Eq("rank", 1))
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+	_, err = players.FindWhere(ctx, store.QueryJoinerOr)
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+	_, err = players.FindWhere(ctx, store.In(PlayerColAge, 27))
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+	_, err = players.FindWhere(ctx, store.WhereCond{Field: PlayerColAge, Op: store.OpBetween, Val: []int{1}})
+	assert.ErrorIs(t, err, store.ErrInvalidCond)
+}
+
+func testFindPage(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	ids := seedPlayers(t, players)
+
+	page, err := players.FindPage(ctx, store.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), page.Total)
+	if assert.Len(t, page.Items, 5) {
+		for i, player := range page.Items {
+			assert.Equal(t, ids[i], player.ID)
+		}
+	}
+	assert.Empty(t, page.Next)
+
+	// ties on age are broken by the primary key
+	q := store.Query{OrderBy: []store.Order{{Field: PlayerColAge, Dir: store.Desc}}, Limit: 2}
+	var names []string
+	for {
+		page, err = players.FindPage(ctx, q)
+		if !assert.NoError(t, err) {
+			break
+		}
+		assert.Equal(t, int64(5), page.Total)
+		names = append(names, playerNames(page.Items)...)
+		if len(page.Next) == 0 {
+			break
+		}
+		q.After = page.Next
+	}
+	assert.Equal(t, []string{"timo boll", "ma long", "fan zhendong", "dang qiu", "truls moregard"}, names)
+
+	page, err = players.FindPage(ctx, store.Query{OrderBy: []store.Order{{Field: PlayerColName}}, Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fan zhendong", "ma long"}, playerNames(page.Items))
+	page, err = players.FindPage(ctx, store.Query{Where: []store.Cond{store.Eq(PlayerColClub, "bayi")}, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), page.Total)
+	assert.Equal(t, []string{"ma long"}, playerNames(page.Items))
+	assert.NotEmpty(t, page.Next)
+
+	_, err = players.FindPage(ctx, store.Query{After: "not a cursor!"})
+	assert.ErrorIs(t, err, store.ErrInvalidCursor)
+	_, err = players.FindPage(ctx, store.Query{OrderBy: []store.Order{{Field: "rank"}}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+}
+
+func testAggregate(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	seedPlayers(t, players)
+
+	n, err := players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = players.Count(ctx, store.Eq(PlayerColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	ok, err := players.Exists(ctx, store.Eq(PlayerColName, "ma long"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = players.Exists(ctx, store.Eq(PlayerColName, "ding ning"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	groups, err := players.Aggregate(ctx, store.Aggregation{Func: store.AggMax, Field: PlayerColAge})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{{Keys: []any{}, Value: 43}}, groups)
+	// text keys are ordered by their bytes
+	groups, err = players.Aggregate(ctx, store.Aggregation{
+		Func:    store.AggAvg,
+		Field:   PlayerColAge,
+		GroupBy: []string{PlayerColClub},
+		Where:   []store.Cond{store.Gt(PlayerColAge, 25)},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{
+		{Keys: []any{"Dusseldorf"}, Value: 35},
+		{Keys: []any{"bayi"}, Value: 31},
+	}, groups)
+	groups, err = players.Aggregate(ctx, store.Aggregation{Func: store.AggCount, GroupBy: []string{PlayerColClub, PlayerColAge}})
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 5) {
+		assert.Equal(t, store.Group{Keys: []any{"Dusseldorf", int64(27)}, Value: 1}, groups[0])
+	}
+	groups, err = players.Aggregate(ctx, store.Aggregation{Func: store.AggSum, Field: PlayerColRating, GroupBy: []string{PlayerColActive}})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{
+		{Keys: []any{int64(0)}, Value: 5.5},
+		{Keys: []any{int64(1)}, Value: 10.7},
+	}, roundGroups(groups))
+	groups, err = players.Aggregate(ctx, store.Aggregation{
+		Func:  store.AggMin,
+		Field: PlayerColAge,
+		Where: []store.Cond{store.Eq(PlayerColClub, "none")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []store.Group{{Keys: []any{}, Value: 0}}, groups)
+
+	_, err = players.Aggregate(ctx, store.Aggregation{Func: "median", Field: PlayerColAge})
+	assert.ErrorIs(t, err, store.ErrInvalidAggregation)
+	_, err = players.Aggregate(ctx, store.Aggregation{Func: store.AggSum})
+	assert.ErrorIs(t, err, store.ErrInvalidAggregation)
+	_, err = players.Aggregate(ctx, store.Aggregation{Func: store.AggCount, GroupBy: []string{"rank"}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+}
+
+// roundGroups rounds the values of groups to 6 decimals, so that sums of floats
+// compare equal whatever their order of addition.
+func roundGroups(groups []store.Group) []store.Group {
+	for i := range groups {
+		groups[i].Value = float64(int64(groups[i].Value*1e6+0.5)) / 1e6
+	}
+	return groups
+}
+
+func testEach(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	seedPlayers(t, players)
+
+	var names []string
+	assert.NoError(t, players.Each(ctx, func(player Player) error {
+		names = append(names, player.Name)
+		return nil
+	}, store.Eq(PlayerColClub, "bayi")))
+	assert.ElementsMatch(t, []string{"ma long", "fan zhendong"}, names)
+
+	n := 0
+	assert.NoError(t, players.Each(ctx, func(player Player) error {
+		n++
+		return store.ErrStop
+	}))
+	assert.Equal(t, 1, n)
+	errAbort := errors.New("abort")
+	assert.ErrorIs(t, players.Each(ctx, func(player Player) error { return errAbort }), errAbort)
+	assert.ErrorIs(t, players.Each(ctx, func(player Player) error { return nil }, store.Eq("rank", 1)), store.ErrUnknownField)
+}
+
+func testUpsert(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	ids := seedPlayers(t, players)
+
+	upserted, err := players.Upsert(ctx, PlayerColName, []Player{{Name: "ma long", Age: 36}, {Name: "lin shidong", Age: 19}})
+	assert.NoError(t, err)
+	if assert.Len(t, upserted, 2) {
+		assert.Equal(t, ids[0], upserted[0])
+		assert.NotContains(t, ids, upserted[1])
+	}
+	ma, err := players.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Equal(t, Player{ID: ids[0], Name: "ma long", Age: 36}, ma)
+	_, err = players.Upsert(ctx, PlayerColClub, []Player{{Name: "x"}})
+	assert.ErrorIs(t, err, store.ErrNotUnique)
+	_, err = players.Upsert(ctx, "rank", []Player{{Name: "x"}})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+
+	// an updated row keeps created_at, and a row in the trash is left alone
+	notes := backend.Notes(t)
+	id, err := notes.Insert(ctx, Note{Title: "a"})
+	assert.NoError(t, err)
+	before, err := notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	upserted, err = notes.Upsert(ctx, NoteColTitle, []Note{{Title: "a", Body: "updated", CreatedAt: 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{id}, upserted)
+	after, err := notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", after.Body)
+	assert.Equal(t, before.CreatedAt, after.CreatedAt)
+	assert.Equal(t, int64(2), after.Version)
+	assert.NoError(t, notes.DeleteMulti(ctx, []int64{id}))
+	upserted, err = notes.Upsert(ctx, NoteColTitle, []Note{{Title: "a", Body: "new"}})
+	assert.NoError(t, err)
+	if assert.Len(t, upserted, 1) {
+		assert.NotEqual(t, id, upserted[0])
+	}
+	deleter, err := store.AsSoftDeleter[Note](notes)
+	assert.NoError(t, err)
+	deleted, err := deleter.FindDeleted(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, "updated", deleted[0].Body)
+	}
+}
+
+func testVersion(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	notes := backend.Notes(t)
+
+	start := time.Now().Unix()
+	id, err := notes.Insert(ctx, Note{Title: "a", CreatedAt: 1, Version: 7})
+	if err != nil {
+		t.Fatalf("fail to insert %v", err)
+	}
+	note, err := notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), note.Version)
+	assert.GreaterOrEqual(t, note.CreatedAt, start)
+	assert.Equal(t, note.CreatedAt, note.UpdatedAt)
+
+	note.Body = "edited"
+	note.CreatedAt = 1
+	assert.NoError(t, notes.Update(ctx, id, note))
+	got, err := notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), got.Version)
+	assert.Equal(t, "edited", got.Body)
+	assert.GreaterOrEqual(t, got.CreatedAt, start)
+
+	// note still has version 1
+	assert.ErrorIs(t, notes.Update(ctx, id, note), store.ErrStaleVersion)
+	assert.ErrorIs(t, notes.Update(ctx, id+100, note), store.ErrNotFound)
+}
+
+func testSoftDelete(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	notes := backend.Notes(t)
+	deleter, err := store.AsSoftDeleter[Note](notes)
+	if err != nil {
+		t.Fatalf("notes store is not a SoftDeleter %v", err)
+	}
+
+	ids, err := notes.InsertMulti(ctx, []Note{{Title: "a"}, {Title: "b"}})
+	if err != nil {
+		t.Fatalf("fail to insert %v", err)
+	}
+	assert.NoError(t, notes.DeleteMulti(ctx, ids[:1]))
+	_, err = notes.GetOne(ctx, ids[0])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	n, err := notes.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.ErrorIs(t, notes.Update(ctx, ids[0], Note{Title: "a2", Version: 1}), store.ErrNotFound)
+	assert.ErrorIs(t, notes.DeleteMulti(ctx, ids[:1]), store.ErrNotFound)
+	deleted, err := deleter.FindDeleted(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, "a", deleted[0].Title)
+		assert.NotZero(t, deleted[0].DeletedAt)
+	}
+	// a row in the trash does not keep its unique value, and is not restored
+	// while a live row has it
+	other, err := notes.Insert(ctx, Note{Title: "a"})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, deleter.Restore(ctx, ids[:1]), store.ErrConflicted)
+	assert.NoError(t, notes.DeleteMulti(ctx, []int64{other}))
+	assert.ErrorIs(t, deleter.Restore(ctx, []int64{ids[0], other}), store.ErrConflicted)
+	_, err = notes.GetOne(ctx, ids[0])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	assert.NoError(t, deleter.Restore(ctx, ids[:1]))
+	assert.ErrorIs(t, deleter.Restore(ctx, ids[:1]), store.ErrNotFound)
+	note, err := notes.GetOne(ctx, ids[0])
+	assert.NoError(t, err)
+	assert.Zero(t, note.DeletedAt)
+
+	assert.NoError(t, notes.DeleteMulti(ctx, ids))
+	purged, err := deleter.Purge(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = deleter.Purge(ctx, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	deleted, err = deleter.FindDeleted(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, deleted)
+	_, err = notes.Insert(ctx, Note{Title: "a"})
+	assert.NoError(t, err)
+}
+
+func testHooks(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	notes := backend.Notes(t)
+	if _, ok := notes.(store.Hooker[Note]); !ok {
+		t.Skip("store does not support hooks")
+	}
+
+	errAbort := errors.New("abort")
+	var events []string
+	assert.NoError(t, store.On(notes, store.BeforeInsert, func(ctx context.Context, obj *Note) error {
+		obj.Body = strings.ToUpper(obj.Title)
+		return nil
+	}))
+	assert.NoError(t, store.On(notes, store.AfterUpdate, func(ctx context.Context, obj *Note) error {
+		if obj.Title == "abort" {
+			return errAbort
+		}
+		events = append(events, "after_update "+obj.Title)
+		return nil
+	}))
+	assert.NoError(t, store.On(notes, store.BeforeDelete, func(ctx context.Context, obj *Note) error {
+		events = append(events, "before_delete "+obj.Title)
+		return nil
+	}))
+
+	id, err := notes.Insert(ctx, Note{Title: "a"})
+	assert.NoError(t, err)
+	note, err := notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "A", note.Body)
+
+	// an error of an after hook rolls the change back
+	err = notes.Update(ctx, id, Note{Title: "abort", Version: 1})
+	assert.ErrorIs(t, err, errAbort)
+	note, err = notes.GetOne(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", note.Title)
+	assert.Equal(t, int64(1), note.Version)
+
+	assert.NoError(t, notes.Update(ctx, id, Note{Title: "b", Version: 1}))
+	assert.NoError(t, notes.DeleteMulti(ctx, []int64{id}))
+	assert.Equal(t, []string{"after_update b", "before_delete b"}, events)
+}
+
+func testProjection(t *testing.T, backend Backend) {
+	ctx := context.Background()
+	players := backend.Players(t)
+	if _, ok := players.(store.Projector); !ok {
+		t.Skip("store does not support projection")
+	}
+	ids := seedPlayers(t, players)
+
+	type option struct {
+		ID   int64    `db:"id"`
+		Name string   `db:"name"`
+		Tags []string `db:"tags,json"`
+	}
+	options, err := store.Project[option](ctx, players, store.Eq(PlayerColClub, "bayi"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []option{
+		{ID: ids[0], Name: "ma long", Tags: []string{"olympian"}},
+		{ID: ids[1], Name: "fan zhendong", Tags: []string{"olympian", "captain"}},
+	}, options)
+
+	rows, err := store.FindMaps(ctx, players, []string{PlayerColName, PlayerColAge, PlayerColRating, PlayerColTags}, store.Eq(PlayerColID, ids[2]))
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]any{
+		{PlayerColName: "timo boll", PlayerColAge: int64(43), PlayerColRating: 2.5, PlayerColTags: []any{"lefty"}},
+	}, rows)
+	_, err = store.FindMaps(ctx, players, []string{"rank"})
+	assert.ErrorIs(t, err, store.ErrUnknownField)
+}
+
+func testTx(t *testing.T, backend Backend) {
+	if backend.DB == nil {
+		t.Skip("backend does not open databases")
+	}
+	ctx := context.Background()
+	db, players, notes := backend.DB(t)
+
+	// the changes are only seen outside of the transaction once it commits
+	committed := false
+	err := db.RunInTx(ctx, func(tx store.Tx) error {
+		txPlayers, err := store.WithTx(players, tx)
+		if err != nil {
+			return err
+		}
+		txNotes, err := store.WithTx(notes, tx)
+		if err != nil {
+			return err
+		}
+		if _, err = txPlayers.Insert(ctx, Player{Name: "ma long"}); err != nil {
+			return err
+		}
+		if _, err = txNotes.Insert(ctx, Note{Title: "a"}); err != nil {
+			return err
+		}
+		n, err := txPlayers.Count(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+		// another goroutine, as a read outside of the transaction may wait
+		// for it in some databases
+		done := make(chan int64)
+		go func() {
+			n, err := players.Count(ctx)
+			assert.NoError(t, err)
+			done <- n
+		}()
+		assert.Equal(t, int64(0), <-done)
+		store.OnCommit(tx, func() { committed = true })
+		assert.False(t, committed)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, committed)
+	n, err := notes.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// an error rolls back the changes to every store
+	errAbort := errors.New("abort")
+	committed = false
+	err = db.RunInTx(ctx, func(tx store.Tx) error {
+		txPlayers, err := store.WithTx(players, tx)
+		if err != nil {
+			return err
+		}
+		txNotes, err := store.WithTx(notes, tx)
+		if err != nil {
+			return err
+		}
+		if _, err = txPlayers.Insert(ctx, Player{Name: "fan zhendong"}); err != nil {
+			return err
+		}
+		if err = txNotes.DeleteMulti(ctx, []int64{1}); err != nil {
+			return err
+		}
+		store.OnCommit(tx, func() { committed = true })
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+	assert.False(t, committed)
+	n, err = players.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	n, err = notes.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// hooks get the transaction of the change, and can bind other stores to it
+	if _, ok := notes.(store.Hooker[Note]); !ok {
+		return
+	}
+	assert.NoError(t, store.On(notes, store.AfterInsert, func(ctx context.Context, obj *Note) error {
+		txPlayers, err := store.WithTx(players, store.TxFromContext(ctx))
+		if err != nil {
+			return err
+		}
+		if _, err = txPlayers.Insert(ctx, Player{Name: obj.Title}); err != nil {
+			return err
+		}
+		if obj.Title == "abort" {
+			return errAbort
+		}
+		return nil
+	}))
+	_, err = notes.Insert(ctx, Note{Title: "timo boll"})
+	assert.NoError(t, err)
+	_, err = notes.Insert(ctx, Note{Title: "abort"})
+	assert.ErrorIs(t, err, errAbort)
+	found, err := players.FindWhere(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ma long", "timo boll"}, playerNames(found))
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Whitelist lists the fields of a model that users may filter and sort on.
+// Handlers that build queries from user input should go through ParseQuery
+// rather than copying request parameters into a WhereCond.
+type Whitelist struct {
+	Filter []string
+	Sort   []string
+}
+
+// filterOps maps the operator suffix of a filter parameter to its condition.
+var filterOps = map[string]func(field string, val string) Cond{
+	"eq":       func(field string, val string) Cond { return Eq(field, val) },
+	"ne":       func(field string, val string) Cond { return Ne(field, val) },
+	"gt":       func(field string, val string) Cond { return Gt(field, val) },
+	"gte":      func(field string, val string) Cond { return Gte(field, val) },
+	"lt":       func(field string, val string) Cond { return Lt(field, val) },
+	"lte":      func(field string, val string) Cond { return Lte(field, val) },
+	"like":     func(field string, val string) Cond { return Like(field, val) },
+	"contains": func(field string, val string) Cond { return Like(field, "%"+val+"%") },
+}
+
+// ParseQuery builds the Where and OrderBy of a Query from url query values:
+//
+//	<field>=<value>        field equals value
+//	<field>__<op>=<value>  op is one of eq, ne, gt, gte, lt, lte, like, contains
+//	sort=<field>,-<field>  ascending, or descending with a leading '-'
+//
+// Filters on different fields are ANDed. Empty values are skipped and keys
+// that do not name a whitelisted field are ignored, so the values may also
+// carry unrelated parameters such as a cursor. Sorting on a field that is not
+// in w.Sort returns an UnknownFieldError.
+func (w Whitelist) ParseQuery(values url.Values) (Query, error) {
+	var q Query
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if key == "sort" {
+			continue
+		}
+		field, opName, hasOp := strings.Cut(key, "__")
+		if !slices.Contains(w.Filter, field) {
+			continue
+		}
+		if !hasOp {
+			opName = "eq"
+		}
+		newCond, ok := filterOps[opName]
+		if !ok {
+			return q, fmt.Errorf("%w: unknown filter operator %q", ErrInvalidCond, opName)
+		}
+		for _, val := range values[key] {
+			if len(val) > 0 {
+				q.Where = append(q.Where, newCond(field, val))
+			}
+		}
+	}
+	if len(q.Where) > 1 {
+		q.Where = []Cond{And(q.Where...)}
+	}
+
+	for _, sort := range values["sort"] {
+		for _, field := range strings.Split(sort, ",") {
+			dir := Asc
+			if strings.HasPrefix(field, "-") {
+				dir = Desc
+				field = field[1:]
+			}
+			if len(field) == 0 {
+				continue
+			}
+			if !slices.Contains(w.Sort, field) {
+				return q, &UnknownFieldError{Field: field}
+			}
+			q.OrderBy = append(q.OrderBy, Order{Field: field, Dir: dir})
+		}
+	}
+	return q, nil
+}
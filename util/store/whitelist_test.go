@@ -0,0 +1,35 @@
+package store
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhitelist_ParseQuery(t *testing.T) {
+	w := Whitelist{Filter: []string{"name", "age"}, Sort: []string{"name"}}
+
+	values, err := url.ParseQuery("name__contains=ref&age__gte=18&cursor=abc&role=1&sort=-name")
+	assert.NoError(t, err)
+	q, err := w.ParseQuery(values)
+	assert.NoError(t, err)
+	query, args, err := Where(q.Where...)
+	assert.NoError(t, err)
+	assert.Equal(t, "(age >= ? and name like ?)", query)
+	assert.Equal(t, []any{"18", "%ref%"}, args)
+	assert.Equal(t, []Order{{Field: "name", Dir: Desc}}, q.OrderBy)
+
+	q, err = w.ParseQuery(url.Values{"name": {""}})
+	assert.NoError(t, err)
+	assert.Empty(t, q.Where)
+
+	_, err = w.ParseQuery(url.Values{"name__regexp": {"x"}})
+	assert.ErrorIs(t, err, ErrInvalidCond)
+
+	_, err = w.ParseQuery(url.Values{"sort": {"age"}})
+	assert.ErrorIs(t, err, ErrUnknownField)
+	var fieldErr *UnknownFieldError
+	assert.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "age", fieldErr.Field)
+}